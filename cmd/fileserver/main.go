@@ -3,18 +3,29 @@ package main
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
+	custgrpc "github.com/AleksandrMac/fileserver/internal/delivery/grpc"
 	custhttp "github.com/AleksandrMac/fileserver/internal/delivery/http"
+	"github.com/AleksandrMac/fileserver/internal/download"
+	"github.com/AleksandrMac/fileserver/internal/metrics"
+	"github.com/AleksandrMac/fileserver/internal/ratelimit"
 	"github.com/AleksandrMac/fileserver/internal/repository"
+	"github.com/AleksandrMac/fileserver/internal/s3"
+	"github.com/AleksandrMac/fileserver/internal/sftp"
 	"github.com/AleksandrMac/fileserver/internal/usecase"
 	editor_usecase "github.com/AleksandrMac/fileserver/internal/usecase/editor"
+	"github.com/AleksandrMac/fileserver/internal/watcher"
+	"github.com/AleksandrMac/fileserver/internal/webdav"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"github.com/go-chi/chi/v5"
@@ -50,6 +61,17 @@ func main() {
 	docServerUrl := getEnv("DOCUMENT_SERVER_URL", "")
 	docServerUrlInternal := getEnv("DOCUMENT_SERVER_URL_INTERNAL", "")
 	storageUrlPath := storagePathUrl()
+	auditPath := getEnv("AUDIT_PATH", "./audit")
+	historyPath := getEnv("HISTORY_PATH", "./history")
+	dataPath := getEnv("DATA_PATH", "./data")
+	templatesPath := getEnv("TEMPLATES_PATH", "")
+	permissionsFile := getEnv("PERMISSIONS_FILE", "")
+	davPrefix := strings.TrimSuffix(getEnv("WEBDAV_PREFIX", ""), "/")
+	s3Port := getEnv("S3_PORT", "")
+	sftpPort := getEnv("SFTP_PORT", "")
+	grpcPort := getEnv("GRPC_PORT", "")
+	webhooksFile := getEnv("WEBHOOKS_FILE", "")
+	watchStorage := getEnv("WATCH_STORAGE", "inotify")
 	if err := os.MkdirAll(filepath.Join(storagePath, storageUrlPath), 0755); err != nil {
 		log.Fatal().Msg("can't make storage")
 	}
@@ -63,14 +85,119 @@ func main() {
 	if docServerUrl == "" {
 		log.Fatal().Msg("DOCUMENT_SERVER_URL is required")
 	}
+	if sftpPort != "" && getEnv("SFTP_USERS_FILE", "") == "" {
+		log.Fatal().Msg("SFTP_USERS_FILE is required when SFTP_PORT is set")
+	}
+	if davPrefix != "" && (!strings.HasPrefix(davPrefix, "/") || davPrefix+"/" == storageUrlPath) {
+		log.Fatal().Str("prefix", davPrefix).Msg("WEBDAV_PREFIX must start with / and differ from STORAGE_PATH_URL")
+	}
 
 	// Init
 	repo := repository.NewFileRepository(storagePath)
-	fileUC := usecase.NewFileUseCase(repo)
+	usageRepo, err := repository.NewUsageRepository(storagePath, filepath.Join(dataPath, "usage.json"))
+	if err != nil {
+		log.Fatal().Err(err).Msg("can't load storage usage")
+	}
+	repo.TrackUsage(usageRepo)
+	storageUsage := usecase.NewStorageUsage(usageRepo, usecase.StorageUsageConfig{
+		FlushInterval:     10 * time.Second,
+		ReconcileInterval: time.Duration(getEnvInt("USAGE_RECONCILE_HOURS", 24)) * time.Hour,
+		Pause:             time.Millisecond,
+	})
+	storageUsage.Start()
+	metrics.StorageSize(func() int64 { return usageRepo.Usage(storagePath).Size })
+	// наблюдатель подключается к репозиторию до первой записи через него
+	var storageWatcher *watcher.Watcher
+	if watchStorage != "off" {
+		w, err := watcher.New(storagePath, repo, usageRepo, watcher.Config{
+			Debounce:       500 * time.Millisecond,
+			RescanInterval: time.Duration(getEnvInt("WATCH_RESCAN_SEC", 60)) * time.Second,
+			Poll:           watchStorage == "poll",
+		})
+		if err != nil {
+			log.Fatal().Err(err).Msg("can't watch storage")
+		}
+		repo.Observe(w)
+		storageWatcher = w
+	}
+	historyRepo := repository.NewHistoryRepository(historyPath)
+	docKeyRepo, err := repository.NewDocKeyRepository(filepath.Join(dataPath, "dockeys.json"), 30*24*time.Hour)
+	if err != nil {
+		log.Fatal().Err(err).Msg("can't load document keys")
+	}
+	permissionRepo, err := repository.NewPermissionRepository(permissionsFile)
+	if err != nil {
+		log.Fatal().Err(err).Msg("can't load permissions")
+	}
+	lockRepo, err := repository.NewLockRepository(filepath.Join(dataPath, "locks.json"))
+	if err != nil {
+		log.Fatal().Err(err).Msg("can't load locks")
+	}
+	davPropRepo, err := repository.NewDavPropRepository(filepath.Join(dataPath, "davprops.json"))
+	if err != nil {
+		log.Fatal().Err(err).Msg("can't load webdav properties")
+	}
+	queueRepo, err := repository.NewQueueRepository(filepath.Join(dataPath, "queue"))
+	if err != nil {
+		log.Fatal().Err(err).Msg("can't open save queue")
+	}
+	auditRepo, err := repository.NewAuditRepository(auditPath, int64(getEnvInt("AUDIT_MAX_SIZE_MB", 100))<<20, getEnvInt("AUDIT_MAX_FILES", 10))
+	if err != nil {
+		log.Fatal().Err(err).Msg("can't open audit log")
+	}
+	defer auditRepo.Close()
+
+	fileUC := usecase.NewFileUseCase(repo, historyRepo)
+	sessions := usecase.NewSessions()
+	locks := usecase.NewLocks(lockRepo, sessions, docKeyRepo)
+	previewUC := usecase.NewPreviewUC(repo, int64(getEnvInt("PREVIEW_MAX_BYTES", 1<<20)))
+	changes := usecase.NewChanges()
+	auditUC := usecase.NewAuditUC(auditRepo, changes, getEnv("AUDIT_DOWNLOADS", "false") == "true")
+	var webhooks *usecase.Webhooks
+	if webhooksFile != "" {
+		webhookRepo, err := repository.NewWebhookRepository(webhooksFile)
+		if err != nil {
+			log.Fatal().Err(err).Msg("can't load webhooks")
+		}
+		outboxRepo, err := repository.NewWebhookOutboxRepository(filepath.Join(dataPath, "webhooks"))
+		if err != nil {
+			log.Fatal().Err(err).Msg("can't open webhook outbox")
+		}
+		webhooks = usecase.NewWebhooks(webhookRepo, outboxRepo, usecase.WebhooksConfig{
+			MaxAttempts: getEnvInt("WEBHOOK_MAX_ATTEMPTS", 10),
+			Backoff:     time.Duration(getEnvInt("WEBHOOK_BACKOFF_SEC", 5)) * time.Second,
+			MaxBackoff:  time.Hour,
+			Timeout:     time.Duration(getEnvInt("WEBHOOK_TIMEOUT_SEC", 10)) * time.Second,
+		})
+		webhooks.Start(changes)
+	}
 	infoUC := usecase.NewInfoService(version, commit, buildTime, port, repo)
-	editorUC := editor_usecase.NewEditorUsecase(jwtSecret, docServerUrl, docServerUrlInternal, fmt.Sprintf("http://%s:%s", hostname, port))
-	trackUC := usecase.NewTrackUC(repo, docServerUrl, docServerUrlInternal)
-	handler := custhttp.NewHandler(fileUC, infoUC, editorUC, trackUC, apiKey, storageUrlPath)
+	downloader := download.New(download.Config{
+		ConnectTimeout: time.Duration(getEnvInt("TRACK_CONNECT_TIMEOUT_SEC", 5)) * time.Second,
+		Timeout:        time.Duration(getEnvInt("TRACK_DOWNLOAD_TIMEOUT_SEC", 120)) * time.Second,
+		Retries:        getEnvInt("TRACK_DOWNLOAD_RETRIES", 3),
+		Backoff:        time.Second,
+		MaxSize:        int64(getEnvInt("TRACK_MAX_DOCUMENT_MB", 100)) << 20,
+		AllowedHosts:   trackAllowedHosts(docServerUrl, docServerUrlInternal),
+	})
+	editorUC := editor_usecase.NewEditorUsecase(repo, historyRepo, docKeyRepo, sessions, permissionRepo, locks, downloader, jwtSecret, docServerUrl, docServerUrlInternal, fmt.Sprintf("http://%s:%s", hostname, port), templatesPath)
+	saveQueue := usecase.NewSaveQueue(queueRepo, getEnvInt("SAVE_WORKERS", 4), getEnvInt("SAVE_RETRIES", 3), 5*time.Second)
+	trackUC := usecase.NewTrackUC(repo, historyRepo, docKeyRepo, sessions, locks, auditUC, downloader, saveQueue, jwtSecret, docServerUrl, docServerUrlInternal)
+	if err := saveQueue.Start(trackUC.ProcessSave, trackUC.FinishSave); err != nil {
+		log.Fatal().Err(err).Msg("can't load save queue")
+	}
+	convertJobs := usecase.NewConvertJobs(editorUC, time.Hour, getEnvInt("CONVERT_WORKERS", 2), getEnvInt("CONVERT_QUEUE", 100))
+	handler := custhttp.NewHandler(fileUC, infoUC, editorUC, trackUC, sessions, previewUC, auditUC, convertJobs, locks, apiKey, storageUrlPath)
+	if storageWatcher != nil {
+		storageWatcher.Start(handler.Notify)
+	}
+	rateLimit := handler.RateLimit(ratelimit.New(ratelimit.Config{
+		RequestsPerSecond: getEnvFloat("RATE_LIMIT_RPS", 0),
+		Burst:             getEnvInt("RATE_LIMIT_BURST", 0),
+		MaxDownloads:      getEnvInt("RATE_LIMIT_DOWNLOADS", 0),
+		MaxUploads:        getEnvInt("RATE_LIMIT_UPLOADS", 0),
+		BytesPerSecond:    getEnvInt("RATE_LIMIT_BANDWIDTH", 0),
+	}))
 
 	// Router
 	r := chi.NewRouter()
@@ -87,13 +214,57 @@ func main() {
 	// Metrics
 	r.Handle("/metrics", promhttp.Handler())
 
-	r.Get(storageUrlPath+"*", handler.ServeFile)
-	r.Post(storageUrlPath+"*", handler.Auth(http.HandlerFunc(handler.Upload)).ServeHTTP)
-	r.Head(storageUrlPath+"*", handler.ServeFile)
-	r.Options(storageUrlPath+"*", handler.ServeFileOptions)
+	// Admin
+	r.Get("/admin/audit", handler.AdminAuth(http.HandlerFunc(handler.AuditLog)).ServeHTTP)
+	r.Delete("/admin/locks", handler.AdminAuth(http.HandlerFunc(handler.BreakLock)).ServeHTTP)
+	r.Post("/admin/tokens", handler.AdminAuth(http.HandlerFunc(handler.IssueToken)).ServeHTTP)
+
+	// потоки изменений долгие, поэтому вне ограничения частоты запросов
+	events := custhttp.NewEvents(changes)
+	r.Get("/events", handler.Auth(http.HandlerFunc(events.SSE)).ServeHTTP)
+	r.Get("/events/ws", handler.Auth(http.HandlerFunc(events.WebSocket)).ServeHTTP)
+
+	if webhooks != nil {
+		webhookAdmin := custhttp.NewWebhookAdmin(webhooks)
+		r.Get("/admin/webhooks/dead", handler.AdminAuth(http.HandlerFunc(webhookAdmin.Dead)).ServeHTTP)
+		r.Post("/admin/webhooks/redeliver", handler.AdminAuth(http.HandlerFunc(webhookAdmin.Redeliver)).ServeHTTP)
+	}
 
-	r.Get("/edit", handler.Edit)
-	r.Post("/track", handler.Auth(http.HandlerFunc(handler.Track)).ServeHTTP)
+	r.Group(func(r chi.Router) {
+		r.Use(rateLimit.Requests)
+
+		r.Get(storageUrlPath+"*", rateLimit.Downloads(http.HandlerFunc(handler.ServeFile)).ServeHTTP)
+		r.Post(storageUrlPath+"*", handler.Auth(rateLimit.Uploads(http.HandlerFunc(handler.Upload))).ServeHTTP)
+		r.Head(storageUrlPath+"*", handler.ServeFile)
+		r.Options(storageUrlPath+"*", handler.ServeFileOptions)
+
+		r.Get("/manifest", handler.Auth(http.HandlerFunc(handler.Manifest)).ServeHTTP)
+		r.Get("/edit", handler.Edit)
+		r.Post("/create", handler.Auth(http.HandlerFunc(handler.Create)).ServeHTTP)
+		r.Get("/preview", handler.Preview)
+		r.Get("/sessions", handler.Sessions)
+		r.Get("/locks", handler.Auth(http.HandlerFunc(handler.Locks)).ServeHTTP)
+		r.Post("/locks", handler.Auth(http.HandlerFunc(handler.Lock)).ServeHTTP)
+		r.Delete("/locks", handler.Auth(http.HandlerFunc(handler.Unlock)).ServeHTTP)
+		r.Post("/convert", handler.Auth(http.HandlerFunc(handler.ConvertStart)).ServeHTTP)
+		r.Get("/convert/{id}", handler.Auth(http.HandlerFunc(handler.ConvertStatus)).ServeHTTP)
+		r.Get("/history", handler.EditorAuth(http.HandlerFunc(handler.History)).ServeHTTP)
+		r.Get("/history/data", handler.EditorAuth(http.HandlerFunc(handler.HistoryData)).ServeHTTP)
+		r.Post("/history/restore", handler.EditorAuth(http.HandlerFunc(handler.HistoryRestore)).ServeHTTP)
+		// ссылка подписана в HistoryData, ее запрашивает Document Server
+		r.Get("/history/file", handler.HistoryFile)
+		// JWT Document Server проверяется в TrackUC
+		r.Post("/track", handler.Track)
+
+		if davPrefix != "" {
+			for _, m := range webdav.Methods {
+				chi.RegisterMethod(m)
+			}
+			dav := rateLimit.Transfers(handler.WebDAV(webdav.New(davPrefix, storageUrlPath, repo, locks, davPropRepo)))
+			r.Handle(davPrefix, dav)
+			r.Handle(davPrefix+"/*", dav)
+		}
+	})
 
 	// Server
 	addr := ":" + port
@@ -101,6 +272,7 @@ func main() {
 		Addr:    addr,
 		Handler: r,
 	}
+	srv.RegisterOnShutdown(events.Close)
 
 	// Запуск сервера в горутине
 	go func() {
@@ -110,6 +282,77 @@ func main() {
 		}
 	}()
 
+	// S3 API на отдельном порту: клиенты S3 ждут бакеты в корне адреса
+	var s3Srv *http.Server
+	if s3Port != "" {
+		uploadRepo, err := repository.NewUploadRepository(filepath.Join(dataPath, "s3-uploads"), 24*time.Hour)
+		if err != nil {
+			log.Fatal().Err(err).Msg("can't open s3 uploads")
+		}
+
+		s3r := chi.NewRouter()
+		s3r.Use(middleware.RequestID)
+		s3r.Use(middleware.RealIP)
+		s3r.Use(middleware.Recoverer)
+		s3r.Use(handler.Metrics)
+		s3r.Use(rateLimit.Requests)
+		s3r.Handle("/*", rateLimit.Transfers(handler.S3(s3.New(getEnv("S3_ACCESS_KEY", "fileserver"), apiKey, fileUC, locks, uploadRepo))))
+
+		s3Srv = &http.Server{
+			Addr:    ":" + s3Port,
+			Handler: s3r,
+		}
+		go func() {
+			log.Info().Str("addr", s3Srv.Addr).Msg("starting s3 api")
+			if err := s3Srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatal().Err(err).Msg("s3 api failed")
+			}
+		}()
+	}
+
+	// gRPC API для внутренних сервисов на отдельном порту
+	var grpcSrv *custgrpc.Server
+	if grpcPort != "" {
+		grpcSrv = custgrpc.New(fileUC, locks, changes, apiKey, editorUC.VerifyAccessToken, handler.Notify)
+		l, err := net.Listen("tcp", ":"+grpcPort)
+		if err != nil {
+			log.Fatal().Err(err).Msg("can't listen grpc port")
+		}
+		go func() {
+			log.Info().Str("addr", l.Addr().String()).Msg("starting grpc server")
+			if err := grpcSrv.Serve(l); err != nil {
+				log.Fatal().Err(err).Msg("grpc server failed")
+			}
+		}()
+	}
+
+	// SFTP для партнеров на отдельном порту
+	var sftpSrv *sftp.Server
+	if sftpPort != "" {
+		sftpUsers, err := repository.NewSFTPUserRepository(getEnv("SFTP_USERS_FILE", ""))
+		if err != nil {
+			log.Fatal().Err(err).Msg("can't load sftp users")
+		}
+		hostKey, err := sftp.LoadHostKey(getEnv("SFTP_HOST_KEY", filepath.Join(dataPath, "sftp_host_key")))
+		if err != nil {
+			log.Fatal().Err(err).Msg("can't load sftp host key")
+		}
+		sftpSrv, err = sftp.New(hostKey, sftpUsers, repo, locks, filepath.Join(dataPath, "sftp-spool"), handler.Notify)
+		if err != nil {
+			log.Fatal().Err(err).Msg("can't init sftp")
+		}
+		l, err := net.Listen("tcp", ":"+sftpPort)
+		if err != nil {
+			log.Fatal().Err(err).Msg("can't listen sftp port")
+		}
+		go func() {
+			log.Info().Str("addr", l.Addr().String()).Msg("starting sftp server")
+			if err := sftpSrv.Serve(l); err != nil {
+				log.Fatal().Err(err).Msg("sftp server failed")
+			}
+		}()
+	}
+
 	// Ожидание сигнала завершения
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -125,6 +368,31 @@ func main() {
 		log.Error().Err(err).Msg("server forced shutdown")
 		return
 	}
+	if s3Srv != nil {
+		if err := s3Srv.Shutdown(ctx); err != nil {
+			log.Error().Err(err).Msg("s3 api forced shutdown")
+			return
+		}
+	}
+	if grpcSrv != nil {
+		if err := grpcSrv.Shutdown(ctx); err != nil {
+			log.Error().Err(err).Msg("grpc server forced shutdown")
+			return
+		}
+	}
+	// незавершенные загрузки SFTP отбрасываются, клиент повторит их
+	if sftpSrv != nil {
+		sftpSrv.Close()
+	}
+	// незавершенные сохранения останутся в очереди до следующего запуска
+	saveQueue.Stop()
+	if webhooks != nil {
+		webhooks.Stop()
+	}
+	if storageWatcher != nil {
+		storageWatcher.Stop()
+	}
+	storageUsage.Stop()
 
 	log.Info().Msg("server exited gracefully")
 }
@@ -137,6 +405,41 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
+func getEnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+
+	return value
+}
+
+func getEnvFloat(key string, fallback float64) float64 {
+	value, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil {
+		return fallback
+	}
+
+	return value
+}
+
+// trackAllowedHosts хосты, с которых TrackUC скачивает документы:
+// TRACK_ALLOWED_HOSTS через запятую, по умолчанию адреса Document Server
+func trackAllowedHosts(docServerUrl, docServerUrlInternal string) []string {
+	if hosts := getEnv("TRACK_ALLOWED_HOSTS", ""); hosts != "" {
+		return strings.Split(hosts, ",")
+	}
+
+	var hosts []string
+	if u, err := url.Parse(docServerUrl); err == nil && u.Host != "" {
+		hosts = append(hosts, u.Host)
+	}
+	if docServerUrlInternal != "" {
+		hosts = append(hosts, docServerUrlInternal)
+	}
+	return hosts
+}
+
 func storagePathUrl() string {
 	path := getEnv("STORAGE_PATH_URL", "/")
 	path, _ = url.JoinPath("/", path)
@@ -0,0 +1,496 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+	"github.com/AleksandrMac/fileserver/pkg/client"
+)
+
+// transfer результат передачи одного файла
+type transfer struct {
+	Remote string `json:"remote"`
+	Local  string `json:"local"`
+	Size   int64  `json:"size"`
+	// Skipped файл уже совпадает и не передавался
+	Skipped bool `json:"skipped,omitempty"`
+}
+
+// parse разбирает флаги команды и проверяет число аргументов
+func parse(fs *flag.FlagSet, args []string, minArgs, maxArgs int) error {
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() < minArgs || maxArgs >= 0 && fs.NArg() > maxArgs {
+		return errUsage
+	}
+	return nil
+}
+
+func (x *cli) ls(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("ls", flag.ContinueOnError)
+	long := fs.Bool("l", false, "")
+	if err := parse(fs, args, 0, 1); err != nil {
+		return err
+	}
+	dir := first(fs.Arg(0), x.cfg.StoragePrefix, "/")
+
+	files, err := x.client.List(ctx, dir)
+	if err != nil {
+		return err
+	}
+	return x.print(files, func(w io.Writer) {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+		for _, f := range files {
+			name := f.Name
+			if f.IsDir {
+				name += "/"
+			}
+			if !*long {
+				fmt.Fprintln(w, name)
+				continue
+			}
+			lock := ""
+			if f.Lock != nil {
+				lock = "locked by " + f.Lock.Owner
+			}
+			fmt.Fprintf(tw, "%s\t%s\t %s\t %s\n", formatSize(f.Size), f.ModTime.Local().Format(time.DateTime), name, lock)
+		}
+		tw.Flush()
+	})
+}
+
+func (x *cli) stat(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("stat", flag.ContinueOnError)
+	if err := parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+
+	meta, err := x.client.Stat(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return x.print(meta, func(w io.Writer) {
+		tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+		kind := "file"
+		if meta.IsDir {
+			kind = "directory"
+		}
+		fmt.Fprintf(tw, "path:\t%s\n", meta.Path)
+		fmt.Fprintf(tw, "type:\t%s\n", kind)
+		fmt.Fprintf(tw, "mode:\t%s\n", meta.Mode)
+		fmt.Fprintf(tw, "modified:\t%s\n", meta.ModTime.Local().Format(time.RFC3339))
+		if meta.IsDir {
+			fmt.Fprintf(tw, "children:\t%d\n", meta.Children)
+			fmt.Fprintf(tw, "size:\t%s (%d bytes, recursive)\n", formatSize(meta.RecursiveSize), meta.RecursiveSize)
+		} else {
+			fmt.Fprintf(tw, "size:\t%s (%d bytes)\n", formatSize(meta.Size), meta.Size)
+			fmt.Fprintf(tw, "mime:\t%s\n", meta.MIME)
+			fmt.Fprintf(tw, "sha256:\t%s\n", meta.SHA256)
+			fmt.Fprintf(tw, "versions:\t%d\n", meta.Versions)
+		}
+		if meta.Lock != nil {
+			fmt.Fprintf(tw, "lock:\t%s by %s%s\n", meta.Lock.Kind, meta.Lock.Owner, lockExpires(meta.Lock))
+		}
+		tw.Flush()
+	})
+}
+
+func lockExpires(lock *domain.Lock) string {
+	if lock.Expires.IsZero() {
+		return ""
+	}
+	return " until " + lock.Expires.Local().Format(time.DateTime)
+}
+
+func (x *cli) get(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("get", flag.ContinueOnError)
+	recursive := fs.Bool("r", false, "")
+	resume := fs.Bool("c", false, "")
+	if err := parse(fs, args, 1, 2); err != nil {
+		return err
+	}
+
+	meta, err := x.client.Stat(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	local := first(fs.Arg(1), meta.Name)
+
+	if meta.IsDir {
+		if !*recursive {
+			return fmt.Errorf("%s is a directory, use -r", meta.Path)
+		}
+		if local == "-" {
+			return errors.New("can't write a directory to stdout")
+		}
+		var done []transfer
+		if err := x.getDir(ctx, meta.Path, local, *resume, &done); err != nil {
+			return err
+		}
+		return x.print(done, func(io.Writer) {})
+	}
+
+	if local == "-" {
+		_, err := x.client.Download(ctx, meta.Path, x.stdout, 0, -1)
+		return err
+	}
+	if info, err := os.Stat(local); err == nil && info.IsDir() {
+		local = filepath.Join(local, meta.Name)
+	}
+	t, err := x.getFile(ctx, meta.Path, meta.Size, local, *resume)
+	if err != nil {
+		return err
+	}
+	return x.print(t, func(io.Writer) {})
+}
+
+func (x *cli) getDir(ctx context.Context, remote, local string, resume bool, done *[]transfer) error {
+	if err := os.MkdirAll(local, 0755); err != nil {
+		return err
+	}
+	files, err := x.client.List(ctx, remote)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		target := filepath.Join(local, f.Name)
+		if f.IsDir {
+			err = x.getDir(ctx, f.Path, target, resume, done)
+		} else {
+			var t transfer
+			t, err = x.getFile(ctx, f.Path, f.Size, target, resume)
+			*done = append(*done, t)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getFile скачивает файл, с resume — дописывает начатый локальный файл
+func (x *cli) getFile(ctx context.Context, remote string, size int64, local string, resume bool) (transfer, error) {
+	t := transfer{Remote: remote, Local: local, Size: size}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	var offset int64
+	if info, err := os.Stat(local); resume && err == nil && info.Size() <= size {
+		if info.Size() == size {
+			t.Skipped = true
+			return t, nil
+		}
+		offset = info.Size()
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+
+	f, err := os.OpenFile(local, flags, 0644)
+	if err != nil {
+		return t, err
+	}
+	p := newProgress(x.progress, path.Base(remote), size)
+	p.Skip(offset)
+	n, err := x.client.Download(ctx, remote, p.Writer(f), offset, -1)
+	p.Finish()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	t.Size = offset + n
+	return t, err
+}
+
+func (x *cli) put(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("put", flag.ContinueOnError)
+	recursive := fs.Bool("r", false, "")
+	if err := parse(fs, args, 2, 2); err != nil {
+		return err
+	}
+	local, remote := fs.Arg(0), fs.Arg(1)
+
+	info, err := os.Stat(local)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		if !*recursive {
+			return fmt.Errorf("%s is a directory, use -r", local)
+		}
+		var done []transfer
+		if err := x.putDir(ctx, local, remote, &done); err != nil {
+			return err
+		}
+		return x.print(done, func(io.Writer) {})
+	}
+
+	if strings.HasSuffix(remote, "/") {
+		remote = path.Join(remote, info.Name())
+	} else if meta, err := x.client.Stat(ctx, remote); err == nil && meta.IsDir {
+		remote = path.Join(remote, info.Name())
+	}
+	if err := x.putFile(ctx, local, remote, info.Size()); err != nil {
+		return err
+	}
+	return x.print(transfer{Remote: path.Clean("/" + remote), Local: local, Size: info.Size()}, func(io.Writer) {})
+}
+
+// putDir загружает каталог, пропуская файлы, которые уже есть на сервере
+// с тем же размером и SHA-256. Каталоги создаются через WebDAV.
+func (x *cli) putDir(ctx context.Context, local, remote string, done *[]transfer) error {
+	remote = path.Clean("/" + remote)
+	if err := x.client.Mkdir(ctx, remote); err != nil && !errors.Is(err, client.ErrExists) {
+		return err
+	}
+	files, err := x.client.List(ctx, remote)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]domain.FileInfo, len(files))
+	for _, f := range files {
+		existing[f.Name] = f
+	}
+
+	entries, err := os.ReadDir(local)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		lp, rp := filepath.Join(local, e.Name()), path.Join(remote, e.Name())
+		info, err := os.Stat(lp)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case info.IsDir():
+			err = x.putDir(ctx, lp, rp, done)
+		case info.Mode().IsRegular():
+			t := transfer{Remote: rp, Local: lp, Size: info.Size()}
+			if old, ok := existing[e.Name()]; ok && !old.IsDir && old.Size == info.Size() {
+				t.Skipped, err = x.unchanged(ctx, lp, rp)
+			}
+			if err == nil && !t.Skipped {
+				err = x.putFile(ctx, lp, rp, info.Size())
+			}
+			*done = append(*done, t)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unchanged файл на сервере совпадает с локальным по SHA-256
+func (x *cli) unchanged(ctx context.Context, local, remote string) (bool, error) {
+	meta, err := x.client.Stat(ctx, remote)
+	if err != nil || meta.SHA256 == "" {
+		return false, err
+	}
+
+	f, err := os.Open(local)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return false, err
+	}
+	return hex.EncodeToString(h.Sum(nil)) == meta.SHA256, nil
+}
+
+// putFile загружает файл потоком через WebDAV, без него — формой
+func (x *cli) putFile(ctx context.Context, local, remote string, size int64) error {
+	f, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	p := newProgress(x.progress, filepath.Base(local), size)
+	defer p.Finish()
+	if x.cfg.WebDAVPrefix != "" {
+		return x.client.Put(ctx, remote, p.Reader(f), size)
+	}
+	return x.client.Upload(ctx, path.Dir(path.Clean("/"+remote)), path.Base(remote), p.Reader(f))
+}
+
+func (x *cli) rm(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("rm", flag.ContinueOnError)
+	recursive := fs.Bool("r", false, "")
+	if err := parse(fs, args, 1, -1); err != nil {
+		return err
+	}
+
+	var deleted []string
+	for _, p := range fs.Args() {
+		meta, err := x.client.Stat(ctx, p)
+		if err != nil {
+			return err
+		}
+		if meta.IsDir && !*recursive {
+			return fmt.Errorf("%s is a directory, use -r", meta.Path)
+		}
+		if err := x.client.Delete(ctx, meta.Path); err != nil {
+			return err
+		}
+		deleted = append(deleted, meta.Path)
+	}
+	return x.print(deleted, func(io.Writer) {})
+}
+
+func (x *cli) mv(ctx context.Context, args []string) error {
+	return x.relocate(ctx, "mv", args, x.client.Move)
+}
+
+func (x *cli) cp(ctx context.Context, args []string) error {
+	return x.relocate(ctx, "cp", args, x.client.Copy)
+}
+
+// relocate mv или cp: в существующий каталог — под прежним именем
+func (x *cli) relocate(ctx context.Context, name string, args []string, do func(ctx context.Context, from, to string, overwrite bool) error) error {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	force := fs.Bool("f", false, "")
+	if err := parse(fs, args, 2, 2); err != nil {
+		return err
+	}
+	from, to := path.Clean("/"+fs.Arg(0)), path.Clean("/"+fs.Arg(1))
+
+	if meta, err := x.client.Stat(ctx, to); err == nil && meta.IsDir {
+		to = path.Join(to, path.Base(from))
+	}
+	if err := do(ctx, from, to, *force); err != nil {
+		return err
+	}
+	return x.print(map[string]string{"from": from, "to": to}, func(io.Writer) {})
+}
+
+func (x *cli) zipls(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("zipls", flag.ContinueOnError)
+	if err := parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+
+	files, err := x.client.ZipContents(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return x.print(files, func(w io.Writer) {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+		for _, f := range files {
+			fmt.Fprintf(tw, "%s\t%s\t %s\n", formatSize(f.Size), f.ModTime.Local().Format(time.DateTime), f.Name)
+		}
+		tw.Flush()
+	})
+}
+
+func (x *cli) share(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("share", flag.ContinueOnError)
+	expires := fs.Duration("expires", 24*time.Hour, "")
+	put := fs.Bool("put", false, "")
+	if err := parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+
+	presign, method := x.client.PresignGet, "GET"
+	if *put {
+		presign, method = x.client.PresignPut, "PUT"
+	}
+	u, err := presign(fs.Arg(0), *expires)
+	if err != nil {
+		return err
+	}
+	res := struct {
+		URL     string    `json:"url"`
+		Method  string    `json:"method"`
+		Expires time.Time `json:"expires"`
+	}{u, method, time.Now().Add(*expires).UTC().Truncate(time.Second)}
+	return x.print(res, func(w io.Writer) { fmt.Fprintln(w, u) })
+}
+
+func (x *cli) info(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("info", flag.ContinueOnError)
+	if err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+
+	info, err := x.client.Info(ctx)
+	if err != nil {
+		return err
+	}
+	return x.print(info, func(w io.Writer) {
+		fmt.Fprintf(w, "version: %s (%s, built %s)\n", info.Version, info.Commit, info.BuildTime)
+		if info.Storage != nil {
+			fmt.Fprintf(w, "storage: %d files, %s\n", info.Storage.TotalFiles, formatSize(info.Storage.TotalSize))
+		}
+	})
+}
+
+// patterns повторяемый флаг
+type patterns []string
+
+func (x *patterns) String() string     { return strings.Join(*x, ",") }
+func (x *patterns) Set(v string) error { *x = append(*x, v); return nil }
+
+func (x *cli) sync(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("sync", flag.ContinueOnError)
+	pull := fs.Bool("pull", false, "")
+	del := fs.Bool("delete", false, "")
+	dryRun := fs.Bool("dry-run", false, "")
+	state := fs.String("state", "", "")
+	var exclude patterns
+	fs.Var(&exclude, "exclude", "")
+	if err := parse(fs, args, 2, 2); err != nil {
+		return err
+	}
+	local, remote := fs.Arg(0), fs.Arg(1)
+
+	opts := client.SyncOptions{Delete: *del, DryRun: *dryRun, Exclude: exclude, StateFile: *state}
+	if *pull {
+		opts.Direction = client.Pull
+	}
+	if opts.StateFile == "" {
+		opts.StateFile = x.syncState(local, remote, *pull)
+	}
+	if !x.json {
+		opts.OnAction = func(a client.SyncAction) {
+			fmt.Fprintf(x.stdout, "%-8s %s\n", a.Op, path.Join(remote, a.Path))
+		}
+	}
+
+	report, err := x.client.Sync(ctx, local, remote, opts)
+	if err != nil {
+		return err
+	}
+	return x.print(report, func(w io.Writer) {
+		fmt.Fprintf(w, "%d changes, %d unchanged, %s transferred\n", len(report.Actions), report.Unchanged, formatSize(report.Bytes))
+	})
+}
+
+// syncState журнал синхронизации в кеше пользователя, свой для каждой пары
+// каталогов и направления. Пусто, если кеша нет.
+func (x *cli) syncState(local, remote string, pull bool) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	abs, err := filepath.Abs(local)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\n%s\n%s\n%t", x.cfg.URL, path.Clean("/"+remote), abs, pull)))
+	return filepath.Join(dir, "fsctl", "sync-"+hex.EncodeToString(sum[:8])+".json")
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/AleksandrMac/fileserver/internal/testenv"
+	"github.com/AleksandrMac/fileserver/pkg/client"
+)
+
+// testEnv сервер с REST под /files/, WebDAV под /dav и S3 API
+type testEnv struct {
+	storage string
+	flags   []string
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	base := testenv.New(t)
+	t.Setenv("FSCTL_CONFIG", filepath.Join(base.Data, "missing.json"))
+	url, s3URL := base.Serve(t, nil)
+
+	return &testEnv{
+		storage: base.Storage,
+		flags: []string{"-url", url, "-api-key", testenv.APIKey, "-storage-prefix", "/files/",
+			"-webdav-prefix", "/dav", "-s3-url", s3URL},
+	}
+}
+
+// run выполняет fsctl и возвращает stdout
+func (x *testEnv) run(t *testing.T, wantCode int, args ...string) string {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	if code := run(append(append([]string{}, x.flags...), args...), &stdout, &stderr); code != wantCode {
+		t.Fatalf("fsctl %s: exit %d, want %d: %s", strings.Join(args, " "), code, wantCode, stderr.String())
+	}
+	return stdout.String()
+}
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		full := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readFile(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// transferred пути переданных файлов из JSON put или get
+func transferred(t *testing.T, out string) []string {
+	t.Helper()
+	var res []transfer
+	if err := json.Unmarshal([]byte(out), &res); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	var paths []string
+	for _, r := range res {
+		if !r.Skipped {
+			paths = append(paths, r.Remote)
+		}
+	}
+	return paths
+}
+
+func TestPutGetSync(t *testing.T) {
+	env := newTestEnv(t)
+	local := t.TempDir()
+	writeFiles(t, local, map[string]string{
+		"a.txt":     "alpha",
+		"sub/b.txt": "bravo",
+	})
+
+	out := env.run(t, 0, "-json", "put", "-r", local, "/files/backup")
+	if got := strings.Join(transferred(t, out), ","); got != "/files/backup/a.txt,/files/backup/sub/b.txt" {
+		t.Errorf("first sync %s", got)
+	}
+	if got := readFile(t, filepath.Join(env.storage, "files", "backup", "sub", "b.txt")); got != "bravo" {
+		t.Errorf("uploaded %q", got)
+	}
+
+	// тот же размер, другое содержимое
+	writeFiles(t, local, map[string]string{"sub/b.txt": "BRAVO"})
+	out = env.run(t, 0, "-json", "put", "-r", local, "/files/backup")
+	if got := strings.Join(transferred(t, out), ","); got != "/files/backup/sub/b.txt" {
+		t.Errorf("second sync %s", got)
+	}
+	env.run(t, 1, "put", local, "/files/backup")
+
+	if got := env.run(t, 0, "ls", "/files/backup"); got != "a.txt\nsub/\n" {
+		t.Errorf("ls %q", got)
+	}
+
+	dst := filepath.Join(t.TempDir(), "restore")
+	env.run(t, 0, "get", "-r", "/files/backup", dst)
+	if got := readFile(t, filepath.Join(dst, "sub", "b.txt")); got != "BRAVO" {
+		t.Errorf("downloaded %q", got)
+	}
+	if got := env.run(t, 0, "get", "/files/backup/a.txt", "-"); got != "alpha" {
+		t.Errorf("stdout %q", got)
+	}
+	env.run(t, 1, "get", "/files/backup", dst)
+
+	// докачка дописывает начатый файл
+	if err := os.WriteFile(filepath.Join(dst, "a.txt"), []byte("al"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	env.run(t, 0, "get", "-c", "/files/backup/a.txt", dst)
+	if got := readFile(t, filepath.Join(dst, "a.txt")); got != "alpha" {
+		t.Errorf("resumed %q", got)
+	}
+}
+
+func TestPutFile(t *testing.T) {
+	env := newTestEnv(t)
+	local := filepath.Join(t.TempDir(), "report.txt")
+	writeFiles(t, filepath.Dir(local), map[string]string{"report.txt": "report"})
+
+	env.run(t, 0, "put", local, "/files/")
+	env.run(t, 0, "put", local, "/files/renamed.txt")
+	for _, name := range []string{"report.txt", "renamed.txt"} {
+		if got := readFile(t, filepath.Join(env.storage, "files", name)); got != "report" {
+			t.Errorf("%s: %q", name, got)
+		}
+	}
+
+	// без WebDAV файл загружается формой
+	env.flags = append(env.flags, "-webdav-prefix", "")
+	env.run(t, 0, "put", local, "/files/form.txt")
+	if got := readFile(t, filepath.Join(env.storage, "files", "form.txt")); got != "report" {
+		t.Errorf("form upload %q", got)
+	}
+}
+
+func TestManage(t *testing.T) {
+	env := newTestEnv(t)
+	writeFiles(t, filepath.Join(env.storage, "files"), map[string]string{
+		"a.txt":     "alpha",
+		"dir/b.txt": "bravo",
+	})
+
+	env.run(t, 0, "cp", "/files/a.txt", "/files/dir")
+	env.run(t, 1, "mv", "/files/a.txt", "/files/dir/a.txt")
+	env.run(t, 0, "mv", "-f", "/files/a.txt", "/files/c.txt")
+	if got := env.run(t, 0, "ls", "/files"); got != "c.txt\ndir/\n" {
+		t.Errorf("ls %q", got)
+	}
+
+	var meta struct {
+		Path   string `json:"path"`
+		SHA256 string `json:"sha256"`
+	}
+	if err := json.Unmarshal([]byte(env.run(t, 0, "-json", "stat", "/files/dir/a.txt")), &meta); err != nil {
+		t.Fatal(err)
+	}
+	if meta.Path != "/files/dir/a.txt" || meta.SHA256 == "" {
+		t.Errorf("stat %+v", meta)
+	}
+
+	env.run(t, 1, "rm", "/files/dir")
+	env.run(t, 0, "rm", "-r", "/files/dir", "/files/c.txt")
+	if got := env.run(t, 0, "ls"); got != "" {
+		t.Errorf("ls after rm %q", got)
+	}
+	env.run(t, 1, "stat", "/files/dir")
+	env.run(t, 2, "mv", "/files/a.txt")
+	env.run(t, 2, "unknown")
+}
+
+func TestShareAndInfo(t *testing.T) {
+	env := newTestEnv(t)
+	writeFiles(t, filepath.Join(env.storage, "files"), map[string]string{"a.txt": "alpha"})
+
+	u := strings.TrimSpace(env.run(t, 0, "share", "-expires", "1h", "/files/a.txt"))
+	resp, err := http.Get(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "alpha" {
+		t.Errorf("shared link: %d %q", resp.StatusCode, body)
+	}
+
+	if got := env.run(t, 0, "info"); !strings.Contains(got, "version: v1 (abc") || !strings.Contains(got, "storage: 1 files, 5 B") {
+		t.Errorf("info %q", got)
+	}
+}
+
+func TestSync(t *testing.T) {
+	env := newTestEnv(t)
+	local := t.TempDir()
+	state := filepath.Join(t.TempDir(), "state.json")
+	writeFiles(t, local, map[string]string{
+		"a.txt":     "alpha",
+		"sub/b.txt": "bravo",
+		"debug.log": "log",
+	})
+	writeFiles(t, filepath.Join(env.storage, "files"), map[string]string{"mirror/old.txt": "old"})
+
+	out := env.run(t, 0, "sync", "-dry-run", "-delete", "-exclude", "*.log", "-state", state, local, "/files/mirror")
+	want := "upload   /files/mirror/a.txt\nmkdir    /files/mirror/sub\nupload   /files/mirror/sub/b.txt\ndelete   /files/mirror/old.txt\n4 changes, 0 unchanged, 0 B transferred\n"
+	if out != want {
+		t.Errorf("dry run %q", out)
+	}
+	readFile(t, filepath.Join(env.storage, "files", "mirror", "old.txt"))
+
+	env.run(t, 0, "sync", "-delete", "-exclude", "*.log", "-state", state, local, "/files/mirror")
+	if got := env.run(t, 0, "ls", "/files/mirror"); got != "a.txt\nsub/\n" {
+		t.Errorf("ls %q", got)
+	}
+
+	var report client.SyncReport
+	out = env.run(t, 0, "-json", "sync", "-pull", "-state", state+".pull", filepath.Join(t.TempDir(), "copy"), "/files/mirror")
+	if err := json.Unmarshal([]byte(out), &report); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	if len(report.Actions) != 4 || report.Bytes != 10 {
+		t.Errorf("pull %+v", report)
+	}
+	env.run(t, 2, "sync", local)
+}
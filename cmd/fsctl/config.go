@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/AleksandrMac/fileserver/pkg/client"
+)
+
+// Profile адрес и ключи одного сервера. В файле профилей поля называются
+// так же, как переменные окружения без префикса FSCTL_ в нижнем регистре.
+type Profile struct {
+	URL           string `json:"url"`
+	APIKey        string `json:"api_key"`
+	StoragePrefix string `json:"storage_prefix"`
+	WebDAVPrefix  string `json:"webdav_prefix"`
+	S3URL         string `json:"s3_url"`
+	S3AccessKey   string `json:"s3_access_key"`
+}
+
+// options глобальные флаги
+type options struct {
+	Profile
+	profile string
+	config  string
+	json    bool
+	retries int
+}
+
+func (x *options) register(fs *flag.FlagSet) {
+	fs.StringVar(&x.URL, "url", "", "server URL, e.g. http://files:8080 (FSCTL_URL)")
+	fs.StringVar(&x.APIKey, "api-key", "", "API key for writes (FSCTL_API_KEY)")
+	fs.StringVar(&x.StoragePrefix, "storage-prefix", "", "STORAGE_PATH_URL of the server (FSCTL_STORAGE_PREFIX)")
+	fs.StringVar(&x.WebDAVPrefix, "webdav-prefix", "", "WEBDAV_PREFIX of the server (FSCTL_WEBDAV_PREFIX)")
+	fs.StringVar(&x.S3URL, "s3-url", "", "S3 API URL for share (FSCTL_S3_URL)")
+	fs.StringVar(&x.S3AccessKey, "s3-access-key", "", "S3_ACCESS_KEY of the server (FSCTL_S3_ACCESS_KEY)")
+	fs.StringVar(&x.profile, "profile", "", "profile name in the config file (FSCTL_PROFILE, default \"default\")")
+	fs.StringVar(&x.config, "config", "", "profiles file (FSCTL_CONFIG, default <user config dir>/fsctl/config.json)")
+	fs.BoolVar(&x.json, "json", false, "print results as JSON")
+	fs.IntVar(&x.retries, "retries", 3, "retries after network errors, 5xx and 429")
+}
+
+// clientConfig настройки клиента: флаги важнее переменных окружения,
+// переменные важнее профиля
+func (x *options) clientConfig(getenv func(string) string) (client.Config, error) {
+	name := first(x.profile, getenv("FSCTL_PROFILE"))
+	file := first(x.config, getenv("FSCTL_CONFIG"))
+	if file == "" {
+		if dir, err := os.UserConfigDir(); err == nil {
+			file = filepath.Join(dir, "fsctl", "config.json")
+		}
+	}
+
+	profile, err := loadProfile(file, name)
+	if err != nil {
+		return client.Config{}, err
+	}
+
+	cfg := client.Config{
+		URL:           first(x.URL, getenv("FSCTL_URL"), profile.URL),
+		APIKey:        first(x.APIKey, getenv("FSCTL_API_KEY"), profile.APIKey),
+		StoragePrefix: first(x.StoragePrefix, getenv("FSCTL_STORAGE_PREFIX"), profile.StoragePrefix),
+		WebDAVPrefix:  first(x.WebDAVPrefix, getenv("FSCTL_WEBDAV_PREFIX"), profile.WebDAVPrefix),
+		S3URL:         first(x.S3URL, getenv("FSCTL_S3_URL"), profile.S3URL),
+		S3AccessKey:   first(x.S3AccessKey, getenv("FSCTL_S3_ACCESS_KEY"), profile.S3AccessKey),
+		Retries:       x.retries,
+	}
+	if cfg.URL == "" {
+		return cfg, errors.New("server URL is not set: use -url, FSCTL_URL or a profile")
+	}
+	return cfg, nil
+}
+
+// loadProfile профиль name из файла. Нет файла — пустой профиль, если
+// профиль не запрошен явно.
+func loadProfile(file, name string) (Profile, error) {
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) && name == "" {
+		return Profile{}, nil
+	}
+	if err != nil {
+		return Profile{}, err
+	}
+
+	var profiles map[string]Profile
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return Profile{}, fmt.Errorf("%s: %w", file, err)
+	}
+	if name == "" {
+		return profiles["default"], nil
+	}
+	p, ok := profiles[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("%s: no profile %q", file, name)
+	}
+	return p, nil
+}
+
+// first первое непустое значение
+func first(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClientConfig(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(file, []byte(`{
+		"default": {"url": "http://default:8080", "api_key": "default-key", "webdav_prefix": "/dav"},
+		"prod": {"url": "http://prod:8080", "api_key": "prod-key"}
+	}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		opts    options
+		env     map[string]string
+		wantURL string
+		wantKey string
+		wantDAV string
+		wantErr bool
+	}{
+		{name: "default profile", opts: options{config: file}, wantURL: "http://default:8080", wantKey: "default-key", wantDAV: "/dav"},
+		{name: "named profile", opts: options{config: file, profile: "prod"}, wantURL: "http://prod:8080", wantKey: "prod-key"},
+		{name: "profile from env", opts: options{config: file}, env: map[string]string{"FSCTL_PROFILE": "prod"}, wantURL: "http://prod:8080", wantKey: "prod-key"},
+		{name: "env over profile", opts: options{config: file}, env: map[string]string{"FSCTL_API_KEY": "env-key"}, wantURL: "http://default:8080", wantKey: "env-key", wantDAV: "/dav"},
+		{name: "flag over env", opts: options{config: file, Profile: Profile{APIKey: "flag-key"}}, env: map[string]string{"FSCTL_API_KEY": "env-key"}, wantURL: "http://default:8080", wantKey: "flag-key", wantDAV: "/dav"},
+		{name: "unknown profile", opts: options{config: file, profile: "stage"}, wantErr: true},
+		{name: "no file", opts: options{config: file + ".missing"}, env: map[string]string{"FSCTL_URL": "http://env:8080"}, wantURL: "http://env:8080"},
+		{name: "no file with profile", opts: options{config: file + ".missing", profile: "prod"}, wantErr: true},
+		{name: "no url", opts: options{config: file + ".missing"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := tt.opts.clientConfig(func(key string) string { return tt.env[key] })
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if cfg.URL != tt.wantURL || cfg.APIKey != tt.wantKey || cfg.WebDAVPrefix != tt.wantDAV {
+				t.Errorf("got %s %s %s", cfg.URL, cfg.APIKey, cfg.WebDAVPrefix)
+			}
+		})
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1536, "1.5 KiB"},
+		{5 << 20, "5.0 MiB"},
+		{3 << 30, "3.0 GiB"},
+	}
+	for _, tt := range tests {
+		if got := formatSize(tt.n); got != tt.want {
+			t.Errorf("formatSize(%d) = %s, want %s", tt.n, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+// fsctl клиент файлового сервера для командной строки и скриптов
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+
+	"github.com/AleksandrMac/fileserver/pkg/client"
+)
+
+// errUsage неверные аргументы команды, выход с кодом 2
+var errUsage = errors.New("usage")
+
+type command struct {
+	args    string
+	summary string
+	run     func(x *cli, ctx context.Context, args []string) error
+}
+
+var commands = map[string]command{
+	"ls":    {"[-l] [path]", "list a directory", (*cli).ls},
+	"stat":  {"path", "show file or directory metadata", (*cli).stat},
+	"get":   {"[-r] [-c] remote [local|-]", "download a file or, with -r, a directory", (*cli).get},
+	"put":   {"[-r] local remote", "upload a file or, with -r, sync a directory", (*cli).put},
+	"rm":    {"[-r] path...", "delete files or, with -r, directories", (*cli).rm},
+	"mv":    {"[-f] from to", "move or rename", (*cli).mv},
+	"cp":    {"[-f] from to", "copy", (*cli).cp},
+	"zipls": {"path", "list files in a zip archive", (*cli).zipls},
+	"sync":  {"[-pull] [-delete] local remote", "mirror a directory to or, with -pull, from the server", (*cli).sync},
+	"share": {"[-expires 24h] [-put] path", "print a presigned URL", (*cli).share},
+	"info":  {"", "show server version and storage size", (*cli).info},
+}
+
+// cli выполняет команды одним клиентом
+type cli struct {
+	client   *client.Client
+	cfg      client.Config
+	json     bool
+	progress bool
+	stdout   io.Writer
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	var opts options
+	fs := flag.NewFlagSet("fsctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	opts.register(fs)
+	fs.Usage = func() { usage(fs, stderr) }
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	name := fs.Arg(0)
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(stderr, "fsctl: unknown command %q\n", name)
+		fs.Usage()
+		return 2
+	}
+
+	cfg, err := opts.clientConfig(os.Getenv)
+	if err != nil {
+		fmt.Fprintln(stderr, "fsctl:", err)
+		return 1
+	}
+	c, err := client.New(cfg)
+	if err != nil {
+		fmt.Fprintln(stderr, "fsctl:", err)
+		return 1
+	}
+	x := &cli{client: c, cfg: cfg, json: opts.json, progress: !opts.json, stdout: stdout}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err = cmd.run(x, ctx, fs.Args()[1:])
+	switch {
+	case errors.Is(err, errUsage):
+		fmt.Fprintf(stderr, "usage: fsctl [flags] %s %s\n", name, cmd.args)
+		return 2
+	case err != nil:
+		fmt.Fprintf(stderr, "fsctl %s: %v\n", name, err)
+		return 1
+	}
+	return 0
+}
+
+func usage(fs *flag.FlagSet, w io.Writer) {
+	fmt.Fprintln(w, "usage: fsctl [flags] <command> [args]")
+	fmt.Fprintln(w, "\ncommands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-6s %-28s %s\n", name, commands[name].args, commands[name].summary)
+	}
+	fmt.Fprintln(w, "\nflags:")
+	fs.PrintDefaults()
+}
+
+// print выводит v как JSON или текстом text
+func (x *cli) print(v any, text func(w io.Writer)) error {
+	if x.json {
+		enc := json.NewEncoder(x.stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	text(x.stdout)
+	return nil
+}
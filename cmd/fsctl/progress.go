@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// minProgressSize файлы меньше передаются без индикатора
+	minProgressSize = 1 << 20
+	progressWidth   = 30
+	progressEvery   = 200 * time.Millisecond
+)
+
+// progress индикатор передачи в одну строку stderr. Считает байты,
+// прошедшие через Reader или Writer.
+type progress struct {
+	out   io.Writer
+	name  string
+	total int64
+	start time.Time
+
+	mu    sync.Mutex
+	done  int64
+	drawn time.Time
+}
+
+// newProgress индикатор для передачи size байт (-1 — размер неизвестен).
+// Без терминала и для малых файлов возвращает nil: все методы nil работают
+// как сквозные.
+func newProgress(enabled bool, name string, size int64) *progress {
+	if !enabled || size >= 0 && size < minProgressSize || !isTerminal(os.Stderr) {
+		return nil
+	}
+	return &progress{out: os.Stderr, name: name, total: size, start: time.Now()}
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Skip учитывает уже переданное, например при докачке
+func (x *progress) Skip(n int64) {
+	if x == nil {
+		return
+	}
+	x.add(n)
+}
+
+func (x *progress) Reader(r io.Reader) io.Reader {
+	if x == nil {
+		return r
+	}
+	return &progressReader{r: r, p: x}
+}
+
+func (x *progress) Writer(w io.Writer) io.Writer {
+	if x == nil {
+		return w
+	}
+	return io.MultiWriter(w, writerFunc(func(p []byte) (int, error) {
+		x.add(int64(len(p)))
+		return len(p), nil
+	}))
+}
+
+// Finish дорисовывает индикатор и переводит строку
+func (x *progress) Finish() {
+	if x == nil {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.draw()
+	fmt.Fprintln(x.out)
+}
+
+func (x *progress) add(n int64) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.done += n
+	if time.Since(x.drawn) >= progressEvery {
+		x.draw()
+	}
+}
+
+func (x *progress) draw() {
+	x.drawn = time.Now()
+	speed := float64(x.done) / time.Since(x.start).Seconds()
+
+	if x.total <= 0 {
+		fmt.Fprintf(x.out, "\r%s  %s  %s/s ", x.name, formatSize(x.done), formatSize(int64(speed)))
+		return
+	}
+	filled := int(x.done * progressWidth / x.total)
+	filled = min(filled, progressWidth)
+	fmt.Fprintf(x.out, "\r%s [%s%s] %3d%%  %s / %s  %s/s ",
+		x.name,
+		strings.Repeat("=", filled), strings.Repeat(" ", progressWidth-filled),
+		x.done*100/x.total,
+		formatSize(x.done), formatSize(x.total), formatSize(int64(speed)))
+}
+
+type progressReader struct {
+	r io.Reader
+	p *progress
+}
+
+func (x *progressReader) Read(b []byte) (int, error) {
+	n, err := x.r.Read(b)
+	x.p.add(int64(n))
+	return n, err
+}
+
+// Seek нужен клиенту, чтобы повторить запрос с тем же телом
+func (x *progressReader) Seek(offset int64, whence int) (int64, error) {
+	s, ok := x.r.(io.Seeker)
+	if !ok {
+		return 0, fmt.Errorf("progress: %T can't seek", x.r)
+	}
+	pos, err := s.Seek(offset, whence)
+	if err == nil {
+		x.p.mu.Lock()
+		x.p.done = pos
+		x.p.mu.Unlock()
+	}
+	return pos, err
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+// formatSize размер в двоичных единицах: 512 B, 1.5 MiB
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,442 @@
+package grpc
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	fileserverv1 "github.com/AleksandrMac/fileserver/api/fileserver/v1"
+	"github.com/AleksandrMac/fileserver/internal/domain"
+	"github.com/AleksandrMac/fileserver/pkg/hashreader"
+)
+
+const (
+	// размер сообщения Download
+	chunkSize = 64 << 10
+	// временные файлы SaveFile, клиенту не показываются
+	tempPrefix = ".tmp_"
+)
+
+var errChecksum = status.Error(codes.DataLoss, "sha256 mismatch")
+
+func (x *Server) Stat(ctx context.Context, req *fileserverv1.StatRequest) (*fileserverv1.FileInfo, error) {
+	rel, _, err := x.resolve(req.Path)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := x.files.Meta(rel)
+	if err != nil {
+		return nil, fail(err)
+	}
+
+	info := fileInfo(&meta.FileInfo)
+	info.Sha256 = meta.SHA256
+	info.Mime = meta.MIME
+	return info, nil
+}
+
+func (x *Server) List(ctx context.Context, req *fileserverv1.ListRequest) (*fileserverv1.ListResponse, error) {
+	_, full, err := x.resolve(req.Path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := x.stat(full, true); err != nil {
+		return nil, err
+	}
+
+	entries, err := x.files.List(full)
+	if err != nil {
+		return nil, fail(err)
+	}
+	resp := &fileserverv1.ListResponse{Entries: make([]*fileserverv1.FileInfo, 0, len(entries))}
+	for i := range entries {
+		if !strings.HasPrefix(entries[i].Name, tempPrefix) {
+			resp.Entries = append(resp.Entries, fileInfo(&entries[i]))
+		}
+	}
+	return resp, nil
+}
+
+func (x *Server) Download(req *fileserverv1.DownloadRequest, stream fileserverv1.FileService_DownloadServer) error {
+	rel, full, err := x.resolve(req.Path)
+	if err != nil {
+		return err
+	}
+	info, err := x.stat(full, false)
+	if err != nil {
+		return err
+	}
+	if req.Offset < 0 || req.Offset > info.Size() || req.Limit < 0 {
+		return status.Error(codes.OutOfRange, "offset or limit out of range")
+	}
+	limit := info.Size() - req.Offset
+	if req.Limit > 0 && req.Limit < limit {
+		limit = req.Limit
+	}
+
+	f, err := x.files.ReadFile(full)
+	if err != nil {
+		return fail(err)
+	}
+	defer f.Close()
+	if req.Offset > 0 {
+		if _, err := f.(io.Seeker).Seek(req.Offset, io.SeekStart); err != nil {
+			return fail(err)
+		}
+	}
+
+	msg := &fileserverv1.DownloadResponse{Info: osFileInfo(rel, info)}
+	buf := make([]byte, chunkSize)
+	r := io.LimitReader(f, limit)
+	var sent int64
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 || msg.Info != nil {
+			msg.Data = buf[:n]
+			if err := stream.Send(msg); err != nil {
+				return err
+			}
+			msg.Info = nil
+			sent += int64(n)
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return fail(err)
+		}
+	}
+
+	x.record(stream.Context(), domain.AuditRecord{
+		Action: domain.AuditDownload,
+		Path:   rel,
+		Size:   sent,
+	})
+	return nil
+}
+
+func (x *Server) Upload(stream fileserverv1.FileService_UploadServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	header := first.GetHeader()
+	if header == nil {
+		return status.Error(codes.InvalidArgument, "first message must be a header")
+	}
+	if header.Sha256 != "" {
+		if b, err := hex.DecodeString(header.Sha256); err != nil || len(b) != 32 {
+			return status.Error(codes.InvalidArgument, "sha256 must be 64 hex digits")
+		}
+	}
+
+	rel, full, err := x.resolve(header.Path)
+	if err != nil {
+		return err
+	}
+	if err := x.writable(rel, header.LockToken); err != nil {
+		return err
+	}
+	if _, err := x.stat(filepath.Dir(full), true); err != nil {
+		return err
+	}
+	old, err := x.files.FileInfo(full)
+	if err != nil {
+		return fail(err)
+	}
+	if old != nil && old.IsDir() {
+		return status.Error(codes.FailedPrecondition, "path is a directory")
+	}
+	if old != nil && !header.Overwrite {
+		return status.Error(codes.AlreadyExists, "file exists")
+	}
+
+	hr := hashreader.New(&uploadReader{stream: stream})
+	if err := x.files.SaveFile(full, &checkedReader{hr: hr, want: strings.ToLower(header.Sha256)}); err != nil {
+		return fail(err)
+	}
+
+	action := domain.AuditUpload
+	if old != nil {
+		action = domain.AuditOverwrite
+	}
+	x.record(stream.Context(), domain.AuditRecord{
+		Action: action,
+		Path:   rel,
+		Size:   hr.Size(),
+		SHA256: hr.Sum(),
+	})
+
+	info, err := x.stat(full, false)
+	if err != nil {
+		return err
+	}
+	resp := &fileserverv1.UploadResponse{Info: osFileInfo(rel, info), Created: old == nil}
+	resp.Info.Sha256 = hr.Sum()
+	return stream.SendAndClose(resp)
+}
+
+func (x *Server) Delete(ctx context.Context, req *fileserverv1.DeleteRequest) (*fileserverv1.DeleteResponse, error) {
+	rel, full, err := x.resolve(req.Path)
+	if err != nil {
+		return nil, err
+	}
+	if err := x.writable(rel, req.LockToken); err != nil {
+		return nil, err
+	}
+	info, err := x.files.FileInfo(full)
+	if err != nil {
+		return nil, fail(err)
+	}
+	if info == nil {
+		return nil, status.Error(codes.NotFound, "not found")
+	}
+
+	size := info.Size()
+	if info.IsDir() {
+		entries, err := x.files.List(full)
+		if err != nil {
+			return nil, fail(err)
+		}
+		if len(entries) > 0 && !req.Recursive {
+			return nil, status.Error(codes.FailedPrecondition, "directory is not empty")
+		}
+		meta, err := x.files.Meta(rel)
+		if err != nil {
+			return nil, fail(err)
+		}
+		size = meta.Size
+	}
+
+	if err := x.files.Remove(full); err != nil {
+		return nil, fail(err)
+	}
+	x.record(ctx, domain.AuditRecord{
+		Action: domain.AuditDelete,
+		Path:   rel,
+		Size:   size,
+	})
+	return &fileserverv1.DeleteResponse{}, nil
+}
+
+func (x *Server) Move(ctx context.Context, req *fileserverv1.MoveRequest) (*fileserverv1.MoveResponse, error) {
+	fromRel, fromFull, err := x.resolve(req.From)
+	if err != nil {
+		return nil, err
+	}
+	toRel, toFull, err := x.resolve(req.To)
+	if err != nil {
+		return nil, err
+	}
+	if fromRel == toRel || strings.HasPrefix(toRel, fromRel+"/") {
+		return nil, status.Error(codes.InvalidArgument, "can't move into itself")
+	}
+	if err := x.writable(fromRel, req.LockToken); err != nil {
+		return nil, err
+	}
+	if err := x.writable(toRel, req.LockToken); err != nil {
+		return nil, err
+	}
+
+	info, err := x.files.FileInfo(fromFull)
+	if err != nil {
+		return nil, fail(err)
+	}
+	if info == nil {
+		return nil, status.Error(codes.NotFound, "not found")
+	}
+	if _, err := x.stat(filepath.Dir(toFull), true); err != nil {
+		return nil, err
+	}
+	target, err := x.files.FileInfo(toFull)
+	if err != nil {
+		return nil, fail(err)
+	}
+	if target != nil {
+		// заменить можно только файл файлом
+		if target.IsDir() || info.IsDir() {
+			return nil, status.Error(codes.FailedPrecondition, "target exists and is not replaceable")
+		}
+		if !req.Overwrite {
+			return nil, status.Error(codes.AlreadyExists, "target exists")
+		}
+	}
+
+	if err := x.files.Rename(fromFull, toFull); err != nil {
+		return nil, fail(err)
+	}
+	x.record(ctx, domain.AuditRecord{
+		Action: domain.AuditMove,
+		Path:   toRel,
+		From:   fromRel,
+	})
+
+	moved, err := x.stat(toFull, info.IsDir())
+	if err != nil {
+		return nil, err
+	}
+	return &fileserverv1.MoveResponse{Info: osFileInfo(toRel, moved)}, nil
+}
+
+func (x *Server) Watch(req *fileserverv1.WatchRequest, stream fileserverv1.FileService_WatchServer) error {
+	rel, _, err := x.resolve(req.Prefix)
+	if err != nil {
+		return err
+	}
+	changes, cancel := x.changes.Subscribe(rel)
+	defer cancel()
+	if err := stream.SendHeader(metadata.MD{}); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-x.done:
+			return status.Error(codes.Unavailable, "server is shutting down")
+		case rec, ok := <-changes:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "client is too slow, watch again")
+			}
+			err := stream.Send(&fileserverv1.WatchResponse{Event: &fileserverv1.Event{
+				Action:    string(rec.Action),
+				Path:      rec.Path,
+				From:      rec.From,
+				Size:      rec.Size,
+				Sha256:    rec.SHA256,
+				Principal: rec.Principal,
+				Time:      timestamppb.New(rec.Time),
+			}})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// resolve путь клиента в путь относительно хранилища и полный путь
+func (x *Server) resolve(p string) (rel, full string, err error) {
+	rel = path.Clean("/" + p)
+	if strings.HasPrefix(path.Base(rel), tempPrefix) {
+		return "", "", status.Error(codes.InvalidArgument, "invalid path")
+	}
+	full, err = x.files.GetFullPath(rel)
+	if err != nil {
+		return "", "", status.Error(codes.InvalidArgument, "invalid path")
+	}
+	return rel, full, nil
+}
+
+// writable запись в rel возможна: это не корень хранилища и нет чужой блокировки
+func (x *Server) writable(rel, token string) error {
+	if rel == "/" {
+		return status.Error(codes.PermissionDenied, "storage root can't be changed")
+	}
+	if _, err := x.locks.CheckWrite(rel, token); err != nil {
+		return status.Error(codes.FailedPrecondition, "locked")
+	}
+	return nil
+}
+
+// stat информация о существующем файле или, при dir, каталоге
+func (x *Server) stat(full string, dir bool) (os.FileInfo, error) {
+	info, err := x.files.FileInfo(full)
+	if err != nil {
+		return nil, fail(err)
+	}
+	if info == nil {
+		return nil, status.Error(codes.NotFound, "not found")
+	}
+	if info.IsDir() != dir {
+		if dir {
+			return nil, status.Error(codes.FailedPrecondition, "not a directory")
+		}
+		return nil, status.Error(codes.FailedPrecondition, "path is a directory")
+	}
+	return info, nil
+}
+
+// fail статус для ошибки usecase или файловой системы
+func fail(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	switch {
+	case errors.Is(err, domain.ErrNotFound), errors.Is(err, os.ErrNotExist):
+		return status.Error(codes.NotFound, "not found")
+	case errors.Is(err, domain.ErrInvalid):
+		return status.Error(codes.InvalidArgument, "invalid path")
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	}
+	log.Error().Err(err).Msg("grpc call failed")
+	return status.Error(codes.Internal, "internal error")
+}
+
+func fileInfo(fi *domain.FileInfo) *fileserverv1.FileInfo {
+	return &fileserverv1.FileInfo{
+		Path:    fi.Path,
+		Name:    fi.Name,
+		Size:    fi.Size,
+		ModTime: timestamppb.New(fi.ModTime),
+		IsDir:   fi.IsDir,
+	}
+}
+
+func osFileInfo(rel string, fi os.FileInfo) *fileserverv1.FileInfo {
+	return &fileserverv1.FileInfo{
+		Path:    rel,
+		Name:    fi.Name(),
+		Size:    fi.Size(),
+		ModTime: timestamppb.New(fi.ModTime()),
+		IsDir:   fi.IsDir(),
+	}
+}
+
+// uploadReader данные из сообщений Upload после заголовка
+type uploadReader struct {
+	stream fileserverv1.FileService_UploadServer
+	buf    []byte
+}
+
+func (x *uploadReader) Read(p []byte) (int, error) {
+	for len(x.buf) == 0 {
+		msg, err := x.stream.Recv()
+		if err != nil {
+			return 0, err
+		}
+		if msg.GetHeader() != nil {
+			return 0, status.Error(codes.InvalidArgument, "header must be sent once")
+		}
+		x.buf = msg.GetData()
+	}
+	n := copy(p, x.buf)
+	x.buf = x.buf[n:]
+	return n, nil
+}
+
+// checkedReader сверяет sha256 в конце данных, до замены файла
+type checkedReader struct {
+	hr   *hashreader.Reader
+	want string
+}
+
+func (x *checkedReader) Read(p []byte) (int, error) {
+	n, err := x.hr.Read(p)
+	if err == io.EOF && x.want != "" && x.hr.Sum() != x.want {
+		return n, errChecksum
+	}
+	return n, err
+}
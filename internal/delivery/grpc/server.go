@@ -0,0 +1,181 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	fileserverv1 "github.com/AleksandrMac/fileserver/api/fileserver/v1"
+	"github.com/AleksandrMac/fileserver/internal/domain"
+	"github.com/AleksandrMac/fileserver/internal/interfaces"
+	"github.com/AleksandrMac/fileserver/internal/metrics"
+)
+
+type ctxKey int
+
+const principalKey ctxKey = iota
+
+const (
+	principalAPIKey    = "api-key"
+	principalUser      = "user:"
+	principalAnonymous = "anonymous"
+)
+
+// API-ключ или токен доступа нужен всем методам FileService, health открыт для проб
+var authService = "/" + fileserverv1.FileService_ServiceDesc.ServiceName + "/"
+
+// Server gRPC API поверх FileUsecase со службой health. Блокировки, размер
+// хранилища и аудит общие с REST, лимиты скорости не применяются.
+type Server struct {
+	fileserverv1.UnimplementedFileServiceServer
+
+	files   interfaces.FileUsecase
+	locks   interfaces.LockUsecase
+	changes interfaces.ChangeFeed
+	apiKey  string
+	verify  func(token string) (userId string, ok bool)
+	notify  func(rec domain.AuditRecord)
+
+	grpc   *grpc.Server
+	health *health.Server
+	// done закрывается при остановке, чтобы завершить Watch
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// New gRPC-сервер. notify вызывается после каждого изменения хранилища,
+// скачивания и неудачной авторизации.
+func New(files interfaces.FileUsecase, locks interfaces.LockUsecase, changes interfaces.ChangeFeed, apiKey string,
+	verify func(token string) (userId string, ok bool), notify func(rec domain.AuditRecord)) *Server {
+	x := &Server{
+		files:   files,
+		locks:   locks,
+		changes: changes,
+		apiKey:  apiKey,
+		verify:  verify,
+		notify:  notify,
+		health:  health.NewServer(),
+		done:    make(chan struct{}),
+	}
+	x.grpc = grpc.NewServer(
+		grpc.ChainUnaryInterceptor(x.unaryInterceptor),
+		grpc.ChainStreamInterceptor(x.streamInterceptor),
+	)
+	fileserverv1.RegisterFileServiceServer(x.grpc, x)
+	healthpb.RegisterHealthServer(x.grpc, x.health)
+	x.health.SetServingStatus(fileserverv1.FileService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	return x
+}
+
+func (x *Server) Serve(l net.Listener) error {
+	return x.grpc.Serve(l)
+}
+
+// Shutdown переводит health в NOT_SERVING, завершает Watch и ждет остальные
+// вызовы до отмены ctx
+func (x *Server) Shutdown(ctx context.Context) error {
+	x.health.Shutdown()
+	x.stopOnce.Do(func() { close(x.done) })
+
+	stopped := make(chan struct{})
+	go func() {
+		x.grpc.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		x.grpc.Stop()
+		return ctx.Err()
+	}
+}
+
+func (x *Server) unaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	ctx, err := x.authenticate(ctx, info.FullMethod)
+	var resp any
+	if err == nil {
+		resp, err = handler(ctx, req)
+	}
+	observe(info.FullMethod, start, err)
+	return resp, err
+}
+
+func (x *Server) streamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	ctx, err := x.authenticate(ss.Context(), info.FullMethod)
+	if err == nil {
+		err = handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+	observe(info.FullMethod, start, err)
+	return err
+}
+
+// authenticate проверяет API-ключ из метаданных x-api-key или токен доступа
+// из authorization: Bearer, как REST, и запоминает субъекта
+func (x *Server) authenticate(ctx context.Context, method string) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if keys := md.Get("x-api-key"); len(keys) > 0 && keys[0] == x.apiKey {
+		return context.WithValue(ctx, principalKey, principalAPIKey), nil
+	}
+	if auth := md.Get("authorization"); len(auth) > 0 && strings.HasPrefix(auth[0], "Bearer ") {
+		if userId, ok := x.verify(strings.TrimPrefix(auth[0], "Bearer ")); ok {
+			return context.WithValue(ctx, principalKey, principalUser+userId), nil
+		}
+	}
+	if !strings.HasPrefix(method, authService) {
+		return context.WithValue(ctx, principalKey, principalAnonymous), nil
+	}
+
+	x.record(ctx, domain.AuditRecord{
+		Action: domain.AuditAuthFailure,
+		Path:   method,
+		Reason: "no valid api key or access token",
+	})
+	return ctx, status.Error(codes.Unauthenticated, "api key or access token required")
+}
+
+// record дополняет запись субъектом и адресом клиента
+func (x *Server) record(ctx context.Context, rec domain.AuditRecord) {
+	rec.Principal = principalAnonymous
+	if p, ok := ctx.Value(principalKey).(string); ok {
+		rec.Principal = p
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		rec.ClientIP, _, _ = net.SplitHostPort(p.Addr.String())
+	}
+	x.notify(rec)
+}
+
+func observe(method string, start time.Time, err error) {
+	code := status.Code(err)
+	metrics.RequesCount.WithLabelValues("GRPC", method, code.String()).Inc()
+
+	log.Info().
+		Str("method", method).
+		Str("code", code.String()).
+		Dur("duration", time.Since(start)).
+		Msg("grpc call completed")
+}
+
+// serverStream поток с контекстом после авторизации
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (x *serverStream) Context() context.Context {
+	return x.ctx
+}
@@ -0,0 +1,391 @@
+package grpc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	fileserverv1 "github.com/AleksandrMac/fileserver/api/fileserver/v1"
+	"github.com/AleksandrMac/fileserver/internal/domain"
+	"github.com/AleksandrMac/fileserver/internal/testenv"
+	"github.com/AleksandrMac/fileserver/internal/usecase"
+	editor_usecase "github.com/AleksandrMac/fileserver/internal/usecase/editor"
+)
+
+type testEnv struct {
+	storage string
+	server  *Server
+	client  fileserverv1.FileServiceClient
+	conn    *grpc.ClientConn
+	locks   *usecase.Locks
+	editor  *editor_usecase.EditorUsecase
+
+	mu      sync.Mutex
+	actions []string
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	base := testenv.New(t)
+	env := &testEnv{storage: base.Storage, locks: base.Locks, editor: base.Editor}
+	changes := usecase.NewChanges()
+
+	// аудит как у Handler.Notify: изменения попадают и в рассылку
+	env.server = New(base.Files, env.locks, changes, testenv.APIKey, base.Editor.VerifyAccessToken, func(rec domain.AuditRecord) {
+		env.mu.Lock()
+		env.actions = append(env.actions, string(rec.Action)+" "+rec.Principal+" "+rec.Path)
+		env.mu.Unlock()
+		if rec.Time.IsZero() {
+			rec.Time = time.Now()
+		}
+		if rec.Action.Change() {
+			changes.Publish(&rec)
+		}
+	})
+
+	l := bufconn.Listen(1 << 20)
+	go env.server.Serve(l)
+	var err error
+	env.conn, err = grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return l.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.client = fileserverv1.NewFileServiceClient(env.conn)
+	t.Cleanup(func() {
+		env.conn.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		env.server.Shutdown(ctx)
+	})
+	return env
+}
+
+func (x *testEnv) takeActions() []string {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	actions := x.actions
+	x.actions = nil
+	return actions
+}
+
+func authorized() context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "x-api-key", testenv.APIKey)
+}
+
+func (x *testEnv) upload(ctx context.Context, header *fileserverv1.UploadHeader, chunks ...string) (*fileserverv1.UploadResponse, error) {
+	stream, err := x.client.Upload(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.Send(&fileserverv1.UploadRequest{Payload: &fileserverv1.UploadRequest_Header{Header: header}}); err != nil {
+		return nil, err
+	}
+	for _, c := range chunks {
+		if err := stream.Send(&fileserverv1.UploadRequest{Payload: &fileserverv1.UploadRequest_Data{Data: []byte(c)}}); err != nil {
+			break
+		}
+	}
+	return stream.CloseAndRecv()
+}
+
+func (x *testEnv) download(req *fileserverv1.DownloadRequest) (string, error) {
+	stream, err := x.client.Download(authorized(), req)
+	if err != nil {
+		return "", err
+	}
+	var data []byte
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			return string(data), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		data = append(data, msg.Data...)
+	}
+}
+
+func hexSHA256(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestAuth(t *testing.T) {
+	env := newTestEnv(t)
+	if err := os.WriteFile(filepath.Join(env.storage, "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := env.client.Stat(authorized(), &fileserverv1.StatRequest{Path: "/a.txt"}); err != nil {
+		t.Errorf("stat with key: %v", err)
+	}
+	_, err := env.client.Stat(context.Background(), &fileserverv1.StatRequest{Path: "/a.txt"})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("stat without key: %v, want Unauthenticated", err)
+	}
+	_, err = env.client.Delete(context.Background(), &fileserverv1.DeleteRequest{Path: "/a.txt"})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("delete without key: %v, want Unauthenticated", err)
+	}
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-api-key", "wrong")
+	_, err = env.upload(ctx, &fileserverv1.UploadHeader{Path: "/b.txt"}, "b")
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("upload with wrong key: %v, want Unauthenticated", err)
+	}
+
+	// токен доступа, как у REST
+	token, err := env.editor.GenerateAccessToken("ivanov", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx = metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+	if _, err := env.upload(ctx, &fileserverv1.UploadHeader{Path: "/c.txt"}, "c"); err != nil {
+		t.Errorf("upload with access token: %v", err)
+	}
+	ctx = metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token+"x")
+	_, err = env.client.Stat(ctx, &fileserverv1.StatRequest{Path: "/a.txt"})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("stat with wrong token: %v, want Unauthenticated", err)
+	}
+
+	want := []string{
+		"auth_failure anonymous " + fileserverv1.FileService_Stat_FullMethodName,
+		"auth_failure anonymous " + fileserverv1.FileService_Delete_FullMethodName,
+		"auth_failure anonymous " + fileserverv1.FileService_Upload_FullMethodName,
+		"upload user:ivanov /c.txt",
+		"auth_failure anonymous " + fileserverv1.FileService_Stat_FullMethodName,
+	}
+	if got := env.takeActions(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("actions = %q, want %q", got, want)
+	}
+
+	health, err := healthpb.NewHealthClient(env.conn).Check(context.Background(), &healthpb.HealthCheckRequest{
+		Service: fileserverv1.FileService_ServiceDesc.ServiceName,
+	})
+	if err != nil || health.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("health = %v, %v", health, err)
+	}
+}
+
+func TestUpload(t *testing.T) {
+	env := newTestEnv(t)
+	if err := os.Mkdir(filepath.Join(env.storage, "docs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		header  *fileserverv1.UploadHeader
+		chunks  []string
+		want    codes.Code
+		created bool
+	}{
+		{name: "new file", header: &fileserverv1.UploadHeader{Path: "/docs/a.txt", Sha256: hexSHA256("hello world")}, chunks: []string{"hello ", "world"}, created: true},
+		{name: "exists", header: &fileserverv1.UploadHeader{Path: "/docs/a.txt"}, chunks: []string{"x"}, want: codes.AlreadyExists},
+		{name: "checksum mismatch", header: &fileserverv1.UploadHeader{Path: "/docs/a.txt", Sha256: hexSHA256("other"), Overwrite: true}, chunks: []string{"changed"}, want: codes.DataLoss},
+		{name: "bad checksum", header: &fileserverv1.UploadHeader{Path: "/docs/a.txt", Sha256: "abc", Overwrite: true}, want: codes.InvalidArgument},
+		{name: "overwrite", header: &fileserverv1.UploadHeader{Path: "/docs/a.txt", Overwrite: true}, chunks: []string{"hi"}},
+		{name: "no parent", header: &fileserverv1.UploadHeader{Path: "/missing/a.txt"}, chunks: []string{"x"}, want: codes.NotFound},
+		{name: "directory", header: &fileserverv1.UploadHeader{Path: "/docs", Overwrite: true}, want: codes.FailedPrecondition},
+		{name: "temp file", header: &fileserverv1.UploadHeader{Path: "/docs/.tmp_123"}, want: codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := env.upload(authorized(), tt.header, tt.chunks...)
+			if status.Code(err) != tt.want {
+				t.Fatalf("upload error = %v, want %v", err, tt.want)
+			}
+			if err == nil && (resp.Created != tt.created || resp.Info.Sha256 != hexSHA256(strings.Join(tt.chunks, ""))) {
+				t.Errorf("response = %v", resp)
+			}
+		})
+	}
+
+	data, err := os.ReadFile(filepath.Join(env.storage, "docs", "a.txt"))
+	if err != nil || string(data) != "hi" {
+		t.Errorf("stored = %q, %v", data, err)
+	}
+	entries, _ := os.ReadDir(filepath.Join(env.storage, "docs"))
+	if len(entries) != 1 {
+		t.Errorf("docs has %d entries, temporary files are left", len(entries))
+	}
+
+	// блокированный файл не перезаписывается без токена
+	lock, err := env.locks.Lock("/docs/a.txt", "editor", "", false, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := &fileserverv1.UploadHeader{Path: "/docs/a.txt", Overwrite: true}
+	if _, err := env.upload(authorized(), header, "x"); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("upload of a locked file: %v, want FailedPrecondition", err)
+	}
+	header.LockToken = lock.Token
+	if _, err := env.upload(authorized(), header, "x"); err != nil {
+		t.Errorf("upload with lock token: %v", err)
+	}
+}
+
+func TestFiles(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := authorized()
+	if err := os.Mkdir(filepath.Join(env.storage, "in"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	content := strings.Repeat("0123456789", 20000)
+	if _, err := env.upload(ctx, &fileserverv1.UploadHeader{Path: "/in/big.txt"}, content); err != nil {
+		t.Fatal(err)
+	}
+
+	stat, err := env.client.Stat(ctx, &fileserverv1.StatRequest{Path: "/in/big.txt"})
+	if err != nil || stat.Size != int64(len(content)) || stat.Sha256 != hexSHA256(content) || stat.IsDir {
+		t.Errorf("stat = %v, %v", stat, err)
+	}
+	if _, err := env.client.Stat(ctx, &fileserverv1.StatRequest{Path: "/in/none"}); status.Code(err) != codes.NotFound {
+		t.Errorf("stat of a missing file: %v", err)
+	}
+
+	downloads := []struct {
+		name   string
+		offset int64
+		limit  int64
+		want   string
+		code   codes.Code
+	}{
+		{name: "whole", want: content},
+		{name: "offset", offset: 199990, want: "0123456789"},
+		{name: "offset and limit", offset: 5, limit: 3, want: "567"},
+		{name: "at end", offset: int64(len(content))},
+		{name: "past end", offset: int64(len(content)) + 1, code: codes.OutOfRange},
+	}
+	for _, tt := range downloads {
+		got, err := env.download(&fileserverv1.DownloadRequest{Path: "/in/big.txt", Offset: tt.offset, Limit: tt.limit})
+		if status.Code(err) != tt.code || got != tt.want {
+			t.Errorf("%s: download = %d bytes, %v", tt.name, len(got), err)
+		}
+	}
+
+	if _, err := env.client.Move(ctx, &fileserverv1.MoveRequest{From: "/in/big.txt", To: "/in/sub/big.txt"}); status.Code(err) != codes.NotFound {
+		t.Errorf("move to a missing directory: %v", err)
+	}
+	moved, err := env.client.Move(ctx, &fileserverv1.MoveRequest{From: "/in/big.txt", To: "/big.txt"})
+	if err != nil || moved.Info.Path != "/big.txt" {
+		t.Fatalf("move = %v, %v", moved, err)
+	}
+	if _, err := env.client.Move(ctx, &fileserverv1.MoveRequest{From: "/in", To: "/in/x"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("move into itself: %v", err)
+	}
+
+	list, err := env.client.List(ctx, &fileserverv1.ListRequest{Path: "/"})
+	if err != nil || len(list.Entries) != 2 || list.Entries[0].Path != "/big.txt" || !list.Entries[1].IsDir {
+		t.Errorf("list = %v, %v", list, err)
+	}
+
+	if _, err := env.upload(ctx, &fileserverv1.UploadHeader{Path: "/in/a.txt"}, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.client.Delete(ctx, &fileserverv1.DeleteRequest{Path: "/in"}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("delete of a non-empty directory: %v", err)
+	}
+	if _, err := env.client.Delete(ctx, &fileserverv1.DeleteRequest{Path: "/in", Recursive: true}); err != nil {
+		t.Error(err)
+	}
+	if _, err := env.client.Delete(ctx, &fileserverv1.DeleteRequest{Path: "/", Recursive: true}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("delete of the root: %v", err)
+	}
+
+	want := []string{
+		"upload api-key /in/big.txt",
+		"download api-key /in/big.txt",
+		"download api-key /in/big.txt",
+		"download api-key /in/big.txt",
+		"download api-key /in/big.txt",
+		"move api-key /big.txt",
+		"upload api-key /in/a.txt",
+		"delete api-key /in",
+	}
+	if got := env.takeActions(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("actions:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestWatch(t *testing.T) {
+	env := newTestEnv(t)
+	if err := os.Mkdir(filepath.Join(env.storage, "docs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(authorized())
+	defer cancel()
+	stream, err := env.client.Watch(ctx, &fileserverv1.WatchRequest{Prefix: "/docs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Header(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := env.upload(authorized(), &fileserverv1.UploadHeader{Path: "/other.txt"}, "x"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.upload(authorized(), &fileserverv1.UploadHeader{Path: "/docs/a.txt"}, "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.client.Move(authorized(), &fileserverv1.MoveRequest{From: "/docs/a.txt", To: "/a.txt"}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"upload /docs/a.txt  3", "move /a.txt /docs/a.txt 0"}
+	for _, w := range want {
+		msg, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := msg.Event
+		if got := strings.Join([]string{e.Action, e.Path, e.From, strconv.FormatInt(e.Size, 10)}, " "); got != w {
+			t.Errorf("event = %q, want %q", got, w)
+		}
+		if e.Principal != "api-key" || e.Time == nil {
+			t.Errorf("event = %v", e)
+		}
+	}
+
+	// ошибка авторизации приходит при чтении потока
+	anonymous, err := env.client.Watch(context.Background(), &fileserverv1.WatchRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := anonymous.Recv(); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("watch without key: %v", err)
+	}
+
+	// остановка сервера завершает Watch
+	shutdown, cancelShutdown := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelShutdown()
+	if err := env.server.Shutdown(shutdown); err != nil {
+		t.Errorf("shutdown: %v", err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.Unavailable {
+		t.Errorf("watch after shutdown: %v", err)
+	}
+}
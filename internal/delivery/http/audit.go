@@ -0,0 +1,56 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+)
+
+// AuditLog возвращает записи журнала аудита.
+// Параметры: path (префикс), principal, action, from/to (RFC3339), limit.
+func (h *Handler) AuditLog(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := &domain.AuditFilter{
+		Path:      q.Get("path"),
+		Principal: q.Get("principal"),
+		Action:    domain.AuditAction(q.Get("action")),
+		Limit:     1000,
+	}
+
+	var err error
+	if v := q.Get("from"); v != "" {
+		if filter.From, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "Invalid 'from'", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if filter.To, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "Invalid 'to'", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit < 0 {
+			http.Error(w, "Invalid 'limit'", http.StatusBadRequest)
+			return
+		}
+	}
+
+	records, err := h.auditUC.Query(filter)
+	if err != nil {
+		log.Error().Err(err).Msg("failed query audit log")
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(records); err != nil {
+		log.Warn().Err(err).Msg("failed to encode audit response")
+	}
+}
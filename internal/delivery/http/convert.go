@@ -0,0 +1,65 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+)
+
+// ConvertStart запускает конвертацию с сохранением результата рядом с исходным файлом.
+// Тело: {"path": "/docs/report.docx", "outputtype": "pdf"}, ответ 202 с задачей.
+func (h *Handler) ConvertStart(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Path       string `json:"path"`
+		OutputType string `json:"outputtype"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Path == "" || req.OutputType == "" {
+		http.Error(w, "path and outputtype are required", http.StatusBadRequest)
+		return
+	}
+
+	// результат задачи попадает в журнал от имени того, кто ее запустил
+	who, ip, reqId := principal(r), clientIP(r), middleware.GetReqID(r.Context())
+
+	job, err := h.convertJobs.Start(req.Path, req.OutputType, func(job domain.ConvertJob) {
+		if job.Status != domain.ConvertDone {
+			return
+		}
+		h.auditUC.Record(&domain.AuditRecord{
+			Action:    domain.AuditConvert,
+			Principal: who,
+			ClientIP:  ip,
+			RequestID: reqId,
+			Path:      job.Result,
+			Size:      job.Size,
+			Reason:    "converted from " + job.Path,
+		})
+	})
+	if errors.Is(err, domain.ErrConvertBusy) {
+		w.Header().Set("Retry-After", "10")
+		http.Error(w, "Conversion queue is full", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/convert/"+job.Id)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// ConvertStatus состояние задачи конвертации
+func (h *Handler) ConvertStatus(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.convertJobs.Get(chi.URLParam(r, "id"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
@@ -0,0 +1,75 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+)
+
+// Create создает пустой документ в каталоге dir.
+// Тело: {"type": "text", "dir": "/docs", "template": "letter"}, type — text,
+// spreadsheet или presentation. Поля, которых нет в теле, берутся из ?type=,
+// ?dir= и ?template=, тело можно не передавать. Ответ 201 с путем документа,
+// Location — страница редактора. Это POST, а не ссылка GET: документ не должен
+// появляться от предзагрузки или обхода ссылок.
+func (h *Handler) Create(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Type     string `json:"type"`
+		Dir      string `json:"dir"`
+		Template string `json:"template"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	query := r.URL.Query()
+	if req.Type == "" {
+		req.Type = query.Get("type")
+	}
+	if req.Dir == "" {
+		req.Dir = query.Get("dir")
+	}
+	if req.Template == "" {
+		req.Template = query.Get("template")
+	}
+	if req.Dir == "" {
+		req.Dir = h.urlPrefix
+	}
+
+	path, size, err := h.editorUC.CreateDocument(req.Dir, req.Type, req.Template)
+	switch {
+	case errors.Is(err, domain.ErrInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, domain.ErrNotFound):
+		http.NotFound(w, r)
+		return
+	case errors.Is(err, domain.ErrLocked):
+		locked(w, nil)
+		return
+	case err != nil:
+		log.Error().Err(err).Str("dir", req.Dir).Msg("failed create document")
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	h.audit(r, domain.AuditRecord{
+		Action: domain.AuditCreate,
+		Path:   path,
+		Size:   size,
+	})
+	log.Info().Str("path", path).Msg("document created")
+
+	edit := url.Values{}
+	edit.Set("file", path)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Location", "/edit?"+edit.Encode())
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]any{"path": path, "size": size})
+}
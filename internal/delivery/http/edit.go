@@ -1,10 +1,14 @@
 package http
 
 import (
+	"errors"
 	"net/http"
 	"strings"
 
 	"github.com/rs/zerolog/log"
+	"golang.org/x/text/language"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
 )
 
 func (h Handler) Edit(w http.ResponseWriter, r *http.Request) {
@@ -14,18 +18,60 @@ func (h Handler) Edit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	username := r.URL.Query().Get("username")
-	if username == "" {
-		username = "Аноним"
+	err := h.editorUC.EditHtml(w, h.editorRequest(r, filename))
+	if errors.Is(err, domain.ErrNotFound) {
+		http.NotFound(w, r)
+		return
 	}
-
-	userId := r.URL.Query().Get("userId")
-	if userId == "" {
-		userId = "9999"
+	if errors.Is(err, domain.ErrForbidden) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
 	}
-
-	err := h.editorUC.EditHtml(w, username, userId, filename)
 	if err != nil {
 		log.Error().Err(err).Msg("failed build editor page")
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+	}
+}
+
+// anonymousUser пользователь редактора без токена доступа. Правила users
+// в PERMISSIONS_FILE к нему не применяются, если не заданы для этого id явно.
+const anonymousUser = "anonymous"
+
+// editorRequest пользователь OnlyOffice из токена доступа (?token= или
+// Authorization), имя для показа из username, язык интерфейса из Accept-Language
+func (h Handler) editorRequest(r *http.Request, file string) *domain.EditorRequest {
+	req := &domain.EditorRequest{
+		File:     file,
+		UserId:   anonymousUser,
+		UserName: r.URL.Query().Get("username"),
+		Lang:     editorLang(r.Header.Get("Accept-Language")),
+		ViewOnly: r.URL.Query().Get("mode") == "view",
 	}
+
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		token = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	if userId, ok := h.editorUC.VerifyAccessToken(token); ok {
+		req.UserId = userId
+		if req.UserName == "" {
+			req.UserName = userId
+		}
+	}
+	if req.UserName == "" {
+		req.UserName = "Аноним"
+	}
+
+	return req
+}
+
+// editorLang первый язык из Accept-Language, по умолчанию ru
+func editorLang(accept string) string {
+	tags, _, err := language.ParseAcceptLanguage(accept)
+	if err != nil || len(tags) == 0 {
+		return "ru"
+	}
+
+	base, _ := tags[0].Base()
+	return base.String()
 }
@@ -0,0 +1,258 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/websocket"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+	"github.com/AleksandrMac/fileserver/internal/interfaces"
+)
+
+// интервал комментариев SSE, чтобы прокси не закрывали тихое соединение
+const eventsHeartbeat = 30 * time.Second
+
+// Events поток изменений хранилища через SSE и WebSocket
+type Events struct {
+	changes interfaces.ChangeFeed
+	done    chan struct{}
+	once    sync.Once
+}
+
+func NewEvents(changes interfaces.ChangeFeed) *Events {
+	return &Events{
+		changes: changes,
+		done:    make(chan struct{}),
+	}
+}
+
+// Close завершает открытые потоки, иначе остановка сервера ждет их до таймаута
+func (x *Events) Close() {
+	x.once.Do(func() { close(x.done) })
+}
+
+// eventMessage событие для клиента. Action reset значит, что часть событий
+// пропущена и состояние нужно перечитать.
+type eventMessage struct {
+	ID        uint64     `json:"id,omitempty"`
+	Action    string     `json:"action"`
+	Path      string     `json:"path,omitempty"`
+	From      string     `json:"from,omitempty"`
+	Size      int64      `json:"size,omitempty"`
+	SHA256    string     `json:"sha256,omitempty"`
+	Principal string     `json:"principal,omitempty"`
+	Time      *time.Time `json:"time,omitempty"`
+}
+
+func newEventMessage(ev *domain.ChangeEvent) eventMessage {
+	return eventMessage{
+		ID:        ev.ID,
+		Action:    string(ev.Action),
+		Path:      ev.Path,
+		From:      ev.From,
+		Size:      ev.Size,
+		SHA256:    ev.SHA256,
+		Principal: ev.Principal,
+		Time:      &ev.Time,
+	}
+}
+
+// eventSubscription подписка по параметрам запроса
+type eventSubscription struct {
+	changes <-chan domain.ChangeEvent
+	cancel  func()
+	actions map[domain.AuditAction]bool
+	// reset события после Last-Event-ID уже не хранятся
+	reset bool
+}
+
+func (x *eventSubscription) match(ev *domain.ChangeEvent) bool {
+	return len(x.actions) == 0 || x.actions[ev.Action]
+}
+
+// subscribe разбирает ?path=, ?type= через запятую и номер последнего
+// полученного события из Last-Event-ID или ?after=
+func (x *Events) subscribe(r *http.Request) (*eventSubscription, error) {
+	q := r.URL.Query()
+	prefix := q.Get("path")
+	if strings.Contains(prefix, "..") {
+		return nil, errors.New("invalid path")
+	}
+	prefix = path.Clean("/" + prefix)
+
+	sub := &eventSubscription{actions: map[domain.AuditAction]bool{}}
+	if types := q.Get("type"); types != "" {
+		for _, t := range strings.Split(types, ",") {
+			action := domain.AuditAction(strings.TrimSpace(t))
+			if !action.Change() {
+				return nil, fmt.Errorf("unknown event type %q", t)
+			}
+			sub.actions[action] = true
+		}
+	}
+
+	last := r.Header.Get("Last-Event-ID")
+	if last == "" {
+		last = q.Get("after")
+	}
+	if last == "" {
+		sub.changes, sub.cancel = x.changes.Subscribe(prefix)
+		return sub, nil
+	}
+	after, err := strconv.ParseUint(last, 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid event id")
+	}
+	sub.changes, sub.cancel, err = x.changes.Since(prefix, after)
+	if errors.Is(err, domain.ErrNotFound) {
+		sub.changes, sub.cancel = x.changes.Subscribe(prefix)
+		sub.reset = true
+		return sub, nil
+	}
+	return sub, err
+}
+
+// SSE отдает изменения как text/event-stream. Медленный клиент отключается
+// и при переподключении с Last-Event-ID получает пропущенное из истории.
+func (x *Events) SSE(w http.ResponseWriter, r *http.Request) {
+	sub, err := x.subscribe(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer sub.cancel()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(event string, msg eventMessage) error {
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		if msg.ID != 0 {
+			fmt.Fprintf(w, "id: %d\n", msg.ID)
+		}
+		if event != "" {
+			fmt.Fprintf(w, "event: %s\n", event)
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	if sub.reset {
+		if err := send("reset", eventMessage{Action: "reset"}); err != nil {
+			return
+		}
+	} else if _, err := io.WriteString(w, ": subscribed\n\n"); err != nil || rc.Flush() != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-x.done:
+			return
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil || rc.Flush() != nil {
+				return
+			}
+		case ev, ok := <-sub.changes:
+			if !ok {
+				log.Warn().Str("client", clientIP(r)).Msg("event stream client is too slow, disconnected")
+				return
+			}
+			if !sub.match(&ev) {
+				continue
+			}
+			if err := send("", newEventMessage(&ev)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// WebSocket отдает изменения JSON-сообщениями. Параметры те же, что у SSE,
+// номер последнего события передается в ?after=.
+func (x *Events) WebSocket(w http.ResponseWriter, r *http.Request) {
+	sub, err := x.subscribe(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer sub.cancel()
+
+	srv := websocket.Server{
+		// браузер с сохраненным паролем Basic не должен подключаться со
+		// сторонней страницы, клиенты без Origin пропускаются
+		Handshake: func(cfg *websocket.Config, r *http.Request) error {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				return nil
+			}
+			u, err := url.Parse(origin)
+			if err != nil || u.Host != r.Host {
+				return errors.New("cross-origin request")
+			}
+			return nil
+		},
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+			x.streamWebSocket(ws, sub)
+		},
+	}
+	srv.ServeHTTP(w, r)
+}
+
+func (x *Events) streamWebSocket(ws *websocket.Conn, sub *eventSubscription) {
+	// чтение нужно, чтобы заметить закрытие соединения клиентом
+	closed := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, ws)
+		close(closed)
+	}()
+
+	if sub.reset {
+		if err := websocket.JSON.Send(ws, eventMessage{Action: "reset"}); err != nil {
+			return
+		}
+	}
+	for {
+		select {
+		case <-closed:
+			return
+		case <-x.done:
+			return
+		case ev, ok := <-sub.changes:
+			if !ok {
+				log.Warn().Str("client", ws.Request().RemoteAddr).Msg("event stream client is too slow, disconnected")
+				return
+			}
+			if !sub.match(&ev) {
+				continue
+			}
+			if err := websocket.JSON.Send(ws, newEventMessage(&ev)); err != nil {
+				return
+			}
+		}
+	}
+}
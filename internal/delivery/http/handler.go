@@ -1,13 +1,17 @@
 package http
 
 import (
+	"bufio"
 	"encoding/json"
+	"errors"
+	"net"
 	"net/http"
+	"strings"
 
 	"github.com/rs/zerolog/log"
 
+	"github.com/AleksandrMac/fileserver/internal/domain"
 	"github.com/AleksandrMac/fileserver/internal/interfaces"
-	"github.com/AleksandrMac/fileserver/internal/metrics"
 )
 
 type Handler struct {
@@ -15,8 +19,12 @@ type Handler struct {
 	infoServiceUC interfaces.InfoServiceInterface
 	editorUC      interfaces.EditorUsecase
 	trackUC       interfaces.TrackUsecase
+	sessions      interfaces.SessionRegistry
+	previewUC     interfaces.PreviewUsecase
+	auditUC       interfaces.AuditUsecase
+	convertJobs   interfaces.ConvertJobs
+	locks         interfaces.LockUsecase
 	apiKey        string
-	storageSize   int64
 	urlPrefix     string
 }
 
@@ -25,26 +33,26 @@ func NewHandler(
 	infoService interfaces.InfoServiceInterface,
 	editor interfaces.EditorUsecase,
 	track interfaces.TrackUsecase,
+	sessions interfaces.SessionRegistry,
+	preview interfaces.PreviewUsecase,
+	audit interfaces.AuditUsecase,
+	convertJobs interfaces.ConvertJobs,
+	locks interfaces.LockUsecase,
 	apiKey,
 	urlPrefix string,
 ) *Handler {
-
-	storage, err := usecase.GetStorageInfo()
-	if err != nil {
-		log.Fatal().Err(err).Msg("failed to calculate initial storage size")
-	}
-
-	log.Info().Int64("bytes", storage.TotalSize).Msg("initial storage size calculated")
-	metrics.TotalStorageSize.Set(float64(storage.TotalSize))
-
 	return &Handler{
 		fileUC:        usecase,
 		infoServiceUC: infoService,
 		editorUC:      editor,
 		apiKey:        apiKey,
-		storageSize:   storage.TotalSize,
 		urlPrefix:     urlPrefix,
 		trackUC:       track,
+		sessions:      sessions,
+		previewUC:     preview,
+		auditUC:       audit,
+		convertJobs:   convertJobs,
+		locks:         locks,
 	}
 }
 
@@ -60,7 +68,13 @@ func (h *Handler) Ready(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("OK"))
 }
 
+// Info возвращает информацию о сервисе, а с параметром path — метаданные файла
 func (x *Handler) Info(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Has("path") {
+		x.FileMeta(w, r)
+		return
+	}
+
 	info := x.infoServiceUC.GetInfo()
 
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
@@ -78,3 +92,44 @@ func (rw *responseWriterWrapper) WriteHeader(code int) {
 	rw.statusCode = code
 	rw.ResponseWriter.WriteHeader(code)
 }
+
+// Unwrap для http.ResponseController: потоку событий нужен Flush
+func (rw *responseWriterWrapper) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// Hijack для WebSocket, который проверяет http.Hijacker напрямую
+func (rw *responseWriterWrapper) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	rw.statusCode = http.StatusSwitchingProtocols
+	return http.NewResponseController(rw.ResponseWriter).Hijack()
+}
+
+// FileMeta метаданные файла или каталога ?path=
+func (x *Handler) FileMeta(w http.ResponseWriter, r *http.Request) {
+	relPath := r.URL.Query().Get("path")
+	if relPath == "" || strings.Contains(relPath, "..") {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+
+	meta, err := x.fileUC.Meta(relPath)
+	switch {
+	case errors.Is(err, domain.ErrInvalid):
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	case errors.Is(err, domain.ErrNotFound):
+		http.NotFound(w, r)
+		return
+	case err != nil:
+		log.Error().Err(err).Str("path", relPath).Msg("failed get file meta")
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	meta.Lock = x.locks.Active(meta.Path)
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(meta); err != nil {
+		log.Warn().Err(err).Msg("failed to encode file meta response")
+	}
+}
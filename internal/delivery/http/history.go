@@ -0,0 +1,129 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/rs/zerolog/log"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+	"github.com/AleksandrMac/fileserver/pkg/uerror/logwrapper"
+)
+
+// History список версий для панели истории редактора (onRequestHistory).
+// Документ и пользователь берутся из токена редактора в Authorization.
+func (h *Handler) History(w http.ResponseWriter, r *http.Request) {
+	key, _, ok := h.editorSession(w, r)
+	if !ok {
+		return
+	}
+
+	history, err := h.editorUC.History(key)
+	if err != nil {
+		historyError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(history)
+}
+
+// HistoryData данные версии ?version=n для setHistoryData (onRequestHistoryData)
+func (h *Handler) HistoryData(w http.ResponseWriter, r *http.Request) {
+	key, _, ok := h.editorSession(w, r)
+	if !ok {
+		return
+	}
+
+	version, err := strconv.Atoi(r.URL.Query().Get("version"))
+	if err != nil {
+		http.Error(w, "Invalid version", http.StatusBadRequest)
+		return
+	}
+
+	data, err := h.editorUC.HistoryData(key, version)
+	if err != nil {
+		historyError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
+
+// HistoryRestore восстанавливает версию {"version": n} (onRequestRestore)
+func (h *Handler) HistoryRestore(w http.ResponseWriter, r *http.Request) {
+	key, userId, ok := h.editorSession(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Version int `json:"version"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Version < 1 {
+		http.Error(w, "Invalid version", http.StatusBadRequest)
+		return
+	}
+
+	err := h.trackUC.Restore(&domain.RestoreRequest{
+		Key:       key,
+		UserId:    userId,
+		Version:   req.Version,
+		RequestID: middleware.GetReqID(r.Context()),
+		ClientIP:  clientIP(r),
+	})
+	if err != nil {
+		logwrapper.ZeroLog(log.Warn().Str("func", "TrackUsecase.Restore"), err)
+		http.Error(w, err.Message(), err.Status())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HistoryFile отдает Document Server содержимое версии или архив изменений
+// по подписанной ссылке из HistoryData
+func (h *Handler) HistoryFile(w http.ResponseWriter, r *http.Request) {
+	file, err := h.editorUC.VersionFile(r.URL.Query().Get("token"))
+	if err != nil {
+		historyError(w, r, err)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if _, err := io.Copy(w, file); err != nil {
+		log.Warn().Err(err).Msg("failed write version file")
+	}
+}
+
+// editorSession документ и пользователь из токена редактора
+func (h *Handler) editorSession(w http.ResponseWriter, r *http.Request) (key, userId string, ok bool) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	key, userId, err := h.editorUC.EditorSession(token)
+	if err != nil {
+		http.Error(w, "Editor token required", http.StatusForbidden)
+		return "", "", false
+	}
+
+	return key, userId, true
+}
+
+func historyError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, domain.ErrNotFound):
+		http.NotFound(w, r)
+	case errors.Is(err, domain.ErrForbidden):
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	default:
+		log.Error().Err(err).Msg("failed read document history")
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+	}
+}
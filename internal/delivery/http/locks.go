@@ -0,0 +1,147 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+)
+
+// Locks список действующих блокировок: сессии редактирования и явные
+func (h *Handler) Locks(w http.ResponseWriter, r *http.Request) {
+	locks, err := h.locks.List()
+	if err != nil {
+		log.Error().Err(err).Msg("failed list locks")
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	// токен знает только владелец блокировки
+	for i := range locks {
+		locks[i].Token = ""
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(locks)
+}
+
+// Lock создает блокировку {"path": "...", "owner": "...", "timeout": 3600, "deep": false}.
+// С заголовком Lock-Token продлевает действующую блокировку.
+func (h *Handler) Lock(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Path    string `json:"path"`
+		Owner   string `json:"owner"`
+		Timeout int    `json:"timeout"`
+		Deep    bool   `json:"deep"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Path == "" || strings.Contains(req.Path, "..") {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+	if req.Owner == "" {
+		req.Owner = principal(r)
+	}
+
+	token := lockToken(r)
+	lock, err := h.locks.Lock(req.Path, req.Owner, token, req.Deep, time.Duration(req.Timeout)*time.Second)
+	if errors.Is(err, domain.ErrLocked) {
+		locked(w, lock)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("path", req.Path).Msg("failed lock file")
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	status := http.StatusCreated
+	if token != "" {
+		status = http.StatusOK
+	} else {
+		h.audit(r, domain.AuditRecord{
+			Action: domain.AuditLock,
+			Path:   lock.Path,
+			Reason: "owner " + lock.Owner,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Lock-Token", "<"+lock.Token+">")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(lock)
+}
+
+// Unlock снимает блокировку ?path= по токену из заголовка Lock-Token
+func (h *Handler) Unlock(w http.ResponseWriter, r *http.Request) {
+	relPath := r.URL.Query().Get("path")
+
+	err := h.locks.Unlock(relPath, lockToken(r))
+	switch {
+	case errors.Is(err, domain.ErrNotFound):
+		http.NotFound(w, r)
+		return
+	case errors.Is(err, domain.ErrForbidden):
+		http.Error(w, "Lock token does not match", http.StatusForbidden)
+		return
+	case err != nil:
+		log.Error().Err(err).Str("path", relPath).Msg("failed unlock file")
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	h.audit(r, domain.AuditRecord{Action: domain.AuditUnlock, Path: relPath})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// BreakLock снимает любую блокировку ?path=, в том числе сессию редактирования
+func (h *Handler) BreakLock(w http.ResponseWriter, r *http.Request) {
+	relPath := r.URL.Query().Get("path")
+
+	lock, err := h.locks.Break(relPath)
+	if errors.Is(err, domain.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("path", relPath).Msg("failed break lock")
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	h.audit(r, domain.AuditRecord{
+		Action: domain.AuditLockBreak,
+		Path:   lock.Path,
+		Reason: lock.Kind + " lock of " + lock.Owner,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// locked отвечает 423 с описанием блокировки без токена
+func locked(w http.ResponseWriter, lock *domain.Lock) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusLocked)
+
+	if lock != nil {
+		l := *lock
+		l.Token = ""
+		json.NewEncoder(w).Encode(l)
+	}
+}
+
+var lockTokenRe = regexp.MustCompile(`opaquelocktoken:[0-9a-fA-F-]+`)
+
+// lockToken токен блокировки из заголовка Lock-Token или If (WebDAV)
+func lockToken(r *http.Request) string {
+	for _, header := range []string{"Lock-Token", "If"} {
+		if t := lockTokenRe.FindString(r.Header.Get(header)); t != "" {
+			return t
+		}
+	}
+	return ""
+}
@@ -0,0 +1,41 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+)
+
+// Manifest размеры, время изменения и SHA256 всех файлов каталога ?path=
+// для синхронизации
+func (h *Handler) Manifest(w http.ResponseWriter, r *http.Request) {
+	relPath := r.URL.Query().Get("path")
+	if relPath == "" || strings.Contains(relPath, "..") {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+
+	entries, err := h.fileUC.Manifest(relPath)
+	switch {
+	case errors.Is(err, domain.ErrInvalid):
+		http.Error(w, "Not a directory", http.StatusBadRequest)
+		return
+	case errors.Is(err, domain.ErrNotFound):
+		http.NotFound(w, r)
+		return
+	case err != nil:
+		log.Error().Err(err).Str("path", relPath).Msg("failed build manifest")
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(entries); err != nil {
+		log.Warn().Err(err).Msg("failed to encode manifest response")
+	}
+}
@@ -1,34 +1,117 @@
 package http
 
 import (
+	"context"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/AleksandrMac/fileserver/internal/metrics"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/rs/zerolog/log"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+	"github.com/AleksandrMac/fileserver/internal/metrics"
+)
+
+type ctxKey int
+
+const principalKey ctxKey = iota
+
+// Субъекты журнала аудита. Запросы по токену доступа записываются как
+// user:<id>, из редактора — editor:<id>, по Basic — api-key:<имя>.
+const (
+	principalAPIKey    = "api-key"
+	principalUser      = "user:"
+	principalEditor    = "editor:"
+	principalAnonymous = "anonymous"
 )
 
 func (h *Handler) Auth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		checkList := []func() bool{
-			func() bool { return r.Header.Get("X-API-Key") == h.apiKey },
-			func() bool {
-				t := r.Header.Get("Authorization")
-				return strings.HasPrefix(t, "Bearer ") &&
-					h.editorUC.VerifyEditorToken(strings.TrimPrefix(t, "Bearer "))
-			},
+		if p, ok := h.authenticate(r); ok {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey, p)))
+			return
 		}
 
-		for _, f := range checkList {
-			if f() {
-				next.ServeHTTP(w, r)
-				return
+		h.audit(r, domain.AuditRecord{
+			Action: domain.AuditAuthFailure,
+			Path:   r.URL.Path,
+			Reason: "no valid api key or access token",
+		})
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	})
+}
+
+// authenticate определяет субъекта по API-ключу (X-API-Key или пароль Basic,
+// для WebDAV-клиентов) либо токену доступа. Токен конфигурации редактора
+// подписан тем же секретом, но доступа к API не дает.
+func (h *Handler) authenticate(r *http.Request) (string, bool) {
+	checkList := []func() (string, bool){
+		func() (string, bool) {
+			return principalAPIKey, r.Header.Get("X-API-Key") == h.apiKey
+		},
+		func() (string, bool) {
+			user, password, ok := r.BasicAuth()
+			if !ok || password != h.apiKey {
+				return "", false
+			}
+			if user == "" {
+				return principalAPIKey, true
 			}
+			return principalAPIKey + ":" + user, true
+		},
+		func() (string, bool) {
+			t := r.Header.Get("Authorization")
+			if !strings.HasPrefix(t, "Bearer ") {
+				return "", false
+			}
+			userId, ok := h.editorUC.VerifyAccessToken(strings.TrimPrefix(t, "Bearer "))
+			return principalUser + userId, ok
+		},
+	}
+
+	for _, check := range checkList {
+		if p, ok := check(); ok {
+			return p, true
 		}
+	}
+	return "", false
+}
 
-		http.Error(w, "Forbidden", http.StatusForbidden)
+// EditorAuth пропускает запросы страницы редактора с токеном ее конфигурации
+func (h *Handler) EditorAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if _, userId, err := h.editorUC.EditorSession(token); err == nil {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey, principalEditor+userId)))
+			return
+		}
+
+		h.audit(r, domain.AuditRecord{
+			Action: domain.AuditAuthFailure,
+			Path:   r.URL.Path,
+			Reason: "editor token required",
+		})
+		http.Error(w, "Editor token required", http.StatusForbidden)
+	})
+}
+
+// AdminAuth пропускает только запросы с API-ключом
+func (h *Handler) AdminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-Key") != h.apiKey {
+			h.audit(r, domain.AuditRecord{
+				Action: domain.AuditAuthFailure,
+				Path:   r.URL.Path,
+				Reason: "admin api key required",
+			})
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey, principalAPIKey)))
 	})
 }
 
@@ -53,3 +136,28 @@ func (h *Handler) Metrics(next http.Handler) http.Handler {
 			Msg("request completed")
 	})
 }
+
+// audit дополняет запись данными запроса и пишет ее в журнал
+func (h *Handler) audit(r *http.Request, rec domain.AuditRecord) {
+	rec.Principal = principal(r)
+	rec.ClientIP = clientIP(r)
+	rec.RequestID = middleware.GetReqID(r.Context())
+	h.auditUC.Record(&rec)
+}
+
+// principal возвращает субъекта, установленного Auth
+func principal(r *http.Request) string {
+	if p, ok := r.Context().Value(principalKey).(string); ok {
+		return p
+	}
+	return principalAnonymous
+}
+
+// clientIP адрес клиента (RemoteAddr уже заменен middleware.RealIP)
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
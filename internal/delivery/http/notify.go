@@ -0,0 +1,23 @@
+package http
+
+import (
+	"github.com/AleksandrMac/fileserver/internal/domain"
+	"github.com/AleksandrMac/fileserver/internal/metrics"
+)
+
+// Notify учитывает изменения, сделанные не через HTTP (SFTP, gRPC), так же как
+// запросы REST: метрики и аудит. Субъекта и адрес клиента заполняет вызывающий сервер.
+func (h *Handler) Notify(rec domain.AuditRecord) {
+	switch rec.Action {
+	case domain.AuditDownload:
+		metrics.BytesDownloaded.Add(float64(rec.Size))
+		if h.auditUC.LogDownloads() {
+			h.auditUC.Record(&rec)
+		}
+		return
+	case domain.AuditUpload, domain.AuditOverwrite:
+		metrics.BytesUploaded.Add(float64(rec.Size))
+	}
+
+	h.auditUC.Record(&rec)
+}
@@ -0,0 +1,64 @@
+package http
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+	"github.com/AleksandrMac/fileserver/internal/templates"
+)
+
+var previewTemplate = template.Must(
+	template.New("preview.html").
+		Funcs(template.FuncMap{"formatTime": funcMap["formatTime"]}).
+		ParseFS(templates.HTML, "html/preview.html"))
+
+// Preview показывает файл: офисные документы — в OnlyOffice на просмотр,
+// изображения и PDF — встроенными, текст — с подсветкой, zip — списком файлов,
+// остальное — карточкой с метаданными
+func (h *Handler) Preview(w http.ResponseWriter, r *http.Request) {
+	relPath := r.URL.Query().Get("path")
+	if relPath == "" || strings.Contains(relPath, "..") {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+
+	preview, err := h.previewUC.Prepare(relPath)
+	switch {
+	case errors.Is(err, domain.ErrInvalid):
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	case errors.Is(err, domain.ErrNotFound):
+		http.NotFound(w, r)
+		return
+	case err != nil:
+		log.Error().Err(err).Str("path", relPath).Msg("failed prepare preview")
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	if preview.Kind == domain.PreviewOffice {
+		req := h.editorRequest(r, preview.Path)
+		req.ViewOnly = true
+		err := h.editorUC.EditHtml(w, req)
+		if errors.Is(err, domain.ErrForbidden) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("failed build viewer page")
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if err := previewTemplate.Execute(w, preview); err != nil {
+		log.Error().Err(err).Str("path", relPath).Msg("failed render preview")
+	}
+}
@@ -0,0 +1,128 @@
+package http
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/AleksandrMac/fileserver/internal/metrics"
+	"github.com/AleksandrMac/fileserver/internal/ratelimit"
+)
+
+type RateLimit struct {
+	limiter *ratelimit.Limiter
+	// authenticate проверяет учетные данные запроса, лимиты работают до Auth
+	authenticate func(r *http.Request) (string, bool)
+}
+
+// RateLimit лимиты по клиентам. Клиент с проверенными учетными данными
+// считается по субъекту, остальные — по IP.
+func (h *Handler) RateLimit(limiter *ratelimit.Limiter) *RateLimit {
+	return &RateLimit{limiter: limiter, authenticate: h.authenticate}
+}
+
+// Requests ограничивает частоту запросов клиента
+func (x *RateLimit) Requests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, retryAfter := x.limiter.Allow(x.clientKey(r)); !ok {
+			tooManyRequests(w, "requests", retryAfter)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Downloads ограничивает число одновременных скачиваний и их скорость
+func (x *RateLimit) Downloads(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := x.clientKey(r)
+		release, ok := x.limiter.Acquire(key, ratelimit.Download)
+		if !ok {
+			tooManyRequests(w, "downloads", time.Second)
+			return
+		}
+		defer release()
+
+		if l := x.limiter.Bandwidth(key); l != nil {
+			w = &limitedResponseWriter{
+				ResponseWriter: w,
+				w:              ratelimit.NewWriter(r.Context(), w, l),
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Uploads ограничивает число одновременных загрузок и их скорость
+func (x *RateLimit) Uploads(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := x.clientKey(r)
+		release, ok := x.limiter.Acquire(key, ratelimit.Upload)
+		if !ok {
+			tooManyRequests(w, "uploads", time.Second)
+			return
+		}
+		defer release()
+
+		if l := x.limiter.Bandwidth(key); l != nil {
+			r.Body = limitedBody{
+				Reader: ratelimit.NewReader(r.Context(), r.Body, l),
+				Closer: r.Body,
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Transfers ограничивает скачивания (GET) и загрузки (PUT) на общем маршруте, как у WebDAV
+func (x *RateLimit) Transfers(next http.Handler) http.Handler {
+	downloads, uploads := x.Downloads(next), x.Uploads(next)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			downloads.ServeHTTP(w, r)
+		case http.MethodPut:
+			uploads.ServeHTTP(w, r)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// clientKey определяет клиента: субъект, если учетные данные верны, иначе IP.
+// Непроверенные ключи и токены не в счет: иначе каждый выдуманный токен
+// получал бы свою корзину.
+func (x *RateLimit) clientKey(r *http.Request) string {
+	if p, ok := x.authenticate(r); ok {
+		return "principal:" + p
+	}
+
+	return "ip:" + clientIP(r)
+}
+
+func tooManyRequests(w http.ResponseWriter, limit string, retryAfter time.Duration) {
+	metrics.Throttled.WithLabelValues(limit).Inc()
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+}
+
+type limitedResponseWriter struct {
+	http.ResponseWriter
+	w io.Writer
+}
+
+func (x *limitedResponseWriter) Write(p []byte) (int, error) {
+	return x.w.Write(p)
+}
+
+type limitedBody struct {
+	io.Reader
+	io.Closer
+}
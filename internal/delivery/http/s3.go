@@ -0,0 +1,36 @@
+package http
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+	"github.com/AleksandrMac/fileserver/internal/interfaces"
+	"github.com/AleksandrMac/fileserver/internal/metrics"
+)
+
+// S3 обработчик S3 API. Подпись SigV4 проверяет сам s3, секрет — API-ключ,
+// поэтому в журнале аудита запросы записываются от его имени.
+func (h *Handler) S3(s3 interfaces.S3) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(context.WithValue(r.Context(), principalKey, principalAPIKey))
+
+		s3.Serve(w, r, func(rec domain.AuditRecord) {
+			switch rec.Action {
+			case domain.AuditAuthFailure:
+				h.audit(r.WithContext(context.WithValue(r.Context(), principalKey, principalAnonymous)), rec)
+				return
+			case domain.AuditDownload:
+				metrics.BytesDownloaded.Add(float64(rec.Size))
+				if h.auditUC.LogDownloads() {
+					h.audit(r, rec)
+				}
+				return
+			case domain.AuditUpload, domain.AuditOverwrite:
+				metrics.BytesUploaded.Add(float64(rec.Size))
+			}
+
+			h.audit(r, rec)
+		})
+	})
+}
@@ -14,7 +14,9 @@ import (
 	"github.com/AleksandrMac/fileserver/internal/domain"
 	"github.com/AleksandrMac/fileserver/internal/metrics"
 	"github.com/AleksandrMac/fileserver/internal/templates"
+	"github.com/AleksandrMac/fileserver/pkg/hashreader"
 
+	"github.com/gabriel-vasile/mimetype"
 	"github.com/rs/zerolog/log"
 )
 
@@ -63,6 +65,11 @@ func (h *Handler) ServeFile(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Invalid path", http.StatusBadRequest)
 			return
 		}
+		for i := range files {
+			if !files[i].IsDir {
+				files[i].Lock = h.locks.Active(files[i].Path)
+			}
+		}
 
 		if resultType == d.ApplictionJSON {
 			w.Header().Set("Content-Type", string(resultType))
@@ -96,10 +103,16 @@ func (h *Handler) ServeFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if r.URL.Query().Get("meta") == "true" {
+		h.zipMeta(w, fullPath)
+		return
+	}
+
 	if resultType == d.ApplictionJSON {
 		data, err := json.Marshal(domain.FileInfo{
 			Name:    info.Name(),
 			Path:    relPath,
+			Size:    info.Size(),
 			IsDir:   false,
 			ModTime: info.ModTime(),
 		})
@@ -124,6 +137,11 @@ func (h *Handler) ServeFile(w http.ResponseWriter, r *http.Request) {
 	} else {
 		w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
 		w.Header().Set("Content-Type", string(d.ApplcationOctetStream))
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+		if r.URL.Query().Get("inline") == "true" {
+			setInline(w, fullPath)
+		}
 
 		if head {
 			w.WriteHeader(http.StatusOK)
@@ -138,9 +156,83 @@ func (h *Handler) ServeFile(w http.ResponseWriter, r *http.Request) {
 		}
 		defer file.Close()
 
-		n, err := io.Copy(w, file)
+		// докачка: часть файла отдает ServeContent, сумма части в аудит не пишется
+		if rs, ok := file.(io.ReadSeeker); ok && r.Header.Get("Range") != "" {
+			cw := &countingWriter{ResponseWriter: w}
+			http.ServeContent(cw, r, info.Name(), info.ModTime(), rs)
+			metrics.BytesDownloaded.Add(float64(cw.n))
+			if h.auditUC.LogDownloads() && cw.n > 0 {
+				h.audit(r, domain.AuditRecord{
+					Action: domain.AuditDownload,
+					Path:   relPath,
+					Size:   cw.n,
+				})
+			}
+			return
+		}
+
+		hr := hashreader.New(file)
+		n, err := io.Copy(w, hr)
 		if err == nil {
 			metrics.BytesDownloaded.Add(float64(n))
+
+			if h.auditUC.LogDownloads() {
+				h.audit(r, domain.AuditRecord{
+					Action: domain.AuditDownload,
+					Path:   relPath,
+					Size:   n,
+					SHA256: hr.Sum(),
+				})
+			}
 		}
 	}
 }
+
+// zipMeta список файлов архива
+func (h *Handler) zipMeta(w http.ResponseWriter, fullPath string) {
+	files, err := h.fileUC.ListZipContents(fullPath)
+	if err != nil {
+		log.Debug().Err(err).Str("path", fullPath).Msg("failed read zip")
+		http.Error(w, "Not a zip archive", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", string(d.ApplictionJSON))
+	if err := json.NewEncoder(w).Encode(files); err != nil {
+		log.Warn().Err(err).Msg("failed write to client")
+	}
+}
+
+// countingWriter считает отданные клиенту байты тела
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (x *countingWriter) Write(p []byte) (int, error) {
+	n, err := x.ResponseWriter.Write(p)
+	x.n += int64(n)
+	return n, err
+}
+
+// setInline разрешает браузеру показать изображение или PDF на странице предпросмотра.
+// Остальные типы отдаются как octet-stream, чтобы не исполнить чужой HTML с нашего домена.
+func setInline(w http.ResponseWriter, fullPath string) {
+	mime, err := mimetype.DetectFile(fullPath)
+	if err != nil {
+		return
+	}
+
+	switch {
+	case mime.Is("image/svg+xml"):
+		// svg может содержать скрипты
+		w.Header().Set("Content-Security-Policy", "sandbox; default-src 'none'; style-src 'unsafe-inline'")
+	case strings.HasPrefix(mime.String(), "image/"), mime.Is("application/pdf"):
+	default:
+		return
+	}
+
+	w.Header().Set("Content-Type", mime.String())
+	w.Header().Set("Content-Disposition", "inline")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+}
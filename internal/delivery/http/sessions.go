@@ -0,0 +1,18 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Sessions возвращает активные сессии редактирования (?path= — только для файла)
+func (h *Handler) Sessions(w http.ResponseWriter, r *http.Request) {
+	sessions := h.sessions.List(r.URL.Query().Get("path"))
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(sessions); err != nil {
+		log.Warn().Err(err).Msg("failed to encode sessions response")
+	}
+}
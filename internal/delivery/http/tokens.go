@@ -0,0 +1,58 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+)
+
+// maxTokenTTL наибольший срок токена доступа
+const maxTokenTTL = 30 * 24 * time.Hour
+
+// IssueToken выдает токен доступа {"user": "ivanov", "ttl": 3600}.
+// Срок в секундах, по умолчанию сутки.
+func (h *Handler) IssueToken(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		User string `json:"user"`
+		TTL  int64  `json:"ttl"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	ttl := 24 * time.Hour
+	if req.TTL != 0 {
+		ttl = time.Duration(req.TTL) * time.Second
+	}
+	if ttl <= 0 || ttl > maxTokenTTL {
+		http.Error(w, "Invalid 'ttl'", http.StatusBadRequest)
+		return
+	}
+
+	token, err := h.editorUC.GenerateAccessToken(req.User, ttl)
+	if errors.Is(err, domain.ErrInvalid) {
+		http.Error(w, "Invalid 'user'", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed sign access token")
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	h.audit(r, domain.AuditRecord{
+		Action: domain.AuditTokenIssue,
+		Reason: principalUser + req.User,
+	})
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(map[string]any{
+		"token":   token,
+		"expires": time.Now().Add(ttl).UTC(),
+	})
+}
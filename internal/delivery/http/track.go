@@ -3,7 +3,9 @@ package http
 import (
 	"net/http"
 
+	"github.com/AleksandrMac/fileserver/internal/domain"
 	"github.com/AleksandrMac/fileserver/pkg/uerror/logwrapper"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/rs/zerolog/log"
 )
 
@@ -13,15 +15,34 @@ func (x *Handler) Track(w http.ResponseWriter, r *http.Request) {
 	args, err := uc.ReadRequestData(r)
 	if err != nil {
 		logwrapper.ZeroLog(log.Debug().Str("func", "TrackUsecase.ReadRequestData"), err)
-		http.Error(w, err.Message(), err.Status())
+		if err.Status() == http.StatusForbidden {
+			x.audit(r, domain.AuditRecord{
+				Action: domain.AuditAuthFailure,
+				Path:   r.URL.Path,
+				Reason: err.Message() + ": " + err.Error(),
+			})
+		}
+		// Document Server ждет ответ 200 с кодом ошибки в теле
+		if err := uc.WriteResponse(w, &domain.TrackResponse{Err: 1}, "application/json"); err != nil {
+			log.Error().Err(err).Msg("failid write response")
+		}
 		return
 	}
+	args.RequestID = middleware.GetReqID(r.Context())
+	args.ClientIP = clientIP(r)
 
 	result, err := uc.Proceed(args)
 	if err != nil {
 		logwrapper.ZeroLog(log.Error().Str("func", "TrackUsecase.Proceed"), err)
-		http.Error(w, err.Message(), err.Status())
-		return
+		if err.Status() == http.StatusForbidden {
+			x.audit(r, domain.AuditRecord{
+				Action: domain.AuditAuthFailure,
+				Path:   r.URL.Path,
+				Reason: err.Message() + ": " + err.Error(),
+			})
+		}
+		// Document Server ждет ответ 200 с кодом ошибки в теле
+		result = &domain.TrackResponse{Err: 1}
 	}
 
 	if err := uc.WriteResponse(w, result, "application/json"); err != nil {
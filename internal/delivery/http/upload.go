@@ -2,10 +2,13 @@ package http
 
 import (
 	"net/http"
+	"path"
 	"path/filepath"
 	"strings"
 
+	"github.com/AleksandrMac/fileserver/internal/domain"
 	"github.com/AleksandrMac/fileserver/internal/metrics"
+	"github.com/AleksandrMac/fileserver/pkg/hashreader"
 	"github.com/rs/zerolog/log"
 )
 
@@ -52,6 +55,11 @@ func (h *Handler) Upload(w http.ResponseWriter, r *http.Request) {
 
 	fullFileName := filepath.Join(fullPath, filename)
 
+	if lock, err := h.locks.CheckWrite(path.Join(relPath, filepath.ToSlash(filename)), lockToken(r)); err != nil {
+		locked(w, lock)
+		return
+	}
+
 	oldFileInfo, err := h.fileUC.FileInfo(fullFileName)
 	if err != nil {
 		log.Error().Err(err).Str("path", fullPath).Msg("get info failed")
@@ -60,7 +68,8 @@ func (h *Handler) Upload(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Save
-	if err := h.fileUC.SaveFile(fullFileName, file); err != nil {
+	hr := hashreader.New(file)
+	if err := h.fileUC.SaveFile(fullFileName, hr); err != nil {
 		log.Error().Err(err).Str("path", fullPath).Msg("upload failed")
 		http.Error(w, "Internal error", http.StatusInternalServerError)
 		return
@@ -68,17 +77,18 @@ func (h *Handler) Upload(w http.ResponseWriter, r *http.Request) {
 
 	newSize, _ := h.fileUC.GetFileSize(fullFileName)
 
-	var delta int64
+	metrics.BytesUploaded.Add(float64(newSize))
 
+	action := domain.AuditUpload
 	if oldFileInfo != nil {
-		delta = newSize - oldFileInfo.Size()
-	} else {
-		delta = newSize
+		action = domain.AuditOverwrite
 	}
-
-	h.storageSize += delta
-	metrics.TotalStorageSize.Set(float64(h.storageSize))
-	metrics.BytesUploaded.Add(float64(newSize))
+	h.audit(r, domain.AuditRecord{
+		Action: action,
+		Path:   path.Join(relPath, filepath.ToSlash(filename)),
+		Size:   hr.Size(),
+		SHA256: hr.Sum(),
+	})
 
 	w.WriteHeader(http.StatusCreated)
 	log.Info().Str("path", filename).Int64("size", newSize).Msg("file uploaded")
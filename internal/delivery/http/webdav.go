@@ -0,0 +1,38 @@
+package http
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+	"github.com/AleksandrMac/fileserver/internal/interfaces"
+	"github.com/AleksandrMac/fileserver/internal/metrics"
+)
+
+// WebDAV обработчик WebDAV. Любой метод, включая PROPFIND, требует API-ключ
+// или токен доступа: листинг дерева не должен быть открыт. Без них клиент
+// получает 401 с запросом Basic, чтобы подключение сетевого диска спросило пароль.
+func (h *Handler) WebDAV(dav interfaces.WebDAV) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok := h.authenticate(r)
+		if !ok {
+			h.audit(r, domain.AuditRecord{
+				Action: domain.AuditAuthFailure,
+				Path:   r.URL.Path,
+				Reason: "webdav " + r.Method + " without api key",
+			})
+			w.Header().Set("WWW-Authenticate", `Basic realm="fileserver", charset="UTF-8"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), principalKey, p))
+
+		dav.Serve(w, r, principal(r), func(rec domain.AuditRecord) {
+			if rec.Action == domain.AuditUpload || rec.Action == domain.AuditOverwrite {
+				metrics.BytesUploaded.Add(float64(rec.Size))
+			}
+
+			h.audit(r, rec)
+		})
+	})
+}
@@ -0,0 +1,61 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+	"github.com/AleksandrMac/fileserver/internal/interfaces"
+)
+
+// WebhookAdmin разбор неудавшихся доставок вебхуков
+type WebhookAdmin struct {
+	webhooks interfaces.WebhookUsecase
+}
+
+func NewWebhookAdmin(webhooks interfaces.WebhookUsecase) *WebhookAdmin {
+	return &WebhookAdmin{webhooks: webhooks}
+}
+
+// Dead доставки, исчерпавшие попытки
+func (x *WebhookAdmin) Dead(w http.ResponseWriter, r *http.Request) {
+	dead, err := x.webhooks.Dead()
+	if err != nil {
+		log.Error().Err(err).Msg("failed read webhook dead letters")
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(dead); err != nil {
+		log.Warn().Err(err).Msg("failed to encode dead letters response")
+	}
+}
+
+// Redeliver возвращает доставку ?id= в очередь с новыми попытками
+func (x *WebhookAdmin) Redeliver(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "Missing id", http.StatusBadRequest)
+		return
+	}
+
+	d, err := x.webhooks.Redeliver(id)
+	if errors.Is(err, domain.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("delivery", id).Msg("failed redeliver webhook")
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(d); err != nil {
+		log.Warn().Err(err).Msg("failed to encode redelivery response")
+	}
+}
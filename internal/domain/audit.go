@@ -0,0 +1,101 @@
+package domain
+
+import (
+	"time"
+)
+
+type AuditAction string
+
+const (
+	AuditUpload      AuditAction = "upload"
+	AuditOverwrite   AuditAction = "overwrite"
+	AuditCreate      AuditAction = "create"
+	AuditMkdir       AuditAction = "mkdir"
+	AuditDelete      AuditAction = "delete"
+	AuditMove        AuditAction = "move"
+	AuditDownload    AuditAction = "download"
+	AuditConvert     AuditAction = "convert"
+	AuditTrackSave   AuditAction = "track_save"
+	AuditRestore     AuditAction = "restore"
+	AuditLock        AuditAction = "lock"
+	AuditUnlock      AuditAction = "unlock"
+	AuditLockBreak   AuditAction = "lock_break"
+	AuditAuthFailure AuditAction = "auth_failure"
+	AuditTokenIssue  AuditAction = "token_issue"
+)
+
+// Change true для действий, которые меняют хранилище
+func (x AuditAction) Change() bool {
+	switch x {
+	case AuditUpload, AuditOverwrite, AuditCreate, AuditMkdir, AuditDelete, AuditMove, AuditTrackSave, AuditRestore:
+		return true
+	}
+	return false
+}
+
+type AuditRecord struct {
+	Time      time.Time   `json:"time"`
+	Action    AuditAction `json:"action"`
+	Principal string      `json:"principal"`
+	ClientIP  string      `json:"client_ip,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
+	Path      string      `json:"path"`
+	Size      int64       `json:"size,omitempty"`
+	SHA256    string      `json:"sha256,omitempty"`
+	From      string      `json:"from,omitempty"`
+	Reason    string      `json:"reason,omitempty"`
+}
+
+// Within true если запись касается prefix: путь или, для move, прежний путь внутри него
+func (x *AuditRecord) Within(prefix string) bool {
+	if hasPathPrefix(x.Path, prefix) {
+		return true
+	}
+	return x.From != "" && hasPathPrefix(x.From, prefix)
+}
+
+// ChangeEvent изменение хранилища. ID растут и не повторяются после
+// перезапуска, по ним подписчик продолжает с места обрыва.
+type ChangeEvent struct {
+	ID uint64
+	AuditRecord
+}
+
+// AuditFilter условия выборки из журнала аудита. Пустые поля не фильтруют.
+type AuditFilter struct {
+	Path      string
+	Principal string
+	Action    AuditAction
+	From      time.Time
+	To        time.Time
+	Limit     int
+}
+
+func (x *AuditFilter) Match(rec *AuditRecord) bool {
+	switch {
+	case x.Path != "" && !hasPathPrefix(rec.Path, x.Path):
+		return false
+	case x.Principal != "" && rec.Principal != x.Principal:
+		return false
+	case x.Action != "" && rec.Action != x.Action:
+		return false
+	case !x.From.IsZero() && rec.Time.Before(x.From):
+		return false
+	case !x.To.IsZero() && rec.Time.After(x.To):
+		return false
+	}
+
+	return true
+}
+
+// hasPathPrefix true если path совпадает с prefix или лежит внутри него
+func hasPathPrefix(path, prefix string) bool {
+	if len(prefix) > 1 && prefix[len(prefix)-1] == '/' {
+		prefix = prefix[:len(prefix)-1]
+	}
+	if len(path) < len(prefix) || path[:len(prefix)] != prefix {
+		return false
+	}
+
+	return len(path) == len(prefix) || path[len(prefix)] == '/' || prefix == "/"
+}
@@ -0,0 +1,45 @@
+package domain
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrConvert Document Server не смог сконвертировать документ
+	ErrConvert = errors.New("conversion failed")
+	// ErrConvertBusy очередь задач конвертации заполнена
+	ErrConvertBusy = errors.New("conversion queue is full")
+)
+
+// Коды ошибок ConvertService Document Server
+var ConvertErrors = map[int]string{
+	-1: "unknown error",
+	-2: "conversion timeout",
+	-3: "conversion error",
+	-4: "error while downloading the document file",
+	-5: "incorrect password",
+	-6: "error while accessing the conversion result database",
+	-7: "input error",
+	-8: "invalid token",
+}
+
+// Статусы задачи конвертации
+const (
+	ConvertPending = "pending"
+	ConvertDone    = "done"
+	ConvertFailed  = "error"
+)
+
+// ConvertJob задача конвертации, результат сохраняется рядом с исходным файлом
+type ConvertJob struct {
+	Id         string    `json:"id"`
+	Path       string    `json:"path"`
+	OutputType string    `json:"outputtype"`
+	Status     string    `json:"status"`
+	Result     string    `json:"result,omitempty"`
+	Size       int64     `json:"size,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Created    time.Time `json:"created"`
+	Updated    time.Time `json:"updated"`
+}
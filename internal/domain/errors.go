@@ -0,0 +1,9 @@
+package domain
+
+import "errors"
+
+var (
+	ErrNotFound  = errors.New("file not found")
+	ErrInvalid   = errors.New("invalid argument")
+	ErrForbidden = errors.New("forbidden")
+)
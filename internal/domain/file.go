@@ -1,10 +1,52 @@
 package domain
 
-import "time"
+import (
+	"path/filepath"
+	"strings"
+	"time"
+)
 
 type FileInfo struct {
 	Name    string    `json:"name"`
 	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
 	ModTime time.Time `json:"mod_time"`
 	IsDir   bool      `json:"is_dir"`
+	Lock    *Lock     `json:"lock,omitempty"`
+}
+
+// FileMeta подробная информация о файле или каталоге
+type FileMeta struct {
+	FileInfo
+	Mode            string `json:"mode"`
+	MIME            string `json:"mime,omitempty"`
+	SHA256          string `json:"sha256,omitempty"`
+	Versions        int    `json:"versions"`
+	EditorSupported bool   `json:"editor_supported"`
+
+	// только для каталогов
+	Children      int64 `json:"children,omitempty"`
+	RecursiveSize int64 `json:"recursive_size,omitempty"`
+}
+
+// ManifestEntry файл или каталог в манифесте дерева для синхронизации
+type ManifestEntry struct {
+	// Path путь относительно корня манифеста, без ведущего "/"
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	IsDir   bool      `json:"is_dir,omitempty"`
+	SHA256  string    `json:"sha256,omitempty"`
+}
+
+// расширения, которые открываются в OnlyOffice
+var officeExt = map[string]bool{
+	".doc": true, ".docx": true, ".odt": true, ".rtf": true,
+	".xls": true, ".xlsx": true, ".ods": true,
+	".ppt": true, ".pptx": true, ".odp": true,
+}
+
+// EditorSupported true если файл можно открыть в OnlyOffice
+func EditorSupported(name string) bool {
+	return officeExt[strings.ToLower(filepath.Ext(name))]
 }
@@ -0,0 +1,33 @@
+package domain
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrLocked файл заблокирован другим владельцем
+var ErrLocked = errors.New("locked")
+
+// Виды блокировок
+const (
+	LockSession  = "session"  // идет сессия редактирования в OnlyOffice
+	LockExplicit = "explicit" // блокировка через API или WebDAV LOCK
+)
+
+// Lock рекомендательная блокировка файла. Пока она действует, запись
+// в файл без ее токена отклоняется с 423 Locked.
+type Lock struct {
+	Path    string    `json:"path"`
+	Kind    string    `json:"kind"`
+	Token   string    `json:"token,omitempty"`
+	Owner   string    `json:"owner"`
+	Created time.Time `json:"created"`
+	// Expires нулевое у блокировок сессий: они снимаются с окончанием сессии
+	Expires time.Time `json:"expires,omitempty"`
+	// Deep блокировка каталога распространяется на все вложенное (WebDAV Depth: infinity)
+	Deep bool `json:"deep,omitempty"`
+}
+
+func (x *Lock) Expired(now time.Time) bool {
+	return !x.Expires.IsZero() && now.After(x.Expires)
+}
@@ -0,0 +1,69 @@
+package domain
+
+// Access уровень доступа к документу в редакторе
+type Access string
+
+const (
+	AccessNone      Access = "none"
+	AccessView      Access = "view"
+	AccessComment   Access = "comment"
+	AccessFillForms Access = "fillForms"
+	AccessReview    Access = "review"
+	AccessEdit      Access = "edit"
+)
+
+var accessRank = map[Access]int{
+	AccessNone:      0,
+	AccessView:      1,
+	AccessComment:   2,
+	AccessFillForms: 2,
+	AccessReview:    3,
+	AccessEdit:      4,
+}
+
+func (x Access) Valid() bool {
+	_, ok := accessRank[x]
+	return ok
+}
+
+// CanSave true если сессия с таким доступом может менять документ
+func (x Access) CanSave() bool {
+	return accessRank[x] > accessRank[AccessView]
+}
+
+// Max возвращает больший из двух уровней
+func (x Access) Max(other Access) Access {
+	if accessRank[other] > accessRank[x] {
+		return other
+	}
+	return x
+}
+
+// Permissions итоговые права пользователя на файл
+type Permissions struct {
+	Access   Access
+	Download bool
+	Print    bool
+	Copy     bool
+}
+
+// PermissionRule правило для каталога Path и всего, что внутри.
+// Правило более глубокого каталога переопределяет заданные в нем поля.
+type PermissionRule struct {
+	Path     string            `json:"path"`
+	Access   Access            `json:"access,omitempty"`
+	Users    map[string]Access `json:"users,omitempty"`
+	Download *bool             `json:"download,omitempty"`
+	Print    *bool             `json:"print,omitempty"`
+	Copy     *bool             `json:"copy,omitempty"`
+}
+
+// EditorRequest параметры открытия документа в OnlyOffice
+type EditorRequest struct {
+	File     string
+	UserId   string
+	UserName string
+	Lang     string
+	// ViewOnly открыть на просмотр независимо от прав
+	ViewOnly bool
+}
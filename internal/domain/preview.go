@@ -0,0 +1,37 @@
+package domain
+
+import (
+	"html/template"
+	"time"
+)
+
+type PreviewKind string
+
+const (
+	PreviewOffice  PreviewKind = "office"
+	PreviewImage   PreviewKind = "image"
+	PreviewPDF     PreviewKind = "pdf"
+	PreviewText    PreviewKind = "text"
+	PreviewCSV     PreviewKind = "csv"
+	PreviewArchive PreviewKind = "archive"
+	PreviewInfo    PreviewKind = "info"
+)
+
+// Preview данные для страницы предпросмотра файла
+type Preview struct {
+	Kind    PreviewKind
+	Name    string
+	Path    string
+	Size    int64
+	ModTime time.Time
+	MIME    string
+
+	// PreviewText: подсвеченный исходник
+	Highlighted template.HTML
+	// PreviewCSV: строки таблицы
+	Rows [][]string
+	// PreviewArchive: содержимое архива
+	Entries []FileInfo
+	// Truncated показан только первый фрагмент файла
+	Truncated bool
+}
@@ -0,0 +1,18 @@
+package domain
+
+import "time"
+
+// MultipartUpload незавершенная multipart-загрузка S3
+type MultipartUpload struct {
+	Id      string    `json:"id"`
+	Bucket  string    `json:"bucket"`
+	Key     string    `json:"key"`
+	Created time.Time `json:"created"`
+}
+
+// UploadPart загруженная часть multipart-загрузки
+type UploadPart struct {
+	Number int    `json:"number"`
+	ETag   string `json:"etag"`
+	Size   int64  `json:"size"`
+}
@@ -8,6 +8,12 @@ type ServiceInfo struct {
 	Storage   *StorageInfo `json:"storage"`
 }
 
+// Usage занятое место: число файлов и их суммарный размер
+type Usage struct {
+	Files int64 `json:"files"`
+	Size  int64 `json:"size"`
+}
+
 type StorageInfo struct {
 	Path       string `json:"path"`
 	TotalFiles int64  `json:"total_files"`
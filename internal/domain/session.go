@@ -0,0 +1,12 @@
+package domain
+
+import "time"
+
+// EditSession активная сессия редактирования документа в OnlyOffice
+type EditSession struct {
+	Key     string    `json:"key"`
+	Path    string    `json:"path"`
+	Users   []string  `json:"users"`
+	Since   time.Time `json:"since"`
+	Updated time.Time `json:"updated"`
+}
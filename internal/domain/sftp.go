@@ -0,0 +1,11 @@
+package domain
+
+// SFTPUser учетная запись SFTP. Пользователь видит только каталог Root
+// хранилища и входит по паролю (bcrypt-хеш в Password) или по ключу из Keys.
+type SFTPUser struct {
+	Name     string   `json:"name"`
+	Root     string   `json:"root"`
+	Password string   `json:"password,omitempty"`
+	Keys     []string `json:"keys,omitempty"`
+	ReadOnly bool     `json:"read_only,omitempty"`
+}
@@ -1,5 +1,20 @@
 package domain
 
+import (
+	"encoding/json"
+	"time"
+)
+
+// Статусы callback Document Server
+const (
+	TrackEditing        = 1 // документ редактируется
+	TrackMustSave       = 2 // редактирование завершено, документ готов к сохранению
+	TrackSaveError      = 3 // ошибка при сохранении документа
+	TrackClosed         = 4 // документ закрыт без изменений
+	TrackForceSave      = 6 // принудительное сохранение, редактирование продолжается
+	TrackForceSaveError = 7 // ошибка принудительного сохранения
+)
+
 type TrackRequest struct {
 	Status  float64 `json:"status" validate:"required"`
 	Url     string  `json:"url"`
@@ -8,7 +23,30 @@ type TrackRequest struct {
 		Type   int    `json:"type"`
 		UserId string `json:"userid"`
 	} `json:"actions"`
-	Token string `json:"token"`
+	Users         []string        `json:"users"`
+	ChangesUrl    string          `json:"changesurl"`
+	History       json.RawMessage `json:"history"`
+	ForceSaveType *int            `json:"forcesavetype"`
+	Token         string          `json:"token"`
+
+	// заполняются из HTTP-запроса для журнала аудита
+	RequestID string `json:"-"`
+	ClientIP  string `json:"-"`
+}
+
+// UserIds идентификаторы пользователей из users, либо из actions
+func (x *TrackRequest) UserIds() []string {
+	if len(x.Users) > 0 {
+		return x.Users
+	}
+
+	ids := make([]string, 0, len(x.Actions))
+	for _, a := range x.Actions {
+		if a.UserId != "" {
+			ids = append(ids, a.UserId)
+		}
+	}
+	return ids
 }
 
 func (x *TrackRequest) Valid() error {
@@ -18,3 +56,15 @@ func (x *TrackRequest) Valid() error {
 type TrackResponse struct {
 	Err int `json:"error"`
 }
+
+// SaveJob сохранение документа из callback, ожидающее в очереди
+type SaveJob struct {
+	Id      string       `json:"id"`
+	Path    string       `json:"path"`
+	Request TrackRequest `json:"request"`
+	// End завершить сессию после сохранения (статусы 2 и 3)
+	End       bool      `json:"end"`
+	RequestID string    `json:"request_id,omitempty"`
+	ClientIP  string    `json:"client_ip,omitempty"`
+	Enqueued  time.Time `json:"enqueued"`
+}
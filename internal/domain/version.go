@@ -0,0 +1,49 @@
+package domain
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// FileVersion сохраненная версия документа. Содержимое версии — файл
+// до сохранения (prev), изменения от Document Server — архив diff.zip.
+type FileVersion struct {
+	Version int             `json:"version"`
+	Key     string          `json:"key"`
+	Created time.Time       `json:"created"`
+	Users   []string        `json:"users,omitempty"`
+	Size    int64           `json:"size"`
+	HasDiff bool            `json:"has_diff"`
+	History json.RawMessage `json:"history,omitempty"`
+}
+
+// EditorHistory список версий для refreshHistory редактора OnlyOffice
+type EditorHistory struct {
+	CurrentVersion int                    `json:"currentVersion"`
+	History        []EditorHistoryVersion `json:"history"`
+}
+
+type EditorHistoryVersion struct {
+	Version       int             `json:"version"`
+	Key           string          `json:"key"`
+	Created       string          `json:"created"`
+	User          *EditorUser     `json:"user,omitempty"`
+	Changes       json.RawMessage `json:"changes,omitempty"`
+	ServerVersion json.RawMessage `json:"serverVersion,omitempty"`
+}
+
+type EditorUser struct {
+	Id   string `json:"id"`
+	Name string `json:"name,omitempty"`
+}
+
+// RestoreRequest восстановление версии документа из редактора
+type RestoreRequest struct {
+	Key     string
+	UserId  string
+	Version int
+
+	// для журнала аудита
+	RequestID string
+	ClientIP  string
+}
@@ -0,0 +1,9 @@
+package domain
+
+// DavProperty свойство ресурса WebDAV, заданное клиентом через PROPPATCH
+type DavProperty struct {
+	Space    string `json:"space"`
+	Local    string `json:"local"`
+	Lang     string `json:"lang,omitempty"`
+	InnerXML string `json:"inner_xml"`
+}
@@ -0,0 +1,76 @@
+package domain
+
+import (
+	"path"
+	"strings"
+	"time"
+)
+
+// Webhook подписка внешней системы на изменения хранилища
+type Webhook struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// Secret ключ HMAC-SHA256 подписи запросов
+	Secret string `json:"secret"`
+	// Paths шаблоны path.Match, "/dir/**" — все внутри dir. Пусто — все пути.
+	Paths []string `json:"paths,omitempty"`
+	// Events действия, пусто — все изменения
+	Events []AuditAction `json:"events,omitempty"`
+}
+
+// Match true если изменение подходит под события и пути подписки. Для move
+// путь проверяется и до, и после перемещения.
+func (x *Webhook) Match(rec *AuditRecord) bool {
+	if len(x.Events) > 0 {
+		found := false
+		for _, e := range x.Events {
+			found = found || e == rec.Action
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(x.Paths) == 0 {
+		return true
+	}
+	for _, pattern := range x.Paths {
+		if matchPath(pattern, rec.Path) || rec.From != "" && matchPath(pattern, rec.From) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchPath(pattern, p string) bool {
+	if dir, ok := strings.CutSuffix(pattern, "/**"); ok {
+		return hasPathPrefix(p, dir) && p != dir
+	}
+	ok, _ := path.Match(pattern, p)
+	return ok
+}
+
+// WebhookEvent тело запроса к подписчику. ID одинаковый у повторов одной
+// доставки, по нему получатель отбрасывает дубли.
+type WebhookEvent struct {
+	ID        string      `json:"id"`
+	Webhook   string      `json:"webhook"`
+	Action    AuditAction `json:"action"`
+	Path      string      `json:"path"`
+	From      string      `json:"from,omitempty"`
+	Size      int64       `json:"size,omitempty"`
+	SHA256    string      `json:"sha256,omitempty"`
+	Principal string      `json:"principal"`
+	RequestID string      `json:"request_id,omitempty"`
+	Time      time.Time   `json:"time"`
+}
+
+// WebhookDelivery доставка события, ожидающая в outbox или неудавшаяся
+type WebhookDelivery struct {
+	ID          string       `json:"id"`
+	Webhook     string       `json:"webhook"`
+	Event       WebhookEvent `json:"event"`
+	Attempts    int          `json:"attempts"`
+	NextAttempt time.Time    `json:"next_attempt"`
+	LastError   string       `json:"last_error,omitempty"`
+	Created     time.Time    `json:"created"`
+}
@@ -0,0 +1,184 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	ErrHostNotAllowed = errors.New("host is not allowed")
+	ErrTooLarge       = errors.New("document exceeds size limit")
+	ErrStatus         = errors.New("unexpected response status")
+)
+
+// Config ограничения скачивания. Нулевое значение отключает ограничение,
+// кроме AllowedHosts: пустой список запрещает любые адреса.
+type Config struct {
+	// ConnectTimeout таймаут установки соединения
+	ConnectTimeout time.Duration
+	// Timeout таймаут всего запроса вместе с чтением тела
+	Timeout time.Duration
+	// Retries сколько раз повторить запрос после сетевой ошибки или 5xx
+	Retries int
+	// Backoff пауза перед первым повтором, дальше удваивается
+	Backoff time.Duration
+	// MaxSize максимальный размер тела ответа
+	MaxSize int64
+	// AllowedHosts хосты (host или host:port), с которых разрешено скачивать
+	AllowedHosts []string
+}
+
+// Client скачивает документы только с разрешенных хостов: url в callback
+// приходит извне и не должен вести во внутреннюю сеть (SSRF).
+type Client struct {
+	cfg    Config
+	client *http.Client
+}
+
+func New(cfg Config) *Client {
+	x := &Client{cfg: cfg}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: cfg.ConnectTimeout}).DialContext
+	transport.ResponseHeaderTimeout = cfg.Timeout
+
+	x.client = &http.Client{
+		Transport: transport,
+		Timeout:   cfg.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			return x.checkHost(req.URL)
+		},
+	}
+
+	return x
+}
+
+// Get скачивает rawUrl с повторами. Тело ответа ограничено MaxSize:
+// чтение сверх лимита возвращает ErrTooLarge.
+func (x *Client) Get(ctx context.Context, rawUrl string) (*http.Response, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	if err := x.checkHost(u); err != nil {
+		return nil, err
+	}
+
+	backoff := x.cfg.Backoff
+	for attempt := 0; ; attempt++ {
+		resp, err := x.get(ctx, u.String())
+		if err == nil || !retryable(err) || attempt >= x.cfg.Retries {
+			return resp, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (x *Client) get(ctx context.Context, rawUrl string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := x.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, &statusError{status: resp.StatusCode}
+	}
+
+	if x.cfg.MaxSize > 0 {
+		if resp.ContentLength > x.cfg.MaxSize {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%w: %d bytes", ErrTooLarge, resp.ContentLength)
+		}
+		resp.Body = &limitedBody{ReadCloser: resp.Body, left: x.cfg.MaxSize}
+	}
+
+	return resp, nil
+}
+
+func (x *Client) checkHost(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: scheme %q", ErrHostNotAllowed, u.Scheme)
+	}
+
+	for _, h := range x.cfg.AllowedHosts {
+		if strings.EqualFold(h, u.Host) || strings.EqualFold(h, u.Hostname()) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrHostNotAllowed, u.Host)
+}
+
+type statusError struct {
+	status int
+}
+
+func (x *statusError) Error() string {
+	return fmt.Sprintf("%s: %d %s", ErrStatus, x.status, http.StatusText(x.status))
+}
+
+func (x *statusError) Unwrap() error {
+	return ErrStatus
+}
+
+// retryable повторяются сетевые ошибки, 5xx и 429
+func retryable(err error) bool {
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.status >= 500 || se.status == http.StatusTooManyRequests
+	}
+	if errors.Is(err, ErrHostNotAllowed) || errors.Is(err, ErrTooLarge) ||
+		errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var ue *url.Error
+	if errors.As(err, &ue) && errors.Is(ue.Err, ErrHostNotAllowed) {
+		return false
+	}
+	return true
+}
+
+type limitedBody struct {
+	io.ReadCloser
+	left int64
+}
+
+func (x *limitedBody) Read(p []byte) (int, error) {
+	if x.left <= 0 {
+		// тело длиннее лимита, если в нем есть еще хотя бы байт
+		var b [1]byte
+		if n, _ := x.ReadCloser.Read(b[:]); n > 0 {
+			return 0, ErrTooLarge
+		}
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > x.left {
+		p = p[:x.left]
+	}
+	n, err := x.ReadCloser.Read(p)
+	x.left -= int64(n)
+	return n, err
+}
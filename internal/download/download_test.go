@@ -0,0 +1,107 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClientGet(t *testing.T) {
+	var flaky, missing atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("document"))
+	})
+	// первые два запроса — 503
+	mux.HandleFunc("/flaky", func(w http.ResponseWriter, r *http.Request) {
+		if flaky.Add(1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("document"))
+	})
+	mux.HandleFunc("/missing", func(w http.ResponseWriter, r *http.Request) {
+		missing.Add(1)
+		http.NotFound(w, r)
+	})
+	mux.HandleFunc("/large", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 100)))
+	})
+	// без Content-Length лимит проверяется при чтении
+	mux.HandleFunc("/large-chunked", func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 10; i++ {
+			w.Write([]byte(strings.Repeat("x", 10)))
+			w.(http.Flusher).Flush()
+		}
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://169.254.169.254/latest/meta-data", http.StatusFound)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	host, _ := url.Parse(srv.URL)
+	client := New(Config{
+		ConnectTimeout: time.Second,
+		Timeout:        5 * time.Second,
+		Retries:        2,
+		Backoff:        time.Millisecond,
+		MaxSize:        50,
+		AllowedHosts:   []string{host.Host},
+	})
+
+	tests := []struct {
+		name    string
+		url     string
+		want    string
+		wantErr error
+	}{
+		{name: "ok", url: srv.URL + "/ok", want: "document"},
+		{name: "retried 5xx", url: srv.URL + "/flaky", want: "document"},
+		{name: "not found", url: srv.URL + "/missing", wantErr: ErrStatus},
+		{name: "content length over limit", url: srv.URL + "/large", wantErr: ErrTooLarge},
+		{name: "body over limit", url: srv.URL + "/large-chunked", wantErr: ErrTooLarge},
+		{name: "foreign host", url: "http://169.254.169.254/latest/meta-data", wantErr: ErrHostNotAllowed},
+		{name: "redirect to foreign host", url: srv.URL + "/redirect", wantErr: ErrHostNotAllowed},
+		{name: "file scheme", url: "file:///etc/passwd", wantErr: ErrHostNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := client.Get(context.Background(), tt.url)
+			var data []byte
+			if err == nil {
+				data, err = io.ReadAll(resp.Body)
+				resp.Body.Close()
+			}
+
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Get() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("Get() = %q, want %q", data, tt.want)
+			}
+		})
+	}
+
+	// 5xx повторяется, 404 — нет
+	if n := flaky.Load(); n != 3 {
+		t.Errorf("flaky requests = %d, want 3", n)
+	}
+	if n := missing.Load(); n != 1 {
+		t.Errorf("missing requests = %d, want 1", n)
+	}
+}
@@ -0,0 +1,26 @@
+package interfaces
+
+import "github.com/AleksandrMac/fileserver/internal/domain"
+
+type AuditRepo interface {
+	Append(rec *domain.AuditRecord) error
+	Query(filter *domain.AuditFilter) ([]domain.AuditRecord, error)
+}
+
+// ChangeFeed рассылает изменения хранилища подписчикам
+type ChangeFeed interface {
+	Publish(rec *domain.AuditRecord)
+	// Subscribe изменения внутри prefix. Канал закрывается после cancel или
+	// если подписчик не успевает читать.
+	Subscribe(prefix string) (changes <-chan domain.ChangeEvent, cancel func())
+	// Since как Subscribe, но сначала отдает события после after.
+	// domain.ErrNotFound, если они уже не хранятся.
+	Since(prefix string, after uint64) (changes <-chan domain.ChangeEvent, cancel func(), err error)
+}
+
+type AuditUsecase interface {
+	Record(rec *domain.AuditRecord)
+	Query(filter *domain.AuditFilter) ([]domain.AuditRecord, error)
+	// LogDownloads включена ли запись скачиваний
+	LogDownloads() bool
+}
@@ -0,0 +1,13 @@
+package interfaces
+
+import "github.com/AleksandrMac/fileserver/internal/domain"
+
+// DocKeyRepo соответствие ключей документов OnlyOffice путям файлов
+type DocKeyRepo interface {
+	Put(key, path string) error
+	Get(key string) (path string, err error)
+	Delete(key string) error
+	Grant(key, userId string, access domain.Access) error
+	Access(key, userId string) (domain.Access, error)
+	Writable(key string) bool
+}
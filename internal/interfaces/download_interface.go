@@ -0,0 +1,11 @@
+package interfaces
+
+import (
+	"context"
+	"net/http"
+)
+
+// Downloader скачивает файлы Document Server с ограничениями по времени, размеру и адресам
+type Downloader interface {
+	Get(ctx context.Context, rawUrl string) (*http.Response, error)
+}
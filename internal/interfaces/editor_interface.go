@@ -1,9 +1,40 @@
 package interfaces
 
-import "io"
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+)
 
 type EditorUsecase interface {
-	GenerateEditorToken(docURL, callbackURL, docKey string) string
-	VerifyEditorToken(token string) bool
-	EditHtml(w io.Writer, userName, userId, fileName string) error
+	GenerateEditorToken(config map[string]any) string
+	// GenerateAccessToken токен доступа к API от имени пользователя userId
+	GenerateAccessToken(userId string, ttl time.Duration) (string, error)
+	// VerifyAccessToken пользователь из токена доступа
+	VerifyAccessToken(token string) (userId string, ok bool)
+	// EditHtml отдает страницу редактора, domain.ErrForbidden если доступа нет
+	EditHtml(w io.Writer, req *domain.EditorRequest) error
+	CreateDocument(dir, kind, template string) (path string, size int64, err error)
+	// Convert конвертирует файл в формат outputType через Document Server
+	Convert(ctx context.Context, fileName, outputType string) (io.ReadCloser, error)
+	// ConvertAndStore конвертирует файл и сохраняет результат в том же каталоге
+	ConvertAndStore(ctx context.Context, fileName, outputType string) (path string, size int64, err error)
+	// EditorSession ключ документа и пользователь из токена конфигурации редактора
+	EditorSession(token string) (key, userId string, err error)
+	// History версии документа для панели истории редактора
+	History(key string) (*domain.EditorHistory, error)
+	// HistoryData подписанные данные версии для setHistoryData
+	HistoryData(key string, version int) (map[string]any, error)
+	// VersionFile содержимое версии по подписанной ссылке из HistoryData
+	VersionFile(token string) (io.ReadCloser, error)
+}
+
+// ConvertJobs асинхронные задачи конвертации
+type ConvertJobs interface {
+	// Start ставит задачу в очередь, done вызывается по ее завершении.
+	// domain.ErrConvertBusy если очередь заполнена.
+	Start(fileName, outputType string, done func(domain.ConvertJob)) (domain.ConvertJob, error)
+	Get(id string) (domain.ConvertJob, bool)
 }
@@ -1,8 +1,10 @@
 package interfaces
 
 import (
+	"context"
 	"io"
 	"os"
+	"time"
 
 	"github.com/AleksandrMac/fileserver/internal/domain"
 )
@@ -12,15 +14,62 @@ type StorageInfoIface interface {
 	GetStorageInfo() (*domain.StorageInfo, error)
 }
 
+// StorageObserver узнает о записи в хранилище через FileRepository, чтобы
+// отличать ее от изменений, сделанных в обход сервера
+type StorageObserver interface {
+	// BeginWrite вызывается перед изменением path, EndWrite после него
+	BeginWrite(path string)
+	EndWrite(path string)
+}
+
+// UsageRepo занятое место по каталогам, обновляется при каждой записи.
+// Пути полные, как у FileRepo.
+type UsageRepo interface {
+	StorageObserver
+	// Add учитывает появление, изменение или удаление файла path
+	Add(path string, files, size int64)
+	// RemoveDir убирает каталог path со всем содержимым
+	RemoveDir(path string)
+	// MoveDir переносит учет каталога from со всем содержимым в to
+	MoveDir(from, to string)
+	// Usage файлов в каталоге path и всех вложенных
+	Usage(path string) domain.Usage
+	// Reconcile сверяет учет с диском, делая паузу pause после каждого каталога.
+	// Возвращает число исправленных каталогов.
+	Reconcile(ctx context.Context, pause time.Duration) (int, error)
+	// Flush сохраняет учет, если он изменился
+	Flush() error
+	// Loaded true если учет прочитан с диска, false если его нужно собрать сверкой
+	Loaded() bool
+}
+
+// WatchedFileRepo кеши репозитория, которые сбрасываются при изменениях извне
+type WatchedFileRepo interface {
+	// Hash SHA256 файла в hex
+	Hash(path string) (string, error)
+	// Forget сбрасывает кеш хешей path и всего, что внутри
+	Forget(path string)
+}
+
 type FileRepo interface {
 	StorageInfoIface
 	GetFullPath(relPath string) (string, error)
 	FileInfo(path string) (os.FileInfo, error)
 	SaveFile(path string, data io.Reader) error
+	// CreateFile как SaveFile, но возвращает os.ErrExist если файл уже есть
+	CreateFile(path string, data io.Reader) error
+	Mkdir(path string) error
+	// Remove удаляет файл или каталог со всем содержимым
+	Remove(path string) error
+	Rename(oldPath, newPath string) error
 	List(path string) ([]domain.FileInfo, error)
 	ListZipContents(zipPath string) ([]domain.FileInfo, error)
 	ReadFile(path string) (*os.File, error)
 	GetFileSize(path string) (int64, error)
+	// Hash SHA256 файла в hex
+	Hash(path string) (string, error)
+	// Usage файлов в каталоге path и всех вложенных
+	Usage(path string) (domain.Usage, error)
 }
 
 type FileUsecase interface {
@@ -28,8 +77,18 @@ type FileUsecase interface {
 	GetFullPath(relPath string) (string, error)
 	FileInfo(path string) (os.FileInfo, error)
 	SaveFile(path string, data io.Reader) error
+	// Mkdir создает каталог, родительский каталог должен существовать
+	Mkdir(path string) error
+	// Remove удаляет файл или каталог со всем содержимым
+	Remove(path string) error
+	// Rename переносит файл или каталог, существующий newPath заменяется
+	Rename(oldPath, newPath string) error
 	List(path string) ([]domain.FileInfo, error)
 	ListZipContents(zipPath string) ([]domain.FileInfo, error)
 	ReadFile(path string) (io.ReadCloser, error)
 	GetFileSize(path string) (int64, error)
+	// Meta подробная информация о файле или каталоге relPath
+	Meta(relPath string) (*domain.FileMeta, error)
+	// Manifest все файлы и каталоги под relPath с SHA256 файлов
+	Manifest(relPath string) ([]domain.ManifestEntry, error)
 }
@@ -0,0 +1,25 @@
+package interfaces
+
+import (
+	"io"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+)
+
+type HistoryRepo interface {
+	AddVersion(relPath string, prev, diff io.Reader, meta *domain.FileVersion) error
+	List(relPath string) ([]domain.FileVersion, error)
+	// OpenVersion содержимое файла в версии version
+	OpenVersion(relPath string, version int) (io.ReadCloser, error)
+	// OpenDiff архив изменений, сохраненный с версией version
+	OpenDiff(relPath string, version int) (io.ReadCloser, error)
+}
+
+type SessionRegistry interface {
+	// Update отмечает пользователей, редактирующих документ key
+	Update(key, path string, users []string)
+	// End завершает сессию документа key
+	End(key string)
+	// List активные сессии, при непустом path — только для этого файла
+	List(path string) []domain.EditSession
+}
@@ -0,0 +1,32 @@
+package interfaces
+
+import (
+	"time"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+)
+
+// LockRepo явные блокировки файлов
+type LockRepo interface {
+	Put(lock domain.Lock) error
+	Get(path string) (domain.Lock, bool)
+	Delete(path string) error
+	List() ([]domain.Lock, error)
+}
+
+type LockUsecase interface {
+	// Lock блокирует path на timeout, с токеном действующей блокировки — продлевает ее.
+	// deep блокирует и все вложенное в каталог path.
+	Lock(path, owner, token string, deep bool, timeout time.Duration) (*domain.Lock, error)
+	// Unlock снимает блокировку владельцем токена
+	Unlock(path, token string) error
+	// Break снимает любую блокировку path, в том числе сессию редактирования
+	Break(path string) (*domain.Lock, error)
+	// Active действующая блокировка path или ближайшего каталога с deep, либо nil
+	Active(path string) *domain.Lock
+	// ByToken явная блокировка с токеном token или nil
+	ByToken(token string) *domain.Lock
+	// CheckWrite возвращает domain.ErrLocked, если запись с token конфликтует с блокировкой
+	CheckWrite(path, token string) (*domain.Lock, error)
+	List() ([]domain.Lock, error)
+}
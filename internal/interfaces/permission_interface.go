@@ -0,0 +1,8 @@
+package interfaces
+
+import "github.com/AleksandrMac/fileserver/internal/domain"
+
+type PermissionRepo interface {
+	// Resolve права пользователя userId на файл path
+	Resolve(path, userId string) domain.Permissions
+}
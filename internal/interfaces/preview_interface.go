@@ -0,0 +1,7 @@
+package interfaces
+
+import "github.com/AleksandrMac/fileserver/internal/domain"
+
+type PreviewUsecase interface {
+	Prepare(relPath string) (*domain.Preview, error)
+}
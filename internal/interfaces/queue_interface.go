@@ -0,0 +1,19 @@
+package interfaces
+
+import "github.com/AleksandrMac/fileserver/internal/domain"
+
+// SaveQueueRepo хранилище очереди сохранений, переживает перезапуск
+type SaveQueueRepo interface {
+	// Put сохраняет задачу и присваивает ей Id, задающий порядок
+	Put(job *domain.SaveJob) error
+	// Pending задачи в порядке постановки
+	Pending() ([]domain.SaveJob, error)
+	Done(id string) error
+	// Fail переносит задачу к неудавшимся для ручного разбора
+	Fail(id string) error
+}
+
+type SaveQueue interface {
+	// Enqueue возвращает управление после записи задачи на диск
+	Enqueue(job *domain.SaveJob) error
+}
@@ -0,0 +1,27 @@
+package interfaces
+
+import (
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+)
+
+// UploadRepo части незавершенных multipart-загрузок S3
+type UploadRepo interface {
+	// Create присваивает загрузке Id
+	Create(upload *domain.MultipartUpload) error
+	// Get возвращает domain.ErrNotFound для неизвестной или прерванной загрузки
+	Get(id string) (*domain.MultipartUpload, error)
+	PutPart(id string, number int, data io.Reader) (domain.UploadPart, error)
+	Parts(id string) ([]domain.UploadPart, error)
+	OpenPart(id string, number int) (*os.File, error)
+	Delete(id string) error
+}
+
+type S3 interface {
+	// Serve обрабатывает запрос S3 API с проверкой подписи SigV4. notify вызывается
+	// после каждого изменения хранилища и при отклоненной подписи.
+	Serve(w http.ResponseWriter, r *http.Request, notify func(rec domain.AuditRecord))
+}
@@ -0,0 +1,8 @@
+package interfaces
+
+import "github.com/AleksandrMac/fileserver/internal/domain"
+
+// SFTPUserRepo учетные записи SFTP из конфигурации
+type SFTPUserRepo interface {
+	Get(name string) (*domain.SFTPUser, bool)
+}
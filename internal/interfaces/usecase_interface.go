@@ -7,7 +7,15 @@ import (
 	"github.com/AleksandrMac/fileserver/pkg/uerror"
 )
 
-type TrackUsecase UseCaseI[*TrackRequest, *TrackResponse]
+type TrackUsecase interface {
+	UseCaseI[*TrackRequest, *TrackResponse]
+	// Restore восстанавливает версию документа тем же путем, что и сохранение из callback
+	Restore(req *RestoreRequest) uerror.UError
+	// ProcessSave сохраняет документ по задаче из очереди, очередь повторяет его при ошибке
+	ProcessSave(job *SaveJob) error
+	// FinishSave вызывается один раз после последней попытки сохранения
+	FinishSave(job *SaveJob)
+}
 
 type UseCaseI[Input, Output any] interface {
 	ReadRequestData(r *http.Request) (args Input, err uerror.UError)
@@ -0,0 +1,22 @@
+package interfaces
+
+import (
+	"net/http"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+)
+
+// DavPropRepo свойства ресурсов WebDAV, заданные клиентами
+type DavPropRepo interface {
+	Get(path string) []domain.DavProperty
+	Put(path string, props []domain.DavProperty) error
+	// Move и Delete применяются к ресурсу и всему, что в нем
+	Move(oldPath, newPath string) error
+	Delete(path string) error
+}
+
+type WebDAV interface {
+	// Serve обрабатывает запрос WebDAV от имени owner. notify вызывается после
+	// каждого изменения хранилища с записью для аудита.
+	Serve(w http.ResponseWriter, r *http.Request, owner string, notify func(rec domain.AuditRecord))
+}
@@ -0,0 +1,28 @@
+package interfaces
+
+import "github.com/AleksandrMac/fileserver/internal/domain"
+
+type WebhookRepo interface {
+	List() []domain.Webhook
+	Get(id string) (*domain.Webhook, bool)
+}
+
+// WebhookOutboxRepo доставки, ожидающие отправки, переживают перезапуск
+type WebhookOutboxRepo interface {
+	// Put сохраняет доставку и присваивает ей ID, задающий порядок
+	Put(d *domain.WebhookDelivery) error
+	Update(d *domain.WebhookDelivery) error
+	// Pending доставки в порядке постановки
+	Pending() ([]domain.WebhookDelivery, error)
+	Done(id string) error
+	// Fail переносит доставку к неудавшимся
+	Fail(d *domain.WebhookDelivery) error
+	Dead() ([]domain.WebhookDelivery, error)
+	// Redeliver возвращает неудавшуюся доставку в очередь, ErrNotFound если ее нет
+	Redeliver(id string) (*domain.WebhookDelivery, error)
+}
+
+type WebhookUsecase interface {
+	Dead() ([]domain.WebhookDelivery, error)
+	Redeliver(id string) (*domain.WebhookDelivery, error)
+}
@@ -6,11 +6,6 @@ import (
 )
 
 var (
-	TotalStorageSize = promauto.NewGauge(prometheus.GaugeOpts{
-		Name: "fileserver_total_storage_bytes",
-		Help: "Total size of all stored files in bytes",
-	})
-
 	RequesCount = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "fileserver_requests_total",
 		Help: "Total number of HTTP request",
@@ -25,4 +20,59 @@ var (
 		Name: "fileserver_bytes_uploaded_total",
 		Help: "Total number of bytes uploaded",
 	})
+
+	Throttled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fileserver_throttled_requests_total",
+		Help: "Total number of requests rejected by rate limits",
+	}, []string{"limit"})
+
+	TrackCallbacks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fileserver_track_callbacks_total",
+		Help: "Total number of Document Server callbacks by status",
+	}, []string{"status"})
+
+	TrackErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fileserver_track_errors_total",
+		Help: "Total number of Document Server save errors (status 3 and 7)",
+	}, []string{"status"})
+
+	SaveQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "fileserver_save_queue_depth",
+		Help: "Number of editor saves waiting in the queue or in progress",
+	})
+
+	SaveQueueLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "fileserver_save_queue_latency_seconds",
+		Help:    "Time from callback to saved document",
+		Buckets: []float64{0.1, 0.5, 1, 2, 5, 10, 30, 60, 120, 300},
+	}, []string{"result"})
+
+	WebhookDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fileserver_webhook_deliveries_total",
+		Help: "Total number of webhook delivery attempts by result (ok, retry, dead)",
+	}, []string{"result"})
+
+	WebhookOutboxDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "fileserver_webhook_outbox_depth",
+		Help: "Number of webhook deliveries waiting in the outbox",
+	})
+
+	ExternalChanges = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fileserver_external_changes_total",
+		Help: "Total number of storage changes made bypassing the server, by action",
+	}, []string{"action"})
+
+	WatchRescans = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "fileserver_watch_rescans_total",
+		Help: "Total number of full storage rescans by the watcher",
+	})
 )
+
+// StorageSize задает, откуда брать размер хранилища. Значение читается при
+// каждом сборе метрик, поэтому size должна отвечать без обхода дерева.
+func StorageSize(size func() int64) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "fileserver_total_storage_bytes",
+		Help: "Total size of all stored files in bytes",
+	}, func() float64 { return float64(size()) })
+}
@@ -0,0 +1,240 @@
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Kind тип ограничиваемой параллельной передачи
+type Kind int
+
+const (
+	Download Kind = iota
+	Upload
+)
+
+// Config лимиты на одного клиента. Нулевое значение отключает лимит.
+type Config struct {
+	// RequestsPerSecond скорость пополнения корзины запросов
+	RequestsPerSecond float64
+	// Burst размер корзины запросов
+	Burst int
+	// MaxDownloads максимум одновременных скачиваний
+	MaxDownloads int
+	// MaxUploads максимум одновременных загрузок
+	MaxUploads int
+	// BytesPerSecond ограничение полосы пропускания
+	BytesPerSecond int
+	// IdleTTL через сколько неактивный клиент удаляется из памяти
+	IdleTTL time.Duration
+	// MaxClients сколько клиентов держать в памяти, при переполнении
+	// вытесняется давно не появлявшийся клиент без активных передач
+	MaxClients int
+}
+
+type client struct {
+	requests  *rate.Limiter
+	bandwidth *rate.Limiter
+	active    [2]int
+	lastSeen  time.Time
+}
+
+// Limiter хранит token-bucket лимиты по ключу клиента
+type Limiter struct {
+	cfg       Config
+	mu        sync.Mutex
+	clients   map[string]*client
+	lastSweep time.Time
+}
+
+func New(cfg Config) *Limiter {
+	if cfg.Burst <= 0 {
+		cfg.Burst = max(1, int(cfg.RequestsPerSecond))
+	}
+	if cfg.IdleTTL <= 0 {
+		cfg.IdleTTL = 10 * time.Minute
+	}
+	if cfg.MaxClients <= 0 {
+		cfg.MaxClients = 100000
+	}
+
+	return &Limiter{
+		cfg:       cfg,
+		clients:   make(map[string]*client),
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow списывает один токен из корзины запросов клиента.
+// Если токена нет — возвращает время, через которое стоит повторить.
+func (x *Limiter) Allow(key string) (bool, time.Duration) {
+	if x.cfg.RequestsPerSecond <= 0 {
+		return true, 0
+	}
+
+	x.mu.Lock()
+	c := x.get(key)
+	x.mu.Unlock()
+
+	r := c.requests.Reserve()
+	if delay := r.Delay(); delay > 0 {
+		r.Cancel()
+		return false, delay
+	}
+
+	return true, 0
+}
+
+// Acquire занимает слот параллельной передачи.
+// release необходимо вызвать по окончании передачи.
+func (x *Limiter) Acquire(key string, kind Kind) (release func(), ok bool) {
+	limit := x.cfg.MaxDownloads
+	if kind == Upload {
+		limit = x.cfg.MaxUploads
+	}
+	if limit <= 0 {
+		return func() {}, true
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	c := x.get(key)
+	if c.active[kind] >= limit {
+		return nil, false
+	}
+	c.active[kind]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			x.mu.Lock()
+			c.active[kind]--
+			c.lastSeen = time.Now()
+			x.mu.Unlock()
+		})
+	}, true
+}
+
+// Bandwidth возвращает ограничитель полосы клиента, nil если лимит отключен
+func (x *Limiter) Bandwidth(key string) *rate.Limiter {
+	if x.cfg.BytesPerSecond <= 0 {
+		return nil
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	return x.get(key).bandwidth
+}
+
+// get должен вызываться под x.mu
+func (x *Limiter) get(key string) *client {
+	now := time.Now()
+	x.sweep(now)
+
+	c, ok := x.clients[key]
+	if !ok {
+		if len(x.clients) >= x.cfg.MaxClients {
+			x.evict()
+		}
+		c = &client{
+			requests: rate.NewLimiter(rate.Limit(x.cfg.RequestsPerSecond), x.cfg.Burst),
+		}
+		if x.cfg.BytesPerSecond > 0 {
+			c.bandwidth = rate.NewLimiter(rate.Limit(x.cfg.BytesPerSecond), x.cfg.BytesPerSecond)
+		}
+		x.clients[key] = c
+	}
+	c.lastSeen = now
+
+	return c
+}
+
+// sweep удаляет клиентов без активных передач, не появлявшихся дольше IdleTTL
+func (x *Limiter) sweep(now time.Time) {
+	if now.Sub(x.lastSweep) < x.cfg.IdleTTL {
+		return
+	}
+	x.lastSweep = now
+
+	for k, c := range x.clients {
+		if c.active[Download] == 0 && c.active[Upload] == 0 && now.Sub(c.lastSeen) > x.cfg.IdleTTL {
+			delete(x.clients, k)
+		}
+	}
+}
+
+// evict удаляет клиента без активных передач, который появлялся раньше всех.
+// Активные клиенты не вытесняются и не сбрасывают свои корзины.
+func (x *Limiter) evict() {
+	var oldest string
+	var found *client
+	for k, c := range x.clients {
+		if c.active[Download] == 0 && c.active[Upload] == 0 && (found == nil || c.lastSeen.Before(found.lastSeen)) {
+			oldest, found = k, c
+		}
+	}
+	if found != nil {
+		delete(x.clients, oldest)
+	}
+}
+
+// Writer ограничивает скорость записи в w
+type Writer struct {
+	w   io.Writer
+	l   *rate.Limiter
+	ctx context.Context
+}
+
+func NewWriter(ctx context.Context, w io.Writer, l *rate.Limiter) *Writer {
+	return &Writer{w: w, l: l, ctx: ctx}
+}
+
+func (x *Writer) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		chunk := min(len(p), x.l.Burst())
+		if err = x.l.WaitN(x.ctx, chunk); err != nil {
+			return n, err
+		}
+
+		m, err := x.w.Write(p[:chunk])
+		n += m
+		if err != nil {
+			return n, err
+		}
+		p = p[chunk:]
+	}
+
+	return n, nil
+}
+
+// Reader ограничивает скорость чтения из r
+type Reader struct {
+	r   io.Reader
+	l   *rate.Limiter
+	ctx context.Context
+}
+
+func NewReader(ctx context.Context, r io.Reader, l *rate.Limiter) *Reader {
+	return &Reader{r: r, l: l, ctx: ctx}
+}
+
+func (x *Reader) Read(p []byte) (int, error) {
+	if len(p) > x.l.Burst() {
+		p = p[:x.l.Burst()]
+	}
+
+	n, err := x.r.Read(p)
+	if n > 0 {
+		if werr := x.l.WaitN(x.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+
+	return n, err
+}
@@ -0,0 +1,90 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAllow(t *testing.T) {
+	l := New(Config{RequestsPerSecond: 1, Burst: 2})
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatalf("request %d rejected within burst", i)
+		}
+	}
+
+	ok, retryAfter := l.Allow("a")
+	if ok {
+		t.Fatal("request beyond burst allowed")
+	}
+	if retryAfter <= 0 {
+		t.Errorf("retryAfter = %v, want > 0", retryAfter)
+	}
+
+	// лимиты разных клиентов независимы
+	if ok, _ := l.Allow("b"); !ok {
+		t.Error("other client rejected")
+	}
+}
+
+func TestAcquire(t *testing.T) {
+	l := New(Config{MaxDownloads: 1})
+
+	release, ok := l.Acquire("a", Download)
+	if !ok {
+		t.Fatal("first download rejected")
+	}
+	if _, ok := l.Acquire("a", Download); ok {
+		t.Fatal("second concurrent download allowed")
+	}
+	if _, ok := l.Acquire("a", Upload); !ok {
+		t.Fatal("unlimited upload rejected")
+	}
+
+	release()
+	release() // повторный вызов не должен освобождать чужой слот
+
+	if _, ok := l.Acquire("a", Download); !ok {
+		t.Fatal("download rejected after release")
+	}
+	if _, ok := l.Acquire("a", Download); ok {
+		t.Fatal("double release freed an extra slot")
+	}
+}
+
+func TestMaxClients(t *testing.T) {
+	l := New(Config{RequestsPerSecond: 1, Burst: 1, MaxClients: 2, MaxDownloads: 1})
+
+	release, _ := l.Acquire("busy", Download)
+	defer release()
+	l.Allow("idle")
+	l.Allow("new")
+
+	if len(l.clients) != 2 {
+		t.Fatalf("clients = %d, want 2", len(l.clients))
+	}
+	if _, ok := l.clients["busy"]; !ok {
+		t.Fatal("client with active transfer evicted")
+	}
+}
+
+func TestEvictLeastRecentlySeen(t *testing.T) {
+	l := New(Config{RequestsPerSecond: 1, Burst: 1, MaxClients: 3})
+
+	for _, key := range []string{"a", "b", "c"} {
+		l.Allow(key)
+		time.Sleep(time.Millisecond)
+	}
+	l.Allow("a")
+	l.Allow("d")
+
+	if _, ok := l.clients["b"]; ok {
+		t.Fatal("least recently seen client b kept")
+	}
+	for _, key := range []string{"a", "c", "d"} {
+		if _, ok := l.clients[key]; !ok {
+			t.Errorf("client %s evicted", key)
+		}
+	}
+}
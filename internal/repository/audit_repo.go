@@ -0,0 +1,221 @@
+package repository
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+)
+
+const (
+	auditFileName   = "audit.log"
+	auditFilePrefix = "audit-"
+)
+
+// AuditRepository журнал аудита в формате JSON lines.
+// Текущий файл audit.log дописывается, при превышении maxSize
+// переименовывается в audit-<time>.log; хранится не более maxFiles архивов.
+type AuditRepository struct {
+	dir      string
+	maxSize  int64
+	maxFiles int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func NewAuditRepository(dir string, maxSize int64, maxFiles int) (*AuditRepository, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+
+	x := &AuditRepository{
+		dir:      dir,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+	}
+	if err := x.open(); err != nil {
+		return nil, err
+	}
+
+	return x, nil
+}
+
+func (x *AuditRepository) Append(rec *domain.AuditRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if x.maxSize > 0 && x.size+int64(len(line)) > x.maxSize && x.size > 0 {
+		if err := x.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := x.file.Write(line)
+	x.size += int64(n)
+	return err
+}
+
+// Query читает архивы и текущий файл в хронологическом порядке.
+// При filter.Limit > 0 возвращаются последние Limit записей.
+func (x *AuditRepository) Query(filter *domain.AuditFilter) ([]domain.AuditRecord, error) {
+	files, err := x.snapshot()
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	// файлы уже открыты: ротация и удаление старых архивов чтению не мешают
+	result := make([]domain.AuditRecord, 0)
+	for _, f := range files {
+		if result, err = x.scan(f, filter, result); err != nil {
+			return nil, err
+		}
+	}
+
+	if filter.Limit > 0 && len(result) > filter.Limit {
+		result = result[len(result)-filter.Limit:]
+	}
+
+	return result, nil
+}
+
+func (x *AuditRepository) Close() error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	return x.file.Close()
+}
+
+// snapshot открывает архивы и текущий файл под блокировкой. Текущий файл
+// читается до размера на момент снимка, недописанная строка не попадет.
+func (x *AuditRepository) snapshot() ([]io.ReadCloser, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	names, err := x.archives()
+	if err != nil {
+		return nil, err
+	}
+	var files []io.ReadCloser
+	for _, name := range names {
+		f, err := os.Open(name)
+		if err != nil {
+			return files, err
+		}
+		files = append(files, f)
+	}
+
+	f, err := os.Open(filepath.Join(x.dir, auditFileName))
+	if err != nil {
+		return files, err
+	}
+	files = append(files, struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, x.size), f})
+	return files, nil
+}
+
+func (x *AuditRepository) scan(f io.Reader, filter *domain.AuditFilter, result []domain.AuditRecord) ([]domain.AuditRecord, error) {
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var rec domain.AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			// поврежденная строка (например, обрыв записи) — пропускаем
+			continue
+		}
+		if filter.Match(&rec) {
+			result = append(result, rec)
+		}
+	}
+
+	return result, scanner.Err()
+}
+
+func (x *AuditRepository) open() error {
+	f, err := os.OpenFile(filepath.Join(x.dir, auditFileName), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	x.file = f
+	x.size = info.Size()
+	return nil
+}
+
+func (x *AuditRepository) rotate() error {
+	if err := x.file.Close(); err != nil {
+		return err
+	}
+
+	archive := filepath.Join(x.dir, fmt.Sprintf("%s%s.log", auditFilePrefix, time.Now().UTC().Format("20060102T150405.000000000")))
+	if err := os.Rename(filepath.Join(x.dir, auditFileName), archive); err != nil {
+		return err
+	}
+
+	if err := x.open(); err != nil {
+		return err
+	}
+
+	if x.maxFiles <= 0 {
+		return nil
+	}
+
+	archives, err := x.archives()
+	if err != nil {
+		return err
+	}
+	for len(archives) > x.maxFiles {
+		if err := os.Remove(archives[0]); err != nil {
+			return err
+		}
+		archives = archives[1:]
+	}
+
+	return nil
+}
+
+// archives возвращает архивные файлы от старых к новым
+func (x *AuditRepository) archives() ([]string, error) {
+	entries, err := os.ReadDir(x.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasPrefix(e.Name(), auditFilePrefix) && strings.HasSuffix(e.Name(), ".log") {
+			result = append(result, filepath.Join(x.dir, e.Name()))
+		}
+	}
+	sort.Strings(result)
+
+	return result, nil
+}
@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+)
+
+func TestAuditRepositoryRotateAndQuery(t *testing.T) {
+	dir := t.TempDir()
+
+	repo, err := NewAuditRepository(dir, 200, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+
+	start := time.Date(2024, 12, 1, 10, 0, 0, 0, time.UTC)
+	paths := []string{"/docs/a.txt", "/docs/b.txt", "/img/c.png", "/docs/sub/d.txt", "/documents/e.txt"}
+	for i, p := range paths {
+		err := repo.Append(&domain.AuditRecord{
+			Time:      start.Add(time.Duration(i) * time.Minute),
+			Action:    domain.AuditUpload,
+			Principal: "api-key",
+			Path:      p,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	archives, _ := filepath.Glob(filepath.Join(dir, "audit-*.log"))
+	if len(archives) == 0 || len(archives) > 2 {
+		t.Fatalf("archives = %d, want 1..2", len(archives))
+	}
+	if _, err := os.Stat(filepath.Join(dir, auditFileName)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		filter domain.AuditFilter
+		want   []string
+	}{
+		{
+			name:   "path prefix",
+			filter: domain.AuditFilter{Path: "/docs"},
+			want:   []string{"/docs/sub/d.txt"},
+		},
+		{
+			name:   "time range",
+			filter: domain.AuditFilter{From: start.Add(3 * time.Minute), To: start.Add(4 * time.Minute)},
+			want:   []string{"/docs/sub/d.txt", "/documents/e.txt"},
+		},
+		{
+			name:   "principal mismatch",
+			filter: domain.AuditFilter{Principal: "editor-token"},
+			want:   nil,
+		},
+		{
+			name:   "limit keeps newest",
+			filter: domain.AuditFilter{Limit: 1},
+			want:   []string{"/documents/e.txt"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := repo.Query(&tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// старые архивы удалены ротацией, поэтому сравниваем только хвост
+			var paths []string
+			for _, rec := range got {
+				if !tt.filter.Match(&rec) {
+					t.Errorf("record %+v does not match filter", rec)
+				}
+				paths = append(paths, rec.Path)
+			}
+			if len(paths) < len(tt.want) {
+				t.Fatalf("Query() = %v, want suffix %v", paths, tt.want)
+			}
+			paths = paths[len(paths)-len(tt.want):]
+			for i := range tt.want {
+				if paths[i] != tt.want[i] {
+					t.Errorf("Query() = %v, want suffix %v", paths, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestAuditFilterPathPrefix(t *testing.T) {
+	tests := []struct {
+		prefix string
+		path   string
+		want   bool
+	}{
+		{"/docs", "/docs", true},
+		{"/docs", "/docs/a.txt", true},
+		{"/docs/", "/docs/a.txt", true},
+		{"/docs", "/documents/a.txt", false},
+		{"/", "/img/c.png", true},
+	}
+
+	for _, tt := range tests {
+		f := domain.AuditFilter{Path: tt.prefix}
+		if got := f.Match(&domain.AuditRecord{Path: tt.path}); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.prefix, tt.path, got, tt.want)
+		}
+	}
+}
+
+// запросы идут параллельно с записью и ротацией
+func TestAuditQueryDuringAppend(t *testing.T) {
+	repo, err := NewAuditRepository(t.TempDir(), 500, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		start := time.Now()
+		for i := 0; i < 200; i++ {
+			repo.Append(&domain.AuditRecord{Time: start.Add(time.Duration(i)), Action: domain.AuditUpload, Path: "/a.txt"})
+		}
+	}()
+
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		recs, err := repo.Query(&domain.AuditFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 1; i < len(recs); i++ {
+			if recs[i].Time.Before(recs[i-1].Time) {
+				t.Fatalf("records out of order at %d", i)
+			}
+		}
+	}
+}
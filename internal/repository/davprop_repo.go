@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+)
+
+// DavPropRepository свойства WebDAV по пути ресурса. Хранятся в памяти
+// и сбрасываются в JSON-файл при каждом изменении.
+type DavPropRepository struct {
+	file string
+
+	mu    sync.Mutex
+	props map[string][]domain.DavProperty
+}
+
+func NewDavPropRepository(file string) (*DavPropRepository, error) {
+	x := &DavPropRepository{
+		file:  file,
+		props: make(map[string][]domain.DavProperty),
+	}
+
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return x, os.MkdirAll(filepath.Dir(file), 0755)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &x.props); err != nil {
+		return nil, err
+	}
+
+	return x, nil
+}
+
+func (x *DavPropRepository) Get(p string) []domain.DavProperty {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	return append([]domain.DavProperty(nil), x.props[path.Clean("/"+p)]...)
+}
+
+// Put заменяет свойства ресурса, пустой props удаляет их
+func (x *DavPropRepository) Put(p string, props []domain.DavProperty) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	p = path.Clean("/" + p)
+	if len(props) == 0 {
+		if _, ok := x.props[p]; !ok {
+			return nil
+		}
+		delete(x.props, p)
+	} else {
+		x.props[p] = props
+	}
+
+	return x.flush()
+}
+
+// Move переносит свойства ресурса и всего, что в нем
+func (x *DavPropRepository) Move(oldPath, newPath string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	oldPath, newPath = path.Clean("/"+oldPath), path.Clean("/"+newPath)
+	x.deleteTree(newPath)
+	for p, props := range x.props {
+		if rel, ok := within(p, oldPath); ok {
+			delete(x.props, p)
+			x.props[newPath+rel] = props
+		}
+	}
+
+	return x.flush()
+}
+
+// Delete удаляет свойства ресурса и всего, что в нем
+func (x *DavPropRepository) Delete(p string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if !x.deleteTree(path.Clean("/" + p)) {
+		return nil
+	}
+	return x.flush()
+}
+
+// deleteTree должен вызываться под x.mu
+func (x *DavPropRepository) deleteTree(dir string) bool {
+	deleted := false
+	for p := range x.props {
+		if _, ok := within(p, dir); ok {
+			delete(x.props, p)
+			deleted = true
+		}
+	}
+	return deleted
+}
+
+// flush должен вызываться под x.mu
+func (x *DavPropRepository) flush() error {
+	return writeJSONFile(x.file, x.props)
+}
+
+// within возвращает остаток пути p внутри dir
+func within(p, dir string) (string, bool) {
+	switch {
+	case p == dir:
+		return "", true
+	case dir == "/":
+		return p, true
+	case strings.HasPrefix(p, dir+"/"):
+		return p[len(dir):], true
+	}
+	return "", false
+}
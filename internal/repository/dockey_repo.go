@@ -0,0 +1,178 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+)
+
+var ErrUnknownKey = errors.New("unknown document key")
+
+type docKeyEntry struct {
+	Path    string    `json:"path"`
+	Created time.Time `json:"created"`
+	// Grants доступ, с которым пользователи открывали документ
+	Grants map[string]domain.Access `json:"grants,omitempty"`
+}
+
+// DocKeyRepository соответствие ключей документов OnlyOffice путям файлов.
+// Хранится в памяти и сбрасывается в JSON-файл при каждом изменении,
+// чтобы callback после перезапуска сервера находил свой файл.
+type DocKeyRepository struct {
+	file string
+	ttl  time.Duration
+
+	mu   sync.Mutex
+	keys map[string]docKeyEntry
+}
+
+// NewDocKeyRepository загружает ключи из file. Ключи старше ttl удаляются при записи.
+func NewDocKeyRepository(file string, ttl time.Duration) (*DocKeyRepository, error) {
+	x := &DocKeyRepository{
+		file: file,
+		ttl:  ttl,
+		keys: make(map[string]docKeyEntry),
+	}
+
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return x, os.MkdirAll(filepath.Dir(file), 0755)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &x.keys); err != nil {
+		return nil, err
+	}
+
+	return x, nil
+}
+
+func (x *DocKeyRepository) Put(key, path string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if e, ok := x.keys[key]; ok && e.Path == path {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	for k, e := range x.keys {
+		if x.ttl > 0 && now.Sub(e.Created) > x.ttl {
+			delete(x.keys, k)
+		}
+	}
+	x.keys[key] = docKeyEntry{Path: path, Created: now}
+
+	return x.flush()
+}
+
+// Grant запоминает доступ пользователя к документу key.
+// Повторное открытие не понижает уже выданный доступ.
+func (x *DocKeyRepository) Grant(key, userId string, access domain.Access) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	e, ok := x.keys[key]
+	if !ok {
+		return ErrUnknownKey
+	}
+	if e.Grants == nil {
+		e.Grants = make(map[string]domain.Access)
+	}
+
+	prev := e.Grants[userId]
+	if prev != "" && prev.Max(access) == prev {
+		return nil
+	}
+	e.Grants[userId] = prev.Max(access)
+	x.keys[key] = e
+
+	return x.flush()
+}
+
+// Access доступ, выданный пользователю к документу key, AccessNone если не выдавался
+func (x *DocKeyRepository) Access(key, userId string) (domain.Access, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	e, ok := x.keys[key]
+	if !ok {
+		return domain.AccessNone, ErrUnknownKey
+	}
+	if a, ok := e.Grants[userId]; ok {
+		return a, nil
+	}
+
+	return domain.AccessNone, nil
+}
+
+// Writable true если хотя бы одному пользователю выдан доступ с правом изменения
+func (x *DocKeyRepository) Writable(key string) bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	for _, a := range x.keys[key].Grants {
+		if a.CanSave() {
+			return true
+		}
+	}
+	return false
+}
+
+func (x *DocKeyRepository) Get(key string) (string, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	e, ok := x.keys[key]
+	if !ok {
+		return "", ErrUnknownKey
+	}
+
+	return e.Path, nil
+}
+
+func (x *DocKeyRepository) Delete(key string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if _, ok := x.keys[key]; !ok {
+		return nil
+	}
+	delete(x.keys, key)
+
+	return x.flush()
+}
+
+// flush должен вызываться под x.mu
+func (x *DocKeyRepository) flush() error {
+	return writeJSONFile(x.file, x.keys)
+}
+
+// writeJSONFile атомарно заменяет file содержимым v в JSON
+func writeJSONFile(file string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".tmp_")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), file)
+}
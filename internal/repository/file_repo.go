@@ -4,18 +4,36 @@ import (
 	"archive/zip"
 	"errors"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"golang.org/x/text/encoding/charmap"
 
 	"github.com/AleksandrMac/fileserver/internal/domain"
+	"github.com/AleksandrMac/fileserver/internal/interfaces"
+	"github.com/AleksandrMac/fileserver/pkg/hashreader"
 )
 
 type FileRepository struct {
 	storagePath      string
 	fallbackEncoding *charmap.Charmap
+
+	hashMu sync.Mutex
+	hashes map[string]fileHash
+
+	observer interfaces.StorageObserver
+	usage    interfaces.UsageRepo
+}
+
+// fileHash SHA256 файла, действителен пока не изменились mtime и размер
+type fileHash struct {
+	modTime time.Time
+	size    int64
+	sum     string
 }
 
 func NewFileRepository(storagePath string) *FileRepository {
@@ -30,9 +48,72 @@ func NewFileRepository(storagePath string) *FileRepository {
 	return &FileRepository{
 		storagePath:      path,
 		fallbackEncoding: charmap.CodePage866,
+		hashes:           make(map[string]fileHash),
 	}
 }
 
+// Observe сообщает o о каждой записи в хранилище через репозиторий.
+// Вызывается до начала работы с репозиторием.
+func (x *FileRepository) Observe(o interfaces.StorageObserver) {
+	x.observer = o
+}
+
+// TrackUsage ведет в u учет занятого места при каждой записи. Без него размер
+// хранилища и каталогов считается обходом дерева.
+// Вызывается до начала работы с репозиторием.
+func (x *FileRepository) TrackUsage(u interfaces.UsageRepo) {
+	x.usage = u
+}
+
+// write отмечает начало записи в paths, возвращает функцию для отметки конца
+func (x *FileRepository) write(paths ...string) func() {
+	var observers []interfaces.StorageObserver
+	for _, o := range []interfaces.StorageObserver{x.observer, x.usage} {
+		if o != nil {
+			observers = append(observers, o)
+		}
+	}
+	for _, o := range observers {
+		for _, p := range paths {
+			o.BeginWrite(p)
+		}
+	}
+	return func() {
+		for _, o := range observers {
+			for _, p := range paths {
+				o.EndWrite(p)
+			}
+		}
+	}
+}
+
+// addUsage учитывает файл path, если ведется учет занятого места
+func (x *FileRepository) addUsage(path string, files, size int64) {
+	if x.usage != nil {
+		x.usage.Add(path, files, size)
+	}
+}
+
+// removeUsage убирает из учета то, что было в path до изменения
+func (x *FileRepository) removeUsage(path string, info os.FileInfo) {
+	switch {
+	case x.usage == nil || info == nil:
+	case info.IsDir():
+		x.usage.RemoveDir(path)
+	case info.Mode().IsRegular():
+		x.usage.Add(path, -1, -info.Size())
+	}
+}
+
+// lstat информация о path, nil если его нет
+func lstat(path string) os.FileInfo {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil
+	}
+	return info
+}
+
 func (x *FileRepository) GetFullPath(relPath string) (string, error) {
 	return x.validateAndCleanPath(relPath)
 }
@@ -52,6 +133,7 @@ func (x *FileRepository) SaveFile(fullPath string, data io.Reader) error {
 	if !strings.HasPrefix(fullPath, x.storagePath) {
 		return errors.New("failed path, want absoulute path.")
 	}
+	defer x.write(fullPath)()
 
 	// 1. создаем все директории в пути
 	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
@@ -65,7 +147,8 @@ func (x *FileRepository) SaveFile(fullPath string, data io.Reader) error {
 	}
 
 	// 3. пишем данные во временный файл
-	_, err = io.Copy(tempFile, data)
+	hr := hashreader.New(data)
+	_, err = io.Copy(tempFile, hr)
 	closeErr := tempFile.Close()
 	if err != nil || closeErr != nil {
 		os.Remove(tempFile.Name())
@@ -76,7 +159,174 @@ func (x *FileRepository) SaveFile(fullPath string, data io.Reader) error {
 	}
 
 	// 4. Атомарно переименовываем (в Linux/Mac — это atomic)
-	return os.Rename(tempFile.Name(), fullPath)
+	x.invalidateHash(fullPath)
+	old := lstat(fullPath)
+	if err := os.Rename(tempFile.Name(), fullPath); err != nil {
+		return err
+	}
+
+	x.removeUsage(fullPath, old)
+	x.addUsage(fullPath, 1, hr.Size())
+	x.storeHash(fullPath, hr.Sum())
+	return nil
+}
+
+func (x *FileRepository) CreateFile(fullPath string, data io.Reader) error {
+	if !strings.HasPrefix(fullPath, x.storagePath) {
+		return errors.New("failed path, want absoulute path.")
+	}
+	defer x.write(fullPath)()
+
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return err
+	}
+
+	tempFile, err := os.CreateTemp(filepath.Dir(fullPath), ".tmp_")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+
+	hr := hashreader.New(data)
+	_, err = io.Copy(tempFile, hr)
+	closeErr := tempFile.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+
+	// link, в отличие от rename, не заменяет существующий файл
+	if err := os.Link(tempFile.Name(), fullPath); err != nil {
+		return err
+	}
+
+	x.addUsage(fullPath, 1, hr.Size())
+	x.storeHash(fullPath, hr.Sum())
+	return nil
+}
+
+// Hash возвращает SHA256 файла. Значение кешируется и сбрасывается при записи
+// через репозиторий или при изменении mtime/размера файла извне.
+func (x *FileRepository) Hash(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	x.hashMu.Lock()
+	h, ok := x.hashes[path]
+	x.hashMu.Unlock()
+	if ok && h.modTime.Equal(info.ModTime()) && h.size == info.Size() {
+		return h.sum, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hr := hashreader.New(f)
+	if _, err := io.Copy(io.Discard, hr); err != nil {
+		return "", err
+	}
+
+	x.hashMu.Lock()
+	x.hashes[path] = fileHash{modTime: info.ModTime(), size: info.Size(), sum: hr.Sum()}
+	x.hashMu.Unlock()
+
+	return hr.Sum(), nil
+}
+
+func (x *FileRepository) storeHash(path, sum string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+
+	x.hashMu.Lock()
+	x.hashes[path] = fileHash{modTime: info.ModTime(), size: info.Size(), sum: sum}
+	x.hashMu.Unlock()
+}
+
+func (x *FileRepository) invalidateHash(path string) {
+	x.hashMu.Lock()
+	delete(x.hashes, path)
+	x.hashMu.Unlock()
+}
+
+// Forget сбрасывает кеш хешей path и всего, что внутри, после изменения извне
+func (x *FileRepository) Forget(path string) {
+	x.invalidateTree(path)
+}
+
+// invalidateTree сбрасывает хеши path и всего, что внутри
+func (x *FileRepository) invalidateTree(path string) {
+	x.hashMu.Lock()
+	for p := range x.hashes {
+		if p == path || strings.HasPrefix(p, path+string(filepath.Separator)) {
+			delete(x.hashes, p)
+		}
+	}
+	x.hashMu.Unlock()
+}
+
+// Mkdir создает каталог, родительский каталог должен существовать
+func (x *FileRepository) Mkdir(fullPath string) error {
+	if !x.inStorage(fullPath) {
+		return errors.New("failed path, want absoulute path.")
+	}
+	defer x.write(fullPath)()
+	return os.Mkdir(fullPath, 0755)
+}
+
+// Remove удаляет файл или каталог со всем содержимым
+func (x *FileRepository) Remove(fullPath string) error {
+	if !x.inStorage(fullPath) {
+		return errors.New("failed path, want absoulute path.")
+	}
+	defer x.write(fullPath)()
+
+	x.invalidateTree(fullPath)
+	old := lstat(fullPath)
+	if err := os.RemoveAll(fullPath); err != nil {
+		return err
+	}
+	x.removeUsage(fullPath, old)
+	return nil
+}
+
+// Rename переносит файл или каталог, существующий newPath заменяется
+func (x *FileRepository) Rename(oldPath, newPath string) error {
+	if !x.inStorage(oldPath) || !x.inStorage(newPath) {
+		return errors.New("failed path, want absoulute path.")
+	}
+	defer x.write(oldPath, newPath)()
+
+	x.invalidateTree(oldPath)
+	x.invalidateTree(newPath)
+	moved, replaced := lstat(oldPath), lstat(newPath)
+	if err := os.Rename(oldPath, newPath); err != nil {
+		return err
+	}
+
+	x.removeUsage(newPath, replaced)
+	switch {
+	case x.usage == nil || moved == nil:
+	case moved.IsDir():
+		x.usage.MoveDir(oldPath, newPath)
+	case moved.Mode().IsRegular():
+		x.usage.Add(oldPath, -1, -moved.Size())
+		x.usage.Add(newPath, 1, moved.Size())
+	}
+	return nil
+}
+
+// inStorage true для путей внутри хранилища, сам корень хранилища не подходит
+func (x *FileRepository) inStorage(fullPath string) bool {
+	return strings.HasPrefix(fullPath, x.storagePath+string(filepath.Separator))
 }
 
 func (x *FileRepository) List(path string) ([]domain.FileInfo, error) {
@@ -91,8 +341,13 @@ func (x *FileRepository) List(path string) ([]domain.FileInfo, error) {
 		if err != nil {
 			return nil, err
 		}
+		var size int64
+		if !f.IsDir() {
+			size = fi.Size()
+		}
 		result = append(result, domain.FileInfo{
 			Name:    f.Name(),
+			Size:    size,
 			ModTime: fi.ModTime(),
 			IsDir:   f.IsDir(),
 			Path:    strings.Replace(strings.TrimPrefix(filepath.Join(path, f.Name()), x.storagePath), "\\", "/", -1),
@@ -129,6 +384,7 @@ func (x *FileRepository) ListZipContents(zipPath string) ([]domain.FileInfo, err
 
 		files = append(files, domain.FileInfo{
 			Name:    filename,
+			Size:    int64(f.UncompressedSize64),
 			ModTime: f.Modified,
 			IsDir:   f.FileInfo().IsDir(),
 		})
@@ -138,29 +394,34 @@ func (x *FileRepository) ListZipContents(zipPath string) ([]domain.FileInfo, err
 }
 
 func (x *FileRepository) GetStorageInfo() (*domain.StorageInfo, error) {
-	totalFiles := int64(0)
-	totalSize := int64(0)
+	usage, err := x.Usage(x.storagePath)
+	return &domain.StorageInfo{
+		Path:       x.storagePath,
+		TotalFiles: usage.Files,
+		TotalSize:  usage.Size,
+	}, err
+}
 
-	if err := filepath.Walk(x.storagePath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
+// Usage из учета занятого места, без него обходом каталога path
+func (x *FileRepository) Usage(path string) (domain.Usage, error) {
+	if x.usage != nil {
+		return x.usage.Usage(path), nil
+	}
+
+	var usage domain.Usage
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
 			return err
 		}
-
-		if !info.IsDir() {
-			totalFiles++
-			totalSize += info.Size()
+		info, err := d.Info()
+		if err != nil {
+			return err
 		}
-
+		usage.Files++
+		usage.Size += info.Size()
 		return nil
-	}); err != nil {
-		return &domain.StorageInfo{Path: x.storagePath}, err
-	}
-
-	return &domain.StorageInfo{
-		Path:       x.storagePath,
-		TotalFiles: totalFiles,
-		TotalSize:  totalSize,
-	}, nil
+	})
+	return usage, err
 }
 
 func (x *FileRepository) ReadFile(path string) (*os.File, error) {
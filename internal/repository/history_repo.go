@@ -0,0 +1,185 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+)
+
+const (
+	versionMetaFile = "meta.json"
+	versionDiffFile = "diff.zip"
+	versionPrevFile = "prev"
+)
+
+// HistoryRepository хранит версии документов вне дерева файлов:
+// <root>/<относительный путь файла>/<номер версии>/{prev<ext>, diff.zip, meta.json}
+type HistoryRepository struct {
+	root string
+	mu   sync.Mutex
+}
+
+func NewHistoryRepository(root string) *HistoryRepository {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		panic("failed create HistoryRepository: " + err.Error())
+	}
+	path, err := filepath.Abs(root)
+	if err != nil {
+		panic("failed get absolute path: " + err.Error())
+	}
+	return &HistoryRepository{root: path}
+}
+
+// AddVersion сохраняет prev (содержимое до сохранения) и архив изменений diff
+// как новую версию файла relPath. diff может быть nil.
+func (x *HistoryRepository) AddVersion(relPath string, prev, diff io.Reader, meta *domain.FileVersion) error {
+	dir, err := x.dir(relPath)
+	if err != nil {
+		return err
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	versions, err := x.versions(dir)
+	if err != nil {
+		return err
+	}
+	meta.Version = 1
+	if len(versions) > 0 {
+		meta.Version = versions[len(versions)-1] + 1
+	}
+
+	// пишем во временный каталог и переименовываем, чтобы не оставить
+	// неполную версию при ошибке
+	tmp, err := os.MkdirTemp(dir, ".tmp_")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	meta.Size, err = writeFile(filepath.Join(tmp, versionPrevFile+filepath.Ext(relPath)), prev)
+	if err != nil {
+		return err
+	}
+
+	if diff != nil {
+		if _, err := writeFile(filepath.Join(tmp, versionDiffFile), diff); err != nil {
+			return err
+		}
+		meta.HasDiff = true
+	}
+
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(tmp, versionMetaFile), data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, filepath.Join(dir, strconv.Itoa(meta.Version)))
+}
+
+// List возвращает версии файла по возрастанию номера
+func (x *HistoryRepository) List(relPath string) ([]domain.FileVersion, error) {
+	dir, err := x.dir(relPath)
+	if err != nil {
+		return nil, err
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	versions, err := x.versions(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]domain.FileVersion, 0, len(versions))
+	for _, v := range versions {
+		data, err := os.ReadFile(filepath.Join(dir, strconv.Itoa(v), versionMetaFile))
+		if err != nil {
+			return nil, err
+		}
+
+		var meta domain.FileVersion
+		if err := json.Unmarshal(data, &meta); err != nil {
+			return nil, err
+		}
+		result = append(result, meta)
+	}
+
+	return result, nil
+}
+
+func (x *HistoryRepository) OpenVersion(relPath string, version int) (io.ReadCloser, error) {
+	return x.open(relPath, version, versionPrevFile+filepath.Ext(relPath))
+}
+
+func (x *HistoryRepository) OpenDiff(relPath string, version int) (io.ReadCloser, error) {
+	return x.open(relPath, version, versionDiffFile)
+}
+
+func (x *HistoryRepository) open(relPath string, version int, name string) (io.ReadCloser, error) {
+	dir, err := x.dir(relPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Open(filepath.Join(dir, strconv.Itoa(version), name))
+}
+
+func (x *HistoryRepository) dir(relPath string) (string, error) {
+	clean := filepath.Clean("/" + relPath)
+	if clean == "/" || strings.Contains(clean, "..") {
+		return "", errors.New("invalid path: " + relPath)
+	}
+
+	dir := filepath.Join(x.root, clean)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	return dir, nil
+}
+
+// versions номера версий в каталоге файла по возрастанию
+func (x *HistoryRepository) versions(dir string) ([]int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []int
+	for _, e := range entries {
+		if n, err := strconv.Atoi(e.Name()); err == nil && e.IsDir() {
+			result = append(result, n)
+		}
+	}
+	sort.Ints(result)
+
+	return result, nil
+}
+
+func writeFile(path string, data io.Reader) (int64, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := io.Copy(f, data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	return n, err
+}
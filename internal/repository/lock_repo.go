@@ -0,0 +1,102 @@
+package repository
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+)
+
+// LockRepository явные блокировки файлов по пути. Хранятся в памяти
+// и сбрасываются в JSON-файл, чтобы пережить перезапуск сервера.
+type LockRepository struct {
+	file string
+
+	mu    sync.Mutex
+	locks map[string]domain.Lock
+}
+
+func NewLockRepository(file string) (*LockRepository, error) {
+	x := &LockRepository{
+		file:  file,
+		locks: make(map[string]domain.Lock),
+	}
+
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return x, os.MkdirAll(filepath.Dir(file), 0755)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &x.locks); err != nil {
+		return nil, err
+	}
+
+	return x, nil
+}
+
+func (x *LockRepository) Put(lock domain.Lock) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.locks[lock.Path] = lock
+	return x.flush()
+}
+
+// Get действующая блокировка path, истекшие не возвращаются
+func (x *LockRepository) Get(path string) (domain.Lock, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	lock, ok := x.locks[path]
+	if !ok || lock.Expired(time.Now()) {
+		return domain.Lock{}, false
+	}
+	return lock, true
+}
+
+func (x *LockRepository) Delete(path string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if _, ok := x.locks[path]; !ok {
+		return nil
+	}
+	delete(x.locks, path)
+
+	return x.flush()
+}
+
+// List действующие блокировки по пути, истекшие удаляются
+func (x *LockRepository) List() ([]domain.Lock, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	now := time.Now()
+	result := make([]domain.Lock, 0, len(x.locks))
+	expired := false
+	for path, lock := range x.locks {
+		if lock.Expired(now) {
+			delete(x.locks, path)
+			expired = true
+			continue
+		}
+		result = append(result, lock)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+
+	if expired {
+		return result, x.flush()
+	}
+	return result, nil
+}
+
+// flush должен вызываться под x.mu
+func (x *LockRepository) flush() error {
+	return writeJSONFile(x.file, x.locks)
+}
@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+)
+
+// PermissionRepository права доступа к документам из JSON-файла:
+//
+//	{"rules": [{"path": "/contracts", "access": "review", "users": {"1": "edit"}, "download": false}]}
+//
+// Без правил каждый может редактировать, скачивать, печатать и копировать.
+type PermissionRepository struct {
+	rules []domain.PermissionRule
+}
+
+func NewPermissionRepository(file string) (*PermissionRepository, error) {
+	x := &PermissionRepository{}
+	if file == "" {
+		return x, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg struct {
+		Rules []domain.PermissionRule `json:"rules"`
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+
+	for i, r := range cfg.Rules {
+		if r.Access != "" && !r.Access.Valid() {
+			return nil, fmt.Errorf("rule %d: invalid access %q", i, r.Access)
+		}
+		for u, a := range r.Users {
+			if !a.Valid() {
+				return nil, fmt.Errorf("rule %d: invalid access %q for user %s", i, a, u)
+			}
+		}
+		cfg.Rules[i].Path = path.Clean("/" + r.Path)
+	}
+
+	// от корня к вложенным каталогам
+	sort.SliceStable(cfg.Rules, func(i, j int) bool {
+		return len(cfg.Rules[i].Path) < len(cfg.Rules[j].Path)
+	})
+	x.rules = cfg.Rules
+
+	return x, nil
+}
+
+func (x *PermissionRepository) Resolve(filePath, userId string) domain.Permissions {
+	p := domain.Permissions{
+		Access:   domain.AccessEdit,
+		Download: true,
+		Print:    true,
+		Copy:     true,
+	}
+
+	filePath = path.Clean("/" + filePath)
+	for _, r := range x.rules {
+		if r.Path != "/" && filePath != r.Path && !strings.HasPrefix(filePath, r.Path+"/") {
+			continue
+		}
+
+		if a, ok := r.Users[userId]; ok {
+			p.Access = a
+		} else if r.Access != "" {
+			p.Access = r.Access
+		}
+		if r.Download != nil {
+			p.Download = *r.Download
+		}
+		if r.Print != nil {
+			p.Print = *r.Print
+		}
+		if r.Copy != nil {
+			p.Copy = *r.Copy
+		}
+	}
+
+	return p
+}
@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+)
+
+func TestPermissionRepositoryResolve(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "permissions.json")
+	rules := `{"rules": [
+		{"path": "/contracts/signed", "access": "view", "print": false},
+		{"path": "/contracts", "access": "review", "users": {"1": "edit"}, "download": false},
+		{"path": "/private", "access": "none"}
+	]}`
+	if err := os.WriteFile(file, []byte(rules), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	repo, err := NewPermissionRepository(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path, user string
+		want       domain.Permissions
+	}{
+		// вне правил — полный доступ
+		{"/docs/a.docx", "2", domain.Permissions{Access: domain.AccessEdit, Download: true, Print: true, Copy: true}},
+		{"/contracts/a.docx", "2", domain.Permissions{Access: domain.AccessReview, Download: false, Print: true, Copy: true}},
+		// пользователь из users
+		{"/contracts/a.docx", "1", domain.Permissions{Access: domain.AccessEdit, Download: false, Print: true, Copy: true}},
+		// вложенное правило переопределяет доступ и добавляет свои флаги
+		{"/contracts/signed/a.docx", "1", domain.Permissions{Access: domain.AccessView, Download: false, Print: false, Copy: true}},
+		// совпадение по префиксу имени не считается вложенностью
+		{"/contractsX/a.docx", "2", domain.Permissions{Access: domain.AccessEdit, Download: true, Print: true, Copy: true}},
+		{"/private/a.docx", "1", domain.Permissions{Access: domain.AccessNone, Download: true, Print: true, Copy: true}},
+	}
+
+	for _, tt := range tests {
+		if got := repo.Resolve(tt.path, tt.user); got != tt.want {
+			t.Errorf("Resolve(%q, %q) = %+v, want %+v", tt.path, tt.user, got, tt.want)
+		}
+	}
+}
+
+func TestPermissionRepositoryInvalid(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "permissions.json")
+	if err := os.WriteFile(file, []byte(`{"rules": [{"path": "/", "access": "owner"}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewPermissionRepository(file); err == nil {
+		t.Error("NewPermissionRepository() accepted invalid access")
+	}
+}
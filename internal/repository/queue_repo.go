@@ -0,0 +1,136 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+)
+
+const queueFailedDir = "failed"
+
+// QueueRepository очередь сохранений в каталоге: задача — файл <id>.json,
+// id растет со временем постановки. Неудавшиеся задачи переносятся в failed/.
+type QueueRepository struct {
+	dir string
+
+	mu   sync.Mutex
+	last int64
+}
+
+func NewQueueRepository(dir string) (*QueueRepository, error) {
+	if err := os.MkdirAll(filepath.Join(dir, queueFailedDir), 0755); err != nil {
+		return nil, err
+	}
+	return &QueueRepository{dir: dir}, nil
+}
+
+func (x *QueueRepository) Put(job *domain.SaveJob) error {
+	x.mu.Lock()
+	seq := max(time.Now().UnixNano(), x.last+1)
+	x.last = seq
+	x.mu.Unlock()
+
+	job.Id = fmt.Sprintf("%020d", seq)
+
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(x.dir, ".tmp_")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	// задача должна пережить падение сервера сразу после ответа Document Server
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), x.file(job.Id)); err != nil {
+		return err
+	}
+	return syncDir(x.dir)
+}
+
+func (x *QueueRepository) Pending() ([]domain.SaveJob, error) {
+	entries, err := os.ReadDir(x.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+
+	jobs := make([]domain.SaveJob, 0, len(names))
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(x.dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		var job domain.SaveJob
+		if err := json.Unmarshal(data, &job); err != nil {
+			return nil, fmt.Errorf("queue job %s: %w", name, err)
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, nil
+}
+
+func (x *QueueRepository) Done(id string) error {
+	err := os.Remove(x.file(id))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (x *QueueRepository) Fail(id string) error {
+	failed := filepath.Join(x.dir, queueFailedDir)
+	if err := os.Rename(x.file(id), filepath.Join(failed, id+".json")); err != nil {
+		return err
+	}
+	if err := syncDir(failed); err != nil {
+		return err
+	}
+	return syncDir(x.dir)
+}
+
+func (x *QueueRepository) file(id string) string {
+	return filepath.Join(x.dir, id+".json")
+}
+
+// syncDir сбрасывает на диск запись каталога: без этого переименование
+// может потеряться при падении, даже если сам файл записан с fsync
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+)
+
+// SFTPUserRepository учетные записи SFTP из JSON-файла:
+//
+//	{"users": [{"name": "acme", "root": "/partners/acme", "password": "$2a$10$...", "keys": ["ssh-ed25519 AAAA..."]}]}
+type SFTPUserRepository struct {
+	users map[string]*domain.SFTPUser
+}
+
+func NewSFTPUserRepository(file string) (*SFTPUserRepository, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg struct {
+		Users []*domain.SFTPUser `json:"users"`
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+
+	x := &SFTPUserRepository{users: make(map[string]*domain.SFTPUser, len(cfg.Users))}
+	for i, u := range cfg.Users {
+		switch {
+		case u.Name == "":
+			return nil, fmt.Errorf("user %d: empty name", i)
+		case x.users[u.Name] != nil:
+			return nil, fmt.Errorf("user %s: duplicate name", u.Name)
+		case u.Password == "" && len(u.Keys) == 0:
+			return nil, fmt.Errorf("user %s: no password or keys", u.Name)
+		case u.Password != "" && !strings.HasPrefix(u.Password, "$2"):
+			return nil, fmt.Errorf("user %s: password must be a bcrypt hash", u.Name)
+		}
+		for _, k := range u.Keys {
+			if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(k)); err != nil {
+				return nil, fmt.Errorf("user %s: invalid key: %w", u.Name, err)
+			}
+		}
+
+		u.Root = path.Clean("/" + u.Root)
+		x.users[u.Name] = u
+	}
+
+	return x, nil
+}
+
+func (x *SFTPUserRepository) Get(name string) (*domain.SFTPUser, bool) {
+	u, ok := x.users[name]
+	return u, ok
+}
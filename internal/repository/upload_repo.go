@@ -0,0 +1,169 @@
+package repository
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+)
+
+const (
+	uploadMetaFile = "upload.json"
+	uploadEtagExt  = ".etag"
+)
+
+// UploadRepository незавершенные multipart-загрузки S3. Загрузка — каталог
+// <id> с upload.json и частями <номер>, рядом с частью лежит ее ETag.
+// Брошенные загрузки старше ttl удаляются при создании новых.
+type UploadRepository struct {
+	dir string
+	ttl time.Duration
+}
+
+func NewUploadRepository(dir string, ttl time.Duration) (*UploadRepository, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &UploadRepository{dir: dir, ttl: ttl}, nil
+}
+
+func (x *UploadRepository) Create(upload *domain.MultipartUpload) error {
+	x.prune()
+
+	b := make([]byte, 16)
+	rand.Read(b)
+	upload.Id = hex.EncodeToString(b)
+	upload.Created = time.Now().UTC()
+
+	if err := os.Mkdir(filepath.Join(x.dir, upload.Id), 0755); err != nil {
+		return err
+	}
+	return writeJSONFile(filepath.Join(x.dir, upload.Id, uploadMetaFile), upload)
+}
+
+func (x *UploadRepository) Get(id string) (*domain.MultipartUpload, error) {
+	if !validUploadId(id) {
+		return nil, domain.ErrNotFound
+	}
+
+	data, err := os.ReadFile(filepath.Join(x.dir, id, uploadMetaFile))
+	if os.IsNotExist(err) {
+		return nil, domain.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	upload := new(domain.MultipartUpload)
+	return upload, json.Unmarshal(data, upload)
+}
+
+// PutPart сохраняет часть, повторная загрузка части с тем же номером заменяет ее
+func (x *UploadRepository) PutPart(id string, number int, data io.Reader) (domain.UploadPart, error) {
+	if _, err := x.Get(id); err != nil {
+		return domain.UploadPart{}, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Join(x.dir, id), ".tmp_")
+	if err != nil {
+		return domain.UploadPart{}, err
+	}
+	h := md5.New()
+	size, err := io.Copy(tmp, io.TeeReader(data, h))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return domain.UploadPart{}, err
+	}
+
+	part := domain.UploadPart{Number: number, ETag: hex.EncodeToString(h.Sum(nil)), Size: size}
+	name := x.part(id, number)
+	if err := os.WriteFile(name+uploadEtagExt, []byte(part.ETag), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return domain.UploadPart{}, err
+	}
+	return part, os.Rename(tmp.Name(), name)
+}
+
+// Parts загруженные части по возрастанию номера
+func (x *UploadRepository) Parts(id string) ([]domain.UploadPart, error) {
+	if _, err := x.Get(id); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(filepath.Join(x.dir, id))
+	if err != nil {
+		return nil, err
+	}
+
+	var parts []domain.UploadPart
+	for _, e := range entries {
+		number, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		etag, err := os.ReadFile(x.part(id, number) + uploadEtagExt)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, domain.UploadPart{Number: number, ETag: string(etag), Size: info.Size()})
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].Number < parts[j].Number })
+
+	return parts, nil
+}
+
+func (x *UploadRepository) OpenPart(id string, number int) (*os.File, error) {
+	if !validUploadId(id) {
+		return nil, domain.ErrNotFound
+	}
+	return os.Open(x.part(id, number))
+}
+
+func (x *UploadRepository) Delete(id string) error {
+	if !validUploadId(id) {
+		return domain.ErrNotFound
+	}
+	return os.RemoveAll(filepath.Join(x.dir, id))
+}
+
+func (x *UploadRepository) part(id string, number int) string {
+	return filepath.Join(x.dir, id, strconv.Itoa(number))
+}
+
+// prune удаляет загрузки старше ttl
+func (x *UploadRepository) prune() {
+	if x.ttl <= 0 {
+		return
+	}
+
+	entries, _ := os.ReadDir(x.dir)
+	for _, e := range entries {
+		if info, err := e.Info(); err == nil && e.IsDir() && time.Since(info.ModTime()) > x.ttl {
+			os.RemoveAll(filepath.Join(x.dir, e.Name()))
+		}
+	}
+}
+
+// validUploadId id выдан Create и не выводит за пределы каталога загрузок
+func validUploadId(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	return strings.Trim(id, "0123456789abcdef") == ""
+}
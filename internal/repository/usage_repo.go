@@ -0,0 +1,433 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+)
+
+// tempPrefix временные файлы записи, в занятое место не входят
+const tempPrefix = ".tmp_"
+
+// UsageRepository занятое место по каталогам: у каждого каталога число и размер
+// файлов прямо в нем и во всем поддереве. Обновляется при каждой записи через
+// FileRepository и хранится в файле, поэтому размер хранилища и любого каталога
+// известен без обхода дерева. Расхождения с диском (запись в обход сервера,
+// падение до сохранения) исправляет Reconcile.
+type UsageRepository struct {
+	root string
+	file string
+
+	mu   sync.Mutex
+	dirs map[string]*dirUsage // путь от корня хранилища, корень "/"
+	// children вложенные каталоги: у каждого каталога из dirs и их предков
+	children map[string]map[string]bool
+	// versions меняется при каждом изменении каталога, по нему Reconcile
+	// узнает, что каталог изменился, пока читался с диска. Нужны только
+	// на время сверки и очищаются после нее.
+	versions    map[string]uint64
+	seq         uint64
+	reconciling int
+	busy        map[string]int
+	dirty       bool
+	loaded      bool
+}
+
+type dirUsage struct {
+	Total  domain.Usage `json:"total"`
+	Direct domain.Usage `json:"direct"`
+}
+
+// NewUsageRepository учет хранилища storagePath, сохраненный в file. Если файла
+// еще нет, учет пуст до первого Reconcile.
+func NewUsageRepository(storagePath, file string) (*UsageRepository, error) {
+	root, err := filepath.Abs(storagePath)
+	if err != nil {
+		return nil, err
+	}
+	x := &UsageRepository{
+		root:     root,
+		file:     file,
+		dirs:     make(map[string]*dirUsage),
+		children: make(map[string]map[string]bool),
+		versions: make(map[string]uint64),
+		busy:     make(map[string]int),
+	}
+
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return x, os.MkdirAll(filepath.Dir(file), 0755)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var saved struct {
+		Dirs map[string]*dirUsage `json:"dirs"`
+	}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("usage index %s: %w", file, err)
+	}
+	for p, d := range saved.Dirs {
+		x.put(p, d)
+	}
+	x.loaded = true
+	return x, nil
+}
+
+// Loaded true если учет прочитан из файла, иначе он пуст до первой сверки
+func (x *UsageRepository) Loaded() bool {
+	return x.loaded
+}
+
+func (x *UsageRepository) BeginWrite(fullPath string) {
+	rel, ok := x.rel(fullPath)
+	if !ok {
+		return
+	}
+	x.mu.Lock()
+	x.busy[rel]++
+	x.mu.Unlock()
+}
+
+func (x *UsageRepository) EndWrite(fullPath string) {
+	rel, ok := x.rel(fullPath)
+	if !ok {
+		return
+	}
+	x.mu.Lock()
+	if x.busy[rel]--; x.busy[rel] <= 0 {
+		delete(x.busy, rel)
+	}
+	x.mu.Unlock()
+}
+
+func (x *UsageRepository) Add(fullPath string, files, size int64) {
+	rel, ok := x.rel(fullPath)
+	if !ok || rel == "/" {
+		return
+	}
+	x.mu.Lock()
+	x.addDirect(path.Dir(rel), domain.Usage{Files: files, Size: size})
+	x.mu.Unlock()
+}
+
+func (x *UsageRepository) RemoveDir(fullPath string) {
+	rel, ok := x.rel(fullPath)
+	if !ok || rel == "/" {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	d := x.dirs[rel]
+	for _, p := range x.subtree(rel) {
+		x.drop(p)
+		x.bump(p)
+	}
+	if d != nil {
+		x.addTotal(path.Dir(rel), domain.Usage{Files: -d.Total.Files, Size: -d.Total.Size})
+	}
+}
+
+func (x *UsageRepository) MoveDir(from, to string) {
+	relFrom, ok := x.rel(from)
+	relTo, ok2 := x.rel(to)
+	if !ok || !ok2 || relFrom == "/" || relTo == "/" {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	d := x.dirs[relFrom]
+	for _, p := range x.subtree(relFrom) {
+		moved := relTo + strings.TrimPrefix(p, relFrom)
+		d := x.dirs[p]
+		x.drop(p)
+		x.put(moved, d)
+		x.bump(p)
+		x.bump(moved)
+	}
+	if d != nil {
+		x.addTotal(path.Dir(relFrom), domain.Usage{Files: -d.Total.Files, Size: -d.Total.Size})
+		x.addTotal(path.Dir(relTo), d.Total)
+	}
+}
+
+func (x *UsageRepository) Usage(fullPath string) domain.Usage {
+	rel, ok := x.rel(fullPath)
+	if !ok {
+		return domain.Usage{}
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if d := x.dirs[rel]; d != nil {
+		return d.Total
+	}
+	return domain.Usage{}
+}
+
+// Reconcile обходит хранилище и исправляет файлы и размер прямо в каталогах,
+// которые разошлись с диском. Каталог, который изменился через сервер, пока
+// читался с диска, пропускается: его сверит следующий проход.
+func (x *UsageRepository) Reconcile(ctx context.Context, pause time.Duration) (int, error) {
+	x.mu.Lock()
+	x.reconciling++
+	x.mu.Unlock()
+	defer func() {
+		x.mu.Lock()
+		if x.reconciling--; x.reconciling == 0 {
+			x.versions = make(map[string]uint64)
+		}
+		x.mu.Unlock()
+	}()
+
+	fixed := 0
+	visited := make(map[string]bool)
+	stack := []string{"/"}
+	for len(stack) > 0 {
+		if err := ctx.Err(); err != nil {
+			return fixed, err
+		}
+		rel := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		x.mu.Lock()
+		version := x.versions[rel]
+		x.mu.Unlock()
+
+		entries, err := os.ReadDir(x.full(rel))
+		if err != nil {
+			// исчезнувший каталог уберется вместе с остальными ниже
+			continue
+		}
+		visited[rel] = true
+
+		var direct domain.Usage
+		for _, e := range entries {
+			switch {
+			case strings.HasPrefix(e.Name(), tempPrefix):
+			case e.IsDir():
+				stack = append(stack, path.Join(rel, e.Name()))
+			case e.Type().IsRegular():
+				if info, err := e.Info(); err == nil {
+					direct.Files++
+					direct.Size += info.Size()
+				}
+			}
+		}
+		if x.fix(rel, version, direct) {
+			fixed++
+		}
+
+		if pause > 0 {
+			select {
+			case <-ctx.Done():
+				return fixed, ctx.Err()
+			case <-time.After(pause):
+			}
+		}
+	}
+
+	// каталоги из учета, которых нет на диске; сначала вложенные
+	x.mu.Lock()
+	var stale []string
+	versions := make(map[string]uint64)
+	for p := range x.dirs {
+		if !visited[p] {
+			stale = append(stale, p)
+			versions[p] = x.versions[p]
+		}
+	}
+	x.mu.Unlock()
+	sort.Slice(stale, func(i, j int) bool { return len(stale[i]) > len(stale[j]) })
+	for _, p := range stale {
+		if _, err := os.Stat(x.full(p)); !errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if x.fix(p, versions[p], domain.Usage{}) {
+			fixed++
+		}
+	}
+	return fixed, nil
+}
+
+// fix записывает прочитанное с диска direct, если каталог с тех пор не менялся
+func (x *UsageRepository) fix(rel string, version uint64, direct domain.Usage) bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.versions[rel] != version || x.busyNear(rel) {
+		return false
+	}
+	var cur domain.Usage
+	if d := x.dirs[rel]; d != nil {
+		cur = d.Direct
+	}
+	if cur == direct {
+		return false
+	}
+	x.addDirect(rel, domain.Usage{Files: direct.Files - cur.Files, Size: direct.Size - cur.Size})
+	return true
+}
+
+func (x *UsageRepository) Flush() error {
+	x.mu.Lock()
+	if !x.dirty {
+		x.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(struct {
+		Dirs map[string]*dirUsage `json:"dirs"`
+	}{x.dirs})
+	x.dirty = false
+	x.mu.Unlock()
+
+	if err == nil {
+		err = x.write(data)
+	}
+	if err != nil {
+		x.mu.Lock()
+		x.dirty = true
+		x.mu.Unlock()
+	}
+	return err
+}
+
+func (x *UsageRepository) write(data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(x.file), tempPrefix)
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), x.file)
+}
+
+// addDirect меняет файлы прямо в каталоге dir
+func (x *UsageRepository) addDirect(dir string, u domain.Usage) {
+	d := x.dir(dir)
+	d.Direct.Files += u.Files
+	d.Direct.Size += u.Size
+	x.bump(dir)
+	x.addTotal(dir, u)
+}
+
+// addTotal меняет поддерево dir и всех его предков. Опустевшие каталоги
+// из учета убираются.
+func (x *UsageRepository) addTotal(dir string, u domain.Usage) {
+	for p := dir; ; p = path.Dir(p) {
+		d := x.dir(p)
+		d.Total.Files += u.Files
+		d.Total.Size += u.Size
+		if d.Total == (domain.Usage{}) && p != "/" {
+			x.drop(p)
+		}
+		if p == "/" {
+			break
+		}
+	}
+	x.dirty = true
+}
+
+func (x *UsageRepository) dir(rel string) *dirUsage {
+	d := x.dirs[rel]
+	if d == nil {
+		d = &dirUsage{}
+		x.put(rel, d)
+	}
+	return d
+}
+
+// put добавляет каталог в учет и в дерево children
+func (x *UsageRepository) put(rel string, d *dirUsage) {
+	x.dirs[rel] = d
+	for p := rel; p != "/"; p = path.Dir(p) {
+		parent := path.Dir(p)
+		if x.children[parent][p] {
+			break
+		}
+		if x.children[parent] == nil {
+			x.children[parent] = make(map[string]bool)
+		}
+		x.children[parent][p] = true
+	}
+}
+
+// drop убирает каталог из учета и ветки children, в которых больше ничего нет
+func (x *UsageRepository) drop(rel string) {
+	delete(x.dirs, rel)
+	for p := rel; p != "/"; p = path.Dir(p) {
+		if _, ok := x.dirs[p]; ok || len(x.children[p]) > 0 {
+			break
+		}
+		parent := path.Dir(p)
+		delete(x.children[parent], p)
+		if len(x.children[parent]) == 0 {
+			delete(x.children, parent)
+		}
+	}
+}
+
+// bump отмечает изменение каталога для идущей сверки
+func (x *UsageRepository) bump(rel string) {
+	if x.reconciling == 0 {
+		return
+	}
+	x.seq++
+	x.versions[rel] = x.seq
+}
+
+// subtree каталог rel и все вложенные в учете, вложенные раньше родителей
+func (x *UsageRepository) subtree(rel string) []string {
+	var paths []string
+	var walk func(p string)
+	walk = func(p string) {
+		for child := range x.children[p] {
+			walk(child)
+		}
+		if _, ok := x.dirs[p]; ok {
+			paths = append(paths, p)
+		}
+	}
+	walk(rel)
+	return paths
+}
+
+// busyNear true если через сервер сейчас пишется файл прямо в каталоге rel,
+// сам rel или каталог над ним
+func (x *UsageRepository) busyNear(rel string) bool {
+	for p := range x.busy {
+		if p == rel || path.Dir(p) == rel || strings.HasPrefix(rel, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// rel путь от корня хранилища, false для путей вне его
+func (x *UsageRepository) rel(fullPath string) (string, bool) {
+	rel, err := filepath.Rel(x.root, fullPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return path.Clean("/" + filepath.ToSlash(rel)), true
+}
+
+func (x *UsageRepository) full(rel string) string {
+	return filepath.Join(x.root, filepath.FromSlash(rel))
+}
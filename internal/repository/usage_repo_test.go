@@ -0,0 +1,155 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+)
+
+func newTestUsage(t *testing.T, storage, file string) (*FileRepository, *UsageRepository) {
+	t.Helper()
+	usage, err := NewUsageRepository(storage, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewFileRepository(storage)
+	repo.TrackUsage(usage)
+	return repo, usage
+}
+
+func checkUsage(t *testing.T, usage *UsageRepository, want map[string]domain.Usage) {
+	t.Helper()
+	for p, u := range want {
+		if got := usage.Usage(p); got != u {
+			t.Errorf("Usage(%s) = %+v, want %+v", p, got, u)
+		}
+	}
+}
+
+func TestUsageRepository(t *testing.T) {
+	storage := t.TempDir()
+	file := filepath.Join(t.TempDir(), "usage.json")
+	repo, usage := newTestUsage(t, storage, file)
+	full := func(p string) string { return filepath.Join(storage, filepath.FromSlash(p)) }
+	if usage.Loaded() {
+		t.Error("loaded without index file")
+	}
+
+	for p, data := range map[string]string{"a/b.txt": "bbb", "a/c/d.txt": "ddddd", "a/c/e/f.txt": "f"} {
+		if err := repo.SaveFile(full(p), strings.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := repo.SaveFile(full("a/b.txt"), strings.NewReader("bbbbbbbbbb")); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.CreateFile(full("top.txt"), strings.NewReader("t")); err != nil {
+		t.Fatal(err)
+	}
+	checkUsage(t, usage, map[string]domain.Usage{
+		storage:     {Files: 4, Size: 17},
+		full("a"):   {Files: 3, Size: 16},
+		full("a/c"): {Files: 2, Size: 6},
+	})
+
+	if err := repo.Rename(full("a/c"), full("x")); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Rename(full("top.txt"), full("x/e/top.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Remove(full("a")); err != nil {
+		t.Fatal(err)
+	}
+	checkUsage(t, usage, map[string]domain.Usage{
+		storage:     {Files: 3, Size: 7},
+		full("a"):   {},
+		full("a/c"): {},
+		full("x"):   {Files: 3, Size: 7},
+		full("x/e"): {Files: 2, Size: 2},
+	})
+	if _, ok := usage.children["/a"]; ok || usage.children["/"]["/a"] {
+		t.Errorf("removed dir left in children: %v", usage.children)
+	}
+	if len(usage.versions) != 0 {
+		t.Errorf("versions outside reconcile: %v", usage.versions)
+	}
+
+	// учет переживает перезапуск
+	if err := usage.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	_, usage = newTestUsage(t, storage, file)
+	if !usage.Loaded() {
+		t.Error("index file not loaded")
+	}
+	checkUsage(t, usage, map[string]domain.Usage{storage: {Files: 3, Size: 7}, full("x/e"): {Files: 2, Size: 2}})
+	if got := strings.Join(usage.subtree("/x"), ","); got != "/x/e,/x" {
+		t.Errorf("subtree(/x) = %s", got)
+	}
+
+	// изменения в обход репозитория находит сверка
+	if err := os.WriteFile(full("x/e/g.txt"), []byte("gggg"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(full("x/d.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(full("new/deep"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(full("new/deep/h.txt"), []byte("hh"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fixed, err := usage.Reconcile(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fixed != 3 {
+		t.Errorf("fixed = %d, want 3", fixed)
+	}
+	checkUsage(t, usage, map[string]domain.Usage{
+		storage:          {Files: 4, Size: 8},
+		full("x"):        {Files: 3, Size: 6},
+		full("new/deep"): {Files: 1, Size: 2},
+	})
+	if fixed, _ := usage.Reconcile(context.Background(), 0); fixed != 0 {
+		t.Errorf("second reconcile fixed = %d", fixed)
+	}
+	if len(usage.versions) != 0 {
+		t.Errorf("versions after reconcile: %v", usage.versions)
+	}
+
+	// каталог, которого больше нет
+	if err := os.RemoveAll(full("new")); err != nil {
+		t.Fatal(err)
+	}
+	usage.Reconcile(context.Background(), 0)
+	checkUsage(t, usage, map[string]domain.Usage{storage: {Files: 3, Size: 6}, full("new"): {}})
+}
+
+func TestUsageReconcileSkipsBusy(t *testing.T) {
+	storage := t.TempDir()
+	_, usage := newTestUsage(t, storage, filepath.Join(t.TempDir(), "usage.json"))
+	path := filepath.Join(storage, "a.txt")
+
+	// файл уже на диске, но запись через репозиторий еще не учтена
+	usage.BeginWrite(path)
+	if err := os.WriteFile(path, []byte("aaa"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if fixed, _ := usage.Reconcile(context.Background(), 0); fixed != 0 {
+		t.Errorf("fixed busy dir: %d", fixed)
+	}
+	usage.Add(path, 1, 3)
+	usage.EndWrite(path)
+
+	if fixed, _ := usage.Reconcile(context.Background(), 0); fixed != 0 {
+		t.Errorf("fixed after write: %d", fixed)
+	}
+	checkUsage(t, usage, map[string]domain.Usage{storage: {Files: 1, Size: 3}})
+}
@@ -0,0 +1,216 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+)
+
+// WebhookRepository подписки из JSON-файла:
+//
+//	{"webhooks": [{"id": "reports", "url": "https://erp/hooks/files", "secret": "...", "paths": ["/files/reports/**"], "events": ["upload", "overwrite"]}]}
+type WebhookRepository struct {
+	hooks []domain.Webhook
+}
+
+func NewWebhookRepository(file string) (*WebhookRepository, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg struct {
+		Webhooks []domain.Webhook `json:"webhooks"`
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+
+	ids := make(map[string]bool, len(cfg.Webhooks))
+	for i, h := range cfg.Webhooks {
+		u, err := url.Parse(h.URL)
+		switch {
+		case h.ID == "":
+			return nil, fmt.Errorf("webhook %d: empty id", i)
+		case ids[h.ID]:
+			return nil, fmt.Errorf("webhook %s: duplicate id", h.ID)
+		case err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "":
+			return nil, fmt.Errorf("webhook %s: invalid url %q", h.ID, h.URL)
+		case h.Secret == "":
+			return nil, fmt.Errorf("webhook %s: empty secret", h.ID)
+		}
+		for _, e := range h.Events {
+			if !e.Change() {
+				return nil, fmt.Errorf("webhook %s: unknown event %q", h.ID, e)
+			}
+		}
+		for _, p := range h.Paths {
+			if _, err := path.Match(strings.TrimSuffix(p, "/**"), ""); err != nil {
+				return nil, fmt.Errorf("webhook %s: invalid path %q: %w", h.ID, p, err)
+			}
+		}
+		ids[h.ID] = true
+	}
+
+	return &WebhookRepository{hooks: cfg.Webhooks}, nil
+}
+
+func (x *WebhookRepository) List() []domain.Webhook {
+	return x.hooks
+}
+
+func (x *WebhookRepository) Get(id string) (*domain.Webhook, bool) {
+	for i := range x.hooks {
+		if x.hooks[i].ID == id {
+			return &x.hooks[i], true
+		}
+	}
+	return nil, false
+}
+
+const outboxDeadDir = "dead"
+
+// WebhookOutboxRepository доставки в каталоге: доставка — файл <id>.json, id
+// растет со временем постановки. Неудавшиеся переносятся в dead/.
+type WebhookOutboxRepository struct {
+	dir string
+
+	mu   sync.Mutex
+	last int64
+}
+
+func NewWebhookOutboxRepository(dir string) (*WebhookOutboxRepository, error) {
+	if err := os.MkdirAll(filepath.Join(dir, outboxDeadDir), 0755); err != nil {
+		return nil, err
+	}
+	return &WebhookOutboxRepository{dir: dir}, nil
+}
+
+func (x *WebhookOutboxRepository) Put(d *domain.WebhookDelivery) error {
+	x.mu.Lock()
+	seq := max(time.Now().UnixNano(), x.last+1)
+	x.last = seq
+	x.mu.Unlock()
+
+	d.ID = fmt.Sprintf("%020d", seq)
+	d.Event.ID = d.ID
+	return x.write(x.dir, d)
+}
+
+func (x *WebhookOutboxRepository) Update(d *domain.WebhookDelivery) error {
+	return x.write(x.dir, d)
+}
+
+func (x *WebhookOutboxRepository) Pending() ([]domain.WebhookDelivery, error) {
+	return x.list(x.dir)
+}
+
+func (x *WebhookOutboxRepository) Done(id string) error {
+	err := os.Remove(filepath.Join(x.dir, id+".json"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Fail сохраняет доставку с последней ошибкой в dead/ и убирает из outbox
+func (x *WebhookOutboxRepository) Fail(d *domain.WebhookDelivery) error {
+	if err := x.write(filepath.Join(x.dir, outboxDeadDir), d); err != nil {
+		return err
+	}
+	return x.Done(d.ID)
+}
+
+func (x *WebhookOutboxRepository) Dead() ([]domain.WebhookDelivery, error) {
+	return x.list(filepath.Join(x.dir, outboxDeadDir))
+}
+
+// Redeliver возвращает неудавшуюся доставку в outbox со сброшенными попытками
+func (x *WebhookOutboxRepository) Redeliver(id string) (*domain.WebhookDelivery, error) {
+	if strings.ContainsAny(id, `/\.`) {
+		return nil, domain.ErrNotFound
+	}
+	dead := filepath.Join(x.dir, outboxDeadDir, id+".json")
+	data, err := os.ReadFile(dead)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, domain.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var d domain.WebhookDelivery
+	if err := json.Unmarshal(data, &d); err != nil {
+		return nil, fmt.Errorf("dead delivery %s: %w", id, err)
+	}
+
+	d.Attempts = 0
+	d.NextAttempt = time.Time{}
+	if err := x.write(x.dir, &d); err != nil {
+		return nil, err
+	}
+	return &d, os.Remove(dead)
+}
+
+// write пишет доставку через временный файл с fsync: событие не должно
+// потеряться при падении сервера сразу после записи в хранилище
+func (x *WebhookOutboxRepository) write(dir string, d *domain.WebhookDelivery) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, ".tmp_")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, d.ID+".json"))
+}
+
+func (x *WebhookOutboxRepository) list(dir string) ([]domain.WebhookDelivery, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+
+	deliveries := make([]domain.WebhookDelivery, 0, len(names))
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		var d domain.WebhookDelivery
+		if err := json.Unmarshal(data, &d); err != nil {
+			return nil, fmt.Errorf("webhook delivery %s: %w", name, err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, nil
+}
@@ -0,0 +1,39 @@
+package repository
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewWebhookRepository(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     string
+		wantErr bool
+	}{
+		{name: "valid", cfg: `{"webhooks": [{"id": "a", "url": "https://erp/hook", "secret": "s", "paths": ["/files/in/**"], "events": ["upload"]}]}`},
+		{name: "duplicate id", cfg: `{"webhooks": [{"id": "a", "url": "http://x", "secret": "s"}, {"id": "a", "url": "http://y", "secret": "s"}]}`, wantErr: true},
+		{name: "no scheme", cfg: `{"webhooks": [{"id": "a", "url": "erp/hook", "secret": "s"}]}`, wantErr: true},
+		{name: "no secret", cfg: `{"webhooks": [{"id": "a", "url": "http://x"}]}`, wantErr: true},
+		{name: "unknown event", cfg: `{"webhooks": [{"id": "a", "url": "http://x", "secret": "s", "events": ["download"]}]}`, wantErr: true},
+		{name: "bad pattern", cfg: `{"webhooks": [{"id": "a", "url": "http://x", "secret": "s", "paths": ["/files/[a"]}]}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "webhooks.json")
+			if err := os.WriteFile(file, []byte(tt.cfg), 0644); err != nil {
+				t.Fatal(err)
+			}
+			repo, err := NewWebhookRepository(file)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				if _, ok := repo.Get("a"); !ok {
+					t.Error("webhook a not found")
+				}
+			}
+		})
+	}
+}
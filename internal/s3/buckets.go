@@ -0,0 +1,346 @@
+package s3
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AleksandrMac/fileserver/internal/interfaces"
+)
+
+const maxKeys = 1000
+
+type owner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+type listBucketsResult struct {
+	XMLName xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListAllMyBucketsResult"`
+	Owner   owner    `xml:"Owner"`
+	Buckets []bucket `xml:"Buckets>Bucket"`
+}
+
+type bucket struct {
+	Name         string `xml:"Name"`
+	CreationDate string `xml:"CreationDate"`
+}
+
+type locationResult struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ LocationConstraint"`
+	Location string   `xml:",chardata"`
+}
+
+type listObjectsResult struct {
+	XMLName        xml.Name       `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name           string         `xml:"Name"`
+	Prefix         string         `xml:"Prefix"`
+	Delimiter      string         `xml:"Delimiter,omitempty"`
+	MaxKeys        int            `xml:"MaxKeys"`
+	IsTruncated    bool           `xml:"IsTruncated"`
+	Contents       []object       `xml:"Contents"`
+	CommonPrefixes []commonPrefix `xml:"CommonPrefixes"`
+
+	// ListObjects
+	Marker     *string `xml:"Marker"`
+	NextMarker string  `xml:"NextMarker,omitempty"`
+
+	// ListObjectsV2
+	KeyCount              *int   `xml:"KeyCount"`
+	ContinuationToken     string `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string `xml:"NextContinuationToken,omitempty"`
+	StartAfter            string `xml:"StartAfter,omitempty"`
+}
+
+type object struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type commonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+func (x *Server) listBuckets(req *request) *apiError {
+	root, e := x.fullPath("/")
+	if e != nil {
+		return e
+	}
+	entries, err := x.files.List(root)
+	if err != nil {
+		return internal(err)
+	}
+
+	res := listBucketsResult{Owner: owner{ID: x.accessKey, DisplayName: x.accessKey}}
+	for _, f := range entries {
+		if f.IsDir && !strings.HasPrefix(f.Name, ".") {
+			res.Buckets = append(res.Buckets, bucket{Name: f.Name, CreationDate: formatTime(f.ModTime)})
+		}
+	}
+	writeXML(req.w, http.StatusOK, res)
+	return nil
+}
+
+// bucketLocation регион не используется, бакет всегда в us-east-1
+func (x *Server) bucketLocation(req *request) *apiError {
+	if e := x.bucketExists(req.bucket); e != nil {
+		return e
+	}
+	writeXML(req.w, http.StatusOK, locationResult{})
+	return nil
+}
+
+func (x *Server) headBucket(req *request) *apiError {
+	if e := x.bucketExists(req.bucket); e != nil {
+		return e
+	}
+	req.w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (x *Server) createBucket(req *request) *apiError {
+	if !bucketNameRe.MatchString(req.bucket) {
+		return errInvalidBucketName
+	}
+	// CreateBucketConfiguration с регионом не нужен
+	if _, err := io.Copy(io.Discard, req.body); err != nil {
+		return saveError(err)
+	}
+	if x.bucketExists(req.bucket) == nil {
+		return errBucketExists
+	}
+
+	full, e := x.fullPath(req.path())
+	if e != nil {
+		return e
+	}
+	if err := x.files.Mkdir(full); err != nil {
+		return saveError(err)
+	}
+
+	req.w.Header().Set("Location", "/"+req.bucket)
+	req.w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// deleteBucket удаляет только пустой каталог, как и S3
+func (x *Server) deleteBucket(req *request) *apiError {
+	if e := x.bucketExists(req.bucket); e != nil {
+		return e
+	}
+	full, e := x.fullPath(req.path())
+	if e != nil {
+		return e
+	}
+	entries, err := x.files.List(full)
+	if err != nil {
+		return internal(err)
+	}
+	if len(entries) > 0 {
+		return errBucketNotEmpty
+	}
+	if e := x.checkWrite(req.path()); e != nil {
+		return e
+	}
+
+	if err := x.files.Remove(full); err != nil {
+		return internal(err)
+	}
+	req.w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// listObjects ListObjects и ListObjectsV2 (list-type=2). Каталоги, как и
+// ключи с "/" на конце в putObject, видны как общие префиксы при
+// delimiter "/" и как пустые объекты "dir/", если в них ничего нет.
+func (x *Server) listObjects(req *request) *apiError {
+	if e := x.bucketExists(req.bucket); e != nil {
+		return e
+	}
+	q := req.r.URL.Query()
+	v2 := q.Get("list-type") == "2"
+
+	res := listObjectsResult{
+		Name:      req.bucket,
+		Prefix:    q.Get("prefix"),
+		Delimiter: q.Get("delimiter"),
+		MaxKeys:   maxKeys,
+	}
+	if v := q.Get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return errInvalidArgument.with("invalid max-keys")
+		}
+		res.MaxKeys = min(n, maxKeys)
+	}
+
+	// ключи не больше after уже выданы
+	var after string
+	if v2 {
+		res.StartAfter = q.Get("start-after")
+		after = res.StartAfter
+		if token := q.Get("continuation-token"); token != "" {
+			b, err := base64.RawURLEncoding.DecodeString(token)
+			if err != nil {
+				return errInvalidArgument.with("invalid continuation-token")
+			}
+			res.ContinuationToken = token
+			after = max(after, string(b))
+		}
+	} else {
+		marker := q.Get("marker")
+		res.Marker = &marker
+		after = marker
+	}
+
+	full, e := x.fullPath(req.path())
+	if e != nil {
+		return e
+	}
+
+	var last string
+	w := &walker{files: x.files, prefix: res.Prefix, delimiter: res.Delimiter, after: after}
+	w.fn = func(o entry) bool {
+		key := o.key
+		if res.Delimiter != "" {
+			rest := key[len(res.Prefix):]
+			if i := strings.Index(rest, res.Delimiter); i >= 0 {
+				key = res.Prefix + rest[:i+len(res.Delimiter)]
+				if key == last || key <= after {
+					return true
+				}
+			}
+		}
+
+		if len(res.Contents)+len(res.CommonPrefixes) == res.MaxKeys {
+			res.IsTruncated = res.MaxKeys > 0
+			return false
+		}
+		last = key
+
+		if key != o.key {
+			res.CommonPrefixes = append(res.CommonPrefixes, commonPrefix{Prefix: key})
+			return true
+		}
+		res.Contents = append(res.Contents, object{
+			Key:          key,
+			LastModified: formatTime(o.modTime),
+			ETag:         etag(o.modTime, o.size),
+			Size:         o.size,
+			StorageClass: "STANDARD",
+		})
+		return true
+	}
+	if err := w.walk(full, "", time.Time{}); err != nil && err != errStop {
+		return internal(err)
+	}
+
+	if res.IsTruncated {
+		if v2 {
+			res.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+		} else if res.Delimiter != "" {
+			res.NextMarker = last
+		}
+	}
+	if v2 {
+		n := len(res.Contents) + len(res.CommonPrefixes)
+		res.KeyCount = &n
+	}
+
+	writeXML(req.w, http.StatusOK, res)
+	return nil
+}
+
+// entry файл или пустой каталог в порядке ключей S3
+type entry struct {
+	key     string
+	size    int64
+	modTime time.Time
+}
+
+// errStop обход остановлен, ключей больше не нужно
+var errStop = errors.New("stop walk")
+
+// walker обходит ключи бакета с префиксом prefix после after в порядке
+// возрастания, пока fn возвращает true. Каталог dir идет в порядке ключа
+// "dir/", поэтому обход в глубину дает тот же порядок, что и сортировка
+// ключей. При delimiter "/" каталоги внутри префикса не раскрываются.
+type walker struct {
+	files     interfaces.FileUsecase
+	prefix    string
+	delimiter string
+	after     string
+	fn        func(entry) bool
+}
+
+func (x *walker) walk(full, dir string, modTime time.Time) error {
+	list, err := x.files.List(full)
+	if err != nil {
+		return err
+	}
+	if len(list) == 0 && dir != "" {
+		return x.yield(entry{key: dir, modTime: modTime})
+	}
+
+	keys := make([]string, len(list))
+	order := make([]int, 0, len(list))
+	for i, f := range list {
+		keys[i] = dir + f.Name
+		if f.IsDir {
+			keys[i] += "/"
+		}
+		if !strings.HasPrefix(f.Name, ".tmp_") {
+			order = append(order, i)
+		}
+	}
+	sort.Slice(order, func(i, j int) bool { return keys[order[i]] < keys[order[j]] })
+
+	for _, i := range order {
+		f, key := list[i], keys[i]
+		if !f.IsDir {
+			if err := x.yield(entry{key: key, size: f.Size, modTime: f.ModTime}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// в каталоге нет ключей с префиксом или все они уже выданы
+		if !strings.HasPrefix(key, x.prefix) && !strings.HasPrefix(x.prefix, key) {
+			continue
+		}
+		if key < x.after && !strings.HasPrefix(x.after, key) {
+			continue
+		}
+
+		if x.delimiter == "/" && len(key) > len(x.prefix) && strings.HasPrefix(key, x.prefix) {
+			if !x.fn(entry{key: key, modTime: f.ModTime}) {
+				return errStop
+			}
+			continue
+		}
+		if err := x.walk(filepath.Join(full, f.Name), key, f.ModTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (x *walker) yield(e entry) error {
+	if !strings.HasPrefix(e.key, x.prefix) || e.key <= x.after {
+		return nil
+	}
+	if !x.fn(e) {
+		return errStop
+	}
+	return nil
+}
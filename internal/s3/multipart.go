@@ -0,0 +1,260 @@
+package s3
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+	"github.com/AleksandrMac/fileserver/internal/interfaces"
+)
+
+const (
+	maxPartNumber = 10000
+	// maxCompleteBody предел XML со списком частей
+	maxCompleteBody = 1 << 20
+)
+
+type initiateUploadResult struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ InitiateMultipartUploadResult"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadId string   `xml:"UploadId"`
+}
+
+type completeUpload struct {
+	Parts []struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	} `xml:"Part"`
+}
+
+type completeUploadResult struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CompleteMultipartUploadResult"`
+	Location string   `xml:"Location"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	ETag     string   `xml:"ETag"`
+}
+
+type listPartsResult struct {
+	XMLName     xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListPartsResult"`
+	Bucket      string   `xml:"Bucket"`
+	Key         string   `xml:"Key"`
+	UploadId    string   `xml:"UploadId"`
+	IsTruncated bool     `xml:"IsTruncated"`
+	Parts       []part   `xml:"Part"`
+}
+
+type part struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+	Size       int64  `xml:"Size"`
+}
+
+func (x *Server) createUpload(req *request) *apiError {
+	if strings.HasSuffix(req.key, "/") {
+		return errInvalidArgument.with("multipart upload of a directory key")
+	}
+	if e := x.bucketExists(req.bucket); e != nil {
+		return e
+	}
+	if e := x.checkWrite(req.path()); e != nil {
+		return e
+	}
+
+	upload := &domain.MultipartUpload{Bucket: req.bucket, Key: req.key}
+	if err := x.uploads.Create(upload); err != nil {
+		return internal(err)
+	}
+
+	writeXML(req.w, http.StatusOK, initiateUploadResult{
+		Bucket:   req.bucket,
+		Key:      req.key,
+		UploadId: upload.Id,
+	})
+	return nil
+}
+
+func (x *Server) uploadPart(req *request) *apiError {
+	q := req.r.URL.Query()
+	number, err := strconv.Atoi(q.Get("partNumber"))
+	if err != nil || number < 1 || number > maxPartNumber {
+		return errInvalidArgument.with("part number must be an integer between 1 and 10000")
+	}
+	upload, e := x.upload(req, q.Get("uploadId"))
+	if e != nil {
+		return e
+	}
+
+	body, e := contentMD5(req.r, req.body)
+	if e != nil {
+		return e
+	}
+	p, err := x.uploads.PutPart(upload.Id, number, body)
+	if err != nil {
+		return saveError(err)
+	}
+
+	req.w.Header().Set("ETag", `"`+p.ETag+`"`)
+	req.w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (x *Server) listParts(req *request) *apiError {
+	upload, e := x.upload(req, req.r.URL.Query().Get("uploadId"))
+	if e != nil {
+		return e
+	}
+	parts, err := x.uploads.Parts(upload.Id)
+	if err != nil {
+		return internal(err)
+	}
+
+	res := listPartsResult{Bucket: upload.Bucket, Key: upload.Key, UploadId: upload.Id}
+	for _, p := range parts {
+		res.Parts = append(res.Parts, part{PartNumber: p.Number, ETag: `"` + p.ETag + `"`, Size: p.Size})
+	}
+	writeXML(req.w, http.StatusOK, res)
+	return nil
+}
+
+// completeUpload собирает объект из перечисленных частей. Части читаются
+// подряд в один SaveFile, поэтому объект появляется атомарно.
+func (x *Server) completeUpload(req *request) *apiError {
+	upload, e := x.upload(req, req.r.URL.Query().Get("uploadId"))
+	if e != nil {
+		return e
+	}
+
+	var body completeUpload
+	data, err := io.ReadAll(io.LimitReader(req.body, maxCompleteBody))
+	if err != nil {
+		return saveError(err)
+	}
+	if err := xml.Unmarshal(data, &body); err != nil || len(body.Parts) == 0 {
+		return errMalformedXML
+	}
+
+	uploaded, err := x.uploads.Parts(upload.Id)
+	if err != nil {
+		return internal(err)
+	}
+	etags := make(map[int]string, len(uploaded))
+	for _, p := range uploaded {
+		etags[p.Number] = p.ETag
+	}
+	numbers := make([]int, len(body.Parts))
+	for i, p := range body.Parts {
+		if i > 0 && p.PartNumber <= body.Parts[i-1].PartNumber {
+			return errInvalidPartOrder
+		}
+		if etag, ok := etags[p.PartNumber]; !ok || etag != strings.Trim(p.ETag, `"`) {
+			return errInvalidPart
+		}
+		numbers[i] = p.PartNumber
+	}
+
+	if e := x.bucketExists(req.bucket); e != nil {
+		return e
+	}
+	if e := x.checkWrite(req.path()); e != nil {
+		return e
+	}
+	full, e := x.fullPath(req.path())
+	if e != nil {
+		return e
+	}
+
+	parts := &partsReader{uploads: x.uploads, id: upload.Id, numbers: numbers}
+	defer parts.Close()
+	info, e := x.save(req, full, parts)
+	if e != nil {
+		return e
+	}
+	if err := x.uploads.Delete(upload.Id); err != nil {
+		log.Warn().Err(err).Str("upload", upload.Id).Msg("failed delete completed upload")
+	}
+
+	writeXML(req.w, http.StatusOK, completeUploadResult{
+		Location: req.path(),
+		Bucket:   req.bucket,
+		Key:      req.key,
+		ETag:     etag(info.ModTime(), info.Size()),
+	})
+	return nil
+}
+
+func (x *Server) abortUpload(req *request) *apiError {
+	upload, e := x.upload(req, req.r.URL.Query().Get("uploadId"))
+	if e != nil {
+		return e
+	}
+	if err := x.uploads.Delete(upload.Id); err != nil {
+		return internal(err)
+	}
+	req.w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// upload загрузка id, начатая для того же бакета и ключа
+func (x *Server) upload(req *request, id string) (*domain.MultipartUpload, *apiError) {
+	upload, err := x.uploads.Get(id)
+	if errors.Is(err, domain.ErrNotFound) {
+		return nil, errNoSuchUpload
+	}
+	if err != nil {
+		return nil, internal(err)
+	}
+	if upload.Bucket != req.bucket || upload.Key != req.key {
+		return nil, errNoSuchUpload
+	}
+	return upload, nil
+}
+
+// partsReader читает части загрузки подряд, открывая по одной
+type partsReader struct {
+	uploads interfaces.UploadRepo
+	id      string
+	numbers []int
+	cur     *os.File
+}
+
+func (x *partsReader) Read(p []byte) (int, error) {
+	for {
+		if x.cur == nil {
+			if len(x.numbers) == 0 {
+				return 0, io.EOF
+			}
+			f, err := x.uploads.OpenPart(x.id, x.numbers[0])
+			if err != nil {
+				return 0, err
+			}
+			x.cur, x.numbers = f, x.numbers[1:]
+		}
+
+		n, err := x.cur.Read(p)
+		if err == io.EOF {
+			x.cur.Close()
+			x.cur = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (x *partsReader) Close() error {
+	if x.cur == nil {
+		return nil
+	}
+	return x.cur.Close()
+}
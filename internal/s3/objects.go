@@ -0,0 +1,261 @@
+package s3
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+	"github.com/AleksandrMac/fileserver/pkg/hashreader"
+)
+
+var errNotSeekable = errors.New("file reader is not seekable")
+
+type copyObjectResult struct {
+	XMLName      xml.Name `xml:"CopyObjectResult"`
+	ETag         string   `xml:"ETag"`
+	LastModified string   `xml:"LastModified"`
+}
+
+// getObject GetObject и HeadObject. Range и условные заголовки
+// обрабатывает http.ServeContent.
+func (x *Server) getObject(req *request) *apiError {
+	if e := x.bucketExists(req.bucket); e != nil {
+		return e
+	}
+	full, e := x.fullPath(req.path())
+	if e != nil {
+		return e
+	}
+	info, err := x.files.FileInfo(full)
+	if err != nil {
+		return internal(err)
+	}
+	if info == nil || info.IsDir() {
+		return errNoSuchKey
+	}
+
+	f, err := x.files.ReadFile(full)
+	if err != nil {
+		return internal(err)
+	}
+	defer f.Close()
+
+	rs, ok := f.(io.ReadSeeker)
+	if !ok {
+		return internal(errNotSeekable)
+	}
+
+	req.w.Header().Set("ETag", etag(info.ModTime(), info.Size()))
+	req.w.Header().Set("Content-Type", "application/octet-stream")
+	cw := &countingWriter{ResponseWriter: req.w}
+	http.ServeContent(cw, req.r, info.Name(), info.ModTime(), rs)
+
+	if req.r.Method == http.MethodGet && cw.n > 0 {
+		req.notify(domain.AuditRecord{
+			Action: domain.AuditDownload,
+			Path:   req.path(),
+			Size:   cw.n,
+		})
+	}
+	return nil
+}
+
+// putObject PutObject. Ключ с "/" на конце создает каталог, как папки
+// в консолях S3.
+func (x *Server) putObject(req *request) *apiError {
+	if e := x.bucketExists(req.bucket); e != nil {
+		return e
+	}
+	if e := x.checkWrite(req.path()); e != nil {
+		return e
+	}
+	full, e := x.fullPath(req.path())
+	if e != nil {
+		return e
+	}
+
+	if strings.HasSuffix(req.key, "/") {
+		if _, err := io.Copy(io.Discard, req.body); err != nil {
+			return saveError(err)
+		}
+		if e := x.mkdirAll(req.bucket, req.key); e != nil {
+			return e
+		}
+		req.w.Header().Set("ETag", emptyETag)
+		req.w.WriteHeader(http.StatusOK)
+		return nil
+	}
+
+	body, e := contentMD5(req.r, req.body)
+	if e != nil {
+		return e
+	}
+	info, e := x.save(req, full, body)
+	if e != nil {
+		return e
+	}
+
+	req.w.Header().Set("ETag", etag(info.ModTime(), info.Size()))
+	req.w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// copyObject CopyObject в пределах хранилища, метаданные не копируются
+func (x *Server) copyObject(req *request, source string) *apiError {
+	srcBucket, srcKey, ok := copySource(source)
+	if !ok || strings.HasSuffix(srcKey, "/") {
+		return errInvalidArgument.with("invalid x-amz-copy-source")
+	}
+	if e := x.bucketExists(srcBucket); e != nil {
+		return e
+	}
+	if e := x.bucketExists(req.bucket); e != nil {
+		return e
+	}
+	if e := x.checkWrite(req.path()); e != nil {
+		return e
+	}
+
+	src, e := x.fullPath("/" + srcBucket + "/" + srcKey)
+	if e != nil {
+		return e
+	}
+	full, e := x.fullPath(req.path())
+	if e != nil {
+		return e
+	}
+	if info, err := x.files.FileInfo(src); err != nil {
+		return internal(err)
+	} else if info == nil || info.IsDir() {
+		return errNoSuchKey
+	}
+
+	f, err := x.files.ReadFile(src)
+	if err != nil {
+		return internal(err)
+	}
+	defer f.Close()
+
+	info, e := x.save(req, full, f)
+	if e != nil {
+		return e
+	}
+
+	writeXML(req.w, http.StatusOK, copyObjectResult{
+		ETag:         etag(info.ModTime(), info.Size()),
+		LastModified: formatTime(info.ModTime()),
+	})
+	return nil
+}
+
+// deleteObject DeleteObject. Отсутствующий ключ не ошибка, каталог
+// удаляется только пустым и только по ключу с "/" на конце.
+func (x *Server) deleteObject(req *request) *apiError {
+	if e := x.bucketExists(req.bucket); e != nil {
+		return e
+	}
+	full, e := x.fullPath(req.path())
+	if e != nil {
+		return e
+	}
+	info, err := x.files.FileInfo(full)
+	if err != nil {
+		return internal(err)
+	}
+	if info == nil || info.IsDir() != strings.HasSuffix(req.key, "/") {
+		req.w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	if info.IsDir() {
+		entries, err := x.files.List(full)
+		if err != nil {
+			return internal(err)
+		}
+		if len(entries) > 0 {
+			req.w.WriteHeader(http.StatusNoContent)
+			return nil
+		}
+	}
+	if e := x.checkWrite(req.path()); e != nil {
+		return e
+	}
+
+	if err := x.files.Remove(full); err != nil {
+		return internal(err)
+	}
+
+	if !info.IsDir() {
+		req.notify(domain.AuditRecord{
+			Action: domain.AuditDelete,
+			Path:   req.path(),
+			Size:   info.Size(),
+		})
+	}
+	req.w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// save атомарно записывает body в full и сообщает об изменении хранилища
+func (x *Server) save(req *request, full string, body io.Reader) (os.FileInfo, *apiError) {
+	old, err := x.files.FileInfo(full)
+	if err != nil {
+		return nil, internal(err)
+	}
+	if old != nil && old.IsDir() {
+		return nil, errInvalidArgument.with("object key conflicts with an existing directory")
+	}
+
+	hr := hashreader.New(body)
+	if err := x.files.SaveFile(full, hr); err != nil {
+		return nil, saveError(err)
+	}
+	info, err := x.files.FileInfo(full)
+	if err != nil || info == nil {
+		return nil, internal(err)
+	}
+
+	action := domain.AuditUpload
+	if old != nil {
+		action = domain.AuditOverwrite
+	}
+	req.notify(domain.AuditRecord{
+		Action: action,
+		Path:   req.path(),
+		Size:   hr.Size(),
+		SHA256: hr.Sum(),
+	})
+
+	return info, nil
+}
+
+// mkdirAll создает каталоги ключа key внутри бакета
+func (x *Server) mkdirAll(bucket, key string) *apiError {
+	dir := "/" + bucket
+	for _, s := range strings.Split(strings.TrimSuffix(key, "/"), "/") {
+		dir += "/" + s
+		full, e := x.fullPath(dir)
+		if e != nil {
+			return e
+		}
+		if err := x.files.Mkdir(full); err != nil && !os.IsExist(err) {
+			return saveError(err)
+		}
+	}
+	return nil
+}
+
+// countingWriter считает отданные клиенту байты тела
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (x *countingWriter) Write(p []byte) (int, error) {
+	n, err := x.ResponseWriter.Write(p)
+	x.n += int64(n)
+	return n, err
+}
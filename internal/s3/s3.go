@@ -0,0 +1,256 @@
+package s3
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/rs/zerolog/log"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+	"github.com/AleksandrMac/fileserver/internal/interfaces"
+)
+
+const (
+	// формат дат в ответах S3
+	timeFormat = "2006-01-02T15:04:05.000Z"
+	// ETag пустого объекта, MD5 от пустой строки
+	emptyETag = `"d41d8cd98f00b204e9800998ecf8427e"`
+)
+
+// имена новых бакетов по правилам AWS. Существующие каталоги доступны
+// под любым именем, кроме скрытых.
+var bucketNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+// Server S3-совместимый API поверх хранилища: бакеты — каталоги верхнего
+// уровня STORAGE_PATH, ключи — пути файлов внутри них. Файлы читаются и
+// пишутся через FileUsecase, блокировки те же, что у REST и WebDAV.
+type Server struct {
+	accessKey string
+	secretKey string
+	files     interfaces.FileUsecase
+	locks     interfaces.LockUsecase
+	uploads   interfaces.UploadRepo
+}
+
+// New S3 API с одной парой ключей SigV4
+func New(accessKey, secretKey string, files interfaces.FileUsecase, locks interfaces.LockUsecase, uploads interfaces.UploadRepo) *Server {
+	return &Server{
+		accessKey: accessKey,
+		secretKey: secretKey,
+		files:     files,
+		locks:     locks,
+		uploads:   uploads,
+	}
+}
+
+// request запрос с проверенной подписью
+type request struct {
+	w      http.ResponseWriter
+	r      *http.Request
+	body   io.Reader
+	bucket string
+	key    string
+	notify func(rec domain.AuditRecord)
+}
+
+// path путь объекта относительно хранилища
+func (x *request) path() string {
+	if x.key == "" {
+		return "/" + x.bucket
+	}
+	return "/" + x.bucket + "/" + x.key
+}
+
+func (x *Server) Serve(w http.ResponseWriter, r *http.Request, notify func(rec domain.AuditRecord)) {
+	if id := middleware.GetReqID(r.Context()); id != "" {
+		w.Header().Set("X-Amz-Request-Id", id)
+	}
+
+	body, e := x.verify(r)
+	if e != nil {
+		notify(domain.AuditRecord{
+			Action: domain.AuditAuthFailure,
+			Path:   r.URL.Path,
+			Reason: "s3 " + e.Code,
+		})
+		writeError(w, r, e)
+		return
+	}
+
+	req := &request{w: w, r: r, body: body, notify: notify}
+	req.bucket, req.key, _ = strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if e := x.route(req); e != nil {
+		writeError(w, r, e)
+	}
+}
+
+func (x *Server) route(req *request) *apiError {
+	q := req.r.URL.Query()
+	method := req.r.Method
+
+	if req.bucket == "" {
+		if method == http.MethodGet {
+			return x.listBuckets(req)
+		}
+		return errMethodNotAllowed
+	}
+	if strings.HasPrefix(req.bucket, ".") {
+		return errInvalidBucketName
+	}
+
+	if req.key == "" {
+		switch {
+		case method == http.MethodGet && q.Has("location"):
+			return x.bucketLocation(req)
+		case method == http.MethodGet && q.Has("uploads"):
+			return errNotImplemented
+		case method == http.MethodGet:
+			return x.listObjects(req)
+		case method == http.MethodHead:
+			return x.headBucket(req)
+		case method == http.MethodPut:
+			return x.createBucket(req)
+		case method == http.MethodDelete:
+			return x.deleteBucket(req)
+		case method == http.MethodPost:
+			return errNotImplemented
+		}
+		return errMethodNotAllowed
+	}
+	if !validKey(req.key) {
+		return errInvalidArgument.with("object key is not supported by the file storage")
+	}
+
+	copySource := req.r.Header.Get("X-Amz-Copy-Source")
+	switch {
+	case method == http.MethodGet && q.Has("uploadId"):
+		return x.listParts(req)
+	case method == http.MethodGet, method == http.MethodHead:
+		return x.getObject(req)
+	case method == http.MethodPut && q.Has("uploadId") && copySource != "":
+		return errNotImplemented
+	case method == http.MethodPut && q.Has("uploadId"):
+		return x.uploadPart(req)
+	case method == http.MethodPut && copySource != "":
+		return x.copyObject(req, copySource)
+	case method == http.MethodPut:
+		return x.putObject(req)
+	case method == http.MethodPost && q.Has("uploads"):
+		return x.createUpload(req)
+	case method == http.MethodPost && q.Has("uploadId"):
+		return x.completeUpload(req)
+	case method == http.MethodDelete && q.Has("uploadId"):
+		return x.abortUpload(req)
+	case method == http.MethodDelete:
+		return x.deleteObject(req)
+	}
+	return errMethodNotAllowed
+}
+
+// validKey ключ можно хранить как путь файла: без пустых сегментов, "." и ".."
+// и без временных файлов SaveFile
+func validKey(key string) bool {
+	if len(key) > 1024 {
+		return false
+	}
+	for _, s := range strings.Split(strings.TrimSuffix(key, "/"), "/") {
+		if s == "" || s == "." || s == ".." || strings.HasPrefix(s, ".tmp_") {
+			return false
+		}
+	}
+	return true
+}
+
+// fullPath путь в файловой системе для path относительно хранилища
+func (x *Server) fullPath(path string) (string, *apiError) {
+	full, err := x.files.GetFullPath(path)
+	if err != nil {
+		return "", errInvalidArgument.with(err.Error())
+	}
+	return full, nil
+}
+
+// bucketExists проверяет, что каталог бакета есть
+func (x *Server) bucketExists(bucket string) *apiError {
+	full, e := x.fullPath("/" + bucket)
+	if e != nil {
+		return e
+	}
+	info, err := x.files.FileInfo(full)
+	if err != nil {
+		return internal(err)
+	}
+	if info == nil || !info.IsDir() {
+		return errNoSuchBucket
+	}
+	return nil
+}
+
+// checkWrite запись без токена блокировки, как у клиентов без LOCK
+func (x *Server) checkWrite(path string) *apiError {
+	if _, err := x.locks.CheckWrite(path, ""); err != nil {
+		return errLocked
+	}
+	return nil
+}
+
+// etag версия файла по времени изменения и размеру. Дефис отличает ее от MD5,
+// чтобы клиенты не сверяли с ней содержимое.
+func etag(modTime time.Time, size int64) string {
+	return `"` + strconv.FormatInt(modTime.UnixNano(), 16) + "-" + strconv.FormatInt(size, 16) + `"`
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(timeFormat)
+}
+
+// contentMD5 добавляет к телу проверку заголовка Content-MD5
+func contentMD5(r *http.Request, body io.Reader) (io.Reader, *apiError) {
+	v := r.Header.Get("Content-Md5")
+	if v == "" {
+		return body, nil
+	}
+	want, err := base64.StdEncoding.DecodeString(v)
+	if err != nil || len(want) != md5.Size {
+		return nil, &apiError{"InvalidDigest", "The Content-MD5 you specified is not valid", http.StatusBadRequest}
+	}
+	return &checkedReader{r: body, h: md5.New(), want: want, err: errBadDigest}, nil
+}
+
+// saveError ошибка чтения тела при записи или ошибка файловой системы
+func saveError(err error) *apiError {
+	var e *apiError
+	if errors.As(err, &e) {
+		return e
+	}
+	if errors.Is(err, syscall.ENOTDIR) || errors.Is(err, syscall.EISDIR) {
+		return errInvalidArgument.with("object key conflicts with an existing file or directory")
+	}
+	return internal(err)
+}
+
+func internal(err error) *apiError {
+	log.Error().Err(err).Msg("s3 request failed")
+	return errInternal
+}
+
+// copySource бакет и ключ из x-amz-copy-source: "/bucket/key" или "bucket/key"
+func copySource(v string) (bucket, key string, ok bool) {
+	v, _, _ = strings.Cut(v, "?")
+	v, err := url.PathUnescape(v)
+	if err != nil {
+		return "", "", false
+	}
+	bucket, key, _ = strings.Cut(strings.TrimPrefix(v, "/"), "/")
+	return bucket, key, bucket != "" && !strings.HasPrefix(bucket, ".") && validKey(key)
+}
@@ -0,0 +1,307 @@
+package s3
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	algorithm         = "AWS4-HMAC-SHA256"
+	amzDateFormat     = "20060102T150405Z"
+	unsignedPayload   = "UNSIGNED-PAYLOAD"
+	streamingPayload  = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	streamingTrailer  = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD-TRAILER"
+	streamingUnsigned = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+	emptySHA256       = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+	maxClockSkew    = 15 * time.Minute
+	maxPresignedTTL = 7 * 24 * time.Hour
+	maxChunkSize    = 16 << 20
+)
+
+// signature проверенная подпись запроса, по ней проверяются части тела
+type signature struct {
+	date    string
+	scope   string
+	key     []byte
+	value   string
+	payload string
+}
+
+// verify проверяет подпись SigV4 из заголовка Authorization или параметров
+// presigned URL и возвращает тело запроса, проверяемое по мере чтения
+func (x *Server) verify(r *http.Request) (io.Reader, *apiError) {
+	q := r.URL.Query()
+
+	var credential, signedHeaders, sig, date, payload string
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if !strings.HasPrefix(auth, algorithm+" ") {
+			return nil, errAccessDenied.with("unsupported authorization type")
+		}
+		for _, f := range strings.Split(strings.TrimPrefix(auth, algorithm+" "), ",") {
+			k, v, _ := strings.Cut(strings.TrimSpace(f), "=")
+			switch k {
+			case "Credential":
+				credential = v
+			case "SignedHeaders":
+				signedHeaders = v
+			case "Signature":
+				sig = v
+			}
+		}
+		date = r.Header.Get("X-Amz-Date")
+		payload = r.Header.Get("X-Amz-Content-Sha256")
+		if payload == "" {
+			payload = emptySHA256
+		}
+
+		t, err := time.Parse(amzDateFormat, date)
+		if err != nil {
+			return nil, errAccessDenied.with("invalid X-Amz-Date")
+		}
+		if d := time.Since(t); d > maxClockSkew || d < -maxClockSkew {
+			return nil, errTimeSkewed
+		}
+	} else if q.Get("X-Amz-Algorithm") == algorithm {
+		credential = q.Get("X-Amz-Credential")
+		signedHeaders = q.Get("X-Amz-SignedHeaders")
+		sig = q.Get("X-Amz-Signature")
+		date = q.Get("X-Amz-Date")
+		payload = unsignedPayload
+
+		t, err := time.Parse(amzDateFormat, date)
+		if err != nil {
+			return nil, errAccessDenied.with("invalid X-Amz-Date")
+		}
+		expires, err := strconv.Atoi(q.Get("X-Amz-Expires"))
+		if err != nil || expires < 0 || time.Duration(expires)*time.Second > maxPresignedTTL {
+			return nil, errAccessDenied.with("invalid X-Amz-Expires")
+		}
+		if time.Now().After(t.Add(time.Duration(expires) * time.Second)) {
+			return nil, errAccessDenied.with("request has expired")
+		}
+	} else {
+		return nil, errAccessDenied.with("anonymous access is not allowed")
+	}
+
+	// AKID/20240101/us-east-1/s3/aws4_request
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[3] != "s3" || parts[4] != "aws4_request" || len(date) < 8 || parts[1] != date[:8] {
+		return nil, errAccessDenied.with("invalid credential scope")
+	}
+	if parts[0] != x.accessKey {
+		return nil, errInvalidAccessKey
+	}
+	if signedHeaders == "" || sig == "" {
+		return nil, errAccessDenied.with("missing signature")
+	}
+
+	s := &signature{
+		date:    date,
+		scope:   strings.Join(parts[1:], "/"),
+		key:     signingKey(x.secretKey, parts[1], parts[2]),
+		payload: payload,
+	}
+	stringToSign := strings.Join([]string{
+		algorithm,
+		date,
+		s.scope,
+		hexSHA256([]byte(canonicalRequest(r, strings.Split(signedHeaders, ";"), payload))),
+	}, "\n")
+	s.value = hex.EncodeToString(hmacSHA256(s.key, stringToSign))
+	if !hmac.Equal([]byte(s.value), []byte(sig)) {
+		return nil, errSignatureMismatch
+	}
+
+	switch payload {
+	case unsignedPayload:
+		return r.Body, nil
+	case streamingPayload, streamingTrailer:
+		return &chunkedReader{r: bufio.NewReader(r.Body), sig: s, prev: s.value}, nil
+	case streamingUnsigned:
+		return &chunkedReader{r: bufio.NewReader(r.Body)}, nil
+	}
+	want, err := hex.DecodeString(payload)
+	if err != nil || len(want) != sha256.Size {
+		return nil, errInvalidArgument.with("invalid x-amz-content-sha256")
+	}
+	return &checkedReader{r: r.Body, h: sha256.New(), want: want, err: errContentSHA256Mismatch}, nil
+}
+
+func canonicalRequest(r *http.Request, signedHeaders []string, payload string) string {
+	var b strings.Builder
+	b.WriteString(r.Method + "\n")
+	b.WriteString(uriEncode(r.URL.Path, false) + "\n")
+
+	q := r.URL.Query()
+	q.Del("X-Amz-Signature")
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, k := range keys {
+		values := append([]string(nil), q[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			pairs = append(pairs, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	b.WriteString(strings.Join(pairs, "&") + "\n")
+
+	for _, h := range signedHeaders {
+		var v string
+		switch h {
+		case "host":
+			v = r.Host
+		case "content-length":
+			v = strconv.FormatInt(r.ContentLength, 10)
+		default:
+			v = strings.Join(r.Header.Values(h), ",")
+		}
+		b.WriteString(h + ":" + strings.Join(strings.Fields(v), " ") + "\n")
+	}
+	b.WriteString("\n")
+	b.WriteString(strings.Join(signedHeaders, ";") + "\n")
+	b.WriteString(payload)
+
+	return b.String()
+}
+
+// uriEncode кодирует все, кроме незарезервированных символов (и '/' в пути)
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && !encodeSlash {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func signingKey(secret, date, region string) []byte {
+	k := hmacSHA256([]byte("AWS4"+secret), date)
+	k = hmacSHA256(k, region)
+	k = hmacSHA256(k, "s3")
+	return hmacSHA256(k, "aws4_request")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// checkedReader сверяет хеш тела с ожидаемым в конце чтения. Ошибка вместо
+// io.EOF не дает SaveFile заменить файл непроверенным содержимым.
+type checkedReader struct {
+	r    io.Reader
+	h    hash.Hash
+	want []byte
+	err  *apiError
+}
+
+func (x *checkedReader) Read(p []byte) (int, error) {
+	n, err := x.r.Read(p)
+	x.h.Write(p[:n])
+	if err == io.EOF && !bytes.Equal(x.h.Sum(nil), x.want) {
+		return n, x.err
+	}
+	return n, err
+}
+
+// chunkedReader тело в кодировке aws-chunked. Подпись каждой части
+// проверяется по цепочке от подписи запроса, без sig части не подписаны.
+type chunkedReader struct {
+	r    *bufio.Reader
+	sig  *signature
+	prev string
+	buf  []byte
+	done bool
+}
+
+func (x *chunkedReader) Read(p []byte) (int, error) {
+	for len(x.buf) == 0 {
+		if x.done {
+			return 0, io.EOF
+		}
+		if err := x.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, x.buf)
+	x.buf = x.buf[n:]
+	return n, nil
+}
+
+// next читает следующую часть: "<hex size>[;chunk-signature=<sig>]\r\n<data>\r\n"
+func (x *chunkedReader) next() error {
+	line, err := x.r.ReadString('\n')
+	if err != nil {
+		return errIncompleteBody
+	}
+	sizeHex, ext, _ := strings.Cut(strings.TrimRight(line, "\r\n"), ";")
+	size, err := strconv.ParseInt(sizeHex, 16, 64)
+	if err != nil || size < 0 || size > maxChunkSize {
+		return errIncompleteBody
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(x.r, data); err != nil {
+		return errIncompleteBody
+	}
+
+	if x.sig != nil {
+		chunkSig, ok := strings.CutPrefix(ext, "chunk-signature=")
+		if !ok {
+			return errSignatureMismatch
+		}
+		stringToSign := strings.Join([]string{
+			"AWS4-HMAC-SHA256-PAYLOAD", x.sig.date, x.sig.scope, x.prev, emptySHA256, hexSHA256(data),
+		}, "\n")
+		if !hmac.Equal([]byte(hex.EncodeToString(hmacSHA256(x.sig.key, stringToSign))), []byte(chunkSig)) {
+			return errSignatureMismatch
+		}
+		x.prev = chunkSig
+	}
+
+	if size == 0 {
+		// завершающие заголовки (x-amz-checksum-*) до пустой строки
+		x.done = true
+		for {
+			line, err := x.r.ReadString('\n')
+			if strings.TrimRight(line, "\r\n") == "" || err != nil {
+				return nil
+			}
+		}
+	}
+
+	crlf := make([]byte, 2)
+	if _, err := io.ReadFull(x.r, crlf); err != nil || !bytes.Equal(crlf, []byte("\r\n")) {
+		return errIncompleteBody
+	}
+	x.buf = data
+	return nil
+}
@@ -0,0 +1,404 @@
+package sftp
+
+import (
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/sftp"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+	"github.com/AleksandrMac/fileserver/pkg/hashreader"
+)
+
+// временные файлы SaveFile, клиенту не показываются
+const tempPrefix = ".tmp_"
+
+// fileSystem обработчики sftp.RequestServer для одного пользователя.
+// Путь клиента "/" — корень пользователя u.Root в хранилище.
+type fileSystem struct {
+	server   *Server
+	user     *domain.SFTPUser
+	clientIP string
+}
+
+// resolve путь клиента в путь относительно хранилища и полный путь
+func (x *fileSystem) resolve(p string) (rel, full string, err error) {
+	rel = path.Join(x.user.Root, path.Clean("/"+p))
+	full, err = x.server.repo.GetFullPath(rel)
+	if err != nil {
+		return "", "", sftp.ErrSSHFxPermissionDenied
+	}
+	return rel, full, nil
+}
+
+// writable проверяет право записи пользователя и блокировки rel.
+// Заблокированный файл для клиента выглядит как недоступный для записи.
+func (x *fileSystem) writable(rel string) error {
+	if x.user.ReadOnly || strings.HasPrefix(path.Base(rel), tempPrefix) {
+		return sftp.ErrSSHFxPermissionDenied
+	}
+	if _, err := x.server.locks.CheckWrite(rel, ""); err != nil {
+		return sftp.ErrSSHFxPermissionDenied
+	}
+	return nil
+}
+
+func (x *fileSystem) notify(rec domain.AuditRecord) {
+	rec.Principal = PrincipalPrefix + x.user.Name
+	rec.ClientIP = x.clientIP
+	x.server.notify(rec)
+}
+
+func (x *fileSystem) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	rel, full, err := x.resolve(r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := x.server.repo.FileInfo(full)
+	if err != nil {
+		return nil, err
+	}
+	if info == nil {
+		return nil, os.ErrNotExist
+	}
+	if info.IsDir() {
+		return nil, os.ErrInvalid
+	}
+
+	f, err := x.server.repo.ReadFile(full)
+	if err != nil {
+		return nil, err
+	}
+	return &reader{File: f, fs: x, rel: rel}, nil
+}
+
+// Filewrite принимает файл во временный файл spool, в хранилище он
+// попадает через SaveFile только после закрытия клиентом
+func (x *fileSystem) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	rel, full, err := x.resolve(r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	if err := x.writable(rel); err != nil {
+		return nil, err
+	}
+	if dir, _ := x.server.repo.FileInfo(filepath.Dir(full)); dir == nil || !dir.IsDir() {
+		return nil, os.ErrNotExist
+	}
+
+	old, err := x.server.repo.FileInfo(full)
+	if err != nil {
+		return nil, err
+	}
+	if old != nil && old.IsDir() {
+		return nil, os.ErrInvalid
+	}
+	flags := r.Pflags()
+	if old != nil && flags.Excl {
+		return nil, os.ErrExist
+	}
+
+	spool, err := os.CreateTemp(x.server.spool, "upload-")
+	if err != nil {
+		return nil, err
+	}
+	w := &writer{File: spool, fs: x, rel: rel, full: full, append: flags.Append}
+
+	// дозапись и запись по смещению без O_TRUNC меняют текущее содержимое
+	if old != nil && !flags.Trunc {
+		f, err := x.server.repo.ReadFile(full)
+		if err == nil {
+			_, err = io.Copy(spool, f)
+			f.Close()
+		}
+		if err != nil {
+			w.TransferError(err)
+			w.Close()
+			return nil, err
+		}
+	}
+
+	return w, nil
+}
+
+func (x *fileSystem) Filecmd(r *sftp.Request) error {
+	switch r.Method {
+	case "Setstat":
+		// права и время изменения задает хранилище
+		return nil
+	case "Rename":
+		return x.rename(r.Filepath, r.Target, false)
+	case "Mkdir":
+		rel, full, err := x.resolve(r.Filepath)
+		if err != nil {
+			return err
+		}
+		if err := x.writable(rel); err != nil {
+			return err
+		}
+		return x.server.repo.Mkdir(full)
+	case "Rmdir", "Remove":
+		return x.remove(r.Filepath, r.Method == "Rmdir")
+	}
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+// PosixRename переименование с заменой существующего файла
+func (x *fileSystem) PosixRename(r *sftp.Request) error {
+	return x.rename(r.Filepath, r.Target, true)
+}
+
+func (x *fileSystem) rename(from, to string, replace bool) error {
+	oldRel, oldFull, err := x.resolve(from)
+	if err != nil {
+		return err
+	}
+	newRel, newFull, err := x.resolve(to)
+	if err != nil {
+		return err
+	}
+	if oldRel == x.user.Root || newRel == x.user.Root {
+		return sftp.ErrSSHFxPermissionDenied
+	}
+	if err := x.writable(oldRel); err != nil {
+		return err
+	}
+	if err := x.writable(newRel); err != nil {
+		return err
+	}
+
+	info, err := x.server.repo.FileInfo(oldFull)
+	if err != nil {
+		return err
+	}
+	if info == nil {
+		return os.ErrNotExist
+	}
+	target, err := x.server.repo.FileInfo(newFull)
+	if err != nil {
+		return err
+	}
+	if target != nil {
+		if !replace || target.IsDir() {
+			return os.ErrExist
+		}
+	}
+
+	if err := x.server.repo.Rename(oldFull, newFull); err != nil {
+		return err
+	}
+	x.notify(domain.AuditRecord{
+		Action: domain.AuditMove,
+		Path:   newRel,
+		From:   oldRel,
+	})
+	return nil
+}
+
+// remove удаляет файл или, для Rmdir, пустой каталог
+func (x *fileSystem) remove(p string, dir bool) error {
+	rel, full, err := x.resolve(p)
+	if err != nil {
+		return err
+	}
+	if rel == x.user.Root {
+		return sftp.ErrSSHFxPermissionDenied
+	}
+	if err := x.writable(rel); err != nil {
+		return err
+	}
+
+	info, err := x.server.repo.FileInfo(full)
+	if err != nil {
+		return err
+	}
+	if info == nil {
+		return os.ErrNotExist
+	}
+	if info.IsDir() != dir {
+		return os.ErrInvalid
+	}
+	if dir {
+		entries, err := x.server.repo.List(full)
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			return sftp.ErrSSHFxFailure
+		}
+	}
+
+	if err := x.server.repo.Remove(full); err != nil {
+		return err
+	}
+	if !dir {
+		x.notify(domain.AuditRecord{
+			Action: domain.AuditDelete,
+			Path:   rel,
+			Size:   info.Size(),
+		})
+	}
+	return nil
+}
+
+func (x *fileSystem) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	_, full, err := x.resolve(r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+
+	switch r.Method {
+	case "List":
+		entries, err := x.server.repo.List(full)
+		if err != nil {
+			return nil, err
+		}
+		list := make(listerAt, 0, len(entries))
+		for _, e := range entries {
+			if !strings.HasPrefix(e.Name, tempPrefix) {
+				list = append(list, fileInfo{e})
+			}
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Name() < list[j].Name() })
+		return list, nil
+	case "Stat":
+		info, err := x.server.repo.FileInfo(full)
+		if err != nil {
+			return nil, err
+		}
+		if info == nil {
+			return nil, os.ErrNotExist
+		}
+		return listerAt{info}, nil
+	}
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+// reader отдает файл хранилища и учитывает прочитанные байты
+type reader struct {
+	*os.File
+	fs  *fileSystem
+	rel string
+	n   atomic.Int64
+}
+
+func (x *reader) ReadAt(p []byte, off int64) (int, error) {
+	n, err := x.File.ReadAt(p, off)
+	x.n.Add(int64(n))
+	return n, err
+}
+
+func (x *reader) Close() error {
+	if n := x.n.Load(); n > 0 {
+		x.fs.notify(domain.AuditRecord{
+			Action: domain.AuditDownload,
+			Path:   x.rel,
+			Size:   n,
+		})
+	}
+	return x.File.Close()
+}
+
+// writer принимает файл в spool. Close без ошибки передачи заменяет файл
+// хранилища через SaveFile, после обрыва соединения spool удаляется.
+type writer struct {
+	*os.File
+	fs      *fileSystem
+	rel     string
+	full    string
+	aborted atomic.Bool
+
+	// append при SSH_FXF_APPEND смещение клиента игнорируется
+	append bool
+	mu     sync.Mutex
+}
+
+func (x *writer) WriteAt(p []byte, off int64) (int, error) {
+	if !x.append {
+		return x.File.WriteAt(p, off)
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if _, err := x.File.Seek(0, io.SeekEnd); err != nil {
+		return 0, err
+	}
+	return x.File.Write(p)
+}
+
+// TransferError вызывается sftp.RequestServer, если соединение оборвалось
+// с открытым файлом
+func (x *writer) TransferError(err error) {
+	x.aborted.Store(true)
+}
+
+func (x *writer) Close() error {
+	defer os.Remove(x.File.Name())
+
+	if x.aborted.Load() {
+		return x.File.Close()
+	}
+	if _, err := x.File.Seek(0, io.SeekStart); err != nil {
+		x.File.Close()
+		return err
+	}
+
+	old, _ := x.fs.server.repo.FileInfo(x.full)
+	hr := hashreader.New(x.File)
+	err := x.fs.server.repo.SaveFile(x.full, hr)
+	if closeErr := x.File.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	action := domain.AuditUpload
+	if old != nil {
+		action = domain.AuditOverwrite
+	}
+	x.fs.notify(domain.AuditRecord{
+		Action: action,
+		Path:   x.rel,
+		Size:   hr.Size(),
+		SHA256: hr.Sum(),
+	})
+	return nil
+}
+
+type listerAt []os.FileInfo
+
+func (x listerAt) ListAt(ls []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(x)) {
+		return 0, io.EOF
+	}
+	n := copy(ls, x[offset:])
+	if n < len(ls) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// fileInfo элемент листинга хранилища как os.FileInfo
+type fileInfo struct {
+	domain.FileInfo
+}
+
+func (x fileInfo) Name() string       { return x.FileInfo.Name }
+func (x fileInfo) Size() int64        { return x.FileInfo.Size }
+func (x fileInfo) ModTime() time.Time { return x.FileInfo.ModTime }
+func (x fileInfo) IsDir() bool        { return x.FileInfo.IsDir }
+func (x fileInfo) Sys() any           { return nil }
+
+func (x fileInfo) Mode() os.FileMode {
+	if x.FileInfo.IsDir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
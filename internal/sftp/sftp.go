@@ -0,0 +1,272 @@
+package sftp
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+	"github.com/AleksandrMac/fileserver/internal/interfaces"
+)
+
+// PrincipalPrefix префикс субъекта аудита для пользователей SFTP
+const PrincipalPrefix = "sftp:"
+
+// handshakeTimeout сколько ждать рукопожатия и входа, иначе соединение
+// без единого байта держало бы горутину до Close
+const handshakeTimeout = 30 * time.Second
+
+// Server SFTP поверх хранилища. Каждый пользователь заперт в своем каталоге,
+// файлы пишутся через SaveFile и до конца передачи лежат в spool вне хранилища.
+type Server struct {
+	hostKey ssh.Signer
+	users   interfaces.SFTPUserRepo
+	repo    interfaces.FileRepo
+	locks   interfaces.LockUsecase
+	spool   string
+	notify  func(rec domain.AuditRecord)
+
+	handshakeTimeout time.Duration
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+}
+
+// New SFTP-сервер. spool — каталог для принимаемых файлов, notify вызывается
+// после каждого изменения хранилища, скачивания и неудачного входа.
+func New(hostKey ssh.Signer, users interfaces.SFTPUserRepo, repo interfaces.FileRepo, locks interfaces.LockUsecase, spool string, notify func(rec domain.AuditRecord)) (*Server, error) {
+	if err := os.MkdirAll(spool, 0755); err != nil {
+		return nil, err
+	}
+	return &Server{
+		hostKey: hostKey,
+		users:   users,
+		repo:    repo,
+		locks:   locks,
+		spool:   spool,
+		notify:  notify,
+		conns:   make(map[net.Conn]struct{}),
+
+		handshakeTimeout: handshakeTimeout,
+	}, nil
+}
+
+// Serve принимает соединения до Close
+func (x *Server) Serve(l net.Listener) error {
+	x.mu.Lock()
+	if x.closed {
+		x.mu.Unlock()
+		return net.ErrClosed
+	}
+	x.listener = l
+	x.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go x.handle(conn)
+	}
+}
+
+// Close закрывает слушатель и все соединения. Незавершенные загрузки отбрасываются.
+func (x *Server) Close() error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.closed = true
+	for c := range x.conns {
+		c.Close()
+	}
+	if x.listener != nil {
+		return x.listener.Close()
+	}
+	return nil
+}
+
+func (x *Server) handle(conn net.Conn) {
+	x.mu.Lock()
+	if x.closed {
+		x.mu.Unlock()
+		conn.Close()
+		return
+	}
+	x.conns[conn] = struct{}{}
+	x.mu.Unlock()
+
+	defer func() {
+		x.mu.Lock()
+		delete(x.conns, conn)
+		x.mu.Unlock()
+		conn.Close()
+	}()
+
+	clientIP, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+
+	// последний пользователь, под которым пытались войти
+	var attempted string
+	cfg := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			u, ok := x.users.Get(c.User())
+			if !ok || u.Password == "" || bcrypt.CompareHashAndPassword([]byte(u.Password), password) != nil {
+				return nil, errors.New("invalid credentials")
+			}
+			return &ssh.Permissions{Extensions: map[string]string{"user": u.Name}}, nil
+		},
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			u, ok := x.users.Get(c.User())
+			if ok && authorized(u, key) {
+				return &ssh.Permissions{Extensions: map[string]string{"user": u.Name}}, nil
+			}
+			return nil, errors.New("invalid credentials")
+		},
+		AuthLogCallback: func(c ssh.ConnMetadata, method string, err error) {
+			if method != "none" {
+				attempted = c.User()
+			}
+		},
+	}
+	cfg.AddHostKey(x.hostKey)
+
+	conn.SetDeadline(time.Now().Add(x.handshakeTimeout))
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+	if err != nil {
+		if attempted != "" {
+			x.notify(domain.AuditRecord{
+				Action:    domain.AuditAuthFailure,
+				Principal: PrincipalPrefix + attempted,
+				ClientIP:  clientIP,
+				Reason:    "sftp authentication failed",
+			})
+		}
+		log.Debug().Err(err).Str("client", clientIP).Msg("sftp handshake failed")
+		return
+	}
+	defer sconn.Close()
+	// передачи могут идти долго, дедлайн нужен только рукопожатию
+	conn.SetDeadline(time.Time{})
+	go ssh.DiscardRequests(reqs)
+
+	u, _ := x.users.Get(sconn.Permissions.Extensions["user"])
+	if err := x.mkdirAll(u.Root); err != nil {
+		log.Error().Err(err).Str("user", u.Name).Str("root", u.Root).Msg("can't create sftp root")
+		return
+	}
+	log.Info().Str("user", u.Name).Str("client", clientIP).Msg("sftp session started")
+
+	for ch := range chans {
+		if ch.ChannelType() != "session" {
+			ch.Reject(ssh.UnknownChannelType, "only session channels are supported")
+			continue
+		}
+		channel, requests, err := ch.Accept()
+		if err != nil {
+			log.Warn().Err(err).Msg("can't accept sftp channel")
+			continue
+		}
+		go x.session(channel, requests, &fileSystem{
+			server:   x,
+			user:     u,
+			clientIP: clientIP,
+		})
+	}
+}
+
+// session обслуживает канал, в котором разрешена только подсистема sftp
+func (x *Server) session(channel ssh.Channel, requests <-chan *ssh.Request, fs *fileSystem) {
+	defer channel.Close()
+
+	for req := range requests {
+		ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+		req.Reply(ok, nil)
+		if !ok {
+			continue
+		}
+
+		srv := sftp.NewRequestServer(channel, sftp.Handlers{
+			FileGet:  fs,
+			FilePut:  fs,
+			FileCmd:  fs,
+			FileList: fs,
+		})
+		if err := srv.Serve(); err != nil && !errors.Is(err, io.EOF) {
+			log.Debug().Err(err).Str("user", fs.user.Name).Msg("sftp session ended")
+		}
+		srv.Close()
+		return
+	}
+}
+
+// mkdirAll создает корневой каталог пользователя в хранилище
+func (x *Server) mkdirAll(root string) error {
+	dir := ""
+	for _, s := range strings.Split(strings.Trim(root, "/"), "/") {
+		if s == "" {
+			continue
+		}
+		dir += "/" + s
+		full, err := x.repo.GetFullPath(dir)
+		if err != nil {
+			return err
+		}
+		if err := x.repo.Mkdir(full); err != nil && !os.IsExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func authorized(u *domain.SFTPUser, key ssh.PublicKey) bool {
+	for _, k := range u.Keys {
+		allowed, _, _, _, err := ssh.ParseAuthorizedKey([]byte(k))
+		if err == nil && string(allowed.Marshal()) == string(key.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadHostKey читает ключ сервера из file, при отсутствии создает ed25519
+func LoadHostKey(file string) (ssh.Signer, error) {
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		block, err := ssh.MarshalPrivateKey(key, "fileserver sftp host key")
+		if err != nil {
+			return nil, err
+		}
+		data = pem.EncodeToMemory(block)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(file, data, 0600); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	return ssh.ParsePrivateKey(data)
+}
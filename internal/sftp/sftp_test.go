@@ -0,0 +1,370 @@
+package sftp
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+	"github.com/AleksandrMac/fileserver/internal/repository"
+	"github.com/AleksandrMac/fileserver/internal/testenv"
+	"github.com/AleksandrMac/fileserver/internal/usecase"
+)
+
+// testEnv сервер на случайном порту с пользователями:
+// acme — пароль и ключ, корень /partners/acme; auditor — только чтение всего хранилища
+type testEnv struct {
+	storage string
+	spool   string
+	addr    string
+	key     ssh.Signer
+	locks   *usecase.Locks
+
+	mu      sync.Mutex
+	actions []string
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	base := testenv.New(t)
+	env := &testEnv{storage: base.Storage, locks: base.Locks}
+	data := base.Data
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.key, err = ssh.NewSignerFromKey(priv); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorizedKey := string(ssh.MarshalAuthorizedKey(env.key.PublicKey()))
+	cfg, _ := json.Marshal(map[string]any{"users": []domain.SFTPUser{
+		{Name: "acme", Root: "/partners/acme", Password: string(hash), Keys: []string{authorizedKey}},
+		{Name: "auditor", Root: "/", Keys: []string{authorizedKey}, ReadOnly: true},
+	}})
+	usersFile := filepath.Join(data, "users.json")
+	if err := os.WriteFile(usersFile, cfg, 0600); err != nil {
+		t.Fatal(err)
+	}
+	users, err := repository.NewSFTPUserRepository(usersFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hostKey, err := LoadHostKey(filepath.Join(data, "host_key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.spool = filepath.Join(data, "spool")
+	srv, err := New(hostKey, users, base.Repo, env.locks, env.spool, env.notify)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.handshakeTimeout = 2 * time.Second
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.addr = l.Addr().String()
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+	return env
+}
+
+func (x *testEnv) notify(rec domain.AuditRecord) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.actions = append(x.actions, string(rec.Action)+" "+rec.Principal+" "+rec.Path)
+}
+
+// takeActions возвращает и очищает записанные события
+func (x *testEnv) takeActions() []string {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	actions := x.actions
+	x.actions = nil
+	return actions
+}
+
+func (x *testEnv) dial(user string, auth ssh.AuthMethod) (*ssh.Client, error) {
+	return ssh.Dial("tcp", x.addr, &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{auth},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	})
+}
+
+func (x *testEnv) client(t *testing.T, user string, auth ssh.AuthMethod) (*sftp.Client, *ssh.Client) {
+	t.Helper()
+	conn, err := x.dial(user, auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := sftp.NewClient(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		c.Close()
+		conn.Close()
+	})
+	return c, conn
+}
+
+func (x *testEnv) storageFile(t *testing.T, rel string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(x.storage, filepath.FromSlash(rel)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func put(t *testing.T, c *sftp.Client, p, content string) {
+	t.Helper()
+	f, err := c.Create(p)
+	if err != nil {
+		t.Fatalf("create %s: %v", p, err)
+	}
+	if _, err := f.Write([]byte(content)); err != nil {
+		t.Fatalf("write %s: %v", p, err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("close %s: %v", p, err)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	env := newTestEnv(t)
+
+	tests := []struct {
+		name    string
+		user    string
+		auth    ssh.AuthMethod
+		wantErr bool
+	}{
+		{name: "password", user: "acme", auth: ssh.Password("secret")},
+		{name: "public key", user: "acme", auth: ssh.PublicKeys(env.key)},
+		{name: "wrong password", user: "acme", auth: ssh.Password("wrong"), wantErr: true},
+		{name: "no password set", user: "auditor", auth: ssh.Password(""), wantErr: true},
+		{name: "unknown user", user: "nobody", auth: ssh.PublicKeys(env.key), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env.takeActions()
+			conn, err := env.dial(tt.user, tt.auth)
+			if conn != nil {
+				conn.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("dial error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr {
+				return
+			}
+
+			// запись о неудачном входе делается после закрытия соединения клиентом
+			want := "auth_failure sftp:" + tt.user + " "
+			for i := 0; i < 50; i++ {
+				if actions := env.takeActions(); len(actions) > 0 {
+					if actions[0] != want {
+						t.Errorf("audit = %q, want %q", actions, want)
+					}
+					return
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			t.Error("failed login is not audited")
+		})
+	}
+}
+
+func TestFiles(t *testing.T) {
+	env := newTestEnv(t)
+	c, _ := env.client(t, "acme", ssh.Password("secret"))
+
+	put(t, c, "/report.txt", "hello")
+	if got := env.storageFile(t, "partners/acme/report.txt"); got != "hello" {
+		t.Errorf("stored = %q", got)
+	}
+
+	// выход за корень пользователя невозможен
+	put(t, c, "/../../escape.txt", "x")
+	if got := env.storageFile(t, "partners/acme/escape.txt"); got != "x" {
+		t.Errorf("escape stored = %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(env.storage, "escape.txt")); !os.IsNotExist(err) {
+		t.Errorf("file written outside the user root: %v", err)
+	}
+
+	// дозапись к существующему файлу
+	f, err := c.OpenFile("/report.txt", os.O_WRONLY|os.O_APPEND)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte(" world"))
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err = c.Open("/report.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(f)
+	f.Close()
+	if err != nil || string(data) != "hello world" {
+		t.Errorf("read = %q, %v", data, err)
+	}
+
+	if err := c.Mkdir("/in"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Rename("/report.txt", "/in/report.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Rename("/escape.txt", "/in/report.txt"); err == nil {
+		t.Error("rename over an existing file succeeded")
+	}
+	if err := c.PosixRename("/escape.txt", "/in/report.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := c.ReadDir("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "in" || !entries[0].IsDir() {
+		t.Errorf("list = %v", entries)
+	}
+	if err := c.RemoveDirectory("/in"); err == nil {
+		t.Error("removed a non-empty directory")
+	}
+	if err := c.Remove("/in/report.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RemoveDirectory("/in"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RemoveDirectory("/"); err == nil {
+		t.Error("removed the user root")
+	}
+
+	want := []string{
+		"upload sftp:acme /partners/acme/report.txt",
+		"upload sftp:acme /partners/acme/escape.txt",
+		"overwrite sftp:acme /partners/acme/report.txt",
+		"download sftp:acme /partners/acme/report.txt",
+		"move sftp:acme /partners/acme/in/report.txt",
+		"move sftp:acme /partners/acme/in/report.txt",
+		"delete sftp:acme /partners/acme/in/report.txt",
+	}
+	if got := env.takeActions(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("actions:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestReadOnlyAndLocks(t *testing.T) {
+	env := newTestEnv(t)
+	acme, _ := env.client(t, "acme", ssh.PublicKeys(env.key))
+	put(t, acme, "/a.txt", "a")
+
+	auditor, _ := env.client(t, "auditor", ssh.PublicKeys(env.key))
+	if _, err := auditor.Stat("/partners/acme/a.txt"); err != nil {
+		t.Errorf("read-only user can't stat: %v", err)
+	}
+	if _, err := auditor.Create("/partners/acme/b.txt"); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("read-only create error = %v, want permission denied", err)
+	}
+	if err := auditor.Remove("/partners/acme/a.txt"); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("read-only remove error = %v, want permission denied", err)
+	}
+
+	if _, err := env.locks.Lock("/partners/acme/a.txt", "editor", "", false, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := acme.Create("/a.txt"); err == nil {
+		t.Error("overwrote a locked file")
+	}
+	if err := acme.Remove("/a.txt"); err == nil {
+		t.Error("removed a locked file")
+	}
+	if got := env.storageFile(t, "partners/acme/a.txt"); got != "a" {
+		t.Errorf("locked file = %q", got)
+	}
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	env := newTestEnv(t)
+	conn, err := net.Dial("tcp", env.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// клиент молчит после баннера сервера, сервер закрывает соединение
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err := io.Copy(io.Discard, conn); err != nil {
+		t.Fatalf("connection kept open: %v", err)
+	}
+}
+
+func TestDroppedUpload(t *testing.T) {
+	env := newTestEnv(t)
+	c, conn := env.client(t, "acme", ssh.Password("secret"))
+	put(t, c, "/data.bin", "old")
+
+	f, err := c.Create("/data.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte(strings.Repeat("new", 1000))); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	// файл остается прежним, spool очищается после обрыва
+	for i := 0; ; i++ {
+		entries, _ := os.ReadDir(env.spool)
+		if len(entries) == 0 {
+			break
+		}
+		if i == 50 {
+			t.Fatalf("spool is not cleaned: %d files", len(entries))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := env.storageFile(t, "partners/acme/data.bin"); got != "old" {
+		t.Errorf("file after dropped upload = %q", got)
+	}
+
+	entries, _ := os.ReadDir(filepath.Join(env.storage, "partners", "acme"))
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != "data.bin" {
+		t.Errorf("storage files = %v", names)
+	}
+}
@@ -4,3 +4,8 @@ import "embed"
 
 //go:embed all:html
 var HTML embed.FS
+
+// Office пустые документы для создания новых файлов
+//
+//go:embed office
+var Office embed.FS
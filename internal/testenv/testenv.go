@@ -0,0 +1,117 @@
+// Package testenv общая обвязка тестов: хранилище и репозитории во временных
+// каталогах, собранные так же, как в cmd/fileserver.
+package testenv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	custhttp "github.com/AleksandrMac/fileserver/internal/delivery/http"
+	"github.com/AleksandrMac/fileserver/internal/repository"
+	"github.com/AleksandrMac/fileserver/internal/s3"
+	"github.com/AleksandrMac/fileserver/internal/usecase"
+	editor_usecase "github.com/AleksandrMac/fileserver/internal/usecase/editor"
+	"github.com/AleksandrMac/fileserver/internal/webdav"
+)
+
+// APIKey ключ API тестовых серверов
+const APIKey = "secret"
+
+// JWTSecret секрет токенов доступа тестовых серверов
+const JWTSecret = "jwt-secret"
+
+// Env хранилище Storage и служебные файлы в Data
+type Env struct {
+	Storage  string
+	Data     string
+	Repo     *repository.FileRepository
+	Files    *usecase.FileUsecase
+	Sessions *usecase.Sessions
+	Locks    *usecase.Locks
+	// Editor выдает и проверяет токены доступа, Document Server не настроен
+	Editor *editor_usecase.EditorUsecase
+}
+
+// New пустое хранилище с блокировками, историей версий и токенами доступа
+func New(t *testing.T) *Env {
+	t.Helper()
+	x := &Env{Storage: t.TempDir(), Data: t.TempDir()}
+
+	keys, err := repository.NewDocKeyRepository(x.path("keys.json"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lockRepo, err := repository.NewLockRepository(x.path("locks.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	history := repository.NewHistoryRepository(x.path("history"))
+	x.Repo = repository.NewFileRepository(x.Storage)
+	x.Files = usecase.NewFileUseCase(x.Repo, history)
+	x.Sessions = usecase.NewSessions()
+	x.Locks = usecase.NewLocks(lockRepo, x.Sessions, keys)
+	x.Editor = editor_usecase.NewEditorUsecase(x.Repo, history, keys, x.Sessions, nil, x.Locks, nil, JWTSecret, "", "", "", "")
+	return x
+}
+
+// Serve запускает REST под /files/, WebDAV под /dav и S3 API на отдельном
+// адресе, маршруты как в cmd/fileserver. wrap, если задан, оборачивает оба
+// сервера. Возвращает адреса REST и S3.
+func (x *Env) Serve(t *testing.T, wrap func(http.Handler) http.Handler) (string, string) {
+	t.Helper()
+	if wrap == nil {
+		wrap = func(h http.Handler) http.Handler { return h }
+	}
+	if err := os.MkdirAll(filepath.Join(x.Storage, "files"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	props, err := repository.NewDavPropRepository(x.path("davprops.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditRepo, err := repository.NewAuditRepository(x.path("audit"), 1<<20, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { auditRepo.Close() })
+	uploads, err := repository.NewUploadRepository(x.path("uploads"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := custhttp.NewHandler(x.Files, usecase.NewInfoService("v1", "abc", "", "", x.Repo), x.Editor, nil, x.Sessions,
+		usecase.NewPreviewUC(x.Repo, 1<<20), usecase.NewAuditUC(auditRepo, usecase.NewChanges(), false),
+		usecase.NewConvertJobs(nil, time.Hour, 1, 1), x.Locks, APIKey, "/files/")
+
+	for _, m := range webdav.Methods {
+		chi.RegisterMethod(m)
+	}
+	r := chi.NewRouter()
+	r.Use(wrap)
+	r.Get("/info", handler.Info)
+	r.Get("/manifest", handler.Auth(http.HandlerFunc(handler.Manifest)).ServeHTTP)
+	r.Get("/files/*", handler.ServeFile)
+	r.Post("/files/*", handler.Auth(http.HandlerFunc(handler.Upload)).ServeHTTP)
+	dav := handler.WebDAV(webdav.New("/dav", "/files/", x.Repo, x.Locks, props))
+	r.Handle("/dav", dav)
+	r.Handle("/dav/*", dav)
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+
+	s3Srv := httptest.NewServer(wrap(handler.S3(s3.New("fileserver", APIKey, x.Files, x.Locks, uploads))))
+	t.Cleanup(s3Srv.Close)
+	return srv.URL, s3Srv.URL
+}
+
+// path файл name в каталоге служебных данных
+func (x *Env) path(name string) string {
+	return filepath.Join(x.Data, name)
+}
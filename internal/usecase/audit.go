@@ -0,0 +1,51 @@
+package usecase
+
+import (
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+	"github.com/AleksandrMac/fileserver/internal/interfaces"
+)
+
+type AuditUC struct {
+	repo         interfaces.AuditRepo
+	changes      interfaces.ChangeFeed
+	logDownloads bool
+}
+
+// NewAuditUC журнал аудита. Изменения хранилища из журнала рассылаются в changes.
+func NewAuditUC(repo interfaces.AuditRepo, changes interfaces.ChangeFeed, logDownloads bool) *AuditUC {
+	return &AuditUC{
+		repo:         repo,
+		changes:      changes,
+		logDownloads: logDownloads,
+	}
+}
+
+// Record пишет запись в журнал. Ошибка записи не должна ломать запрос,
+// поэтому она только логируется.
+func (x *AuditUC) Record(rec *domain.AuditRecord) {
+	if rec.Time.IsZero() {
+		rec.Time = time.Now().UTC()
+	}
+	if rec.Action.Change() {
+		x.changes.Publish(rec)
+	}
+
+	if err := x.repo.Append(rec); err != nil {
+		log.Error().Err(err).
+			Str("action", string(rec.Action)).
+			Str("path", rec.Path).
+			Msg("failed write audit record")
+	}
+}
+
+func (x *AuditUC) Query(filter *domain.AuditFilter) ([]domain.AuditRecord, error) {
+	return x.repo.Query(filter)
+}
+
+func (x *AuditUC) LogDownloads() bool {
+	return x.logDownloads
+}
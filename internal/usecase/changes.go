@@ -0,0 +1,119 @@
+package usecase
+
+import (
+	"sync"
+	"time"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+)
+
+// размер очереди подписчика, при переполнении подписка закрывается
+const changesBuffer = 256
+
+// сколько последних событий хранится для продолжения подписки
+const changesHistory = 1024
+
+// Changes рассылка изменений хранилища подписчикам в памяти процесса
+type Changes struct {
+	mu      sync.Mutex
+	subs    map[*subscriber]struct{}
+	history []domain.ChangeEvent
+	// lastID номер последнего события. Начинается с текущего времени в
+	// микросекундах, чтобы номера до перезапуска не совпали с новыми.
+	lastID uint64
+}
+
+type subscriber struct {
+	prefix string
+	ch     chan domain.ChangeEvent
+}
+
+func NewChanges() *Changes {
+	return &Changes{
+		subs:   make(map[*subscriber]struct{}),
+		lastID: uint64(time.Now().UnixMicro()),
+	}
+}
+
+// Publish не блокируется: медленный подписчик отключается, чтобы не задерживать запись
+func (x *Changes) Publish(rec *domain.AuditRecord) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.lastID++
+	ev := domain.ChangeEvent{ID: x.lastID, AuditRecord: *rec}
+	if len(x.history) == 2*changesHistory {
+		x.history = append(x.history[:0:0], x.history[changesHistory:]...)
+	}
+	x.history = append(x.history, ev)
+
+	for s := range x.subs {
+		if !rec.Within(s.prefix) {
+			continue
+		}
+		select {
+		case s.ch <- ev:
+		default:
+			delete(x.subs, s)
+			close(s.ch)
+		}
+	}
+}
+
+func (x *Changes) Subscribe(prefix string) (<-chan domain.ChangeEvent, func()) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.subscribe(prefix, nil)
+}
+
+// Since подписка, которая сначала отдает события после after. ErrNotFound,
+// если часть из них уже вытеснена из истории или after от прошлого запуска.
+func (x *Changes) Since(prefix string, after uint64) (<-chan domain.ChangeEvent, func(), error) {
+	if prefix == "" {
+		prefix = "/"
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	history := x.history
+	if len(history) > changesHistory {
+		history = history[len(history)-changesHistory:]
+	}
+	first := x.lastID + 1
+	if len(history) > 0 {
+		first = history[0].ID
+	}
+	if after+1 < first || after > x.lastID {
+		return nil, nil, domain.ErrNotFound
+	}
+
+	var missed []domain.ChangeEvent
+	for _, ev := range history[int(after+1-first):] {
+		if ev.Within(prefix) {
+			missed = append(missed, ev)
+		}
+	}
+	ch, cancel := x.subscribe(prefix, missed)
+	return ch, cancel, nil
+}
+
+// subscribe вызывается под x.mu, missed сразу кладутся в очередь
+func (x *Changes) subscribe(prefix string, missed []domain.ChangeEvent) (<-chan domain.ChangeEvent, func()) {
+	if prefix == "" {
+		prefix = "/"
+	}
+	s := &subscriber{prefix: prefix, ch: make(chan domain.ChangeEvent, changesBuffer+len(missed))}
+	for _, ev := range missed {
+		s.ch <- ev
+	}
+	x.subs[s] = struct{}{}
+
+	return s.ch, func() {
+		x.mu.Lock()
+		defer x.mu.Unlock()
+		if _, ok := x.subs[s]; ok {
+			delete(x.subs, s)
+			close(s.ch)
+		}
+	}
+}
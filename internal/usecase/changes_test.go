@@ -0,0 +1,134 @@
+package usecase
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+)
+
+func TestChanges(t *testing.T) {
+	changes := NewChanges()
+	docs, cancelDocs := changes.Subscribe("/docs")
+	defer cancelDocs()
+	all, cancelAll := changes.Subscribe("")
+
+	records := []domain.AuditRecord{
+		{Action: domain.AuditUpload, Path: "/docs/a.txt"},
+		{Action: domain.AuditUpload, Path: "/docsx/b.txt"},
+		{Action: domain.AuditMove, Path: "/tmp/a.txt", From: "/docs/a.txt"},
+	}
+	for i := range records {
+		changes.Publish(&records[i])
+	}
+
+	tests := []struct {
+		name string
+		ch   <-chan domain.ChangeEvent
+		want []string
+	}{
+		{name: "prefix", ch: docs, want: []string{"/docs/a.txt", "/tmp/a.txt"}},
+		{name: "all", ch: all, want: []string{"/docs/a.txt", "/docsx/b.txt", "/tmp/a.txt"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for len(tt.ch) > 0 {
+			got = append(got, (<-tt.ch).Path)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+
+	cancelAll()
+	if _, ok := <-all; ok {
+		t.Error("channel is open after cancel")
+	}
+	cancelAll()
+
+	// переполненная подписка закрывается, остальные продолжают получать изменения
+	for i := 0; i <= changesBuffer; i++ {
+		changes.Publish(&domain.AuditRecord{Action: domain.AuditDelete, Path: "/docs/a.txt"})
+	}
+	n := 0
+	for range docs {
+		n++
+	}
+	if n != changesBuffer {
+		t.Errorf("received %d changes before overflow, want %d", n, changesBuffer)
+	}
+}
+
+func TestChangesSince(t *testing.T) {
+	changes := NewChanges()
+	first := changes.lastID
+
+	// до первого события продолжить можно только с текущего номера
+	if _, _, err := changes.Since("", first-1); !errors.Is(err, domain.ErrNotFound) {
+		t.Errorf("since an older run: %v", err)
+	}
+	for _, p := range []string{"/docs/a.txt", "/tmp/b.txt", "/docs/c.txt"} {
+		changes.Publish(&domain.AuditRecord{Action: domain.AuditUpload, Path: p})
+	}
+
+	tests := []struct {
+		name    string
+		prefix  string
+		after   uint64
+		want    []string
+		wantErr bool
+	}{
+		{name: "all", after: first, want: []string{"/docs/a.txt", "/tmp/b.txt", "/docs/c.txt"}},
+		{name: "prefix", prefix: "/docs", after: first + 1, want: []string{"/docs/c.txt"}},
+		{name: "up to date", after: first + 3},
+		{name: "future", after: first + 4, wantErr: true},
+		{name: "older run", after: first - 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch, cancel, err := changes.Since(tt.prefix, tt.after)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer cancel()
+			var got []string
+			for len(ch) > 0 {
+				ev := <-ch
+				if ev.ID <= tt.after {
+					t.Errorf("event %d is not after %d", ev.ID, tt.after)
+				}
+				got = append(got, ev.Path)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// вытесненные события продолжить нельзя
+	for i := 0; i < 2*changesHistory; i++ {
+		changes.Publish(&domain.AuditRecord{Action: domain.AuditDelete, Path: "/docs/a.txt"})
+	}
+	if _, _, err := changes.Since("", first); !errors.Is(err, domain.ErrNotFound) {
+		t.Errorf("since an evicted event: %v", err)
+	}
+	ch, cancel, err := changes.Since("", changes.lastID-changesHistory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+	if len(ch) != changesHistory {
+		t.Errorf("replayed %d events, want %d", len(ch), changesHistory)
+	}
+}
@@ -0,0 +1,126 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+	"github.com/AleksandrMac/fileserver/internal/interfaces"
+)
+
+// ConvertJobs выполняет конвертацию в фоне, результат сохраняется рядом
+// с исходным файлом. Задачи ждут в очереди из queue мест и выполняются
+// workers обработчиками. Завершенные задачи хранятся ttl.
+type ConvertJobs struct {
+	editor interfaces.EditorUsecase
+	ttl    time.Duration
+	queue  chan convertTask
+
+	mu   sync.Mutex
+	jobs map[string]*domain.ConvertJob
+}
+
+type convertTask struct {
+	job  *domain.ConvertJob
+	done func(domain.ConvertJob)
+}
+
+func NewConvertJobs(editor interfaces.EditorUsecase, ttl time.Duration, workers, queue int) *ConvertJobs {
+	if workers < 1 {
+		workers = 1
+	}
+	x := &ConvertJobs{
+		editor: editor,
+		ttl:    ttl,
+		queue:  make(chan convertTask, queue),
+		jobs:   make(map[string]*domain.ConvertJob),
+	}
+	for i := 0; i < workers; i++ {
+		go x.worker()
+	}
+	return x
+}
+
+// Start ставит задачу в очередь, domain.ErrConvertBusy если очередь заполнена
+func (x *ConvertJobs) Start(fileName, outputType string, done func(domain.ConvertJob)) (domain.ConvertJob, error) {
+	now := time.Now().UTC()
+	job := &domain.ConvertJob{
+		Id:         newJobId(),
+		Path:       fileName,
+		OutputType: outputType,
+		Status:     domain.ConvertPending,
+		Created:    now,
+		Updated:    now,
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.prune(now)
+	select {
+	case x.queue <- convertTask{job: job, done: done}:
+	default:
+		return domain.ConvertJob{}, domain.ErrConvertBusy
+	}
+	x.jobs[job.Id] = job
+
+	return *job, nil
+}
+
+func (x *ConvertJobs) Get(id string) (domain.ConvertJob, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	job, ok := x.jobs[id]
+	if !ok {
+		return domain.ConvertJob{}, false
+	}
+	return *job, true
+}
+
+func (x *ConvertJobs) worker() {
+	for task := range x.queue {
+		x.run(task.job, task.done)
+	}
+}
+
+func (x *ConvertJobs) run(job *domain.ConvertJob, done func(domain.ConvertJob)) {
+	result, size, err := x.editor.ConvertAndStore(context.Background(), job.Path, job.OutputType)
+
+	x.mu.Lock()
+	job.Updated = time.Now().UTC()
+	if err != nil {
+		job.Status = domain.ConvertFailed
+		job.Error = err.Error()
+		log.Warn().Err(err).Str("path", job.Path).Str("outputtype", job.OutputType).Msg("conversion failed")
+	} else {
+		job.Status = domain.ConvertDone
+		job.Result = result
+		job.Size = size
+	}
+	snapshot := *job
+	x.mu.Unlock()
+
+	if done != nil {
+		done(snapshot)
+	}
+}
+
+// prune удаляет завершенные задачи старше ttl
+func (x *ConvertJobs) prune(now time.Time) {
+	for id, job := range x.jobs {
+		if job.Status != domain.ConvertPending && now.Sub(job.Updated) > x.ttl {
+			delete(x.jobs, id)
+		}
+	}
+}
+
+func newJobId() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
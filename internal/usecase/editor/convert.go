@@ -0,0 +1,213 @@
+package editor_usecase
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+)
+
+// convertTargets форматы, в которые Document Server конвертирует документы каждого типа
+var convertTargets = map[string]map[string]bool{
+	"word":  {"docx": true, "odt": true, "rtf": true, "txt": true, "html": true, "epub": true, "pdf": true},
+	"cell":  {"xlsx": true, "ods": true, "csv": true, "pdf": true},
+	"slide": {"pptx": true, "odp": true, "pdf": true},
+}
+
+type convertResponse struct {
+	EndConvert bool   `json:"endConvert"`
+	FileUrl    string `json:"fileUrl"`
+	FileType   string `json:"fileType"`
+	Percent    int    `json:"percent"`
+	Error      int    `json:"error"`
+}
+
+// Convert конвертирует файл через ConvertService Document Server и возвращает
+// содержимое результата. Запрос подписывается секретом Document Server,
+// асинхронный результат опрашивается до готовности или convertTimeout.
+func (x *EditorUsecase) Convert(ctx context.Context, fileName, outputType string) (io.ReadCloser, error) {
+	fileUrl, err := x.convert(ctx, fileName, outputType)
+	if err != nil {
+		return nil, err
+	}
+
+	// размер результата ограничен так же, как у документов из callback
+	resp, err := x.downloader.Get(ctx, x.internalUrl(fileUrl))
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w: download result: %s", domain.ErrConvert, err)
+	}
+
+	return resp.Body, nil
+}
+
+// ConvertAndStore конвертирует файл и сохраняет результат рядом с исходным:
+// report.docx -> report.pdf, при занятом имени — report (1).pdf
+func (x *EditorUsecase) ConvertAndStore(ctx context.Context, fileName, outputType string) (string, int64, error) {
+	body, err := x.Convert(ctx, fileName, outputType)
+	if err != nil {
+		return "", 0, err
+	}
+	defer body.Close()
+
+	// результат нужно перечитывать при подборе имени, поэтому сначала во временный файл
+	tmp, err := os.CreateTemp("", "convert_")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	size, err := io.Copy(tmp, body)
+	if err != nil {
+		return "", 0, fmt.Errorf("%w: download result: %s", domain.ErrConvert, err)
+	}
+
+	dir := path.Dir("/" + strings.TrimPrefix(fileName, "/"))
+	fullDir, err := x.fileRepo.GetFullPath(dir)
+	if err != nil {
+		return "", 0, err
+	}
+	name := strings.TrimSuffix(path.Base(fileName), path.Ext(fileName))
+
+	result, err := x.createUnique(dir, fullDir, name, "."+outputType, tmp)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return result, size, nil
+}
+
+// convert отправляет задачу в ConvertService и возвращает ссылку на результат
+func (x *EditorUsecase) convert(ctx context.Context, fileName, outputType string) (string, error) {
+	filename := "/" + strings.TrimPrefix(fileName, "/")
+	outputType = strings.ToLower(outputType)
+
+	fullPath, err := x.fileRepo.GetFullPath(filename)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", domain.ErrInvalid, err)
+	}
+	info, err := x.fileRepo.FileInfo(fullPath)
+	if err != nil {
+		return "", err
+	}
+	if info == nil || info.IsDir() {
+		return "", domain.ErrNotFound
+	}
+
+	ext := strings.ToLower(filepath.Ext(filename))
+	fileType := strings.TrimPrefix(ext, ".")
+	if !domain.EditorSupported(filename) || !convertTargets[getDocType(ext)][outputType] || fileType == outputType {
+		return "", fmt.Errorf("%w: can't convert %s to %q", domain.ErrInvalid, fileType, outputType)
+	}
+
+	// ключ зависит от ревизии файла: Document Server кеширует результат по ключу
+	payload := map[string]any{
+		"async":      true,
+		"filetype":   fileType,
+		"outputtype": outputType,
+		"key":        revisionKey(filename+"\x00"+outputType, info.ModTime(), info.Size()),
+		"title":      strings.TrimSuffix(path.Base(filename), ext) + "." + outputType,
+		"url":        fmt.Sprintf("%s/%s", x.baseUrl, strings.TrimPrefix(filename, "/")),
+	}
+	payload["token"] = x.GenerateEditorToken(payload)
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, x.convertTimeout)
+	defer cancel()
+
+	for {
+		res, err := x.convertRequest(ctx, body)
+		if err != nil {
+			return "", err
+		}
+		if res.Error != 0 {
+			msg, ok := domain.ConvertErrors[res.Error]
+			if !ok {
+				msg = "unknown error"
+			}
+			return "", fmt.Errorf("%w: %s (%d)", domain.ErrConvert, msg, res.Error)
+		}
+		if res.EndConvert {
+			if res.FileUrl == "" {
+				return "", fmt.Errorf("%w: empty result url", domain.ErrConvert)
+			}
+			return res.FileUrl, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(x.convertPoll):
+		}
+	}
+}
+
+func (x *EditorUsecase) convertRequest(ctx context.Context, body []byte) (*convertResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, x.converterUrl(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := x.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w: %s", domain.ErrConvert, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: document server responded %s", domain.ErrConvert, resp.Status)
+	}
+
+	res := new(convertResponse)
+	if err := json.NewDecoder(resp.Body).Decode(res); err != nil {
+		return nil, fmt.Errorf("%w: %s", domain.ErrConvert, err)
+	}
+
+	return res, nil
+}
+
+// converterUrl адрес ConvertService, по внутреннему адресу Document Server если он задан
+func (x *EditorUsecase) converterUrl() string {
+	if x.docServerUrlInternal != "" {
+		return "http://" + x.docServerUrlInternal + "/converter"
+	}
+	return strings.TrimSuffix(x.docServerUrl, "/") + "/converter"
+}
+
+// internalUrl переводит ссылку Document Server на внутренний адрес
+func (x *EditorUsecase) internalUrl(raw string) string {
+	if x.docServerUrlInternal == "" {
+		return raw
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	u.Scheme = "http"
+	u.Host = x.docServerUrlInternal
+
+	return u.String()
+}
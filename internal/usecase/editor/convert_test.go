@@ -0,0 +1,197 @@
+package editor_usecase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+	"github.com/AleksandrMac/fileserver/internal/download"
+	"github.com/AleksandrMac/fileserver/internal/repository"
+)
+
+const convertSecret = "secret"
+
+// testDownloader скачивает результаты только с тестового Document Server
+func testDownloader(maxSize int64) *download.Client {
+	return download.New(download.Config{Timeout: 5 * time.Second, MaxSize: maxSize, AllowedHosts: []string{"127.0.0.1"}})
+}
+
+// fakeDocServer ConvertService: первый опрос задачи — в процессе, второй — готово.
+// Файл с именем broken.docx завершается ошибкой -3.
+func fakeDocServer(t *testing.T) *httptest.Server {
+	var mu sync.Mutex
+	polls := map[string]int{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/converter", func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode convert request: %v", err)
+			return
+		}
+
+		claims := jwt.MapClaims{}
+		_, err := jwt.ParseWithClaims(req["token"].(string), claims, func(*jwt.Token) (any, error) {
+			return []byte(convertSecret), nil
+		})
+		if err != nil || claims["key"] != req["key"] || claims["outputtype"] != req["outputtype"] {
+			json.NewEncoder(w).Encode(map[string]any{"error": -8})
+			return
+		}
+
+		if req["title"] == "broken.pdf" {
+			json.NewEncoder(w).Encode(map[string]any{"error": -3})
+			return
+		}
+
+		mu.Lock()
+		polls[req["key"].(string)]++
+		n := polls[req["key"].(string)]
+		mu.Unlock()
+
+		if n == 1 {
+			json.NewEncoder(w).Encode(map[string]any{"endConvert": false, "percent": 50})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"endConvert": true,
+			"percent":    100,
+			"fileType":   req["outputtype"],
+			"fileUrl":    "http://" + r.Host + "/result/" + req["outputtype"].(string),
+		})
+	})
+	mux.HandleFunc("/result/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("converted " + filepath.Base(r.URL.Path)))
+	})
+
+	return httptest.NewServer(mux)
+}
+
+func TestConvert(t *testing.T) {
+	ds := fakeDocServer(t)
+	defer ds.Close()
+
+	storage := t.TempDir()
+	for _, name := range []string{"report.docx", "broken.docx", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(storage, name), []byte("source"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	uc := &EditorUsecase{
+		jwtSecret:      convertSecret,
+		docServerUrl:   ds.URL,
+		baseUrl:        "http://fileserver",
+		fileRepo:       repository.NewFileRepository(storage),
+		client:         ds.Client(),
+		downloader:     testDownloader(1 << 20),
+		locks:          testLocks{},
+		convertPoll:    time.Millisecond,
+		convertTimeout: 5 * time.Second,
+	}
+
+	tests := []struct {
+		name       string
+		file       string
+		outputType string
+		want       string
+		wantErr    error
+	}{
+		{name: "pdf", file: "/report.docx", outputType: "pdf", want: "converted pdf"},
+		{name: "odt", file: "/report.docx", outputType: "odt", want: "converted odt"},
+		{name: "document server error", file: "/broken.docx", outputType: "pdf", wantErr: domain.ErrConvert},
+		// docx не конвертируется в таблицу
+		{name: "unsupported target", file: "/report.docx", outputType: "csv", wantErr: domain.ErrInvalid},
+		{name: "same format", file: "/report.docx", outputType: "docx", wantErr: domain.ErrInvalid},
+		{name: "not office file", file: "/notes.txt", outputType: "pdf", wantErr: domain.ErrInvalid},
+		{name: "missing", file: "/missing.docx", outputType: "pdf", wantErr: domain.ErrNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := uc.Convert(context.Background(), tt.file, tt.outputType)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Convert() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+			defer body.Close()
+
+			data, _ := io.ReadAll(body)
+			if string(data) != tt.want {
+				t.Errorf("Convert() = %q, want %q", data, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertAndStore(t *testing.T) {
+	ds := fakeDocServer(t)
+	defer ds.Close()
+
+	storage := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(storage, "docs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(storage, "docs", "report.docx"), []byte("source"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	uc := &EditorUsecase{
+		jwtSecret:      convertSecret,
+		docServerUrl:   ds.URL,
+		baseUrl:        "http://fileserver",
+		fileRepo:       repository.NewFileRepository(storage),
+		client:         ds.Client(),
+		downloader:     testDownloader(1 << 20),
+		locks:          testLocks{},
+		convertPoll:    time.Millisecond,
+		convertTimeout: 5 * time.Second,
+	}
+
+	// второй результат не перезаписывает первый
+	for _, want := range []string{"/docs/report.pdf", "/docs/report (1).pdf"} {
+		got, size, err := uc.ConvertAndStore(context.Background(), "/docs/report.docx", "pdf")
+		if err != nil {
+			t.Fatalf("ConvertAndStore() error = %v", err)
+		}
+		if got != want || size != int64(len("converted pdf")) {
+			t.Errorf("ConvertAndStore() = %q, %d, want %q, %d", got, size, want, len("converted pdf"))
+		}
+
+		data, _ := os.ReadFile(filepath.Join(storage, filepath.FromSlash(got)))
+		if string(data) != "converted pdf" {
+			t.Errorf("%s content = %q", got, data)
+		}
+	}
+
+	// результат больше лимита не сохраняется
+	uc.downloader = testDownloader(5)
+	if _, _, err := uc.ConvertAndStore(context.Background(), "/docs/report.docx", "odt"); !errors.Is(err, domain.ErrConvert) {
+		t.Errorf("oversized result: error = %v, want %v", err, domain.ErrConvert)
+	}
+	uc.downloader = testDownloader(1 << 20)
+
+	uc.locks = testLocks{locked: "/docs"}
+	if _, _, err := uc.ConvertAndStore(context.Background(), "/docs/report.docx", "odt"); !errors.Is(err, domain.ErrLocked) {
+		t.Errorf("locked dir: error = %v, want %v", err, domain.ErrLocked)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(storage, "docs")); len(entries) != 3 {
+		t.Errorf("docs has %d files, want 3", len(entries))
+	}
+}
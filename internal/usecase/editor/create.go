@@ -0,0 +1,119 @@
+package editor_usecase
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+	"github.com/AleksandrMac/fileserver/internal/templates"
+)
+
+type documentKind struct {
+	ext  string
+	name string
+}
+
+var documentKinds = map[string]documentKind{
+	"text":         {".docx", "Документ"},
+	"spreadsheet":  {".xlsx", "Таблица"},
+	"presentation": {".pptx", "Презентация"},
+}
+
+// CreateDocument создает пустой документ типа kind (text, spreadsheet, presentation)
+// в каталоге dir и возвращает путь нового файла и его размер.
+// Содержимое берется из <templatesPath>/<template><ext>, по умолчанию — blank<ext>
+// из каталога шаблонов или встроенный пустой документ.
+func (x *EditorUsecase) CreateDocument(dir, kind, template string) (string, int64, error) {
+	k, ok := documentKinds[kind]
+	if !ok {
+		return "", 0, fmt.Errorf("%w: unknown document type %q", domain.ErrInvalid, kind)
+	}
+
+	data, err := x.template(k.ext, template)
+	if err != nil {
+		return "", 0, err
+	}
+
+	dir = "/" + strings.Trim(dir, "/")
+	fullDir, err := x.fileRepo.GetFullPath(dir)
+	if err != nil {
+		return "", 0, fmt.Errorf("%w: %s", domain.ErrInvalid, err)
+	}
+	info, err := x.fileRepo.FileInfo(fullDir)
+	if err != nil {
+		return "", 0, err
+	}
+	if info == nil || !info.IsDir() {
+		return "", 0, domain.ErrNotFound
+	}
+
+	path, err := x.createUnique(dir, fullDir, k.name, k.ext, bytes.NewReader(data))
+	if err != nil {
+		return "", 0, err
+	}
+
+	return path, int64(len(data)), nil
+}
+
+// createUnique сохраняет data в каталог dir под именем name+ext, если имя занято —
+// с суффиксом " (n)". Возвращает путь нового файла.
+func (x *EditorUsecase) createUnique(dir, fullDir, name, ext string, data io.ReadSeeker) (string, error) {
+	for n := 0; n < 1000; n++ {
+		file := name + ext
+		if n > 0 {
+			file = fmt.Sprintf("%s (%d)%s", name, n, ext)
+		}
+
+		if _, err := x.locks.CheckWrite(path.Join(dir, file), ""); err != nil {
+			return "", err
+		}
+		if _, err := data.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+		err := x.fileRepo.CreateFile(filepath.Join(fullDir, file), data)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(dir, file), nil
+	}
+
+	return "", errors.New("failed to pick unique file name in " + dir)
+}
+
+func (x *EditorUsecase) template(ext, name string) ([]byte, error) {
+	if name != "" && (name != filepath.Base(name) || strings.HasPrefix(name, ".")) {
+		return nil, fmt.Errorf("%w: invalid template name %q", domain.ErrInvalid, name)
+	}
+
+	if x.templatesPath != "" {
+		file := name
+		if file == "" {
+			file = "blank"
+		}
+
+		data, err := os.ReadFile(filepath.Join(x.templatesPath, file+ext))
+		if err == nil {
+			return data, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	if name != "" {
+		return nil, fmt.Errorf("%w: template %q", domain.ErrNotFound, name)
+	}
+
+	return templates.Office.ReadFile("office/blank" + ext)
+}
@@ -0,0 +1,81 @@
+package editor_usecase
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+	"github.com/AleksandrMac/fileserver/internal/interfaces"
+	"github.com/AleksandrMac/fileserver/internal/repository"
+)
+
+// testLocks блокирует все внутри каталога locked
+type testLocks struct {
+	interfaces.LockUsecase
+	locked string
+}
+
+func (x testLocks) CheckWrite(filePath, _ string) (*domain.Lock, error) {
+	if x.locked != "" && strings.HasPrefix(filePath, x.locked+"/") {
+		return &domain.Lock{Path: x.locked}, domain.ErrLocked
+	}
+	return nil, nil
+}
+
+func TestCreateDocument(t *testing.T) {
+	storage := t.TempDir()
+	templatesPath := t.TempDir()
+	for _, dir := range []string{"docs", "locked"} {
+		if err := os.MkdirAll(filepath.Join(storage, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(templatesPath, "letter.docx"), []byte("letterhead"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	uc := &EditorUsecase{
+		fileRepo:      repository.NewFileRepository(storage),
+		templatesPath: templatesPath,
+		locks:         testLocks{locked: "/locked"},
+	}
+
+	tests := []struct {
+		name     string
+		dir      string
+		kind     string
+		template string
+		wantPath string
+		wantErr  error
+	}{
+		{name: "blank", dir: "/docs/", kind: "text", wantPath: "/docs/Документ.docx"},
+		{name: "unique name", dir: "/docs", kind: "text", wantPath: "/docs/Документ (1).docx"},
+		{name: "spreadsheet in root", dir: "/", kind: "spreadsheet", wantPath: "/Таблица.xlsx"},
+		{name: "custom template", dir: "/docs", kind: "text", template: "letter", wantPath: "/docs/Документ (2).docx"},
+		{name: "unknown type", dir: "/docs", kind: "video", wantErr: domain.ErrInvalid},
+		{name: "template traversal", dir: "/docs", kind: "text", template: "../letter", wantErr: domain.ErrInvalid},
+		{name: "missing template", dir: "/docs", kind: "text", template: "nope", wantErr: domain.ErrNotFound},
+		{name: "missing dir", dir: "/nope", kind: "text", wantErr: domain.ErrNotFound},
+		{name: "locked dir", dir: "/locked", kind: "text", wantErr: domain.ErrLocked},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := uc.CreateDocument(tt.dir, tt.kind, tt.template)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CreateDocument() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.wantPath {
+				t.Errorf("CreateDocument() = %q, want %q", got, tt.wantPath)
+			}
+		})
+	}
+
+	data, _ := os.ReadFile(filepath.Join(storage, "docs", "Документ (2).docx"))
+	if string(data) != "letterhead" {
+		t.Errorf("template content = %q, want %q", data, "letterhead")
+	}
+}
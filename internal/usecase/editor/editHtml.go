@@ -1,42 +1,56 @@
 package editor_usecase
 
 import (
-	"encoding/base64"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"html/template"
 	"io"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/AleksandrMac/fileserver/internal/domain"
 	"github.com/AleksandrMac/fileserver/internal/templates"
 	"github.com/rs/zerolog/log"
 )
 
-func (x *EditorUsecase) EditHtml(w io.Writer, username, userId, filename string) error {
+// EditHtml отдает страницу редактора. Режим и права редактора определяются
+// правами пользователя на файл, ViewOnly ограничивает их просмотром.
+func (x *EditorUsecase) EditHtml(w io.Writer, req *domain.EditorRequest) error {
+	filename := "/" + strings.TrimPrefix(req.File, "/")
 
-	ext := filepath.Ext(filename)
-	docType := getDocType(ext)
+	perms := x.permissions.Resolve(filename, req.UserId)
+	// явно заблокированный файл открывается только на просмотр
+	lock := x.locks.Active(filename)
+	if (req.ViewOnly || lock != nil && lock.Kind == domain.LockExplicit) && perms.Access.CanSave() {
+		perms.Access = domain.AccessView
+	}
+	if perms.Access == domain.AccessNone {
+		return domain.ErrForbidden
+	}
 
-	// Ключ документа — используем кастомный префикс, чтобы знать имя файла
-	docKey := base64.URLEncoding.EncodeToString([]byte(filename))
+	docKey, err := x.docKey(filename)
+	if err != nil {
+		return err
+	}
+	if err := x.keys.Grant(docKey, req.UserId, perms.Access); err != nil {
+		return err
+	}
 
-	downloadURL := fmt.Sprintf("%s/%s", x.baseUrl, strings.TrimPrefix(filename, "/"))
-	callbackURL := fmt.Sprintf("%s/track", x.baseUrl)
+	config := x.editorConfig(req, filename, docKey, perms)
+	config["token"] = x.GenerateEditorToken(config)
 
 	data := map[string]any{
-		"EditorToken": x.GenerateEditorToken(downloadURL, callbackURL, string(docKey)),
-		"DocServer":   x.docServerUrl,
-		"FileName":    filename,
-		"FileType":    strings.TrimPrefix(ext, "."),
-		"DocType":     docType,
-		"DocKey":      docKey,
-		"DownloadUrl": downloadURL,
-		"CallbackUrl": callbackURL,
-		"UserName":    username,
-		"UserId":      userId,
-	}
-
-	err := template.Must(
+		"DocServer":  x.docServerUrl,
+		"FileName":   filename,
+		"Config":     config,
+		"CanRestore": perms.Access == domain.AccessEdit,
+	}
+
+	err = template.Must(
 		template.New("editor.html").
 			ParseFS(templates.HTML, "html/editor.html")).
 		Execute(w, data)
@@ -45,3 +59,88 @@ func (x *EditorUsecase) EditHtml(w io.Writer, username, userId, filename string)
 	}
 	return nil
 }
+
+// editorConfig конфигурация DocsAPI.DocEditor
+func (x *EditorUsecase) editorConfig(req *domain.EditorRequest, filename, docKey string, perms domain.Permissions) map[string]any {
+	ext := filepath.Ext(filename)
+
+	downloadURL := fmt.Sprintf("%s/%s", x.baseUrl, strings.TrimPrefix(filename, "/"))
+	callbackURL := fmt.Sprintf("%s/track", x.baseUrl)
+
+	mode := "edit"
+	if perms.Access == domain.AccessView {
+		mode = "view"
+	}
+
+	lang := req.Lang
+	if lang == "" {
+		lang = "ru"
+	}
+
+	return map[string]any{
+		"document": map[string]any{
+			"title":    path.Base(filename),
+			"url":      downloadURL,
+			"fileType": strings.TrimPrefix(strings.ToLower(ext), "."),
+			"key":      docKey,
+			"permissions": map[string]any{
+				"edit":         perms.Access == domain.AccessEdit,
+				"comment":      perms.Access == domain.AccessComment || perms.Access == domain.AccessEdit,
+				"review":       perms.Access == domain.AccessReview || perms.Access == domain.AccessEdit,
+				"fillForms":    perms.Access == domain.AccessFillForms || perms.Access == domain.AccessEdit,
+				"download":     perms.Download,
+				"print":        perms.Print,
+				"copy":         perms.Copy,
+				"modifyFilter": false,
+			},
+		},
+		"documentType": getDocType(ext),
+		"editorConfig": map[string]any{
+			"lang":        lang,
+			"mode":        mode,
+			"callbackUrl": callbackURL,
+			"user": map[string]any{
+				"id":   req.UserId,
+				"name": req.UserName,
+			},
+		},
+	}
+}
+
+// docKey возвращает ключ документа для редактора. Пока по файлу идет сессия
+// редактирования, используется ее ключ, чтобы пользователи попали в одну сессию.
+// Иначе ключ строится из пути и ревизии (mtime, size), поэтому после замены файла
+// Document Server не отдаст закешированную старую копию.
+func (x *EditorUsecase) docKey(filename string) (string, error) {
+	path := "/" + strings.TrimPrefix(filename, "/")
+
+	if sessions := x.sessions.List(path); len(sessions) > 0 {
+		return sessions[0].Key, nil
+	}
+
+	fullPath, err := x.fileRepo.GetFullPath(path)
+	if err != nil {
+		return "", err
+	}
+	info, err := x.fileRepo.FileInfo(fullPath)
+	if err != nil {
+		return "", err
+	}
+	if info == nil || info.IsDir() {
+		return "", domain.ErrNotFound
+	}
+
+	key := revisionKey(path, info.ModTime(), info.Size())
+	if err := x.keys.Put(key, path); err != nil {
+		return "", err
+	}
+
+	return key, nil
+}
+
+// revisionKey 64 hex-символа: укладывается в лимит 128 символов
+// и допустимый алфавит ключа Document Server
+func revisionKey(path string, modTime time.Time, size int64) string {
+	sum := sha256.Sum256([]byte(path + "\x00" + strconv.FormatInt(modTime.UnixNano(), 10) + "\x00" + strconv.FormatInt(size, 10)))
+	return hex.EncodeToString(sum[:])
+}
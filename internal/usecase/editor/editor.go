@@ -1,42 +1,75 @@
 package editor_usecase
 
 import (
+	"net/http"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+	"github.com/AleksandrMac/fileserver/internal/interfaces"
 )
 
 type EditorUsecase struct {
-	jwtSecret    string
-	docServerUrl string
+	jwtSecret            string
+	docServerUrl         string
 	docServerUrlInternal string
-	baseUrl      string
+	baseUrl              string
+	templatesPath        string
+	fileRepo             interfaces.FileRepo
+	history              interfaces.HistoryRepo
+	keys                 interfaces.DocKeyRepo
+	sessions             interfaces.SessionRegistry
+	permissions          interfaces.PermissionRepo
+	locks                interfaces.LockUsecase
+	// downloader скачивает результаты конвертации с ограничением размера
+	downloader interfaces.Downloader
+
+	// конвертация через Document Server
+	client         *http.Client
+	convertPoll    time.Duration
+	convertTimeout time.Duration
 }
 
-func NewEditorUsecase(jwtSecret, docServerUrl, docServerUrlInternal, baseUrl string) *EditorUsecase {
+func NewEditorUsecase(
+	fileRepo interfaces.FileRepo,
+	history interfaces.HistoryRepo,
+	keys interfaces.DocKeyRepo,
+	sessions interfaces.SessionRegistry,
+	permissions interfaces.PermissionRepo,
+	locks interfaces.LockUsecase,
+	downloader interfaces.Downloader,
+	jwtSecret, docServerUrl, docServerUrlInternal, baseUrl, templatesPath string,
+) *EditorUsecase {
 	return &EditorUsecase{
-		jwtSecret:    jwtSecret,
-		docServerUrl: docServerUrl,
-		baseUrl:      baseUrl,
+		jwtSecret:            jwtSecret,
+		docServerUrl:         docServerUrl,
+		docServerUrlInternal: docServerUrlInternal,
+		baseUrl:              baseUrl,
+		templatesPath:        templatesPath,
+		fileRepo:             fileRepo,
+		history:              history,
+		keys:                 keys,
+		sessions:             sessions,
+		permissions:          permissions,
+		locks:                locks,
+		downloader:           downloader,
+		client:               &http.Client{Timeout: time.Minute},
+		convertPoll:          time.Second,
+		convertTimeout:       5 * time.Minute,
 	}
 }
 
-func (x *EditorUsecase) GenerateEditorToken(docURL, callbackURL, docKey string) string {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"document": map[string]any{
-			"url": docURL,
-			"key": docKey,
-			"permissions": map[string]any{
-				"modifyFilter": false,
-			},
-		},
-		"editorConfig": map[string]any{
-			"lang":        "ru",
-			"mode":        "edit",
-			"callbackUrl": callbackURL,
-		},
+// GenerateEditorToken подписывает конфигурацию редактора, Document Server
+// применяет подписанные значения поверх переданных страницей
+func (x *EditorUsecase) GenerateEditorToken(config map[string]any) string {
+	claims := jwt.MapClaims{
 		"exp": time.Now().Add(1 * time.Hour).Unix(),
-	})
+	}
+	for k, v := range config {
+		claims[k] = v
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
 	signed, err := token.SignedString([]byte(x.jwtSecret))
 	if err != nil {
@@ -45,13 +78,35 @@ func (x *EditorUsecase) GenerateEditorToken(docURL, callbackURL, docKey string)
 	return signed
 }
 
-func (x *EditorUsecase) VerifyEditorToken(tokenStr string) bool {
-	// Валидация токена (без проверки payload — он может быть пустым)
-	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (any, error) {
+// accessScope отличает токены доступа от токенов редактора и Document Server,
+// подписанных тем же секретом
+const accessScope = "access"
+
+// GenerateAccessToken подписывает токен доступа к API от имени userId
+func (x *EditorUsecase) GenerateAccessToken(userId string, ttl time.Duration) (string, error) {
+	if userId == "" || ttl <= 0 {
+		return "", domain.ErrInvalid
+	}
+	claims := jwt.MapClaims{
+		"sub":   userId,
+		"scope": accessScope,
+		"exp":   time.Now().Add(ttl).Unix(),
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(x.jwtSecret))
+}
+
+// VerifyAccessToken пользователь из токена доступа. Прочие JWT с тем же
+// секретом (конфигурация редактора, ссылки на версии) доступа не дают.
+func (x *EditorUsecase) VerifyAccessToken(tokenStr string) (string, bool) {
+	var claims struct {
+		Scope string `json:"scope"`
+		jwt.RegisteredClaims
+	}
+	_, err := jwt.ParseWithClaims(tokenStr, &claims, func(*jwt.Token) (any, error) {
 		return []byte(x.jwtSecret), nil
-	})
-	if err != nil || !token.Valid {
-		return false
+	}, jwt.WithValidMethods([]string{"HS256"}), jwt.WithExpirationRequired())
+	if err != nil || claims.Scope != accessScope || claims.Subject == "" {
+		return "", false
 	}
-	return true
+	return claims.Subject, true
 }
@@ -0,0 +1,58 @@
+package editor_usecase
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRevisionKey(t *testing.T) {
+	mtime := time.Date(2024, 12, 1, 10, 0, 0, 0, time.UTC)
+	base := revisionKey("/docs/report.docx", mtime, 100)
+
+	if len(base) > 128 {
+		t.Fatalf("key length = %d, want <= 128", len(base))
+	}
+	if base != revisionKey("/docs/report.docx", mtime, 100) {
+		t.Error("key is not stable for the same revision")
+	}
+
+	changed := []string{
+		revisionKey("/docs/other.docx", mtime, 100),
+		revisionKey("/docs/report.docx", mtime.Add(time.Nanosecond), 100),
+		revisionKey("/docs/report.docx", mtime, 101),
+	}
+	for i, k := range changed {
+		if k == base {
+			t.Errorf("case %d: key did not change", i)
+		}
+	}
+}
+
+func TestAccessToken(t *testing.T) {
+	uc := &EditorUsecase{jwtSecret: "secret"}
+
+	token, err := uc.GenerateAccessToken("ivanov", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user, ok := uc.VerifyAccessToken(token); !ok || user != "ivanov" {
+		t.Errorf("VerifyAccessToken = %q, %v", user, ok)
+	}
+
+	expired, _ := uc.GenerateAccessToken("ivanov", time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	other, _ := (&EditorUsecase{jwtSecret: "other"}).GenerateAccessToken("ivanov", time.Hour)
+	editor := uc.GenerateEditorToken(map[string]any{
+		"document":     map[string]any{"key": "k"},
+		"editorConfig": map[string]any{"user": map[string]any{"id": "1"}},
+	})
+	for name, token := range map[string]string{"expired": expired, "other secret": other, "editor config": editor} {
+		if _, ok := uc.VerifyAccessToken(token); ok {
+			t.Errorf("%s token accepted", name)
+		}
+	}
+
+	if _, err := uc.GenerateAccessToken("", time.Hour); err == nil {
+		t.Error("token without user issued")
+	}
+}
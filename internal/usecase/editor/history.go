@@ -0,0 +1,208 @@
+package editor_usecase
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+)
+
+// формат даты в панели истории OnlyOffice
+const historyTimeFormat = "2006-01-02 15:04:05"
+
+// EditorSession ключ документа и пользователь из токена конфигурации редактора.
+// Страница редактора передает этот токен в запросах истории.
+func (x *EditorUsecase) EditorSession(token string) (key, userId string, err error) {
+	var claims struct {
+		Document struct {
+			Key string `json:"key"`
+		} `json:"document"`
+		EditorConfig struct {
+			User struct {
+				Id string `json:"id"`
+			} `json:"user"`
+		} `json:"editorConfig"`
+		jwt.RegisteredClaims
+	}
+
+	_, err = jwt.ParseWithClaims(token, &claims, func(*jwt.Token) (any, error) {
+		return []byte(x.jwtSecret), nil
+	}, jwt.WithValidMethods([]string{"HS256"}))
+	if err != nil || claims.Document.Key == "" {
+		return "", "", domain.ErrForbidden
+	}
+
+	return claims.Document.Key, claims.EditorConfig.User.Id, nil
+}
+
+// History версии документа key для панели истории. Версия n — содержимое файла
+// до n-го сохранения, последняя — текущий файл с ключом открытого документа.
+// Изменения версии n+1 — history из callback сохранения n.
+func (x *EditorUsecase) History(key string) (*domain.EditorHistory, error) {
+	filename, versions, err := x.versions(key)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &domain.EditorHistory{CurrentVersion: len(versions) + 1}
+	for i := 0; i <= len(versions); i++ {
+		v := domain.EditorHistoryVersion{Version: i + 1, Key: key}
+		if i < len(versions) {
+			v.Key = versionKey(filename, versions[i])
+		}
+
+		if i == 0 {
+			if len(versions) > 0 {
+				v.Created = versions[0].Created.Format(historyTimeFormat)
+			}
+		} else {
+			// версия создана сохранением i-1
+			save := versions[i-1]
+			v.Created = save.Created.Format(historyTimeFormat)
+			if len(save.Users) > 0 {
+				v.User = &domain.EditorUser{Id: save.Users[0]}
+			}
+
+			var h struct {
+				ServerVersion json.RawMessage `json:"serverVersion"`
+				Changes       json.RawMessage `json:"changes"`
+			}
+			if len(save.History) > 0 && json.Unmarshal(save.History, &h) == nil {
+				v.Changes = h.Changes
+				v.ServerVersion = h.ServerVersion
+			}
+			if u := lastChangeUser(h.Changes); u != nil {
+				v.User = u
+			}
+		}
+
+		result.History = append(result.History, v)
+	}
+
+	return result, nil
+}
+
+// HistoryData данные версии для setHistoryData: ссылка на содержимое, а для
+// версий после первой — на предыдущую версию и архив изменений. Ссылки на
+// файлы истории подписаны и действуют час.
+func (x *EditorUsecase) HistoryData(key string, version int) (map[string]any, error) {
+	filename, versions, err := x.versions(key)
+	if err != nil {
+		return nil, err
+	}
+	if version < 1 || version > len(versions)+1 {
+		return nil, fmt.Errorf("%w: unknown version %d", domain.ErrNotFound, version)
+	}
+
+	fileType := strings.TrimPrefix(strings.ToLower(filepath.Ext(filename)), ".")
+	data := map[string]any{
+		"version":  version,
+		"fileType": fileType,
+	}
+
+	if version == len(versions)+1 {
+		data["key"] = key
+		data["url"] = fmt.Sprintf("%s/%s", x.baseUrl, strings.TrimPrefix(filename, "/"))
+	} else {
+		data["key"] = versionKey(filename, versions[version-1])
+		data["url"] = x.versionUrl(filename, version, false)
+	}
+
+	if version > 1 {
+		prev := versions[version-2]
+		data["previous"] = map[string]any{
+			"key":      versionKey(filename, prev),
+			"url":      x.versionUrl(filename, prev.Version, false),
+			"fileType": fileType,
+		}
+		if prev.HasDiff {
+			data["changesUrl"] = x.versionUrl(filename, prev.Version, true)
+		}
+	}
+
+	data["token"] = x.GenerateEditorToken(data)
+
+	return data, nil
+}
+
+// VersionFile содержимое версии или архив изменений по подписанной ссылке
+func (x *EditorUsecase) VersionFile(token string) (io.ReadCloser, error) {
+	var claims struct {
+		Path    string `json:"path"`
+		Version int    `json:"version"`
+		Diff    bool   `json:"diff"`
+		jwt.RegisteredClaims
+	}
+
+	_, err := jwt.ParseWithClaims(token, &claims, func(*jwt.Token) (any, error) {
+		return []byte(x.jwtSecret), nil
+	}, jwt.WithValidMethods([]string{"HS256"}), jwt.WithExpirationRequired())
+	if err != nil || claims.Path == "" {
+		return nil, domain.ErrForbidden
+	}
+
+	open := x.history.OpenVersion
+	if claims.Diff {
+		open = x.history.OpenDiff
+	}
+
+	file, err := open(claims.Path, claims.Version)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, domain.ErrNotFound
+	}
+
+	return file, err
+}
+
+func (x *EditorUsecase) versions(key string) (string, []domain.FileVersion, error) {
+	filename, err := x.keys.Get(key)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %s", domain.ErrNotFound, err)
+	}
+
+	versions, err := x.history.List(filename)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return filename, versions, nil
+}
+
+func (x *EditorUsecase) versionUrl(filename string, version int, diff bool) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"path":    filename,
+		"version": version,
+		"diff":    diff,
+		"exp":     time.Now().Add(time.Hour).Unix(),
+	})
+	signed, _ := token.SignedString([]byte(x.jwtSecret))
+
+	return fmt.Sprintf("%s/history/file?token=%s", x.baseUrl, signed)
+}
+
+// versionKey ключ документа для сохраненной версии, постоянный для версии
+func versionKey(filename string, v domain.FileVersion) string {
+	return revisionKey(filename+"\x00"+strconv.Itoa(v.Version), v.Created, v.Size)
+}
+
+// lastChangeUser автор последнего изменения из history.changes
+func lastChangeUser(changes json.RawMessage) *domain.EditorUser {
+	var list []struct {
+		User domain.EditorUser `json:"user"`
+	}
+	if json.Unmarshal(changes, &list) != nil || len(list) == 0 || list[len(list)-1].User.Id == "" {
+		return nil
+	}
+
+	u := list[len(list)-1].User
+	return &u
+}
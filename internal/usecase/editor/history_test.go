@@ -0,0 +1,149 @@
+package editor_usecase
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+	"github.com/AleksandrMac/fileserver/internal/repository"
+)
+
+func TestHistory(t *testing.T) {
+	historyRepo := repository.NewHistoryRepository(t.TempDir())
+	keys, err := repository.NewDocKeyRepository(filepath.Join(t.TempDir(), "keys.json"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.Put("cur", "/doc.docx"); err != nil {
+		t.Fatal(err)
+	}
+
+	// два сохранения: первое с архивом изменений и history, второе без
+	saves := []struct {
+		content string
+		diff    io.Reader
+		meta    domain.FileVersion
+	}{
+		{"v1", strings.NewReader("diff1"), domain.FileVersion{
+			Created: time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC),
+			Users:   []string{"1"},
+			History: json.RawMessage(`{"serverVersion":"8.0","changes":[{"created":"2024-01-01 10:00:00","user":{"id":"1","name":"Анна"}}]}`),
+		}},
+		{"v2", nil, domain.FileVersion{
+			Created: time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC),
+			Users:   []string{"2"},
+		}},
+	}
+	for _, s := range saves {
+		if err := historyRepo.AddVersion("/doc.docx", strings.NewReader(s.content), s.diff, &s.meta); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	uc := &EditorUsecase{
+		jwtSecret: "secret",
+		baseUrl:   "http://fileserver",
+		history:   historyRepo,
+		keys:      keys,
+	}
+
+	history, err := uc.History("cur")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history.CurrentVersion != 3 || len(history.History) != 3 {
+		t.Fatalf("History() = %+v, want 3 versions", history)
+	}
+	if history.History[2].Key != "cur" || history.History[0].Key == history.History[1].Key {
+		t.Errorf("version keys = %q, %q, %q", history.History[0].Key, history.History[1].Key, history.History[2].Key)
+	}
+	// автор версии 2 — из history первого сохранения
+	if u := history.History[1].User; u == nil || u.Name != "Анна" || string(history.History[1].ServerVersion) != `"8.0"` {
+		t.Errorf("version 2 = %+v", history.History[1])
+	}
+	if u := history.History[2].User; u == nil || u.Id != "2" {
+		t.Errorf("version 3 user = %+v", history.History[2].User)
+	}
+
+	tests := []struct {
+		version     int
+		wantUrl     string
+		wantPrev    bool
+		wantChanges bool
+		wantErr     error
+	}{
+		{version: 1, wantUrl: "/history/file?token="},
+		{version: 2, wantUrl: "/history/file?token=", wantPrev: true, wantChanges: true},
+		// текущая версия — файл из хранилища
+		{version: 3, wantUrl: "http://fileserver/doc.docx", wantPrev: true},
+		{version: 4, wantErr: domain.ErrNotFound},
+	}
+
+	for _, tt := range tests {
+		data, err := uc.HistoryData("cur", tt.version)
+		if !errors.Is(err, tt.wantErr) {
+			t.Fatalf("version %d: HistoryData() error = %v, want %v", tt.version, err, tt.wantErr)
+		}
+		if err != nil {
+			continue
+		}
+
+		if u, _ := data["url"].(string); !strings.Contains(u, tt.wantUrl) {
+			t.Errorf("version %d: url = %q, want %q", tt.version, u, tt.wantUrl)
+		}
+		if _, ok := data["previous"]; ok != tt.wantPrev {
+			t.Errorf("version %d: previous = %v, want %v", tt.version, ok, tt.wantPrev)
+		}
+		if _, ok := data["changesUrl"]; ok != tt.wantChanges {
+			t.Errorf("version %d: changesUrl = %v, want %v", tt.version, ok, tt.wantChanges)
+		}
+		if data["token"] == "" {
+			t.Errorf("version %d: data not signed", tt.version)
+		}
+	}
+
+	// подписанная ссылка отдает содержимое версии
+	data, _ := uc.HistoryData("cur", 2)
+	for field, want := range map[string]string{"url": "v2", "changesUrl": "diff1"} {
+		u, _ := url.Parse(data[field].(string))
+		file, err := uc.VersionFile(u.Query().Get("token"))
+		if err != nil {
+			t.Fatalf("%s: VersionFile() error = %v", field, err)
+		}
+		content, _ := io.ReadAll(file)
+		file.Close()
+		if string(content) != want {
+			t.Errorf("%s: content = %q, want %q", field, content, want)
+		}
+	}
+
+	if _, err := uc.VersionFile("forged"); !errors.Is(err, domain.ErrForbidden) {
+		t.Errorf("VersionFile(forged) error = %v, want ErrForbidden", err)
+	}
+}
+
+func TestEditorSession(t *testing.T) {
+	uc := &EditorUsecase{jwtSecret: "secret"}
+	token := uc.GenerateEditorToken(map[string]any{
+		"document":     map[string]any{"key": "abc"},
+		"editorConfig": map[string]any{"user": map[string]any{"id": "7"}},
+	})
+
+	key, userId, err := uc.EditorSession(token)
+	if err != nil || key != "abc" || userId != "7" {
+		t.Errorf("EditorSession() = %q, %q, %v, want abc, 7", key, userId, err)
+	}
+
+	other := (&EditorUsecase{jwtSecret: "other"}).GenerateEditorToken(map[string]any{
+		"document": map[string]any{"key": "abc"},
+	})
+	if _, _, err := uc.EditorSession(other); !errors.Is(err, domain.ErrForbidden) {
+		t.Errorf("EditorSession(foreign token) error = %v, want ErrForbidden", err)
+	}
+}
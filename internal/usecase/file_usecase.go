@@ -2,7 +2,13 @@ package usecase
 
 import (
 	"io"
+	"io/fs"
 	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/gabriel-vasile/mimetype"
 
 	"github.com/AleksandrMac/fileserver/internal/domain"
 	"github.com/AleksandrMac/fileserver/internal/interfaces"
@@ -10,11 +16,13 @@ import (
 
 type FileUsecase struct {
 	fileRepo interfaces.FileRepo
+	history  interfaces.HistoryRepo
 }
 
-func NewFileUseCase(fileRepo interfaces.FileRepo) *FileUsecase {
+func NewFileUseCase(fileRepo interfaces.FileRepo, history interfaces.HistoryRepo) *FileUsecase {
 	return &FileUsecase{
 		fileRepo: fileRepo,
+		history:  history,
 	}
 }
 
@@ -30,6 +38,18 @@ func (x *FileUsecase) SaveFile(path string, data io.Reader) error {
 	return x.fileRepo.SaveFile(path, data)
 }
 
+func (x *FileUsecase) Mkdir(path string) error {
+	return x.fileRepo.Mkdir(path)
+}
+
+func (x *FileUsecase) Remove(path string) error {
+	return x.fileRepo.Remove(path)
+}
+
+func (x *FileUsecase) Rename(oldPath, newPath string) error {
+	return x.fileRepo.Rename(oldPath, newPath)
+}
+
 func (x *FileUsecase) List(path string) ([]domain.FileInfo, error) {
 	return x.fileRepo.List(path)
 }
@@ -49,3 +69,116 @@ func (x *FileUsecase) ReadFile(path string) (io.ReadCloser, error) {
 func (x *FileUsecase) GetFileSize(path string) (int64, error) {
 	return x.fileRepo.GetFileSize(path)
 }
+
+func (x *FileUsecase) Meta(relPath string) (*domain.FileMeta, error) {
+	fullPath, err := x.fileRepo.GetFullPath(relPath)
+	if err != nil {
+		return nil, domain.ErrInvalid
+	}
+
+	info, err := x.fileRepo.FileInfo(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	if info == nil {
+		return nil, domain.ErrNotFound
+	}
+
+	relPath = "/" + strings.TrimPrefix(path.Clean("/"+relPath), "/")
+	meta := &domain.FileMeta{
+		FileInfo: domain.FileInfo{
+			Name:    info.Name(),
+			Path:    relPath,
+			ModTime: info.ModTime(),
+			IsDir:   info.IsDir(),
+		},
+		Mode: info.Mode().String(),
+	}
+
+	if info.IsDir() {
+		entries, err := os.ReadDir(fullPath)
+		if err != nil {
+			return nil, err
+		}
+		meta.Children = int64(len(entries))
+
+		usage, err := x.fileRepo.Usage(fullPath)
+		if err != nil {
+			return nil, err
+		}
+		meta.RecursiveSize = usage.Size
+		meta.Size = meta.RecursiveSize
+
+		return meta, nil
+	}
+
+	meta.Size = info.Size()
+	meta.EditorSupported = domain.EditorSupported(info.Name())
+
+	if mime, err := mimetype.DetectFile(fullPath); err == nil {
+		meta.MIME = mime.String()
+	}
+
+	if meta.SHA256, err = x.fileRepo.Hash(fullPath); err != nil {
+		return nil, err
+	}
+
+	versions, err := x.history.List(relPath)
+	if err != nil {
+		return nil, err
+	}
+	meta.Versions = len(versions)
+
+	return meta, nil
+}
+
+// Manifest обходит каталог relPath. Пути в манифесте относительные и идут
+// в порядке обхода, каталог перед своим содержимым.
+func (x *FileUsecase) Manifest(relPath string) ([]domain.ManifestEntry, error) {
+	root, err := x.fileRepo.GetFullPath(relPath)
+	if err != nil {
+		return nil, domain.ErrInvalid
+	}
+	info, err := x.fileRepo.FileInfo(root)
+	if err != nil {
+		return nil, err
+	}
+	if info == nil {
+		return nil, domain.ErrNotFound
+	}
+	if !info.IsDir() {
+		return nil, domain.ErrInvalid
+	}
+
+	entries := []domain.ManifestEntry{}
+	err = filepath.WalkDir(root, func(full string, d fs.DirEntry, err error) error {
+		if err != nil || full == root {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, full)
+		if err != nil {
+			return err
+		}
+
+		e := domain.ManifestEntry{Path: filepath.ToSlash(rel), ModTime: fi.ModTime(), IsDir: d.IsDir()}
+		if !d.IsDir() {
+			if !fi.Mode().IsRegular() {
+				return nil
+			}
+			e.Size = fi.Size()
+			if e.SHA256, err = x.fileRepo.Hash(full); err != nil {
+				return err
+			}
+		}
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,124 @@
+package usecase
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+	"github.com/AleksandrMac/fileserver/internal/repository"
+)
+
+func sha(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestFileUsecaseMeta(t *testing.T) {
+	storage := t.TempDir()
+	fileRepo := repository.NewFileRepository(storage)
+	historyRepo := repository.NewHistoryRepository(t.TempDir())
+	uc := NewFileUseCase(fileRepo, historyRepo)
+
+	fullPath := filepath.Join(storage, "docs", "report.docx")
+	if err := uc.SaveFile(fullPath, strings.NewReader("v1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := uc.SaveFile(filepath.Join(storage, "docs", "sub", "a.txt"), strings.NewReader("12345")); err != nil {
+		t.Fatal(err)
+	}
+	if err := historyRepo.AddVersion("/docs/report.docx", strings.NewReader("v0"), nil, &domain.FileVersion{}); err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := uc.Meta("docs/report.docx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Path != "/docs/report.docx" || meta.Size != 2 || meta.SHA256 != sha("v1") ||
+		meta.Versions != 1 || !meta.EditorSupported {
+		t.Errorf("Meta() = %+v", meta)
+	}
+
+	// запись через репозиторий обновляет хеш
+	if err := uc.SaveFile(fullPath, strings.NewReader("v2")); err != nil {
+		t.Fatal(err)
+	}
+	if meta, _ := uc.Meta("/docs/report.docx"); meta.SHA256 != sha("v2") {
+		t.Errorf("SHA256 after save = %s, want %s", meta.SHA256, sha("v2"))
+	}
+
+	// изменение файла в обход репозитория тоже
+	if err := os.WriteFile(fullPath, []byte("external"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(fullPath, time.Now().Add(time.Hour), time.Now().Add(time.Hour))
+	if meta, _ := uc.Meta("/docs/report.docx"); meta.SHA256 != sha("external") {
+		t.Errorf("SHA256 after external write = %s, want %s", meta.SHA256, sha("external"))
+	}
+
+	dir, err := uc.Meta("/docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !dir.IsDir || dir.Children != 2 || dir.RecursiveSize != int64(len("external")+5) || dir.SHA256 != "" {
+		t.Errorf("Meta(dir) = %+v", dir)
+	}
+
+	if _, err := uc.Meta("/missing"); err != domain.ErrNotFound {
+		t.Errorf("Meta(missing) error = %v, want %v", err, domain.ErrNotFound)
+	}
+
+	list, err := uc.List(filepath.Join(storage, "docs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range list {
+		if !f.IsDir && f.Size != int64(len("external")) {
+			t.Errorf("List() size of %s = %d", f.Name, f.Size)
+		}
+	}
+}
+
+func TestFileUsecaseManifest(t *testing.T) {
+	storage := t.TempDir()
+	uc := NewFileUseCase(repository.NewFileRepository(storage), repository.NewHistoryRepository(t.TempDir()))
+	for name, content := range map[string]string{"site/index.html": "index", "site/css/a.css": "css"} {
+		if err := uc.SaveFile(filepath.Join(storage, filepath.FromSlash(name)), strings.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(storage, "site", "empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := uc.Manifest("/site")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Path+":"+e.SHA256)
+	}
+	want := []string{"css:", "css/a.css:" + sha("css"), "empty:", "index.html:" + sha("index")}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Manifest() = %v, want %v", got, want)
+	}
+
+	tests := []struct {
+		path string
+		want error
+	}{
+		{"/site/index.html", domain.ErrInvalid},
+		{"/missing", domain.ErrNotFound},
+	}
+	for _, tt := range tests {
+		if _, err := uc.Manifest(tt.path); err != tt.want {
+			t.Errorf("Manifest(%s) error = %v, want %v", tt.path, err, tt.want)
+		}
+	}
+}
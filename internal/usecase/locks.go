@@ -0,0 +1,215 @@
+package usecase
+
+import (
+	"crypto/rand"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+	"github.com/AleksandrMac/fileserver/internal/interfaces"
+)
+
+const (
+	lockDefaultTimeout = time.Hour
+	lockMaxTimeout     = 24 * time.Hour
+)
+
+// Locks реестр рекомендательных блокировок. Файл заблокирован, пока по нему
+// идет сессия редактирования OnlyOffice или действует явная блокировка.
+type Locks struct {
+	repo     interfaces.LockRepo
+	sessions interfaces.SessionRegistry
+	keys     interfaces.DocKeyRepo
+
+	// mu делает проверку и запись блокировки одной операцией
+	mu sync.Mutex
+}
+
+func NewLocks(repo interfaces.LockRepo, sessions interfaces.SessionRegistry, keys interfaces.DocKeyRepo) *Locks {
+	return &Locks{
+		repo:     repo,
+		sessions: sessions,
+		keys:     keys,
+	}
+}
+
+func (x *Locks) Lock(filePath, owner, token string, deep bool, timeout time.Duration) (*domain.Lock, error) {
+	filePath = cleanLockPath(filePath)
+	if timeout <= 0 {
+		timeout = lockDefaultTimeout
+	}
+	timeout = min(timeout, lockMaxTimeout)
+
+	now := time.Now().UTC()
+	lock := domain.Lock{
+		Path:    filePath,
+		Kind:    domain.LockExplicit,
+		Token:   newLockToken(),
+		Owner:   owner,
+		Deep:    deep,
+		Created: now,
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if active := x.Active(filePath); active != nil {
+		if active.Kind != domain.LockExplicit || token == "" || token != active.Token {
+			return active, domain.ErrLocked
+		}
+		// продление
+		lock = *active
+	} else if deep {
+		if inner := x.inner(filePath); inner != nil {
+			return inner, domain.ErrLocked
+		}
+	}
+	lock.Expires = now.Add(timeout)
+
+	if err := x.repo.Put(lock); err != nil {
+		return nil, err
+	}
+	return &lock, nil
+}
+
+func (x *Locks) Unlock(filePath, token string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	lock, ok := x.repo.Get(cleanLockPath(filePath))
+	if !ok {
+		return domain.ErrNotFound
+	}
+	if token != lock.Token {
+		return domain.ErrForbidden
+	}
+
+	return x.repo.Delete(lock.Path)
+}
+
+// Break снимает явную блокировку. Сессия редактирования завершается вместе
+// с ключом документа: последующие сохранения из нее будут отклонены.
+func (x *Locks) Break(filePath string) (*domain.Lock, error) {
+	filePath = cleanLockPath(filePath)
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	active := x.Active(filePath)
+	if active == nil {
+		return nil, domain.ErrNotFound
+	}
+
+	// блокировка может быть унаследована от каталога
+	if err := x.repo.Delete(active.Path); err != nil {
+		return nil, err
+	}
+	for _, s := range x.sessions.List(filePath) {
+		x.sessions.End(s.Key)
+		if err := x.keys.Delete(s.Key); err != nil {
+			log.Warn().Err(err).Str("key", s.Key).Msg("failed delete document key")
+		}
+	}
+
+	return active, nil
+}
+
+func (x *Locks) Active(filePath string) *domain.Lock {
+	filePath = cleanLockPath(filePath)
+
+	if sessions := x.sessions.List(filePath); len(sessions) > 0 {
+		return sessionLock(sessions[0])
+	}
+	if lock, ok := x.repo.Get(filePath); ok {
+		return &lock
+	}
+	for dir := filePath; dir != "/"; {
+		dir = path.Dir(dir)
+		if lock, ok := x.repo.Get(dir); ok && lock.Deep {
+			return &lock
+		}
+	}
+	return nil
+}
+
+func (x *Locks) ByToken(token string) *domain.Lock {
+	locks, err := x.repo.List()
+	if err != nil || token == "" {
+		return nil
+	}
+	for _, lock := range locks {
+		if lock.Token == token {
+			return &lock
+		}
+	}
+	return nil
+}
+
+// inner блокировка чего-либо внутри каталога dir, мешающая заблокировать его целиком
+func (x *Locks) inner(dir string) *domain.Lock {
+	within := func(p string) bool {
+		return dir == "/" || strings.HasPrefix(p, dir+"/")
+	}
+
+	for _, s := range x.sessions.List("") {
+		if within(s.Path) {
+			return sessionLock(s)
+		}
+	}
+	locks, _ := x.repo.List()
+	for _, lock := range locks {
+		if within(lock.Path) {
+			return &lock
+		}
+	}
+	return nil
+}
+
+func (x *Locks) CheckWrite(filePath, token string) (*domain.Lock, error) {
+	lock := x.Active(filePath)
+	if lock == nil || (lock.Kind == domain.LockExplicit && token != "" && token == lock.Token) {
+		return nil, nil
+	}
+	return lock, domain.ErrLocked
+}
+
+// List блокировки сессий и явные блокировки
+func (x *Locks) List() ([]domain.Lock, error) {
+	locks, err := x.repo.List()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range x.sessions.List("") {
+		locks = append(locks, *sessionLock(s))
+	}
+
+	return locks, nil
+}
+
+func sessionLock(s domain.EditSession) *domain.Lock {
+	return &domain.Lock{
+		Path:    s.Path,
+		Kind:    domain.LockSession,
+		Owner:   "onlyoffice:" + strings.Join(s.Users, ","),
+		Created: s.Since,
+	}
+}
+
+func cleanLockPath(p string) string {
+	return path.Clean("/" + p)
+}
+
+// newLockToken токен в формате WebDAV (RFC 4918, opaquelocktoken + UUID)
+func newLockToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("opaquelocktoken:%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -0,0 +1,177 @@
+package usecase
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+	"github.com/AleksandrMac/fileserver/internal/repository"
+)
+
+func TestLocks(t *testing.T) {
+	keys, err := repository.NewDocKeyRepository(filepath.Join(t.TempDir(), "keys.json"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := NewSessions()
+	locks := newTestLocks(t, sessions, keys)
+
+	lock, err := locks.Lock("/docs/a.docx", "api-key", "", false, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{name: "no token", wantErr: domain.ErrLocked},
+		{name: "foreign token", token: "opaquelocktoken:other", wantErr: domain.ErrLocked},
+		{name: "owner token", token: lock.Token},
+	}
+	for _, tt := range tests {
+		if _, err := locks.CheckWrite("/docs/a.docx", tt.token); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: CheckWrite() error = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	// повторная блокировка чужим владельцем и продление владельцем
+	if _, err := locks.Lock("/docs/a.docx", "other", "", false, 0); !errors.Is(err, domain.ErrLocked) {
+		t.Errorf("Lock() by other error = %v, want ErrLocked", err)
+	}
+	refreshed, err := locks.Lock("docs/a.docx", "api-key", lock.Token, false, time.Hour)
+	if err != nil || refreshed.Token != lock.Token || !refreshed.Expires.After(lock.Expires) {
+		t.Errorf("refresh = %+v, %v", refreshed, err)
+	}
+
+	if err := locks.Unlock("/docs/a.docx", "opaquelocktoken:other"); !errors.Is(err, domain.ErrForbidden) {
+		t.Errorf("Unlock(foreign token) error = %v, want ErrForbidden", err)
+	}
+	if err := locks.Unlock("/docs/a.docx", lock.Token); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := locks.CheckWrite("/docs/a.docx", ""); err != nil {
+		t.Errorf("CheckWrite() after unlock error = %v", err)
+	}
+
+	// сессия редактирования блокирует запись и явные блокировки
+	keys.Put("k1", "/docs/b.docx")
+	sessions.Update("k1", "/docs/b.docx", []string{"1"})
+	if active, err := locks.CheckWrite("/docs/b.docx", ""); !errors.Is(err, domain.ErrLocked) || active.Kind != domain.LockSession {
+		t.Errorf("CheckWrite() during session = %+v, %v", active, err)
+	}
+	if _, err := locks.Lock("/docs/b.docx", "api-key", "", false, 0); !errors.Is(err, domain.ErrLocked) {
+		t.Errorf("Lock() during session error = %v, want ErrLocked", err)
+	}
+	if all, _ := locks.List(); len(all) != 1 || all[0].Kind != domain.LockSession {
+		t.Errorf("List() = %+v", all)
+	}
+
+	// снятие администратором завершает сессию и ключ документа
+	if _, err := locks.Break("/docs/b.docx"); err != nil {
+		t.Fatal(err)
+	}
+	if locks.Active("/docs/b.docx") != nil || len(sessions.List("/docs/b.docx")) != 0 {
+		t.Error("lock kept after Break()")
+	}
+	if _, err := keys.Get("k1"); err == nil {
+		t.Error("document key kept after Break()")
+	}
+
+	// блокировка каталога целиком
+	docs, err := locks.Lock("/docs", "other", "", true, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := locks.Lock("/projects", "api-key", "", true, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := locks.CheckWrite("/projects/x/a.txt", ""); !errors.Is(err, domain.ErrLocked) {
+		t.Errorf("CheckWrite() inside deep lock error = %v, want ErrLocked", err)
+	}
+	if _, err := locks.CheckWrite("/projects/x/a.txt", dir.Token); err != nil {
+		t.Errorf("CheckWrite() with deep lock token error = %v", err)
+	}
+	if _, err := locks.CheckWrite("/projects2/a.txt", ""); err != nil {
+		t.Errorf("CheckWrite() next to deep lock error = %v", err)
+	}
+	if got := locks.ByToken(dir.Token); got == nil || got.Path != "/projects" {
+		t.Errorf("ByToken() = %+v", got)
+	}
+	if err := locks.Unlock("/docs", docs.Token); err != nil {
+		t.Fatal(err)
+	}
+
+	// снятие через файл внутри каталога снимает блокировку каталога
+	broken, err := locks.Break("/projects/x/a.txt")
+	if err != nil || broken.Path != "/projects" {
+		t.Fatalf("Break() inside deep lock = %+v, %v", broken, err)
+	}
+	if _, err := locks.CheckWrite("/projects/x/a.txt", ""); err != nil {
+		t.Errorf("CheckWrite() after breaking deep lock error = %v", err)
+	}
+	if _, err := locks.Lock("/docs/d.docx", "api-key", "", false, 0); err != nil {
+		t.Fatal(err)
+	}
+	// нельзя заблокировать каталог с заблокированным файлом внутри
+	if _, err := locks.Lock("/", "other", "", true, 0); !errors.Is(err, domain.ErrLocked) {
+		t.Errorf("Lock() over inner lock error = %v, want ErrLocked", err)
+	}
+
+	// истекшая блокировка не действует
+	expired, _ := locks.Lock("/docs/c.docx", "api-key", "", false, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if locks.Active(expired.Path) != nil {
+		t.Error("expired lock is active")
+	}
+
+	// из одновременных блокировок одного файла проходит одна
+	var wg sync.WaitGroup
+	var granted atomic.Int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := locks.Lock("/race.docx", "api-key", "", false, 0); err == nil {
+				granted.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := granted.Load(); n != 1 {
+		t.Errorf("concurrent Lock() granted %d locks, want 1", n)
+	}
+}
+
+func TestTrackSaveExplicitLock(t *testing.T) {
+	storage := t.TempDir()
+	keys, err := repository.NewDocKeyRepository(filepath.Join(t.TempDir(), "keys.json"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := NewSessions()
+	locks := newTestLocks(t, sessions, keys)
+	uc := NewTrackUC(repository.NewFileRepository(storage), repository.NewHistoryRepository(t.TempDir()), keys, sessions, locks, nopAudit{}, testDownloader, newTestQueue(t), testSecret, "", "")
+
+	if err := os.WriteFile(filepath.Join(storage, "doc.docx"), []byte("v0"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	keys.Put("9c0d", "/doc.docx")
+	keys.Grant("9c0d", "1", domain.AccessEdit)
+	if _, err := locks.Lock("/doc.docx", "api-key", "", false, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	_, uerr := uc.Proceed(&domain.TrackRequest{Status: domain.TrackForceSave, Key: "9c0d", Users: []string{"1"}, Url: "http://unused"})
+	if uerr == nil || uerr.Status() != http.StatusLocked {
+		t.Errorf("Proceed() error = %v, want 423", uerr)
+	}
+}
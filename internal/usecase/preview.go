@@ -0,0 +1,195 @@
+package usecase
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"html/template"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/gabriel-vasile/mimetype"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+	"github.com/AleksandrMac/fileserver/internal/interfaces"
+)
+
+// максимум строк CSV в предпросмотре
+const previewMaxRows = 1000
+
+type PreviewUC struct {
+	fileRepo interfaces.FileRepo
+	maxBytes int64
+}
+
+// NewPreviewUC maxBytes — сколько байт текстового файла показывать
+func NewPreviewUC(fileRepo interfaces.FileRepo, maxBytes int64) *PreviewUC {
+	return &PreviewUC{
+		fileRepo: fileRepo,
+		maxBytes: maxBytes,
+	}
+}
+
+// Prepare определяет способ показа файла и готовит данные для него
+func (x *PreviewUC) Prepare(relPath string) (*domain.Preview, error) {
+	fullPath, err := x.fileRepo.GetFullPath(relPath)
+	if err != nil {
+		return nil, domain.ErrInvalid
+	}
+
+	info, err := x.fileRepo.FileInfo(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	if info == nil || info.IsDir() {
+		return nil, domain.ErrNotFound
+	}
+
+	p := &domain.Preview{
+		Kind:    domain.PreviewInfo,
+		Name:    info.Name(),
+		Path:    "/" + strings.TrimPrefix(path.Clean(relPath), "/"),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+
+	ext := strings.ToLower(filepath.Ext(info.Name()))
+	if domain.EditorSupported(info.Name()) {
+		p.Kind = domain.PreviewOffice
+		return p, nil
+	}
+
+	file, err := x.fileRepo.ReadFile(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	head, err := io.ReadAll(io.LimitReader(file, x.maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	p.Truncated = int64(len(head)) > x.maxBytes
+	if p.Truncated {
+		head = head[:x.maxBytes]
+	}
+
+	mime := mimetype.Detect(head)
+	p.MIME = mime.String()
+
+	switch {
+	case mime.Is("application/zip") || ext == ".zip":
+		p.Kind = domain.PreviewArchive
+		p.Truncated = false
+		p.Entries, err = x.fileRepo.ListZipContents(fullPath)
+		if err != nil {
+			p.Kind = domain.PreviewInfo
+		}
+
+	case mime.Is("application/pdf"):
+		p.Kind = domain.PreviewPDF
+		p.Truncated = false
+
+	case strings.HasPrefix(mime.String(), "image/"):
+		p.Kind = domain.PreviewImage
+		p.Truncated = false
+
+	case isText(mime, head):
+		x.prepareText(p, ext, head)
+
+	default:
+		p.Truncated = false
+	}
+
+	return p, nil
+}
+
+func (x *PreviewUC) prepareText(p *domain.Preview, ext string, data []byte) {
+	if p.Truncated {
+		// не обрываем многобайтовый символ
+		for len(data) > 0 && !utf8.Valid(data) {
+			data = data[:len(data)-1]
+		}
+	}
+
+	if ext == ".csv" {
+		r := csv.NewReader(bytes.NewReader(data))
+		r.FieldsPerRecord = -1
+		r.LazyQuotes = true
+		for len(p.Rows) < previewMaxRows {
+			row, err := r.Read()
+			if err != nil {
+				// обрезанная последняя строка не ошибка предпросмотра
+				break
+			}
+			p.Rows = append(p.Rows, row)
+		}
+		if len(p.Rows) > 0 {
+			p.Kind = domain.PreviewCSV
+			p.Truncated = p.Truncated || len(p.Rows) == previewMaxRows
+			return
+		}
+	}
+
+	if ext == ".json" && !p.Truncated {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, data, "", "  "); err == nil {
+			data = buf.Bytes()
+		}
+	}
+
+	highlighted, err := highlight(p.Name, string(data))
+	if err != nil {
+		highlighted = "<pre>" + template.HTMLEscapeString(string(data)) + "</pre>"
+	}
+
+	p.Kind = domain.PreviewText
+	p.Highlighted = template.HTML(highlighted)
+}
+
+func isText(mime *mimetype.MIME, data []byte) bool {
+	for m := mime; m != nil; m = m.Parent() {
+		if strings.HasPrefix(m.String(), "text/") || m.Is("application/json") {
+			return true
+		}
+	}
+
+	return utf8.Valid(data) && !bytes.ContainsRune(data, 0)
+}
+
+// highlight раскрашивает исходник по имени файла, возвращает HTML с inline-стилями
+func highlight(name, source string) (string, error) {
+	lexer := lexers.Match(name)
+	if lexer == nil {
+		lexer = lexers.Analyse(source)
+	}
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	lexer = chroma.Coalesce(lexer)
+
+	style := styles.Get("github")
+	if style == nil {
+		style = styles.Fallback
+	}
+
+	it, err := lexer.Tokenise(nil, source)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	formatter := chromahtml.New(chromahtml.WithLineNumbers(true), chromahtml.TabWidth(4))
+	if err := formatter.Format(&buf, style, it); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
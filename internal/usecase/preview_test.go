@@ -0,0 +1,78 @@
+package usecase
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+	"github.com/AleksandrMac/fileserver/internal/repository"
+)
+
+func TestPreviewPrepare(t *testing.T) {
+	storage := t.TempDir()
+	write := func(name string, data []byte) {
+		if err := os.WriteFile(filepath.Join(storage, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("report.docx", []byte("PK"))
+	write("photo.png", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"))
+	write("doc.pdf", []byte("%PDF-1.4\n"))
+	write("main.go", []byte("package main\n\nfunc main() {}\n"))
+	write("data.csv", []byte("a,b\n1,2\n"))
+	write("conf.json", []byte(`{"a":1}`))
+	write("big.txt", []byte(strings.Repeat("строка\n", 100)))
+	write("blob.bin", []byte{0, 1, 2, 3, 0xff})
+
+	f, _ := os.Create(filepath.Join(storage, "arch.zip"))
+	zw := zip.NewWriter(f)
+	zw.Create("inner.txt")
+	zw.Close()
+	f.Close()
+
+	uc := NewPreviewUC(repository.NewFileRepository(storage), 64)
+
+	tests := []struct {
+		path          string
+		wantKind      domain.PreviewKind
+		wantTruncated bool
+	}{
+		{"/report.docx", domain.PreviewOffice, false},
+		{"/photo.png", domain.PreviewImage, false},
+		{"/doc.pdf", domain.PreviewPDF, false},
+		{"/main.go", domain.PreviewText, false},
+		{"/data.csv", domain.PreviewCSV, false},
+		{"/conf.json", domain.PreviewText, false},
+		{"/big.txt", domain.PreviewText, true},
+		{"/arch.zip", domain.PreviewArchive, false},
+		{"/blob.bin", domain.PreviewInfo, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			p, err := uc.Prepare(tt.path)
+			if err != nil {
+				t.Fatalf("Prepare() error = %v", err)
+			}
+			if p.Kind != tt.wantKind {
+				t.Errorf("Kind = %v, want %v", p.Kind, tt.wantKind)
+			}
+			if p.Truncated != tt.wantTruncated {
+				t.Errorf("Truncated = %v, want %v", p.Truncated, tt.wantTruncated)
+			}
+		})
+	}
+
+	p, _ := uc.Prepare("/arch.zip")
+	if len(p.Entries) != 1 || p.Entries[0].Name != "inner.txt" {
+		t.Errorf("Entries = %+v", p.Entries)
+	}
+
+	if _, err := uc.Prepare("/missing.txt"); err != domain.ErrNotFound {
+		t.Errorf("Prepare(missing) error = %v, want %v", err, domain.ErrNotFound)
+	}
+}
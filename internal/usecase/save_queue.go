@@ -0,0 +1,150 @@
+package usecase
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+	"github.com/AleksandrMac/fileserver/internal/interfaces"
+	"github.com/AleksandrMac/fileserver/internal/metrics"
+)
+
+// емкость очереди одного обработчика, при заполнении Enqueue ждет
+const saveQueueBuffer = 256
+
+// SaveQueue сохраняет документы из callback в фоне. Задачи пишутся на диск до
+// ответа Document Server и выполняются пулом обработчиков. Задачи одного файла
+// попадают к одному обработчику и выполняются строго по очереди. Неудавшаяся
+// задача повторяется retries раз с паузой от backoff, удваивающейся с каждым
+// повтором, и только потом уходит к неудавшимся. finish вызывается один раз,
+// когда задача выполнена или ушла к неудавшимся.
+type SaveQueue struct {
+	repo    interfaces.SaveQueueRepo
+	workers []chan *domain.SaveJob
+	handle  func(*domain.SaveJob) error
+	finish  func(*domain.SaveJob)
+	retries int
+	backoff time.Duration
+
+	quit    chan struct{}
+	running sync.WaitGroup
+	pending sync.WaitGroup
+}
+
+func NewSaveQueue(repo interfaces.SaveQueueRepo, workers, retries int, backoff time.Duration) *SaveQueue {
+	x := &SaveQueue{
+		repo:    repo,
+		workers: make([]chan *domain.SaveJob, max(workers, 1)),
+		retries: max(retries, 0),
+		backoff: backoff,
+		quit:    make(chan struct{}),
+	}
+	for i := range x.workers {
+		x.workers[i] = make(chan *domain.SaveJob, saveQueueBuffer)
+	}
+	return x
+}
+
+// Start запускает обработчики и возвращает в работу задачи, оставшиеся
+// с прошлого запуска
+func (x *SaveQueue) Start(handle func(*domain.SaveJob) error, finish func(*domain.SaveJob)) error {
+	x.handle, x.finish = handle, finish
+	for _, jobs := range x.workers {
+		x.running.Add(1)
+		go x.work(jobs)
+	}
+
+	pending, err := x.repo.Pending()
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		log.Info().Int("jobs", len(pending)).Msg("resuming saves from queue")
+	}
+	for i := range pending {
+		x.dispatch(&pending[i])
+	}
+
+	return nil
+}
+
+func (x *SaveQueue) Enqueue(job *domain.SaveJob) error {
+	job.Enqueued = time.Now().UTC()
+	if err := x.repo.Put(job); err != nil {
+		return err
+	}
+
+	x.dispatch(job)
+	return nil
+}
+
+// Wait ждет выполнения всех поставленных задач
+func (x *SaveQueue) Wait() {
+	x.pending.Wait()
+}
+
+// Stop останавливает обработчики после текущих задач. Невыполненные
+// задачи остаются на диске до следующего запуска.
+func (x *SaveQueue) Stop() {
+	close(x.quit)
+	x.running.Wait()
+}
+
+func (x *SaveQueue) dispatch(job *domain.SaveJob) {
+	h := fnv.New32a()
+	h.Write([]byte(job.Path))
+
+	x.pending.Add(1)
+	metrics.SaveQueueDepth.Inc()
+	x.workers[h.Sum32()%uint32(len(x.workers))] <- job
+}
+
+func (x *SaveQueue) work(jobs chan *domain.SaveJob) {
+	defer x.running.Done()
+
+	for {
+		select {
+		case <-x.quit:
+			return
+		case job := <-jobs:
+			x.process(job)
+		}
+	}
+}
+
+func (x *SaveQueue) process(job *domain.SaveJob) {
+	defer x.pending.Done()
+	defer metrics.SaveQueueDepth.Dec()
+
+	result := "ok"
+	err := x.handle(job)
+	for attempt, backoff := 1, x.backoff; err != nil && attempt <= x.retries; attempt, backoff = attempt+1, backoff*2 {
+		log.Warn().Err(err).Str("job", job.Id).Str("path", job.Path).Int("attempt", attempt).Dur("backoff", backoff).Msg("queued save failed, retrying")
+		select {
+		case <-x.quit:
+			// задача остается на диске до следующего запуска
+			return
+		case <-time.After(backoff):
+		}
+		err = x.handle(job)
+	}
+
+	if err != nil {
+		result = "error"
+		log.Error().Err(err).Str("job", job.Id).Str("path", job.Path).Str("key", job.Request.Key).Msg("queued save failed")
+
+		if err := x.repo.Fail(job.Id); err != nil {
+			log.Error().Err(err).Str("job", job.Id).Msg("failed move job to failed")
+		}
+	} else if err := x.repo.Done(job.Id); err != nil {
+		log.Error().Err(err).Str("job", job.Id).Msg("failed remove finished job")
+	}
+	if x.finish != nil {
+		x.finish(job)
+	}
+
+	metrics.SaveQueueLatency.WithLabelValues(result).Observe(time.Since(job.Enqueued).Seconds())
+}
@@ -0,0 +1,108 @@
+package usecase
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+	"github.com/AleksandrMac/fileserver/internal/repository"
+)
+
+func TestSaveQueueOrder(t *testing.T) {
+	repo, err := repository.NewQueueRepository(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue := NewSaveQueue(repo, 4, 0, 0)
+
+	var mu sync.Mutex
+	got := map[string][]string{}
+	err = queue.Start(func(job *domain.SaveJob) error {
+		// первые задачи медленнее, гонка поменяла бы порядок
+		if job.Request.Url == "0" {
+			time.Sleep(20 * time.Millisecond)
+		}
+		mu.Lock()
+		got[job.Path] = append(got[job.Path], job.Request.Url)
+		mu.Unlock()
+		return nil
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer queue.Stop()
+
+	for i := range 5 {
+		for _, path := range []string{"/a.docx", "/b.docx", "/c.docx"} {
+			job := &domain.SaveJob{Path: path, Request: domain.TrackRequest{Url: fmt.Sprint(i)}}
+			if err := queue.Enqueue(job); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	queue.Wait()
+
+	for path, urls := range got {
+		if fmt.Sprint(urls) != "[0 1 2 3 4]" {
+			t.Errorf("%s: order = %v", path, urls)
+		}
+	}
+	if pending, _ := repo.Pending(); len(pending) != 0 {
+		t.Errorf("pending = %d, want 0", len(pending))
+	}
+}
+
+func TestSaveQueueRecovery(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := repository.NewQueueRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// задачи, принятые до перезапуска
+	for _, url := range []string{"ok", "flaky", "fail"} {
+		if err := repo.Put(&domain.SaveJob{Path: "/doc.docx", Request: domain.TrackRequest{Url: url}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// flaky удается со второй попытки, fail не удается никогда
+	var done, finished []string
+	queue := NewSaveQueue(repo, 1, 2, time.Millisecond)
+	err = queue.Start(func(job *domain.SaveJob) error {
+		done = append(done, job.Request.Url)
+		switch {
+		case job.Request.Url == "fail":
+			return errors.New("download failed")
+		case job.Request.Url == "flaky" && len(done) == 2:
+			return errors.New("connection reset")
+		}
+		return nil
+	}, func(job *domain.SaveJob) {
+		finished = append(finished, job.Request.Url)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue.Wait()
+	queue.Stop()
+
+	if fmt.Sprint(done) != "[ok flaky flaky fail fail fail]" {
+		t.Errorf("processed = %v, want [ok flaky flaky fail fail fail]", done)
+	}
+	if fmt.Sprint(finished) != "[ok flaky fail]" {
+		t.Errorf("finished = %v, want [ok flaky fail]", finished)
+	}
+	if pending, _ := repo.Pending(); len(pending) != 0 {
+		t.Errorf("pending = %d, want 0", len(pending))
+	}
+	failed, _ := os.ReadDir(filepath.Join(dir, "failed"))
+	if len(failed) != 1 {
+		t.Errorf("failed jobs = %d, want 1", len(failed))
+	}
+}
@@ -0,0 +1,62 @@
+package usecase
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+)
+
+// Sessions реестр активных сессий редактирования, наполняется из callback Document Server
+type Sessions struct {
+	mu       sync.RWMutex
+	sessions map[string]*domain.EditSession
+}
+
+func NewSessions() *Sessions {
+	return &Sessions{
+		sessions: make(map[string]*domain.EditSession),
+	}
+}
+
+func (x *Sessions) Update(key, path string, users []string) {
+	if len(users) == 0 {
+		x.End(key)
+		return
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	now := time.Now().UTC()
+	s, ok := x.sessions[key]
+	if !ok {
+		s = &domain.EditSession{Key: key, Path: path, Since: now}
+		x.sessions[key] = s
+	}
+	s.Users = append([]string(nil), users...)
+	s.Updated = now
+}
+
+func (x *Sessions) End(key string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	delete(x.sessions, key)
+}
+
+func (x *Sessions) List(path string) []domain.EditSession {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	result := make([]domain.EditSession, 0, len(x.sessions))
+	for _, s := range x.sessions {
+		if path == "" || s.Path == path {
+			result = append(result, *s)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+
+	return result
+}
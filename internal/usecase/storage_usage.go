@@ -0,0 +1,103 @@
+package usecase
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/AleksandrMac/fileserver/internal/interfaces"
+)
+
+type StorageUsageConfig struct {
+	// FlushInterval как часто сохранять учет на диск
+	FlushInterval time.Duration
+	// ReconcileInterval как часто сверять учет с диском. Сразу после запуска
+	// сверка идет, только если сохраненного учета не было.
+	ReconcileInterval time.Duration
+	// Pause пауза после каждого каталога при сверке, чтобы не мешать запросам
+	Pause time.Duration
+}
+
+// StorageUsage сохраняет учет занятого места и сверяет его с диском в фоне
+type StorageUsage struct {
+	repo interfaces.UsageRepo
+	cfg  StorageUsageConfig
+
+	ctx     context.Context
+	stop    context.CancelFunc
+	running sync.WaitGroup
+}
+
+func NewStorageUsage(repo interfaces.UsageRepo, cfg StorageUsageConfig) *StorageUsage {
+	ctx, stop := context.WithCancel(context.Background())
+	return &StorageUsage{
+		repo: repo,
+		cfg:  cfg,
+		ctx:  ctx,
+		stop: stop,
+	}
+}
+
+func (x *StorageUsage) Start() {
+	x.running.Add(2)
+	go x.flush()
+	go x.reconcile()
+}
+
+// Stop прерывает сверку и сохраняет учет
+func (x *StorageUsage) Stop() {
+	x.stop()
+	x.running.Wait()
+	if err := x.repo.Flush(); err != nil {
+		log.Error().Err(err).Msg("failed save storage usage")
+	}
+}
+
+func (x *StorageUsage) flush() {
+	defer x.running.Done()
+
+	ticker := time.NewTicker(x.cfg.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-x.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := x.repo.Flush(); err != nil {
+				log.Error().Err(err).Msg("failed save storage usage")
+			}
+		}
+	}
+}
+
+func (x *StorageUsage) reconcile() {
+	defer x.running.Done()
+
+	if x.repo.Loaded() {
+		select {
+		case <-x.ctx.Done():
+			return
+		case <-time.After(x.cfg.ReconcileInterval):
+		}
+	}
+	for {
+		start := time.Now()
+		fixed, err := x.repo.Reconcile(x.ctx, x.cfg.Pause)
+		if x.ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("failed reconcile storage usage")
+		} else {
+			log.Info().Int("fixed_dirs", fixed).Dur("took", time.Since(start)).Msg("storage usage reconciled")
+		}
+
+		select {
+		case <-x.ctx.Done():
+			return
+		case <-time.After(x.cfg.ReconcileInterval):
+		}
+	}
+}
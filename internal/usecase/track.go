@@ -1,32 +1,70 @@
 package usecase
 
 import (
-	"encoding/base64"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"mime"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	. "github.com/AleksandrMac/fileserver/internal/domain"
 	"github.com/AleksandrMac/fileserver/internal/interfaces"
+	"github.com/AleksandrMac/fileserver/internal/metrics"
+	"github.com/AleksandrMac/fileserver/pkg/hashreader"
 	"github.com/AleksandrMac/fileserver/pkg/uerror"
+	"github.com/AleksandrMac/fileserver/pkg/uerror/logwrapper"
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/rs/zerolog/log"
 )
 
 type TrackUC struct {
+	jwtSecret            string
 	docServerUrl         string
 	docServerUrlInternal string
 	fileRepo             interfaces.FileRepo
+	history              interfaces.HistoryRepo
+	keys                 interfaces.DocKeyRepo
+	sessions             interfaces.SessionRegistry
+	locks                interfaces.LockUsecase
+	audit                interfaces.AuditUsecase
+	downloader           interfaces.Downloader
+	queue                interfaces.SaveQueue
 }
 
 func NewTrackUC(
 	fileRepo interfaces.FileRepo,
+	history interfaces.HistoryRepo,
+	keys interfaces.DocKeyRepo,
+	sessions interfaces.SessionRegistry,
+	locks interfaces.LockUsecase,
+	audit interfaces.AuditUsecase,
+	downloader interfaces.Downloader,
+	queue interfaces.SaveQueue,
+	jwtSecret,
 	docServerUrl,
 	docServerUrlInternal string,
 ) interfaces.TrackUsecase {
 	return &TrackUC{
+		jwtSecret:            jwtSecret,
 		docServerUrl:         docServerUrl,
 		docServerUrlInternal: docServerUrlInternal,
 		fileRepo:             fileRepo,
+		history:              history,
+		keys:                 keys,
+		sessions:             sessions,
+		locks:                locks,
+		audit:                audit,
+		downloader:           downloader,
+		queue:                queue,
 	}
 }
 
@@ -38,13 +76,17 @@ func (x *TrackUC) ReadRequestData(r *http.Request) (args *TrackRequest, err uerr
 		)
 	}
 
+	if err := x.verify(r, args); err != nil {
+		return nil, err
+	}
+
 	if err := args.Valid(); err != nil {
 		return nil, uerror.NewUError(
 			http.StatusBadRequest, "failed validate payload", err, nil,
 		)
 	}
 
-	if args.Url == "" && (args.Status == 2 || args.Status == 3) {
+	if args.Url == "" && (args.Status == TrackMustSave || args.Status == TrackForceSave) {
 		return nil, uerror.NewUError(
 			http.StatusBadRequest, "failed validate payload", errors.New("missing url tag"), nil,
 		)
@@ -53,66 +95,459 @@ func (x *TrackUC) ReadRequestData(r *http.Request) (args *TrackRequest, err uerr
 	return
 }
 
+// verify проверяет JWT Document Server из тела (token) или заголовка Authorization.
+// Callback целиком берется из подписанного payload: поля тела вне подписи
+// (users, actions, history) не учитываются.
+func (x *TrackUC) verify(r *http.Request, args *TrackRequest) uerror.UError {
+	forbidden := func(msg string, err error) uerror.UError {
+		return uerror.NewUError(http.StatusForbidden, msg, err, map[string]any{
+			"key": args.Key,
+		})
+	}
+
+	tokenStr, inHeader := args.Token, false
+	if tokenStr == "" {
+		t := r.Header.Get("Authorization")
+		if !strings.HasPrefix(t, "Bearer ") {
+			return forbidden("missing callback token", errors.New("no token in body or header"))
+		}
+		tokenStr, inHeader = strings.TrimPrefix(t, "Bearer "), true
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (any, error) {
+		return []byte(x.jwtSecret), nil
+	}, jwt.WithValidMethods([]string{"HS256", "HS384", "HS512"}))
+	if err != nil {
+		return forbidden("invalid callback token", err)
+	}
+
+	// в заголовке Document Server кладет тело в поле payload
+	var payload any = map[string]any(claims)
+	if inHeader {
+		payload = claims["payload"]
+	}
+
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return forbidden("invalid callback token", err)
+	}
+	signed := new(TrackRequest)
+	if err := json.Unmarshal(raw, signed); err != nil || signed.Key == "" {
+		return forbidden("invalid callback token payload", errors.New("token payload has no key"))
+	}
+
+	signed.Token = args.Token
+	*args = *signed
+
+	return nil
+}
+
 func (x *TrackUC) Proceed(data *TrackRequest) (_ *TrackResponse, err uerror.UError) {
-	// 4. Обрабатываем только статусы 2 (редактирование) и 3 (нужно сохранить)
-	if data.Status == 2 || data.Status == 3 {
-		filename, err := base64.URLEncoding.DecodeString(data.Key)
-		if err != nil {
-			return nil, uerror.NewUError(http.StatusBadRequest,
-				"failed decode key", err, map[string]any{
-					"key": data.Key,
-				},
-			)
+	status := int(data.Status)
+	metrics.TrackCallbacks.WithLabelValues(strconv.Itoa(status)).Inc()
+
+	filename, _, err := x.resolve(data.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	switch status {
+	case TrackEditing:
+		x.sessions.Update(data.Key, filename, data.UserIds())
+
+	case TrackMustSave:
+		if err := x.enqueue(data, filename, true); err != nil {
+			return nil, err
 		}
 
-		fullFilename, err := x.fileRepo.GetFullPath(string(filename))
-		if err != nil {
-			return nil, uerror.NewUError(http.StatusInternalServerError,
-				"failed get full path", err, map[string]any{
-					"path": filename,
-				},
-			)
+	case TrackSaveError:
+		x.alert(data, filename)
+
+		// Document Server передает последнюю версию документа — пытаемся ее сохранить
+		if data.Url == "" {
+			x.end(data.Key)
+			break
+		}
+		if err := x.enqueue(data, filename, true); err != nil {
+			return nil, err
+		}
+
+	case TrackClosed:
+		x.end(data.Key)
+
+	case TrackForceSave:
+		// сессия продолжается
+		if err := x.enqueue(data, filename, false); err != nil {
+			return nil, err
+		}
+		x.sessions.Update(data.Key, filename, data.UserIds())
+
+	case TrackForceSaveError:
+		x.alert(data, filename)
+
+	default:
+		log.Warn().Int("status", status).Str("key", data.Key).Msg("unknown track status")
+	}
+
+	return &TrackResponse{}, nil
+}
+
+// resolve возвращает относительный и полный путь файла по ключу документа,
+// выданному EditorUsecase при открытии редактора
+func (x *TrackUC) resolve(key string) (string, string, uerror.UError) {
+	filename, err := x.keys.Get(key)
+	if err != nil {
+		return "", "", uerror.NewUError(http.StatusNotFound,
+			"unknown document key", err, map[string]any{
+				"key": key,
+			},
+		)
+	}
+
+	fullFilename, err := x.fileRepo.GetFullPath(filename)
+	if err != nil {
+		return "", "", uerror.NewUError(http.StatusInternalServerError,
+			"failed get full path", err, map[string]any{
+				"path": filename,
+			},
+		)
+	}
+
+	return filename, fullFilename, nil
+}
+
+// end завершает сессию: ключ больше не понадобится, следующее открытие получит новый
+func (x *TrackUC) end(key string) {
+	x.sessions.End(key)
+	if err := x.keys.Delete(key); err != nil {
+		log.Warn().Err(err).Str("key", key).Msg("failed delete document key")
+	}
+}
+
+// enqueue проверяет право на сохранение и ставит его в очередь. Document Server
+// получает ответ после записи задачи на диск, сам файл сохраняет ProcessSave.
+func (x *TrackUC) enqueue(data *TrackRequest, filename string, end bool) uerror.UError {
+	if err := x.canSave(data); err != nil {
+		return err
+	}
+	if err := x.checkLock(filename); err != nil {
+		return err
+	}
+
+	req := *data
+	req.Token = ""
+	job := &SaveJob{
+		Path:      filename,
+		Request:   req,
+		End:       end,
+		RequestID: data.RequestID,
+		ClientIP:  data.ClientIP,
+	}
+	if err := x.queue.Enqueue(job); err != nil {
+		return uerror.NewUError(http.StatusInternalServerError,
+			"failed enqueue save", err, map[string]any{
+				"key":  data.Key,
+				"path": filename,
+			},
+		)
+	}
+
+	return nil
+}
+
+// ProcessSave выполняет сохранение из очереди: скачивает документ от Document Server
+// и сохраняет его поверх существующего, предыдущее содержимое и архив изменений
+// (changesurl) уходят в историю версий. Очередь повторяет его при ошибке.
+func (x *TrackUC) ProcessSave(job *SaveJob) error {
+	data := job.Request
+	data.RequestID, data.ClientIP = job.RequestID, job.ClientIP
+
+	if err := x.save(&data, job.Path); err != nil {
+		return err
+	}
+	return nil
+}
+
+// FinishSave завершает сессию после последней попытки сохранения, удачной
+// или нет: Document Server уже получил ответ и повторять callback не будет
+func (x *TrackUC) FinishSave(job *SaveJob) {
+	if job.End {
+		x.end(job.Request.Key)
+	}
+}
+
+func (x *TrackUC) save(data *TrackRequest, filename string) uerror.UError {
+	fullFilename, err := x.fileRepo.GetFullPath(filename)
+	if err != nil {
+		return uerror.NewUError(http.StatusInternalServerError,
+			"failed get full path", err, map[string]any{
+				"path": filename,
+			},
+		)
+	}
+	// блокировка могла появиться, пока задача ждала в очереди
+	if err := x.checkLock(filename); err != nil {
+		return err
+	}
+
+	// 6. Скачиваем обновлённый документ от Document Server
+	body, uerr := x.download(data.Url, filepath.Ext(filename))
+	if uerr != nil {
+		return uerr
+	}
+	defer body.Close()
+
+	return x.write(data, filename, fullFilename, body, AuditTrackSave, "")
+}
+
+// write сохраняет body поверх файла, предыдущее содержимое уходит в историю версий
+func (x *TrackUC) write(data *TrackRequest, filename, fullFilename string, body io.Reader, action AuditAction, reason string) uerror.UError {
+	x.addVersion(data, filename, fullFilename)
+
+	// 7. Сохраняем поверх существующего файла
+	hr := hashreader.New(body)
+	if err := x.fileRepo.SaveFile(fullFilename, hr); err != nil {
+		return uerror.NewUError(http.StatusInternalServerError,
+			"failed to write document", err, map[string]any{
+				"fullfilename": fullFilename,
+			},
+		)
+	}
+
+	x.audit.Record(&AuditRecord{
+		Action:    action,
+		Principal: "onlyoffice:" + strings.Join(data.UserIds(), ","),
+		ClientIP:  data.ClientIP,
+		RequestID: data.RequestID,
+		Path:      filename,
+		Size:      hr.Size(),
+		SHA256:    hr.Sum(),
+		Reason:    reason,
+	})
+
+	return nil
+}
+
+// Restore заменяет документ содержимым версии req.Version. Текущее содержимое
+// сохраняется новой версией, как при сохранении из callback. Сессия по ключу
+// завершается: следующее открытие получит ключ восстановленной ревизии.
+func (x *TrackUC) Restore(req *RestoreRequest) uerror.UError {
+	filename, fullFilename, err := x.resolve(req.Key)
+	if err != nil {
+		return err
+	}
+
+	if access, _ := x.keys.Access(req.Key, req.UserId); access != AccessEdit {
+		return uerror.NewUError(http.StatusForbidden,
+			"restore requires edit access", fmt.Errorf("user %s has %s access", req.UserId, access), map[string]any{
+				"key": req.Key,
+			},
+		)
+	}
+
+	// восстановление поверх правок других пользователей потеряло бы их изменения
+	for _, s := range x.sessions.List(filename) {
+		for _, u := range s.Users {
+			if u != req.UserId {
+				return uerror.NewUError(http.StatusConflict,
+					"document is being edited by other users", errors.New("restore during shared session"), map[string]any{
+						"path":  filename,
+						"users": s.Users,
+					},
+				)
+			}
+		}
+	}
+
+	if err := x.checkLock(filename); err != nil {
+		return err
+	}
+
+	version, verr := x.history.OpenVersion(filename, req.Version)
+	if verr != nil {
+		status := http.StatusInternalServerError
+		if os.IsNotExist(verr) {
+			status = http.StatusNotFound
 		}
+		return uerror.NewUError(status, "failed open version", verr, map[string]any{
+			"path":    filename,
+			"version": req.Version,
+		})
+	}
+	defer version.Close()
 
-		// 6. Скачиваем обновлённый документ от Document Server
-		uri := x.updateUri(data.Url)
+	data := &TrackRequest{
+		Key:       req.Key,
+		Users:     []string{req.UserId},
+		RequestID: req.RequestID,
+		ClientIP:  req.ClientIP,
+	}
+	if err := x.write(data, filename, fullFilename, version, AuditRestore, "restored version "+strconv.Itoa(req.Version)); err != nil {
+		return err
+	}
+	x.end(req.Key)
 
-		resp, err := http.Get(uri)
+	return nil
+}
+
+// canSave проверяет, что сессия не открывалась только на просмотр: каждый
+// пользователь из callback должен иметь доступ с правом изменения. Если
+// пользователи не переданы, достаточно одного такого доступа по ключу.
+func (x *TrackUC) canSave(data *TrackRequest) uerror.UError {
+	refused := func(err error) uerror.UError {
+		return uerror.NewUError(http.StatusForbidden,
+			"save refused: session opened read-only", err, map[string]any{
+				"key":   data.Key,
+				"users": data.UserIds(),
+			},
+		)
+	}
+
+	ids := data.UserIds()
+	for _, id := range ids {
+		access, err := x.keys.Access(data.Key, id)
 		if err != nil {
-			return nil, uerror.NewUError(
-				http.StatusInternalServerError, "failed download updated doocument", err, map[string]any{
-					"url": uri,
-				},
-			)
+			return refused(err)
+		}
+		if !access.CanSave() {
+			return refused(fmt.Errorf("user %s has %s access", id, access))
 		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			return nil, uerror.NewUError(http.StatusInternalServerError,
-				"download failed", errors.New("download failed"), map[string]any{
-					"url":    uri,
-					"status": resp.Status,
-				},
-			)
+	}
+	if len(ids) > 0 {
+		return nil
+	}
+
+	if !x.keys.Writable(data.Key) {
+		return refused(errors.New("no writable grant"))
+	}
+	return nil
+}
+
+// checkLock отклоняет запись в явно заблокированный файл.
+// Блокировка сессии принадлежит самому редактору и запись не запрещает.
+func (x *TrackUC) checkLock(filename string) uerror.UError {
+	lock := x.locks.Active(filename)
+	if lock == nil || lock.Kind != LockExplicit {
+		return nil
+	}
+
+	return uerror.NewUError(http.StatusLocked,
+		"document is locked", ErrLocked, map[string]any{
+			"path":  filename,
+			"owner": lock.Owner,
+		},
+	)
+}
+
+// addVersion сохраняет текущее содержимое файла и архив изменений как версию.
+// Ошибки истории не должны мешать сохранению документа, поэтому только логируются.
+func (x *TrackUC) addVersion(data *TrackRequest, filename, fullFilename string) {
+	prev, err := x.fileRepo.ReadFile(fullFilename)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Warn().Err(err).Str("path", filename).Msg("failed read previous version")
+		return
+	}
+	defer prev.Close()
+
+	var diff io.Reader
+	if data.ChangesUrl != "" {
+		body, err := x.download(data.ChangesUrl, ".zip")
+		if err != nil {
+			logwrapper.ZeroLog(log.Warn().Str("path", filename), err)
+		} else {
+			defer body.Close()
+			diff = body
 		}
+	}
+
+	err = x.history.AddVersion(filename, prev, diff, &FileVersion{
+		Key:     data.Key,
+		Created: time.Now().UTC(),
+		Users:   data.UserIds(),
+		History: data.History,
+	})
+	if err != nil {
+		log.Warn().Err(err).Str("path", filename).Msg("failed save version")
+	}
+}
+
+// download скачивает файл Document Server. Content-Type ответа должен
+// соответствовать ожидаемому расширению ext.
+func (x *TrackUC) download(rawUrl, ext string) (io.ReadCloser, uerror.UError) {
+	uri := x.updateUri(rawUrl)
+
+	resp, err := x.downloader.Get(context.Background(), uri)
+	if err != nil {
+		return nil, uerror.NewUError(
+			http.StatusInternalServerError, "failed download updated doocument", err, map[string]any{
+				"url": uri,
+			},
+		)
+	}
 
-		// 7. Сохраняем поверх существующего файла
-		if err := x.fileRepo.SaveFile(fullFilename, resp.Body); err != nil {
-			return nil, uerror.NewUError(http.StatusInternalServerError,
-				"failed to write document", err, map[string]any{
-					"fullfilename": fullFilename,
-				},
-			)
+	if ct := resp.Header.Get("Content-Type"); !contentTypeMatches(ct, ext) {
+		resp.Body.Close()
+		return nil, uerror.NewUError(http.StatusInternalServerError,
+			"download failed", fmt.Errorf("unexpected content type %q for %s", ct, ext), map[string]any{
+				"url": uri,
+			},
+		)
+	}
+
+	return resp.Body, nil
+}
+
+// contentTypeMatches true если тип содержимого подходит файлу с расширением ext.
+// Document Server может отдать документ и как произвольные двоичные данные.
+func contentTypeMatches(contentType, ext string) bool {
+	if contentType == "" {
+		return true
+	}
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	switch mt {
+	case "application/octet-stream", "binary/octet-stream", "application/zip":
+		return true
+	}
+
+	if m := mimetype.Lookup(mt); m != nil {
+		for ; m != nil; m = m.Parent() {
+			if strings.EqualFold(m.Extension(), ext) {
+				return true
+			}
 		}
 	}
+	if byExt, _, err := mime.ParseMediaType(mime.TypeByExtension(ext)); err == nil && byExt == mt {
+		return true
+	}
 
-	return
+	return false
+}
+
+// alert сообщает об ошибке сохранения на стороне Document Server
+func (x *TrackUC) alert(data *TrackRequest, filename string) {
+	status := strconv.Itoa(int(data.Status))
+	metrics.TrackErrors.WithLabelValues(status).Inc()
+
+	log.Error().
+		Str("status", status).
+		Str("key", data.Key).
+		Str("path", filename).
+		Strs("users", data.UserIds()).
+		Msg("document server failed to save document")
 }
 
 func (x *TrackUC) WriteResponse(w http.ResponseWriter, data *TrackResponse, format string) error {
 	w.Header().Set("Content-Type", format)
 	if format == "application/json" {
-		return json.NewEncoder(w).Encode(map[string]int{"error": 0})
+		return json.NewEncoder(w).Encode(data)
 	}
 
 	_, err := w.Write([]byte("unsupported format"))
@@ -120,11 +555,14 @@ func (x *TrackUC) WriteResponse(w http.ResponseWriter, data *TrackResponse, form
 }
 
 func (x *TrackUC) updateUri(uri string) string {
-	if x.docServerUrl == "" {
+	if x.docServerUrlInternal == "" {
 		return uri
 	}
 
-	u, _ := url.Parse(uri)
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
 	u.Scheme = "http"
 	u.Host = x.docServerUrlInternal
 
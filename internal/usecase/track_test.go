@@ -0,0 +1,426 @@
+package usecase
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+	"github.com/AleksandrMac/fileserver/internal/download"
+	"github.com/AleksandrMac/fileserver/internal/interfaces"
+	"github.com/AleksandrMac/fileserver/internal/repository"
+)
+
+const testSecret = "secret"
+
+func sign(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	s, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestTrackReadRequestDataVerify(t *testing.T) {
+	body := map[string]any{"status": 2, "key": "a2V5", "url": "http://ds/file", "users": []string{"1"}}
+	signed := jwt.MapClaims{"status": 2, "key": "a2V5", "url": "http://ds/file", "users": []string{"1"}}
+	editorToken := jwt.MapClaims{"document": map[string]any{"key": "a2V5"}}
+
+	withToken := func(m map[string]any, token string) map[string]any {
+		res := map[string]any{"token": token}
+		for k, v := range m {
+			res[k] = v
+		}
+		return res
+	}
+
+	tests := []struct {
+		name       string
+		body       map[string]any
+		header     string
+		wantStatus int
+	}{
+		{
+			name: "token in body",
+			body: withToken(body, sign(t, signed)),
+		},
+		{
+			name: "token only in body",
+			body: map[string]any{"token": sign(t, signed)},
+		},
+		{
+			name:   "token in header",
+			body:   body,
+			header: "Bearer " + sign(t, jwt.MapClaims{"payload": signed}),
+		},
+		{
+			name:       "no token",
+			body:       body,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "editor token",
+			body:       body,
+			header:     "Bearer " + sign(t, editorToken),
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			// тело без подписи не учитывается, данные берутся из токена
+			name: "body differs from token",
+			body: withToken(map[string]any{"status": 2, "key": "b3RoZXI=", "url": "http://evil/file", "users": []string{"9999"}}, sign(t, signed)),
+		},
+	}
+
+	uc := &TrackUC{jwtSecret: testSecret}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, _ := json.Marshal(tt.body)
+			r := httptest.NewRequest(http.MethodPost, "/track", bytes.NewReader(raw))
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+
+			args, err := uc.ReadRequestData(r)
+			if tt.wantStatus == 0 {
+				if err != nil {
+					t.Fatalf("ReadRequestData() error = %v", err)
+				}
+				if args.Key != "a2V5" || args.Status != 2 || args.Url != "http://ds/file" || len(args.Users) != 1 || args.Users[0] != "1" {
+					t.Errorf("ReadRequestData() = %+v", args)
+				}
+				return
+			}
+
+			if err == nil || err.Status() != tt.wantStatus {
+				t.Fatalf("ReadRequestData() error = %v, want status %d", err, tt.wantStatus)
+			}
+		})
+	}
+}
+
+type nopAudit struct{}
+
+func (nopAudit) Record(*domain.AuditRecord) {}
+func (nopAudit) Query(*domain.AuditFilter) ([]domain.AuditRecord, error) {
+	return nil, nil
+}
+func (nopAudit) LogDownloads() bool { return false }
+
+// testDownloader скачивает только с локальных тестовых серверов
+var testDownloader = download.New(download.Config{
+	Timeout:      5 * time.Second,
+	Retries:      1,
+	Backoff:      time.Millisecond,
+	AllowedHosts: []string{"127.0.0.1"},
+})
+
+func newTestLocks(t *testing.T, sessions interfaces.SessionRegistry, keys interfaces.DocKeyRepo) *Locks {
+	repo, err := repository.NewLockRepository(filepath.Join(t.TempDir(), "locks.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewLocks(repo, sessions, keys)
+}
+
+// newTestQueue очередь сохранений, обработку запускает startTestQueue
+func newTestQueue(t *testing.T) *SaveQueue {
+	repo, err := repository.NewQueueRepository(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewSaveQueue(repo, 2, 0, 0)
+}
+
+func startTestQueue(t *testing.T, queue *SaveQueue, uc interfaces.TrackUsecase) {
+	if err := queue.Start(uc.ProcessSave, uc.FinishSave); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(queue.Stop)
+}
+
+func TestTrackProceedStatuses(t *testing.T) {
+	ds := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write([]byte("content of " + r.URL.Path))
+	}))
+	defer ds.Close()
+
+	storage := t.TempDir()
+	fileRepo := repository.NewFileRepository(storage)
+	historyRepo := repository.NewHistoryRepository(t.TempDir())
+	keys, err := repository.NewDocKeyRepository(filepath.Join(t.TempDir(), "keys.json"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := NewSessions()
+	queue := newTestQueue(t)
+	uc := NewTrackUC(fileRepo, historyRepo, keys, sessions, newTestLocks(t, sessions, keys), nopAudit{}, testDownloader, queue, testSecret, "", "")
+	startTestQueue(t, queue, uc)
+
+	if err := os.WriteFile(filepath.Join(storage, "doc.docx"), []byte("v0"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	key := "3f2a"
+	if err := keys.Put(key, "/doc.docx"); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"1", "2"} {
+		if err := keys.Grant(key, id, domain.AccessEdit); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	steps := []struct {
+		req          domain.TrackRequest
+		wantUsers    []string
+		wantContent  string
+		wantVersions int
+	}{
+		{
+			req:          domain.TrackRequest{Status: domain.TrackEditing, Key: key, Users: []string{"1"}},
+			wantUsers:    []string{"1"},
+			wantContent:  "v0",
+			wantVersions: 0,
+		},
+		{
+			req:          domain.TrackRequest{Status: domain.TrackForceSave, Key: key, Users: []string{"1", "2"}, Url: ds.URL + "/v1", ChangesUrl: ds.URL + "/diff1"},
+			wantUsers:    []string{"1", "2"},
+			wantContent:  "content of /v1",
+			wantVersions: 1,
+		},
+		{
+			req:          domain.TrackRequest{Status: domain.TrackForceSaveError, Key: key, Users: []string{"1", "2"}},
+			wantUsers:    []string{"1", "2"},
+			wantContent:  "content of /v1",
+			wantVersions: 1,
+		},
+		{
+			req:          domain.TrackRequest{Status: domain.TrackMustSave, Key: key, Users: []string{"1"}, Url: ds.URL + "/v2"},
+			wantUsers:    nil,
+			wantContent:  "content of /v2",
+			wantVersions: 2,
+		},
+	}
+
+	for _, step := range steps {
+		if _, err := uc.Proceed(&step.req); err != nil {
+			t.Fatalf("status %v: Proceed() error = %v", step.req.Status, err)
+		}
+		queue.Wait()
+
+		var users []string
+		if s := sessions.List("/doc.docx"); len(s) > 0 {
+			users = s[0].Users
+		}
+		if strings.Join(users, ",") != strings.Join(step.wantUsers, ",") {
+			t.Errorf("status %v: users = %v, want %v", step.req.Status, users, step.wantUsers)
+		}
+
+		content, _ := os.ReadFile(filepath.Join(storage, "doc.docx"))
+		if string(content) != step.wantContent {
+			t.Errorf("status %v: content = %q, want %q", step.req.Status, content, step.wantContent)
+		}
+
+		versions, err := historyRepo.List("/doc.docx")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(versions) != step.wantVersions {
+			t.Errorf("status %v: versions = %d, want %d", step.req.Status, len(versions), step.wantVersions)
+		}
+	}
+
+	versions, _ := historyRepo.List("/doc.docx")
+	if !versions[0].HasDiff || versions[1].HasDiff {
+		t.Errorf("diff flags = %v, %v, want true, false", versions[0].HasDiff, versions[1].HasDiff)
+	}
+
+	// после завершения сессии ключ удален
+	if _, err := keys.Get(key); err == nil {
+		t.Error("document key kept after session end")
+	}
+
+	// новая сессия, закрытая без изменений
+	if err := keys.Put("4b1c", "/doc.docx"); err != nil {
+		t.Fatal(err)
+	}
+	uc.Proceed(&domain.TrackRequest{Status: domain.TrackEditing, Key: "4b1c", Users: []string{"3"}})
+	if _, err := uc.Proceed(&domain.TrackRequest{Status: domain.TrackClosed, Key: "4b1c"}); err != nil {
+		t.Fatalf("Proceed() error = %v", err)
+	}
+	if s := sessions.List("/doc.docx"); len(s) != 0 {
+		t.Errorf("sessions = %v, want none", s)
+	}
+}
+
+func TestTrackProceedReadOnly(t *testing.T) {
+	ds := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.wordprocessingml.document")
+		w.Write([]byte("changed"))
+	}))
+	defer ds.Close()
+
+	storage := t.TempDir()
+	keys, err := repository.NewDocKeyRepository(filepath.Join(t.TempDir(), "keys.json"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue := newTestQueue(t)
+	uc := NewTrackUC(repository.NewFileRepository(storage), repository.NewHistoryRepository(t.TempDir()), keys, NewSessions(), newTestLocks(t, NewSessions(), keys), nopAudit{}, testDownloader, queue, testSecret, "", "")
+	startTestQueue(t, queue, uc)
+
+	if err := os.WriteFile(filepath.Join(storage, "doc.docx"), []byte("v0"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.Put("5d6e", "/doc.docx"); err != nil {
+		t.Fatal(err)
+	}
+	keys.Grant("5d6e", "1", domain.AccessEdit)
+	keys.Grant("5d6e", "2", domain.AccessView)
+
+	tests := []struct {
+		users []string
+		want  int
+	}{
+		// среди пользователей есть открывший на просмотр
+		{[]string{"1", "2"}, http.StatusForbidden},
+		// пользователь без выданного доступа
+		{[]string{"3"}, http.StatusForbidden},
+		{[]string{"1"}, 0},
+	}
+
+	for _, tt := range tests {
+		_, err := uc.Proceed(&domain.TrackRequest{Status: domain.TrackForceSave, Key: "5d6e", Users: tt.users, Url: ds.URL})
+		queue.Wait()
+		got := 0
+		if err != nil {
+			got = err.Status()
+		}
+		if got != tt.want {
+			t.Errorf("users %v: status = %d, want %d", tt.users, got, tt.want)
+		}
+
+		content, _ := os.ReadFile(filepath.Join(storage, "doc.docx"))
+		if tt.want != 0 && string(content) != "v0" {
+			t.Errorf("users %v: document overwritten by read-only session", tt.users)
+		}
+	}
+}
+
+func TestTrackRestore(t *testing.T) {
+	storage := t.TempDir()
+	historyRepo := repository.NewHistoryRepository(t.TempDir())
+	keys, err := repository.NewDocKeyRepository(filepath.Join(t.TempDir(), "keys.json"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := NewSessions()
+	uc := NewTrackUC(repository.NewFileRepository(storage), historyRepo, keys, sessions, newTestLocks(t, sessions, keys), nopAudit{}, testDownloader, newTestQueue(t), testSecret, "", "")
+
+	if err := os.WriteFile(filepath.Join(storage, "doc.docx"), []byte("v2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := historyRepo.AddVersion("/doc.docx", strings.NewReader("v1"), nil, &domain.FileVersion{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.Put("7a8b", "/doc.docx"); err != nil {
+		t.Fatal(err)
+	}
+	keys.Grant("7a8b", "1", domain.AccessEdit)
+	keys.Grant("7a8b", "2", domain.AccessReview)
+	sessions.Update("7a8b", "/doc.docx", []string{"1", "3"})
+
+	tests := []struct {
+		name string
+		req  domain.RestoreRequest
+		want int
+	}{
+		{name: "not edit access", req: domain.RestoreRequest{Key: "7a8b", UserId: "2", Version: 1}, want: http.StatusForbidden},
+		// документ открыт и у пользователя 3
+		{name: "shared session", req: domain.RestoreRequest{Key: "7a8b", UserId: "1", Version: 1}, want: http.StatusConflict},
+		{name: "unknown version", req: domain.RestoreRequest{Key: "7a8b", UserId: "1", Version: 5}, want: http.StatusNotFound},
+		{name: "restore", req: domain.RestoreRequest{Key: "7a8b", UserId: "1", Version: 1}},
+	}
+
+	for _, tt := range tests {
+		if tt.name == "unknown version" {
+			sessions.Update("7a8b", "/doc.docx", []string{"1"})
+		}
+
+		got := 0
+		if err := uc.Restore(&tt.req); err != nil {
+			got = err.Status()
+		}
+		if got != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	content, _ := os.ReadFile(filepath.Join(storage, "doc.docx"))
+	if string(content) != "v1" {
+		t.Errorf("content = %q, want v1", content)
+	}
+
+	// замененное содержимое стало версией 2, сессия завершена
+	versions, _ := historyRepo.List("/doc.docx")
+	if len(versions) != 2 {
+		t.Fatalf("versions = %d, want 2", len(versions))
+	}
+	prev, _ := historyRepo.OpenVersion("/doc.docx", 2)
+	data, _ := io.ReadAll(prev)
+	prev.Close()
+	if string(data) != "v2" {
+		t.Errorf("version 2 = %q, want v2", data)
+	}
+	if _, err := keys.Get("7a8b"); err == nil {
+		t.Error("document key kept after restore")
+	}
+}
+
+func TestContentTypeMatches(t *testing.T) {
+	tests := []struct {
+		contentType string
+		ext         string
+		want        bool
+	}{
+		{"", ".docx", true},
+		{"application/octet-stream", ".docx", true},
+		{"application/vnd.openxmlformats-officedocument.wordprocessingml.document", ".docx", true},
+		{"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", ".docx", false},
+		{"application/zip", ".zip", true},
+		{"text/csv; charset=utf-8", ".csv", true},
+		// страница ошибки вместо документа
+		{"text/html; charset=utf-8", ".docx", false},
+		{"application/json", ".xlsx", false},
+	}
+
+	for _, tt := range tests {
+		if got := contentTypeMatches(tt.contentType, tt.ext); got != tt.want {
+			t.Errorf("contentTypeMatches(%q, %q) = %v, want %v", tt.contentType, tt.ext, got, tt.want)
+		}
+	}
+}
+
+func TestTrackProceedUnknownKey(t *testing.T) {
+	keys, err := repository.NewDocKeyRepository(filepath.Join(t.TempDir(), "keys.json"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uc := NewTrackUC(repository.NewFileRepository(t.TempDir()), nil, keys, NewSessions(), newTestLocks(t, NewSessions(), keys), nopAudit{}, testDownloader, newTestQueue(t), testSecret, "", "")
+
+	// ключ в старом формате base64(filename) больше не принимается
+	_, uerr := uc.Proceed(&domain.TrackRequest{Status: domain.TrackMustSave, Key: "ZG9jLmRvY3g=", Url: "http://ds/doc"})
+	if uerr == nil || uerr.Status() != http.StatusNotFound {
+		t.Fatalf("Proceed() error = %v, want status %d", uerr, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,335 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+	"github.com/AleksandrMac/fileserver/internal/interfaces"
+	"github.com/AleksandrMac/fileserver/internal/metrics"
+)
+
+type WebhooksConfig struct {
+	// MaxAttempts после стольких неудачных попыток доставка уходит в dead-letter
+	MaxAttempts int
+	// Backoff пауза после первой неудачи, дальше удваивается до MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	Timeout    time.Duration
+}
+
+// Webhooks отправляет изменения хранилища подписчикам. Событие сначала
+// пишется в outbox на диске, затем отправляется; доставки одной подписки
+// уходят строго по очереди, следующая ждет, пока не пройдет предыдущая.
+// У каждой подписки свой отправитель, медленный адрес не задерживает
+// остальных. Очереди держатся в памяти, outbox читается только при запуске.
+type Webhooks struct {
+	hooks  interfaces.WebhookRepo
+	outbox interfaces.WebhookOutboxRepo
+	cfg    WebhooksConfig
+	client *http.Client
+
+	mu     sync.Mutex
+	queues map[string]*webhookQueue
+	depth  int
+
+	ctx     context.Context
+	stop    context.CancelFunc
+	running sync.WaitGroup
+}
+
+// webhookQueue доставки одной подписки в порядке отправки
+type webhookQueue struct {
+	items []*domain.WebhookDelivery
+	wake  chan struct{}
+}
+
+func NewWebhooks(hooks interfaces.WebhookRepo, outbox interfaces.WebhookOutboxRepo, cfg WebhooksConfig) *Webhooks {
+	ctx, stop := context.WithCancel(context.Background())
+	return &Webhooks{
+		hooks:  hooks,
+		outbox: outbox,
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+		queues: make(map[string]*webhookQueue),
+		ctx:    ctx,
+		stop:   stop,
+	}
+}
+
+// Start подписывается на изменения и отправляет доставки, в том числе
+// оставшиеся с прошлого запуска
+func (x *Webhooks) Start(changes interfaces.ChangeFeed) {
+	// подписка до возврата, чтобы не пропустить изменения сразу после запуска
+	ch, cancel := changes.Subscribe("/")
+
+	// старые доставки в очередь до новых, listen еще не запущен
+	pending, err := x.outbox.Pending()
+	if err != nil {
+		log.Error().Err(err).Msg("failed read webhook outbox")
+	}
+	for i := range pending {
+		x.push(&pending[i])
+	}
+
+	x.running.Add(1)
+	go x.listen(changes, ch, cancel)
+}
+
+// Stop прерывает отправку. Недоставленное остается в outbox до следующего запуска.
+func (x *Webhooks) Stop() {
+	x.stop()
+	x.running.Wait()
+}
+
+func (x *Webhooks) Dead() ([]domain.WebhookDelivery, error) {
+	return x.outbox.Dead()
+}
+
+func (x *Webhooks) Redeliver(id string) (*domain.WebhookDelivery, error) {
+	d, err := x.outbox.Redeliver(id)
+	if err != nil {
+		return nil, err
+	}
+	retry := *d
+	x.push(&retry)
+	return d, nil
+}
+
+// push ставит доставку в очередь подписки и будит ее отправителя,
+// отправитель запускается при первой доставке
+func (x *Webhooks) push(d *domain.WebhookDelivery) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.ctx.Err() != nil {
+		// после остановки доставка дождется следующего запуска в outbox
+		return
+	}
+
+	q, ok := x.queues[d.Webhook]
+	if !ok {
+		q = &webhookQueue{wake: make(chan struct{}, 1)}
+		x.queues[d.Webhook] = q
+		x.running.Add(1)
+		go x.deliver(d.Webhook, q)
+	}
+	q.items = append(q.items, d)
+	x.depth++
+	metrics.WebhookOutboxDepth.Set(float64(x.depth))
+	q.notify()
+}
+
+// pop убирает отправленную или неудавшуюся первую доставку
+func (x *Webhooks) pop(q *webhookQueue) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	q.items[0] = nil
+	q.items = q.items[1:]
+	x.depth--
+	metrics.WebhookOutboxDepth.Set(float64(x.depth))
+}
+
+func (x *webhookQueue) notify() {
+	select {
+	case x.wake <- struct{}{}:
+	default:
+	}
+}
+
+// listen ставит изменения в outbox. Если подписку закрыли из-за отставания,
+// пропущенное дочитывается из истории изменений.
+func (x *Webhooks) listen(changes interfaces.ChangeFeed, ch <-chan domain.ChangeEvent, cancel func()) {
+	defer x.running.Done()
+
+	defer func() { cancel() }()
+	var last uint64
+	for {
+		select {
+		case <-x.ctx.Done():
+			return
+		case ev, ok := <-ch:
+			if ok {
+				x.enqueue(&ev)
+				last = ev.ID
+				continue
+			}
+			var err error
+			if ch, cancel, err = changes.Since("/", last); err != nil {
+				log.Error().Err(err).Uint64("after", last).Msg("webhook events lost, change feed overflowed")
+				ch, cancel = changes.Subscribe("/")
+			}
+		}
+	}
+}
+
+func (x *Webhooks) enqueue(ev *domain.ChangeEvent) {
+	for _, h := range x.hooks.List() {
+		if !h.Match(&ev.AuditRecord) {
+			continue
+		}
+		d := &domain.WebhookDelivery{
+			Webhook: h.ID,
+			Created: time.Now().UTC(),
+			Event: domain.WebhookEvent{
+				Webhook:   h.ID,
+				Action:    ev.Action,
+				Path:      ev.Path,
+				From:      ev.From,
+				Size:      ev.Size,
+				SHA256:    ev.SHA256,
+				Principal: ev.Principal,
+				RequestID: ev.RequestID,
+				Time:      ev.Time,
+			},
+		}
+		if err := x.outbox.Put(d); err != nil {
+			log.Error().Err(err).Str("webhook", h.ID).Str("path", ev.Path).Msg("failed queue webhook delivery")
+			continue
+		}
+		x.push(d)
+	}
+}
+
+// deliver отправитель одной подписки
+func (x *Webhooks) deliver(id string, q *webhookQueue) {
+	defer x.running.Done()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-x.ctx.Done():
+			return
+		case <-q.wake:
+		case <-timer.C:
+		}
+
+		wait := x.sendHead(id, q)
+		timer.Stop()
+		switch {
+		case wait == 0:
+			q.notify()
+		case wait > 0:
+			timer.Reset(wait)
+		}
+	}
+}
+
+// sendHead отправляет первую доставку подписки, если ее время пришло.
+// Возвращает, через сколько проверить снова, -1 если очередь пуста.
+func (x *Webhooks) sendHead(id string, q *webhookQueue) time.Duration {
+	x.mu.Lock()
+	if len(q.items) == 0 {
+		x.mu.Unlock()
+		return -1
+	}
+	d := q.items[0]
+	x.mu.Unlock()
+
+	hook, ok := x.hooks.Get(id)
+	if !ok {
+		d.LastError = "webhook is not configured"
+		x.fail(d)
+		x.pop(q)
+		return 0
+	}
+	if wait := time.Until(d.NextAttempt); wait > 0 {
+		return wait
+	}
+	if x.attempt(hook, d) {
+		x.pop(q)
+	}
+	return 0
+}
+
+// attempt отправляет доставку, true если она покинула очередь:
+// доставлена или ушла в dead-letter
+func (x *Webhooks) attempt(hook *domain.Webhook, d *domain.WebhookDelivery) bool {
+	err := x.send(hook, d)
+	if x.ctx.Err() != nil {
+		// прервано остановкой, попытка не считается
+		return false
+	}
+	if err == nil {
+		metrics.WebhookDeliveries.WithLabelValues("ok").Inc()
+		if err := x.outbox.Done(d.ID); err != nil {
+			log.Error().Err(err).Str("delivery", d.ID).Msg("failed remove sent webhook delivery")
+		}
+		return true
+	}
+
+	d.Attempts++
+	d.LastError = err.Error()
+	if d.Attempts >= x.cfg.MaxAttempts {
+		x.fail(d)
+		return true
+	}
+	metrics.WebhookDeliveries.WithLabelValues("retry").Inc()
+	d.NextAttempt = time.Now().Add(min(x.cfg.Backoff<<min(d.Attempts-1, 20), x.cfg.MaxBackoff)).UTC()
+	if err := x.outbox.Update(d); err != nil {
+		log.Error().Err(err).Str("delivery", d.ID).Msg("failed update webhook delivery")
+	}
+	return false
+}
+
+func (x *Webhooks) fail(d *domain.WebhookDelivery) {
+	metrics.WebhookDeliveries.WithLabelValues("dead").Inc()
+	log.Warn().Str("webhook", d.Webhook).Str("delivery", d.ID).Int("attempts", d.Attempts).Str("error", d.LastError).Msg("webhook delivery failed")
+	if err := x.outbox.Fail(d); err != nil {
+		log.Error().Err(err).Str("delivery", d.ID).Msg("failed move webhook delivery to dead letters")
+	}
+}
+
+// send POST события. Подпись X-Webhook-Signature: sha256=HMAC(secret, "<timestamp>.<body>"),
+// timestamp из X-Webhook-Timestamp защищает от повтора старых запросов.
+func (x *Webhooks) send(hook *domain.Webhook, d *domain.WebhookDelivery) error {
+	body, err := json.Marshal(d.Event)
+	if err != nil {
+		return err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequestWithContext(x.ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "fileserver-webhooks")
+	req.Header.Set("X-Webhook-Id", hook.ID)
+	req.Header.Set("X-Webhook-Delivery", d.ID)
+	req.Header.Set("X-Webhook-Timestamp", ts)
+	req.Header.Set("X-Webhook-Signature", "sha256="+SignWebhook(hook.Secret, ts, body))
+
+	resp, err := x.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// SignWebhook hex HMAC-SHA256 от "<timestamp>.<body>"
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,223 @@
+package usecase
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+	"github.com/AleksandrMac/fileserver/internal/repository"
+)
+
+// receiver принимает вебхуки, первые fail запросов отвечает 500.
+// Запросы подписки hold ждут закрытия release.
+type receiver struct {
+	mu      sync.Mutex
+	fail    int
+	events  []domain.WebhookEvent
+	errs    []string
+	hold    string
+	release chan struct{}
+}
+
+func (x *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if x.hold != "" && r.Header.Get("X-Webhook-Id") == x.hold {
+		<-x.release
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	sig := "sha256=" + SignWebhook("secret-"+r.Header.Get("X-Webhook-Id"), r.Header.Get("X-Webhook-Timestamp"), body)
+	if r.Header.Get("X-Webhook-Signature") != sig {
+		x.errs = append(x.errs, "bad signature")
+	}
+	if x.fail > 0 {
+		x.fail--
+		http.Error(w, "unavailable", http.StatusInternalServerError)
+		return
+	}
+	var ev domain.WebhookEvent
+	if err := json.Unmarshal(body, &ev); err != nil {
+		x.errs = append(x.errs, err.Error())
+	}
+	if ev.ID != r.Header.Get("X-Webhook-Delivery") {
+		x.errs = append(x.errs, "event id "+ev.ID)
+	}
+	x.events = append(x.events, ev)
+}
+
+// paths пути полученных событий подписки hook
+func (x *receiver) paths(hook string) []string {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	var paths []string
+	for _, ev := range x.events {
+		if ev.Webhook == hook {
+			paths = append(paths, string(ev.Action)+" "+ev.Path)
+		}
+	}
+	return paths
+}
+
+func newTestWebhooks(t *testing.T, url string, maxAttempts int) (*Webhooks, *repository.WebhookOutboxRepository) {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "webhooks.json")
+	cfg := `{"webhooks": [
+		{"id": "reports", "url": "` + url + `", "secret": "secret-reports", "paths": ["/files/reports/**"], "events": ["upload", "overwrite"]},
+		{"id": "all", "url": "` + url + `", "secret": "secret-all"}
+	]}`
+	if err := os.WriteFile(file, []byte(cfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hooks, err := repository.NewWebhookRepository(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outbox, err := repository.NewWebhookOutboxRepository(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewWebhooks(hooks, outbox, WebhooksConfig{
+		MaxAttempts: maxAttempts,
+		Backoff:     time.Millisecond,
+		MaxBackoff:  5 * time.Millisecond,
+		Timeout:     time.Second,
+	}), outbox
+}
+
+func eventually(t *testing.T, what string, ok func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !ok() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWebhooksDelivery(t *testing.T) {
+	recv := &receiver{fail: 3}
+	srv := httptest.NewServer(recv)
+	defer srv.Close()
+	webhooks, outbox := newTestWebhooks(t, srv.URL, 10)
+
+	// доставка с прошлого запуска уходит первой
+	if err := outbox.Put(&domain.WebhookDelivery{Webhook: "all", Event: domain.WebhookEvent{Webhook: "all", Action: domain.AuditDelete, Path: "/files/old.txt"}}); err != nil {
+		t.Fatal(err)
+	}
+	changes := NewChanges()
+	webhooks.Start(changes)
+	defer webhooks.Stop()
+
+	changes.Publish(&domain.AuditRecord{Action: domain.AuditUpload, Path: "/files/reports/q4.pdf", Size: 5, SHA256: "abc", Principal: "api-key", RequestID: "req-1"})
+	changes.Publish(&domain.AuditRecord{Action: domain.AuditDelete, Path: "/files/reports/q3.pdf"})
+	changes.Publish(&domain.AuditRecord{Action: domain.AuditUpload, Path: "/files/reportsx/a.pdf"})
+
+	eventually(t, "deliveries", func() bool { return len(recv.paths("all")) == 4 && len(recv.paths("reports")) == 1 })
+	want := "delete /files/old.txt,upload /files/reports/q4.pdf,delete /files/reports/q3.pdf,upload /files/reportsx/a.pdf"
+	if got := strings.Join(recv.paths("all"), ","); got != want {
+		t.Errorf("all: %s", got)
+	}
+	if got := recv.paths("reports"); got[0] != "upload /files/reports/q4.pdf" {
+		t.Errorf("reports: %v", got)
+	}
+
+	eventually(t, "empty outbox", func() bool {
+		pending, _ := outbox.Pending()
+		return len(pending) == 0
+	})
+
+	recv.mu.Lock()
+	defer recv.mu.Unlock()
+	if len(recv.errs) > 0 {
+		t.Errorf("receiver errors: %v", recv.errs)
+	}
+	for _, ev := range recv.events {
+		if ev.Webhook == "reports" && (ev.Size != 5 || ev.SHA256 != "abc" || ev.Principal != "api-key" || ev.RequestID != "req-1") {
+			t.Errorf("event %+v", ev)
+		}
+	}
+}
+
+func TestWebhooksSlowSubscriber(t *testing.T) {
+	recv := &receiver{hold: "reports", release: make(chan struct{})}
+	srv := httptest.NewServer(recv)
+	defer srv.Close()
+	defer close(recv.release)
+	webhooks, _ := newTestWebhooks(t, srv.URL, 10)
+	changes := NewChanges()
+	webhooks.Start(changes)
+	defer webhooks.Stop()
+
+	changes.Publish(&domain.AuditRecord{Action: domain.AuditUpload, Path: "/files/reports/q4.pdf"})
+	changes.Publish(&domain.AuditRecord{Action: domain.AuditDelete, Path: "/files/a.txt"})
+
+	// reports висит на первом запросе, all получает свое
+	eventually(t, "deliveries of all", func() bool { return len(recv.paths("all")) == 2 })
+	if got := recv.paths("reports"); len(got) != 0 {
+		t.Errorf("reports: %v", got)
+	}
+}
+
+func TestWebhooksDeadLetter(t *testing.T) {
+	recv := &receiver{fail: 2}
+	srv := httptest.NewServer(recv)
+	defer srv.Close()
+	webhooks, _ := newTestWebhooks(t, srv.URL, 2)
+	changes := NewChanges()
+	webhooks.Start(changes)
+	defer webhooks.Stop()
+
+	changes.Publish(&domain.AuditRecord{Action: domain.AuditDelete, Path: "/files/a.txt"})
+
+	var dead []domain.WebhookDelivery
+	eventually(t, "dead letter", func() bool {
+		dead, _ = webhooks.Dead()
+		return len(dead) == 1
+	})
+	if dead[0].Attempts != 2 || !strings.Contains(dead[0].LastError, "500") {
+		t.Errorf("dead letter %+v", dead[0])
+	}
+
+	if _, err := webhooks.Redeliver(dead[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "redelivery", func() bool { return len(recv.paths("all")) == 1 })
+	if dead, _ = webhooks.Dead(); len(dead) != 0 {
+		t.Errorf("dead letters after redelivery: %d", len(dead))
+	}
+	if _, err := webhooks.Redeliver("00000000000000000001"); !errors.Is(err, domain.ErrNotFound) {
+		t.Errorf("redeliver unknown: %v", err)
+	}
+}
+
+func TestWebhookMatch(t *testing.T) {
+	hook := domain.Webhook{Paths: []string{"/files/in/**", "/files/*.csv"}, Events: []domain.AuditAction{domain.AuditUpload, domain.AuditMove}}
+	tests := []struct {
+		rec  domain.AuditRecord
+		want bool
+	}{
+		{domain.AuditRecord{Action: domain.AuditUpload, Path: "/files/in/a/b.txt"}, true},
+		{domain.AuditRecord{Action: domain.AuditUpload, Path: "/files/in"}, false},
+		{domain.AuditRecord{Action: domain.AuditUpload, Path: "/files/inbox/a.txt"}, false},
+		{domain.AuditRecord{Action: domain.AuditUpload, Path: "/files/data.csv"}, true},
+		{domain.AuditRecord{Action: domain.AuditUpload, Path: "/files/sub/data.csv"}, false},
+		{domain.AuditRecord{Action: domain.AuditDelete, Path: "/files/in/a.txt"}, false},
+		{domain.AuditRecord{Action: domain.AuditMove, Path: "/files/out/a.txt", From: "/files/in/a.txt"}, true},
+	}
+	for _, tt := range tests {
+		if got := hook.Match(&tt.rec); got != tt.want {
+			t.Errorf("Match(%s %s) = %v, want %v", tt.rec.Action, tt.rec.Path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,652 @@
+package watcher
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+	"github.com/AleksandrMac/fileserver/internal/interfaces"
+	"github.com/AleksandrMac/fileserver/internal/metrics"
+)
+
+// Principal субъект аудита для изменений, сделанных в обход сервера
+const Principal = "external"
+
+// tempPrefix временные файлы записи через сервер, их не учитываем
+const tempPrefix = ".tmp_"
+
+type Config struct {
+	// Debounce сколько копить события перед сверкой с диском. Переименование
+	// узнается, только если обе его половины попали в одну сверку.
+	Debounce time.Duration
+	// RescanInterval период полного пересканирования без inotify
+	RescanInterval time.Duration
+	// Poll только пересканирование, для томов, где inotify не видит чужих
+	// изменений (NFS и другие сетевые ФС)
+	Poll bool
+}
+
+// Watcher следит за изменениями хранилища в обход сервера: файлы, положенные
+// на том другими подами, правки, удаления и переименования. Изменения сверяются
+// с собственным индексом и сообщаются через notify так же, как запросы SFTP и
+// gRPC. Запись через FileRepository отмечается BeginWrite/EndWrite и внешним
+// изменением не считается. Когда inotify недоступен или исчерпан лимит
+// наблюдений, хранилище пересканируется каждые RescanInterval.
+type Watcher struct {
+	root   string
+	repo   interfaces.WatchedFileRepo
+	usage  interfaces.UsageRepo
+	notify func(rec domain.AuditRecord)
+	cfg    Config
+
+	// дальше только горутина run
+	fsw     *fsnotify.Watcher
+	index   *node
+	watched map[string]bool
+
+	mu    sync.Mutex
+	busy  map[string]int
+	own   []string
+	dirty map[string]bool // путь -> обойти целиком
+	full  bool
+	wake  chan struct{}
+
+	// ready закрывается, когда индекс построен
+	ready chan struct{}
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// node файл или каталог в индексе. Узел есть у каждого файла, поэтому
+// только самое нужное: mtime в наносекундах вместо time.Time.
+type node struct {
+	dir      bool
+	size     int64
+	modTime  int64
+	children map[string]*node
+}
+
+// New наблюдатель за root. Подключается к репозиторию до первой записи через
+// него, следить начинает после Start. usage может быть nil, если учет занятого
+// места не ведется.
+func New(root string, repo interfaces.WatchedFileRepo, usage interfaces.UsageRepo, cfg Config) (*Watcher, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	return &Watcher{
+		root:    root,
+		repo:    repo,
+		usage:   usage,
+		cfg:     cfg,
+		index:   &node{dir: true, children: make(map[string]*node)},
+		watched: make(map[string]bool),
+		busy:    make(map[string]int),
+		dirty:   make(map[string]bool),
+		wake:    make(chan struct{}, 1),
+		ready:   make(chan struct{}),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}, nil
+}
+
+// Start начинает следить, notify вызывается для каждого внешнего изменения.
+// Индекс хранилища строится в фоне, изменения до его готовности не сообщаются.
+func (x *Watcher) Start(notify func(rec domain.AuditRecord)) {
+	x.notify = notify
+	if !x.cfg.Poll {
+		fsw, err := fsnotify.NewWatcher()
+		if err != nil {
+			log.Warn().Err(err).Dur("interval", x.cfg.RescanInterval).Msg("inotify unavailable, falling back to storage rescans")
+		} else {
+			x.fsw = fsw
+		}
+	}
+	go x.run()
+}
+
+// Stop прекращает наблюдение
+func (x *Watcher) Stop() {
+	close(x.stop)
+	<-x.done
+	if x.fsw != nil {
+		x.fsw.Close()
+	}
+}
+
+func (x *Watcher) BeginWrite(path string) {
+	x.mu.Lock()
+	x.busy[path]++
+	x.mu.Unlock()
+}
+
+// EndWrite path приводится в индексе к состоянию на диске без уведомлений
+func (x *Watcher) EndWrite(path string) {
+	x.mu.Lock()
+	if x.busy[path]--; x.busy[path] <= 0 {
+		delete(x.busy, path)
+	}
+	x.own = append(x.own, path)
+	x.mu.Unlock()
+	x.signal()
+}
+
+func (x *Watcher) signal() {
+	select {
+	case x.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (x *Watcher) run() {
+	defer close(x.done)
+
+	// обход большого хранилища не задерживает запуск сервера
+	b := &batch{quiet: true, watch: []string{x.root}}
+	x.diffDir(x.root, x.index, true, b)
+	x.apply(b)
+	close(x.ready)
+
+	debounce := time.NewTimer(time.Hour)
+	debounce.Stop()
+	armed := false
+	arm := func() {
+		if !armed {
+			armed = true
+			debounce.Reset(x.cfg.Debounce)
+		}
+	}
+
+	var rescan <-chan time.Time
+	if x.cfg.RescanInterval > 0 {
+		ticker := time.NewTicker(x.cfg.RescanInterval)
+		defer ticker.Stop()
+		rescan = ticker.C
+	}
+
+	for {
+		var events <-chan fsnotify.Event
+		var errs <-chan error
+		if x.fsw != nil {
+			events, errs = x.fsw.Events, x.fsw.Errors
+		}
+
+		select {
+		case <-x.stop:
+			return
+		case ev := <-events:
+			if x.mark(ev) {
+				arm()
+			}
+		case err := <-errs:
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				log.Warn().Msg("inotify queue overflowed, rescanning storage")
+				x.mu.Lock()
+				x.full = true
+				x.mu.Unlock()
+			} else {
+				log.Warn().Err(err).Msg("storage watcher error")
+			}
+			arm()
+		case <-x.wake:
+			arm()
+		case <-rescan:
+			if x.fsw != nil {
+				continue
+			}
+			x.mu.Lock()
+			x.full = true
+			x.mu.Unlock()
+			x.reconcile()
+		case <-debounce.C:
+			armed = false
+			x.reconcile()
+		}
+	}
+}
+
+// mark запоминает путь события для следующей сверки
+func (x *Watcher) mark(ev fsnotify.Event) bool {
+	if ev.Name == x.root || !strings.HasPrefix(ev.Name, x.root+string(filepath.Separator)) || ignored(filepath.Base(ev.Name)) {
+		return false
+	}
+	if ev.Has(fsnotify.Remove) || ev.Has(fsnotify.Rename) {
+		// наблюдение за удаленным каталогом снимает ядро
+		delete(x.watched, ev.Name)
+	}
+	x.mu.Lock()
+	// каталог, созданный заново, обходим целиком
+	x.dirty[ev.Name] = x.dirty[ev.Name] || ev.Has(fsnotify.Create)
+	x.mu.Unlock()
+	return true
+}
+
+// reconcile сверяет с диском пути событий или все хранилище. Пути, занятые
+// записью через сервер, ждут следующей сверки после EndWrite.
+func (x *Watcher) reconcile() {
+	x.mu.Lock()
+	own, dirty, full := x.own, x.dirty, x.full
+	x.own, x.dirty, x.full = nil, make(map[string]bool), false
+	x.mu.Unlock()
+
+	if len(own) > 0 {
+		b := &batch{quiet: true}
+		for _, p := range own {
+			x.diffPath(p, true, b)
+		}
+		x.apply(b)
+	}
+
+	b := &batch{}
+	if full {
+		metrics.WatchRescans.Inc()
+		x.diffDir(x.root, x.index, true, b)
+	} else {
+		for _, p := range topmost(dirty) {
+			x.diffPath(p, dirty[p], b)
+		}
+	}
+	x.apply(b)
+
+	x.mu.Lock()
+	for _, p := range b.retry {
+		x.dirty[p] = true
+	}
+	x.mu.Unlock()
+}
+
+// topmost пути без тех, что лежат внутри других путей набора
+func topmost(paths map[string]bool) []string {
+	sorted := make([]string, 0, len(paths))
+	for p := range paths {
+		sorted = append(sorted, p)
+	}
+	sort.Strings(sorted)
+
+	var top []string
+	for _, p := range sorted {
+		if n := len(top); n > 0 && paths[top[n-1]] && strings.HasPrefix(p, top[n-1]+string(filepath.Separator)) {
+			continue
+		}
+		top = append(top, p)
+	}
+	return top
+}
+
+// change путь и его узел в индексе
+type change struct {
+	path string
+	node *node
+}
+
+// batch результат сверки
+type batch struct {
+	// quiet только обновить индекс: изменения сделаны через сервер
+	quiet    bool
+	added    []change
+	removed  []change
+	modified []change
+	oldSizes []int64
+	watch    []string
+	retry    []string
+}
+
+// diffPath сверяет path с индексом. Если в индексе нет родительского каталога,
+// сверяется ближайший известный предок.
+func (x *Watcher) diffPath(path string, deep bool, b *batch) {
+	rel, err := filepath.Rel(x.root, path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return
+	}
+
+	parent, dir := x.index, x.root
+	names := strings.Split(rel, string(filepath.Separator))
+	for _, name := range names[:len(names)-1] {
+		next := parent.children[name]
+		if next == nil || !next.dir {
+			// такого каталога в индексе нет, появился он сам
+			x.diff(filepath.Join(dir, name), parent, name, true, b)
+			return
+		}
+		parent, dir = next, filepath.Join(dir, name)
+	}
+	x.diff(path, parent, names[len(names)-1], deep, b)
+}
+
+// diff сверяет parent/name с диском. deep — обойти каталог целиком, иначе
+// только заметить появившиеся и исчезнувшие в нем записи.
+func (x *Watcher) diff(path string, parent *node, name string, deep bool, b *batch) {
+	if x.isBusy(path) {
+		b.retry = append(b.retry, path)
+		return
+	}
+
+	old := parent.children[name]
+	info, err := os.Lstat(path)
+	if err != nil && !os.IsNotExist(err) {
+		log.Warn().Err(err).Str("path", path).Msg("storage watcher can't stat")
+		return
+	}
+	if err != nil || !(info.IsDir() || info.Mode().IsRegular()) {
+		if old != nil {
+			delete(parent.children, name)
+			b.removed = append(b.removed, change{path, old})
+		}
+		return
+	}
+
+	if info.IsDir() {
+		if old != nil && old.dir {
+			old.modTime = info.ModTime().UnixNano()
+			if deep && !x.watched[path] {
+				b.watch = append(b.watch, path)
+			}
+			x.diffDir(path, old, deep, b)
+			return
+		}
+		if old != nil {
+			b.removed = append(b.removed, change{path, old})
+		}
+		n := &node{dir: true, modTime: info.ModTime().UnixNano(), children: make(map[string]*node)}
+		parent.children[name] = n
+		b.added = append(b.added, change{path, n})
+		b.watch = append(b.watch, path)
+		x.diffDir(path, n, true, b)
+		return
+	}
+
+	n := &node{size: info.Size(), modTime: info.ModTime().UnixNano()}
+	switch {
+	case old == nil:
+		b.added = append(b.added, change{path, n})
+	case old.dir:
+		b.removed = append(b.removed, change{path, old})
+		b.added = append(b.added, change{path, n})
+	case old.size != n.size || old.modTime != n.modTime:
+		b.modified = append(b.modified, change{path, n})
+		b.oldSizes = append(b.oldSizes, old.size)
+	default:
+		return
+	}
+	parent.children[name] = n
+}
+
+func (x *Watcher) diffDir(path string, n *node, deep bool, b *batch) {
+	select {
+	case <-x.stop:
+		// обход прерван остановкой
+		return
+	default:
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warn().Err(err).Str("path", path).Msg("storage watcher can't read dir")
+		}
+		return
+	}
+
+	seen := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		name := e.Name()
+		if ignored(name) {
+			continue
+		}
+		seen[name] = true
+		if deep || n.children[name] == nil {
+			x.diff(filepath.Join(path, name), n, name, deep, b)
+		}
+	}
+	for name, child := range n.children {
+		if seen[name] {
+			continue
+		}
+		p := filepath.Join(path, name)
+		if x.isBusy(p) {
+			b.retry = append(b.retry, p)
+			continue
+		}
+		delete(n.children, name)
+		b.removed = append(b.removed, change{p, child})
+	}
+}
+
+// isBusy true если path или его каталог сейчас пишется через сервер
+func (x *Watcher) isBusy(path string) bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if len(x.busy) == 0 {
+		return false
+	}
+	for p := path; len(p) >= len(x.root); p = filepath.Dir(p) {
+		if x.busy[p] > 0 {
+			return true
+		}
+		if p == x.root {
+			break
+		}
+	}
+	return false
+}
+
+func ignored(name string) bool {
+	return strings.HasPrefix(name, tempPrefix)
+}
+
+// apply переставляет наблюдения и сообщает об изменениях
+func (x *Watcher) apply(b *batch) {
+	for _, c := range b.removed {
+		x.unwatch(c.path, c.node)
+	}
+	for _, p := range b.watch {
+		x.watch(p)
+	}
+	if b.quiet {
+		return
+	}
+
+	added := roots(b.added)
+	moved := make(map[int]int)
+	if len(added) > 0 && len(b.removed) > 0 {
+		bySig := make(map[string][]int, len(b.removed))
+		for i, c := range b.removed {
+			s := signature(c.node)
+			bySig[s] = append(bySig[s], i)
+		}
+		for i, c := range added {
+			s := signature(c.node)
+			if from := bySig[s]; len(from) > 0 {
+				moved[i] = from[0]
+				bySig[s] = from[1:]
+			}
+		}
+	}
+	from := make(map[int]bool, len(moved))
+	for _, r := range moved {
+		from[r] = true
+	}
+
+	for i, c := range b.removed {
+		x.repo.Forget(c.path)
+		if from[i] {
+			continue
+		}
+		x.removeUsage(c)
+		size := c.node.total()
+		x.report(domain.AuditRecord{Action: domain.AuditDelete, Path: x.rel(c.path), Size: size})
+	}
+	for i, c := range added {
+		if r, ok := moved[i]; ok {
+			x.moveUsage(b.removed[r], c)
+			x.report(domain.AuditRecord{Action: domain.AuditMove, Path: x.rel(c.path), From: x.rel(b.removed[r].path)})
+			continue
+		}
+		c.node.files(c.path, func(p string, n *node) {
+			x.addUsage(p, 1, n.size)
+			x.report(domain.AuditRecord{Action: domain.AuditUpload, Path: x.rel(p), Size: n.size, SHA256: x.hash(p)})
+		})
+	}
+	for i, c := range b.modified {
+		delta := c.node.size - b.oldSizes[i]
+		x.addUsage(c.path, 0, delta)
+		x.report(domain.AuditRecord{Action: domain.AuditOverwrite, Path: x.rel(c.path), Size: c.node.size, SHA256: x.hash(c.path)})
+	}
+}
+
+func (x *Watcher) addUsage(path string, files, size int64) {
+	if x.usage != nil {
+		x.usage.Add(path, files, size)
+	}
+}
+
+func (x *Watcher) removeUsage(c change) {
+	switch {
+	case x.usage == nil:
+	case c.node.dir:
+		x.usage.RemoveDir(c.path)
+	default:
+		x.usage.Add(c.path, -1, -c.node.size)
+	}
+}
+
+func (x *Watcher) moveUsage(from, to change) {
+	switch {
+	case x.usage == nil:
+	case from.node.dir:
+		x.usage.MoveDir(from.path, to.path)
+	default:
+		x.usage.Add(from.path, -1, -from.node.size)
+		x.usage.Add(to.path, 1, to.node.size)
+	}
+}
+
+func (x *Watcher) report(rec domain.AuditRecord) {
+	rec.Principal = Principal
+	metrics.ExternalChanges.WithLabelValues(string(rec.Action)).Inc()
+	x.notify(rec)
+}
+
+func (x *Watcher) hash(path string) string {
+	sum, err := x.repo.Hash(path)
+	if err != nil && !os.IsNotExist(err) {
+		log.Warn().Err(err).Str("path", path).Msg("storage watcher can't hash")
+	}
+	return sum
+}
+
+// rel путь от корня хранилища, как в аудите
+func (x *Watcher) rel(path string) string {
+	rel, _ := filepath.Rel(x.root, path)
+	return "/" + filepath.ToSlash(rel)
+}
+
+func (x *Watcher) watch(path string) {
+	if x.fsw == nil || x.watched[path] {
+		return
+	}
+	err := x.fsw.Add(path)
+	switch {
+	case err == nil:
+		x.watched[path] = true
+	case errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EMFILE):
+		x.fallback(err)
+	case !errors.Is(err, os.ErrNotExist):
+		log.Warn().Err(err).Str("path", path).Msg("storage watcher can't watch")
+	}
+}
+
+func (x *Watcher) unwatch(path string, n *node) {
+	if !n.dir {
+		return
+	}
+	if x.fsw != nil && x.watched[path] {
+		// после переименования наблюдение осталось бы со старым путем
+		x.fsw.Remove(path)
+	}
+	delete(x.watched, path)
+	for name, child := range n.children {
+		x.unwatch(filepath.Join(path, name), child)
+	}
+}
+
+// fallback переходит на пересканирование: без наблюдения за частью каталогов
+// изменения в них не видны
+func (x *Watcher) fallback(err error) {
+	log.Warn().Err(err).Dur("interval", x.cfg.RescanInterval).Msg("inotify watch limit reached, falling back to storage rescans")
+	x.fsw.Close()
+	x.fsw = nil
+	x.watched = make(map[string]bool)
+	x.mu.Lock()
+	x.full = true
+	x.mu.Unlock()
+	x.signal()
+}
+
+// roots изменения без тех, что лежат внутри других изменений набора
+func roots(changes []change) []change {
+	sort.Slice(changes, func(i, j int) bool { return changes[i].path < changes[j].path })
+	var top []change
+	for _, c := range changes {
+		if n := len(top); n > 0 && top[n-1].node.dir && strings.HasPrefix(c.path, top[n-1].path+string(filepath.Separator)) {
+			continue
+		}
+		top = append(top, c)
+	}
+	return top
+}
+
+// signature одинакова у файла или каталога до и после переименования:
+// размеры, mtime файлов и имена внутри не меняются
+func signature(n *node) string {
+	if !n.dir {
+		return "f" + strconv.FormatInt(n.size, 10) + ":" + strconv.FormatInt(n.modTime, 10)
+	}
+	names := make([]string, 0, len(n.children))
+	for name := range n.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	h.Write([]byte("d"))
+	for _, name := range names {
+		h.Write([]byte("\x00" + name + "\x00" + signature(n.children[name])))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// total размер файла или всех файлов каталога
+func (x *node) total() int64 {
+	if !x.dir {
+		return x.size
+	}
+	var size int64
+	for _, child := range x.children {
+		size += child.total()
+	}
+	return size
+}
+
+// files вызывает fn для файла или каждого файла в каталоге
+func (x *node) files(path string, fn func(path string, n *node)) {
+	if !x.dir {
+		fn(path, x)
+		return
+	}
+	for name, child := range x.children {
+		child.files(filepath.Join(path, name), fn)
+	}
+}
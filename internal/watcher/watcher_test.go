@@ -0,0 +1,157 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+	"github.com/AleksandrMac/fileserver/internal/repository"
+)
+
+// recorder собирает уведомления наблюдателя
+type recorder struct {
+	mu   sync.Mutex
+	recs []string
+}
+
+func (x *recorder) notify(rec domain.AuditRecord) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	s := string(rec.Action) + " " + rec.Path
+	if rec.From != "" {
+		s += " from " + rec.From
+	}
+	if rec.SHA256 != "" {
+		s += " sha"
+	}
+	if rec.Principal != Principal {
+		s += " principal=" + rec.Principal
+	}
+	x.recs = append(x.recs, s)
+}
+
+// wait ждет n уведомлений и возвращает их по порядку путей
+func (x *recorder) wait(t *testing.T, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		x.mu.Lock()
+		if len(x.recs) >= n {
+			recs := x.recs
+			x.recs = nil
+			x.mu.Unlock()
+			sort.Strings(recs)
+			return recs
+		}
+		x.mu.Unlock()
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %d notifications, got %v", n, x.recs)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func write(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	for _, poll := range []bool{false, true} {
+		t.Run("poll="+strconv.FormatBool(poll), func(t *testing.T) {
+			root := t.TempDir()
+			write(t, filepath.Join(root, "files", "old.txt"), "old")
+			write(t, filepath.Join(root, "files", "keep", "a.txt"), "aaaa")
+
+			repo := repository.NewFileRepository(root)
+			rec := &recorder{}
+			usage, err := repository.NewUsageRepository(root, filepath.Join(t.TempDir(), "usage.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			w, err := New(root, repo, usage, Config{Debounce: 20 * time.Millisecond, RescanInterval: 20 * time.Millisecond, Poll: poll})
+			if err != nil {
+				t.Fatal(err)
+			}
+			repo.Observe(w)
+			w.Start(rec.notify)
+			defer w.Stop()
+			<-w.ready
+
+			files := filepath.Join(root, "files")
+			write(t, filepath.Join(files, "new", "deep", "b.txt"), "bb")
+			write(t, filepath.Join(files, "old.txt"), "changed")
+			if got := strings.Join(rec.wait(t, 2), ","); got != "overwrite /files/old.txt sha,upload /files/new/deep/b.txt sha" {
+				t.Errorf("create and modify: %s", got)
+			}
+
+			if err := os.Rename(filepath.Join(files, "new"), filepath.Join(files, "moved")); err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Join(rec.wait(t, 1), ","); got != "move /files/moved from /files/new" {
+				t.Errorf("rename: %s", got)
+			}
+
+			// записи через репозиторий не считаются внешними
+			if err := repo.SaveFile(filepath.Join(files, "api", "c.txt"), strings.NewReader("ccc")); err != nil {
+				t.Fatal(err)
+			}
+			if err := repo.Rename(filepath.Join(files, "keep"), filepath.Join(files, "kept")); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := os.RemoveAll(filepath.Join(files, "moved")); err != nil {
+				t.Fatal(err)
+			}
+			write(t, filepath.Join(files, "moved2", "d.txt"), "d")
+			if got := strings.Join(rec.wait(t, 2), ","); got != "delete /files/moved,upload /files/moved2/d.txt sha" {
+				t.Errorf("delete: %s", got)
+			}
+
+			time.Sleep(100 * time.Millisecond)
+			if got := rec.wait(t, 0); len(got) != 0 {
+				t.Errorf("unexpected notifications: %v", got)
+			}
+			// учет начат после запуска: old 3 -> 7, +2 b.txt, -2 moved, +1 d.txt
+			if got := usage.Usage(root); got.Size != 4+2-2+1 {
+				t.Errorf("usage %+v", got)
+			}
+
+			// внешняя запись в каталог, переименованный через репозиторий
+			write(t, filepath.Join(files, "kept", "e.txt"), "eeeee")
+			if got := strings.Join(rec.wait(t, 1), ","); got != "upload /files/kept/e.txt sha" {
+				t.Errorf("after own rename: %s", got)
+			}
+		})
+	}
+}
+
+func TestWatcherIgnoresTemp(t *testing.T) {
+	root := t.TempDir()
+	repo := repository.NewFileRepository(root)
+	rec := &recorder{}
+	w, err := New(root, repo, nil, Config{Debounce: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Start(rec.notify)
+	defer w.Stop()
+	<-w.ready
+
+	write(t, filepath.Join(root, tempPrefix+"123"), "spool")
+	write(t, filepath.Join(root, "x.txt"), "x")
+	if got := strings.Join(rec.wait(t, 1), ","); got != "upload /x.txt sha" {
+		t.Errorf("got %s", got)
+	}
+}
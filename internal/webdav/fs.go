@@ -0,0 +1,294 @@
+package webdav
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/webdav"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+	"github.com/AleksandrMac/fileserver/internal/interfaces"
+	"github.com/AleksandrMac/fileserver/pkg/hashreader"
+)
+
+// временные файлы записи, в листинги не попадают
+const tempPrefix = ".tmp_"
+
+// fileSystem хранилище для webdav.Handler. Пути проходят ту же проверку
+// GetFullPath, что и в REST, файлы записываются атомарно через SaveFile.
+type fileSystem struct {
+	root   string
+	repo   interfaces.FileRepo
+	props  interfaces.DavPropRepo
+	notify func(rec domain.AuditRecord)
+}
+
+func (x *fileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	name = x.path(name)
+	full, err := x.repo.GetFullPath(name)
+	if err != nil {
+		return os.ErrPermission
+	}
+	if err := x.repo.Mkdir(full); err != nil {
+		return err
+	}
+
+	x.notify(domain.AuditRecord{
+		Action: domain.AuditMkdir,
+		Path:   name,
+	})
+	return nil
+}
+
+func (x *fileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	name = x.path(name)
+	full, err := x.repo.GetFullPath(name)
+	if err != nil {
+		return nil, os.ErrPermission
+	}
+	props := deadProps{props: x.props, name: name}
+
+	// O_RDWR без O_TRUNC открывает PROPPATCH ради свойств, содержимое не меняется
+	if flag&os.O_TRUNC == 0 {
+		f, err := x.repo.ReadFile(full)
+		if err != nil {
+			return nil, err
+		}
+		return &file{File: f, deadProps: props}, nil
+	}
+
+	// PUT, COPY и LOCK перезаписывают файл целиком
+	if dir, _ := x.repo.FileInfo(filepath.Dir(full)); dir == nil || !dir.IsDir() {
+		return nil, os.ErrNotExist
+	}
+	if info, _ := x.repo.FileInfo(full); info != nil && info.IsDir() {
+		return nil, os.ErrExist
+	}
+
+	spool, err := os.CreateTemp(filepath.Dir(full), tempPrefix)
+	if err != nil {
+		return nil, err
+	}
+	return &writer{File: spool, deadProps: props, fs: x, full: full}, nil
+}
+
+func (x *fileSystem) RemoveAll(ctx context.Context, name string) error {
+	name = x.path(name)
+	full, err := x.repo.GetFullPath(name)
+	if err != nil {
+		return os.ErrPermission
+	}
+
+	if err := x.repo.Remove(full); err != nil {
+		return err
+	}
+	if err := x.props.Delete(name); err != nil {
+		log.Warn().Err(err).Str("path", name).Msg("failed delete webdav properties")
+	}
+
+	x.notify(domain.AuditRecord{
+		Action: domain.AuditDelete,
+		Path:   name,
+	})
+	return nil
+}
+
+func (x *fileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	oldName, newName = x.path(oldName), x.path(newName)
+	oldFull, err := x.repo.GetFullPath(oldName)
+	if err != nil {
+		return os.ErrPermission
+	}
+	newFull, err := x.repo.GetFullPath(newName)
+	if err != nil {
+		return os.ErrPermission
+	}
+
+	if err := x.repo.Rename(oldFull, newFull); err != nil {
+		return err
+	}
+	if err := x.props.Move(oldName, newName); err != nil {
+		log.Warn().Err(err).Str("path", newName).Msg("failed move webdav properties")
+	}
+
+	x.notify(domain.AuditRecord{
+		Action: domain.AuditMove,
+		Path:   newName,
+		From:   oldName,
+	})
+	return nil
+}
+
+func (x *fileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	full, err := x.repo.GetFullPath(x.path(name))
+	if err != nil {
+		return nil, os.ErrPermission
+	}
+
+	info, _ := x.repo.FileInfo(full)
+	if info == nil {
+		return nil, os.ErrNotExist
+	}
+	return info, nil
+}
+
+// path путь в хранилище для пути name внутри WebDAV
+func (x *fileSystem) path(name string) string {
+	return path.Join(x.root, "/"+name)
+}
+
+// file открытый на чтение файл или каталог
+type file struct {
+	*os.File
+	deadProps
+}
+
+func (x *file) Readdir(count int) ([]os.FileInfo, error) {
+	infos, err := x.File.Readdir(count)
+
+	visible := infos[:0]
+	for _, info := range infos {
+		if !strings.HasPrefix(info.Name(), tempPrefix) {
+			visible = append(visible, info)
+		}
+	}
+	return visible, err
+}
+
+// writer накапливает содержимое во временном файле и при закрытии
+// заменяет им файл хранилища. webdav.Handler закрывает файл и после
+// оборванного PUT, поэтому ошибка записи запоминается, и тогда Close
+// только удаляет временный файл.
+type writer struct {
+	*os.File
+	deadProps
+	fs     *fileSystem
+	full   string
+	failed error
+}
+
+func (x *writer) Write(p []byte) (int, error) {
+	n, err := x.File.Write(p)
+	if err != nil {
+		x.failed = err
+	}
+	return n, err
+}
+
+// ReadFrom io.Copy из тела запроса: ошибка чтения значит, что клиент
+// не дослал содержимое
+func (x *writer) ReadFrom(r io.Reader) (int64, error) {
+	n, err := x.File.ReadFrom(r)
+	if err != nil {
+		x.failed = err
+	}
+	return n, err
+}
+
+func (x *writer) Stat() (os.FileInfo, error) {
+	info, err := x.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return namedInfo{FileInfo: info, name: path.Base(x.name)}, nil
+}
+
+func (x *writer) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, os.ErrInvalid
+}
+
+func (x *writer) Close() error {
+	defer os.Remove(x.File.Name())
+
+	if x.failed != nil {
+		x.File.Close()
+		return x.failed
+	}
+	if _, err := x.File.Seek(0, io.SeekStart); err != nil {
+		x.File.Close()
+		return err
+	}
+
+	old, _ := x.fs.repo.FileInfo(x.full)
+	hr := hashreader.New(x.File)
+	err := x.fs.repo.SaveFile(x.full, hr)
+	if closeErr := x.File.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	action := domain.AuditUpload
+	if old != nil {
+		action = domain.AuditOverwrite
+	}
+	x.fs.notify(domain.AuditRecord{
+		Action: action,
+		Path:   x.name,
+		Size:   hr.Size(),
+		SHA256: hr.Sum(),
+	})
+	return nil
+}
+
+type namedInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (x namedInfo) Name() string {
+	return x.name
+}
+
+// deadProps свойства ресурса name, заданные клиентом (webdav.DeadPropsHolder)
+type deadProps struct {
+	props interfaces.DavPropRepo
+	name  string
+}
+
+func (x deadProps) DeadProps() (map[xml.Name]webdav.Property, error) {
+	res := make(map[xml.Name]webdav.Property)
+	for _, p := range x.props.Get(x.name) {
+		n := xml.Name{Space: p.Space, Local: p.Local}
+		res[n] = webdav.Property{XMLName: n, Lang: p.Lang, InnerXML: []byte(p.InnerXML)}
+	}
+	return res, nil
+}
+
+func (x deadProps) Patch(patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	current, _ := x.DeadProps()
+
+	pstat := webdav.Propstat{Status: http.StatusOK}
+	for _, patch := range patches {
+		for _, p := range patch.Props {
+			pstat.Props = append(pstat.Props, webdav.Property{XMLName: p.XMLName})
+			if patch.Remove {
+				delete(current, p.XMLName)
+				continue
+			}
+			current[p.XMLName] = p
+		}
+	}
+
+	props := make([]domain.DavProperty, 0, len(current))
+	for n, p := range current {
+		props = append(props, domain.DavProperty{Space: n.Space, Local: n.Local, Lang: p.Lang, InnerXML: string(p.InnerXML)})
+	}
+	sort.Slice(props, func(i, j int) bool {
+		return props[i].Space+" "+props[i].Local < props[j].Space+" "+props[j].Local
+	})
+	if err := x.props.Put(x.name, props); err != nil {
+		return nil, err
+	}
+
+	return []webdav.Propstat{pstat}, nil
+}
@@ -0,0 +1,141 @@
+package webdav
+
+import (
+	"encoding/xml"
+	"errors"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/net/webdav"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+	"github.com/AleksandrMac/fileserver/internal/interfaces"
+)
+
+// tempToken блокировка на время одного запроса без заголовка If. Такие
+// блокировки только проверяют, что ресурс свободен, и в реестр не попадают.
+const tempToken = "opaquelocktoken:00000000-0000-0000-0000-000000000000"
+
+// lockSystem блокировки WebDAV в общем реестре interfaces.LockUsecase
+type lockSystem struct {
+	root      string
+	locks     interfaces.LockUsecase
+	owner     string
+	notify    func(rec domain.AuditRecord)
+	temporary bool
+}
+
+func (x *lockSystem) Confirm(now time.Time, name0, name1 string, conditions ...webdav.Condition) (func(), error) {
+	for _, name := range []string{name0, name1} {
+		if name != "" && !x.confirmed(name, conditions) {
+			return nil, webdav.ErrConfirmationFailed
+		}
+	}
+	return func() {}, nil
+}
+
+// confirmed true если условие из If называет токен блокировки, под которой
+// находится name, и запись с этим токеном не конфликтует с другими блокировками
+func (x *lockSystem) confirmed(name string, conditions []webdav.Condition) bool {
+	name = x.path(name)
+	for _, c := range conditions {
+		lock := x.locks.ByToken(c.Token)
+		if lock == nil || !covers(lock, name) {
+			continue
+		}
+		if _, err := x.locks.CheckWrite(name, c.Token); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (x *lockSystem) Create(now time.Time, details webdav.LockDetails) (string, error) {
+	root := x.path(details.Root)
+	if x.temporary {
+		if _, err := x.locks.CheckWrite(root, ""); err != nil {
+			return "", webdav.ErrLocked
+		}
+		return tempToken, nil
+	}
+
+	lock, err := x.locks.Lock(root, x.owner, "", !details.ZeroDepth, details.Duration)
+	if errors.Is(err, domain.ErrLocked) {
+		return "", webdav.ErrLocked
+	}
+	if err != nil {
+		return "", err
+	}
+
+	x.notify(domain.AuditRecord{
+		Action: domain.AuditLock,
+		Path:   lock.Path,
+		Reason: "owner " + lock.Owner,
+	})
+	return lock.Token, nil
+}
+
+func (x *lockSystem) Refresh(now time.Time, token string, duration time.Duration) (webdav.LockDetails, error) {
+	lock := x.locks.ByToken(token)
+	if lock == nil {
+		return webdav.LockDetails{}, webdav.ErrNoSuchLock
+	}
+
+	lock, err := x.locks.Lock(lock.Path, lock.Owner, token, lock.Deep, duration)
+	if errors.Is(err, domain.ErrLocked) {
+		return webdav.LockDetails{}, webdav.ErrLocked
+	}
+	if err != nil {
+		return webdav.LockDetails{}, err
+	}
+
+	return webdav.LockDetails{
+		Root:      path.Join("/", strings.TrimPrefix(lock.Path, x.root)),
+		Duration:  time.Until(lock.Expires).Round(time.Second),
+		OwnerXML:  escape(lock.Owner),
+		ZeroDepth: !lock.Deep,
+	}, nil
+}
+
+func (x *lockSystem) Unlock(now time.Time, token string) error {
+	if token == tempToken {
+		return nil
+	}
+
+	lock := x.locks.ByToken(token)
+	if lock == nil {
+		return webdav.ErrNoSuchLock
+	}
+	switch err := x.locks.Unlock(lock.Path, token); {
+	case errors.Is(err, domain.ErrNotFound):
+		return webdav.ErrNoSuchLock
+	case errors.Is(err, domain.ErrForbidden):
+		return webdav.ErrForbidden
+	case err != nil:
+		return err
+	}
+
+	x.notify(domain.AuditRecord{Action: domain.AuditUnlock, Path: lock.Path})
+	return nil
+}
+
+// path путь в хранилище для пути name внутри WebDAV
+func (x *lockSystem) path(name string) string {
+	return path.Join(x.root, "/"+name)
+}
+
+// covers true если блокировка действует на name
+func covers(lock *domain.Lock, name string) bool {
+	if name == lock.Path {
+		return true
+	}
+	return lock.Deep && (lock.Path == "/" || strings.HasPrefix(name, lock.Path+"/"))
+}
+
+// escape текст владельца для элемента owner в ответе LOCK
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,91 @@
+package webdav
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/webdav"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+	"github.com/AleksandrMac/fileserver/internal/interfaces"
+)
+
+// Methods методы WebDAV сверх стандартных HTTP
+var Methods = []string{"PROPFIND", "PROPPATCH", "MKCOL", "COPY", "MOVE", "LOCK", "UNLOCK"}
+
+// Server WebDAV (классы 1 и 2) поверх хранилища. Блокировки LOCK общие
+// с REST API и сессиями OnlyOffice, свойства PROPPATCH хранятся в props.
+type Server struct {
+	prefix string
+	root   string
+	repo   interfaces.FileRepo
+	locks  interfaces.LockUsecase
+	props  interfaces.DavPropRepo
+}
+
+// New WebDAV по адресу prefix. Корень WebDAV — каталог root хранилища,
+// тот же, что отдается по REST.
+func New(prefix, root string, repo interfaces.FileRepo, locks interfaces.LockUsecase, props interfaces.DavPropRepo) *Server {
+	return &Server{
+		prefix: prefix,
+		root:   path.Clean("/" + root),
+		repo:   repo,
+		locks:  locks,
+		props:  props,
+	}
+}
+
+func (x *Server) Serve(w http.ResponseWriter, r *http.Request, owner string, notify func(rec domain.AuditRecord)) {
+	h := &webdav.Handler{
+		Prefix: x.prefix,
+		FileSystem: &fileSystem{
+			root:   x.root,
+			repo:   x.repo,
+			props:  x.props,
+			notify: notify,
+		},
+		LockSystem: &lockSystem{
+			root:   x.root,
+			locks:  x.locks,
+			owner:  owner,
+			notify: notify,
+			// вне LOCK обработчик блокирует ресурс на время запроса
+			temporary: r.Method != "LOCK",
+		},
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				log.Debug().Err(err).Str("method", r.Method).Str("path", r.URL.Path).Msg("webdav request failed")
+			}
+		},
+	}
+
+	// x/net/webdav отвечает 403 на MOVE в несуществующий каталог,
+	// RFC 4918 и litmus ждут 409
+	if r.Method == "MOVE" && x.missingParent(r.Header.Get("Destination")) {
+		http.Error(w, http.StatusText(http.StatusConflict), http.StatusConflict)
+		return
+	}
+	h.ServeHTTP(w, r)
+}
+
+// missingParent true если родительского каталога destination нет в хранилище
+func (x *Server) missingParent(destination string) bool {
+	u, err := url.Parse(destination)
+	if err != nil || !strings.HasPrefix(u.Path, x.prefix) {
+		return false
+	}
+	name := path.Clean("/" + strings.TrimPrefix(u.Path, x.prefix))
+	if name == "/" {
+		return false
+	}
+	full, err := x.repo.GetFullPath(path.Join(x.root, path.Dir(name)))
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(full)
+	return os.IsNotExist(err)
+}
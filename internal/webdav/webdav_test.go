@@ -0,0 +1,329 @@
+package webdav
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+	"github.com/AleksandrMac/fileserver/internal/repository"
+	"github.com/AleksandrMac/fileserver/internal/usecase"
+)
+
+const lockBody = `<?xml version="1.0"?><D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype><D:owner>litmus</D:owner></D:lockinfo>`
+
+// testServer WebDAV под /dav над каталогом files хранилища
+type testServer struct {
+	storage string
+	srv     *Server
+	locks   *usecase.Locks
+	props   *repository.DavPropRepository
+
+	actions []string
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	x := &testServer{storage: t.TempDir()}
+	if err := os.MkdirAll(filepath.Join(x.storage, "files"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	data := t.TempDir()
+	keys, err := repository.NewDocKeyRepository(filepath.Join(data, "keys.json"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lockRepo, err := repository.NewLockRepository(filepath.Join(data, "locks.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x.props, err = repository.NewDavPropRepository(filepath.Join(data, "props.json")); err != nil {
+		t.Fatal(err)
+	}
+	x.locks = usecase.NewLocks(lockRepo, usecase.NewSessions(), keys)
+	x.srv = New("/dav", "/files", repository.NewFileRepository(x.storage), x.locks, x.props)
+	return x
+}
+
+func (x *testServer) do(method, target, body string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	x.srv.Serve(w, r, "api-key", func(rec domain.AuditRecord) {
+		x.actions = append(x.actions, string(rec.Action)+" "+rec.Path)
+	})
+	return w
+}
+
+func TestServer(t *testing.T) {
+	x := newTestServer(t)
+	storage, locks, props, do := x.storage, x.locks, x.props, x.do
+
+	const propBody = `<?xml version="1.0"?><D:propertyupdate xmlns:D="DAV:" xmlns:Z="urn:test"><D:set><D:prop><Z:color>red</Z:color></D:prop></D:set></D:propertyupdate>`
+
+	steps := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		header []string
+		want   int
+	}{
+		{name: "mkcol", method: "MKCOL", target: "/dav/dir", want: http.StatusCreated},
+		{name: "mkcol again", method: "MKCOL", target: "/dav/dir", want: http.StatusMethodNotAllowed},
+		{name: "mkcol no parent", method: "MKCOL", target: "/dav/nope/dir", want: http.StatusConflict},
+		{name: "put", method: http.MethodPut, target: "/dav/dir/a.txt", body: "hello", want: http.StatusCreated},
+		{name: "put no parent", method: http.MethodPut, target: "/dav/nope/a.txt", body: "x", want: http.StatusConflict},
+		{name: "get", method: http.MethodGet, target: "/dav/dir/a.txt", want: http.StatusOK},
+		{name: "proppatch", method: "PROPPATCH", target: "/dav/dir/a.txt", body: propBody, want: http.StatusMultiStatus},
+		{name: "move", method: "MOVE", target: "/dav/dir/a.txt", header: []string{"Destination", "http://example.com/dav/dir/b.txt"}, want: http.StatusCreated},
+		{name: "copy", method: "COPY", target: "/dav/dir/b.txt", header: []string{"Destination", "http://example.com/dav/dir/c.txt"}, want: http.StatusCreated},
+		{name: "copy no overwrite", method: "COPY", target: "/dav/dir/b.txt", header: []string{"Destination", "http://example.com/dav/dir/c.txt", "Overwrite", "F"}, want: http.StatusPreconditionFailed},
+		{name: "delete", method: http.MethodDelete, target: "/dav/dir/c.txt", want: http.StatusNoContent},
+		{name: "delete missing", method: http.MethodDelete, target: "/dav/dir/c.txt", want: http.StatusNotFound},
+	}
+	for _, s := range steps {
+		if w := do(s.method, s.target, s.body, s.header...); w.Code != s.want {
+			t.Fatalf("%s: status = %d, want %d: %s", s.name, w.Code, s.want, w.Body)
+		}
+	}
+
+	// файл лежит в корне REST, свойство переехало вместе с ним
+	if content, _ := os.ReadFile(filepath.Join(storage, "files", "dir", "b.txt")); string(content) != "hello" {
+		t.Errorf("b.txt = %q, want hello", content)
+	}
+	w := do("PROPFIND", "/dav/dir/", `<?xml version="1.0"?><D:propfind xmlns:D="DAV:"><D:allprop/></D:propfind>`, "Depth", "1")
+	if body := w.Body.String(); !strings.Contains(body, "/dav/dir/b.txt") || !strings.Contains(body, "red") || strings.Contains(body, tempPrefix) {
+		t.Errorf("propfind = %s", body)
+	}
+
+	// блокировка файла видна REST и требует токен
+	w = do("LOCK", "/dav/dir/b.txt", lockBody, "Depth", "0", "Timeout", "Second-600")
+	if w.Code != http.StatusOK {
+		t.Fatalf("lock: status = %d: %s", w.Code, w.Body)
+	}
+	token := strings.Trim(w.Header().Get("Lock-Token"), "<>")
+	if _, err := locks.CheckWrite("/files/dir/b.txt", ""); !errors.Is(err, domain.ErrLocked) {
+		t.Errorf("REST write to WebDAV-locked file error = %v, want ErrLocked", err)
+	}
+	if w := do(http.MethodPut, "/dav/dir/b.txt", "other"); w.Code != http.StatusLocked {
+		t.Errorf("put without token: status = %d, want 423", w.Code)
+	}
+	if w := do(http.MethodPut, "/dav/dir/b.txt", "bye", "If", "(<"+token+">)"); w.Code != http.StatusCreated {
+		t.Errorf("put with token: status = %d, want 201", w.Code)
+	}
+	if w := do("LOCK", "/dav/dir/b.txt", "", "If", "(<"+token+">)", "Timeout", "Second-900"); w.Code != http.StatusOK {
+		t.Errorf("refresh: status = %d, want 200", w.Code)
+	}
+	if w := do("UNLOCK", "/dav/dir/b.txt", "", "Lock-Token", "<"+token+">"); w.Code != http.StatusNoContent {
+		t.Errorf("unlock: status = %d, want 204", w.Code)
+	}
+
+	// блокировка каталога на всю глубину
+	w = do("LOCK", "/dav/dir", lockBody)
+	if w.Code != http.StatusOK {
+		t.Fatalf("lock collection: status = %d: %s", w.Code, w.Body)
+	}
+	token = strings.Trim(w.Header().Get("Lock-Token"), "<>")
+	if w := do(http.MethodPut, "/dav/dir/new.txt", "x"); w.Code != http.StatusLocked {
+		t.Errorf("put into locked collection: status = %d, want 423", w.Code)
+	}
+	if w := do(http.MethodPut, "/dav/dir/new.txt", "x", "If", "(<"+token+">)"); w.Code != http.StatusCreated {
+		t.Errorf("put into locked collection with token: status = %d, want 201", w.Code)
+	}
+	if w := do(http.MethodDelete, "/dav/dir", "", "If", "(<opaquelocktoken:00000000-0000-0000-0000-000000000001>)"); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("delete with foreign token: status = %d, want 412", w.Code)
+	}
+	if w := do("UNLOCK", "/dav/dir", "", "Lock-Token", "<"+token+">"); w.Code != http.StatusNoContent {
+		t.Errorf("unlock collection: status = %d, want 204", w.Code)
+	}
+
+	if w := do(http.MethodDelete, "/dav/dir", ""); w.Code != http.StatusNoContent {
+		t.Errorf("delete collection: status = %d, want 204", w.Code)
+	}
+	if all, _ := locks.List(); len(all) != 0 {
+		t.Errorf("locks left = %+v", all)
+	}
+	if got := props.Get("/files/dir/b.txt"); len(got) != 0 {
+		t.Errorf("properties left after delete = %+v", got)
+	}
+	if len(x.actions) != 12 || x.actions[0] != "mkdir /files/dir" {
+		t.Errorf("audit = %v", x.actions)
+	}
+}
+
+// TestLitmus повторяет группы basic, copymove и locks набора litmus.
+// {token} в заголовках заменяется токеном последнего LOCK.
+func TestLitmus(t *testing.T) {
+	x := newTestServer(t)
+	const (
+		coll    = "/dav/litmus/"
+		foreign = "(<opaquelocktoken:00000000-0000-0000-0000-000000000001>)"
+	)
+	dest := func(p string) []string { return []string{"Destination", "http://example.com" + coll + p} }
+
+	groups := []struct {
+		name  string
+		steps []struct {
+			name, method, target, body string
+			header                     []string
+			want                       int
+		}
+	}{
+		{name: "basic", steps: []struct {
+			name, method, target, body string
+			header                     []string
+			want                       int
+		}{
+			{"begin", "MKCOL", coll, "", nil, http.StatusCreated},
+			{"options", http.MethodOptions, coll, "", nil, http.StatusOK},
+			{"put_get", http.MethodPut, coll + "res", "This is\na test file.\n", nil, http.StatusCreated},
+			{"put_get", http.MethodGet, coll + "res", "", nil, http.StatusOK},
+			{"put_get_utf8_segment", http.MethodPut, coll + "res-%e2%82%ac", "euro", nil, http.StatusCreated},
+			{"put_get_utf8_segment", http.MethodGet, coll + "res-%e2%82%ac", "", nil, http.StatusOK},
+			{"put_no_parent", http.MethodPut, coll + "409me/noparent.txt", "x", nil, http.StatusConflict},
+			{"mkcol_over_plain", "MKCOL", coll + "res", "", nil, http.StatusMethodNotAllowed},
+			{"delete", http.MethodDelete, coll + "res", "", nil, http.StatusNoContent},
+			{"delete_null", http.MethodDelete, coll + "404me", "", nil, http.StatusNotFound},
+			{"mkcol", "MKCOL", coll + "coll/", "", nil, http.StatusCreated},
+			{"mkcol_again", "MKCOL", coll + "coll/", "", nil, http.StatusMethodNotAllowed},
+			{"delete_coll", http.MethodDelete, coll + "coll/", "", nil, http.StatusNoContent},
+			{"mkcol_no_parent", "MKCOL", coll + "409me/noparent/", "", nil, http.StatusConflict},
+			{"mkcol_with_body", "MKCOL", coll + "mkcolbody", "<x/>", []string{"Content-Type", "xzy-foo/bar-512"}, http.StatusUnsupportedMediaType},
+		}},
+		{name: "copymove", steps: []struct {
+			name, method, target, body string
+			header                     []string
+			want                       int
+		}{
+			{"copy_init", http.MethodPut, coll + "copysrc", "source", nil, http.StatusCreated},
+			{"copy_init", "MKCOL", coll + "copycoll/", "", nil, http.StatusCreated},
+			{"copy_simple", "COPY", coll + "copysrc", "", dest("copydest"), http.StatusCreated},
+			{"copy_overwrite", "COPY", coll + "copysrc", "", append(dest("copydest"), "Overwrite", "F"), http.StatusPreconditionFailed},
+			{"copy_overwrite", "COPY", coll + "copysrc", "", append(dest("copydest"), "Overwrite", "T"), http.StatusNoContent},
+			{"copy_overwrite", "COPY", coll + "copysrc", "", append(dest("copycoll/"), "Overwrite", "T"), http.StatusNoContent},
+			{"copy_nodestcoll", "COPY", coll + "copysrc", "", dest("nonesuch/foo"), http.StatusConflict},
+			{"copy_cleanup", http.MethodDelete, coll + "copydest", "", nil, http.StatusNoContent},
+			{"copy_coll", "MKCOL", coll + "ccsrc/", "", nil, http.StatusCreated},
+			{"copy_coll", http.MethodPut, coll + "ccsrc/foo", "foo", nil, http.StatusCreated},
+			{"copy_coll", "MKCOL", coll + "ccsrc/subcoll/", "", nil, http.StatusCreated},
+			{"copy_coll", "COPY", coll + "ccsrc/", "", dest("ccdest/"), http.StatusCreated},
+			{"copy_coll", http.MethodGet, coll + "ccdest/foo", "", nil, http.StatusOK},
+			{"copy_coll", "COPY", coll + "ccsrc/", "", append(dest("ccdest/"), "Overwrite", "F"), http.StatusPreconditionFailed},
+			{"copy_shallow", "COPY", coll + "ccsrc/", "", append(dest("ccshallow/"), "Depth", "0"), http.StatusCreated},
+			{"copy_shallow", http.MethodGet, coll + "ccshallow/foo", "", nil, http.StatusNotFound},
+			{"move", http.MethodPut, coll + "move", "move", nil, http.StatusCreated},
+			{"move", "MOVE", coll + "move", "", dest("movedest"), http.StatusCreated},
+			{"move", "MOVE", coll + "movedest", "", append(dest("copysrc"), "Overwrite", "F"), http.StatusPreconditionFailed},
+			{"move", "MOVE", coll + "movedest", "", append(dest("copysrc"), "Overwrite", "T"), http.StatusNoContent},
+			{"move", "MOVE", coll + "copysrc", "", dest("nonesuch/foo"), http.StatusConflict},
+			{"move_coll", "MOVE", coll + "ccsrc/", "", dest("mvdest/"), http.StatusCreated},
+			{"move_coll", http.MethodGet, coll + "mvdest/foo", "", nil, http.StatusOK},
+			{"move_coll", http.MethodGet, coll + "ccsrc/foo", "", nil, http.StatusNotFound},
+			{"move_cleanup", http.MethodDelete, coll + "mvdest/", "", nil, http.StatusNoContent},
+		}},
+		{name: "locks", steps: []struct {
+			name, method, target, body string
+			header                     []string
+			want                       int
+		}{
+			{"precond", http.MethodPut, coll + "lockme", "x", []string{"If", foreign}, http.StatusPreconditionFailed},
+			{"put", http.MethodPut, coll + "lockme", "lockme", nil, http.StatusCreated},
+			{"lock_excl", "LOCK", coll + "lockme", lockBody, []string{"Depth", "0", "Timeout", "Second-3600"}, http.StatusOK},
+			{"discover", "PROPFIND", coll + "lockme", `<?xml version="1.0"?><D:propfind xmlns:D="DAV:"><D:prop><D:lockdiscovery/></D:prop></D:propfind>`, []string{"Depth", "0"}, http.StatusMultiStatus},
+			{"refresh", "LOCK", coll + "lockme", "", []string{"If", "(<{token}>)", "Timeout", "Second-7200"}, http.StatusOK},
+			{"notowner_modify", http.MethodPut, coll + "lockme", "other", nil, http.StatusLocked},
+			{"notowner_modify", http.MethodDelete, coll + "lockme", "", nil, http.StatusLocked},
+			{"notowner_modify", "MOVE", coll + "lockme", "", dest("notlock"), http.StatusLocked},
+			{"notowner_lock", "LOCK", coll + "lockme", lockBody, []string{"Depth", "0"}, http.StatusLocked},
+			{"owner_modify", http.MethodPut, coll + "lockme", "owner", []string{"If", "(<{token}>)"}, http.StatusCreated},
+			{"owner_modify", "PROPPATCH", coll + "lockme", `<?xml version="1.0"?><D:propertyupdate xmlns:D="DAV:" xmlns:Z="urn:litmus"><D:set><D:prop><Z:a>b</Z:a></D:prop></D:set></D:propertyupdate>`, []string{"If", "(<{token}>)"}, http.StatusMultiStatus},
+			{"copy", "COPY", coll + "lockme", "", dest("lockme-copy"), http.StatusCreated},
+			{"cond_put_corrupt_token", http.MethodPut, coll + "lockme", "x", []string{"If", "(<{token}x>)"}, http.StatusPreconditionFailed},
+			{"fail_cond_put", http.MethodPut, coll + "lockme", "x", []string{"If", foreign}, http.StatusPreconditionFailed},
+			{"unlock", "UNLOCK", coll + "lockme", "", []string{"Lock-Token", "<{token}>"}, http.StatusNoContent},
+			{"fail_cond_put_unlocked", http.MethodPut, coll + "lockme", "x", []string{"If", "(<{token}>)"}, http.StatusPreconditionFailed},
+			{"lock_collection", "LOCK", coll, lockBody, []string{"Depth", "infinity"}, http.StatusOK},
+			{"owner_modify", http.MethodPut, coll + "lockme", "owner", []string{"If", "(<{token}>)"}, http.StatusCreated},
+			{"notowner_modify", http.MethodPut, coll + "lockme", "other", nil, http.StatusLocked},
+			{"notowner_modify", "MKCOL", coll + "newcoll/", "", nil, http.StatusLocked},
+			{"refresh", "LOCK", coll, "", []string{"If", "(<{token}>)"}, http.StatusOK},
+			{"indirect_refresh", "LOCK", coll + "lockme", "", []string{"If", "(<{token}>)"}, http.StatusOK},
+			{"unlock", "UNLOCK", coll, "", []string{"Lock-Token", "<{token}>"}, http.StatusNoContent},
+			{"unmapped_lock", "LOCK", coll + "unmapped", lockBody, []string{"Depth", "0"}, http.StatusCreated},
+			{"unmapped_lock", "UNLOCK", coll + "unmapped", "", []string{"Lock-Token", "<{token}>"}, http.StatusNoContent},
+			{"finish", http.MethodDelete, coll, "", nil, http.StatusNoContent},
+		}},
+	}
+
+	token := ""
+	for _, g := range groups {
+		t.Run(g.name, func(t *testing.T) {
+			for _, s := range g.steps {
+				header := make([]string, len(s.header))
+				for i, h := range s.header {
+					header[i] = strings.ReplaceAll(h, "{token}", token)
+				}
+				w := x.do(s.method, s.target, s.body, header...)
+				if w.Code != s.want {
+					t.Fatalf("%s: %s %s: status = %d, want %d: %s", s.name, s.method, s.target, w.Code, s.want, w.Body)
+				}
+				if t := w.Header().Get("Lock-Token"); s.method == "LOCK" && t != "" {
+					token = strings.Trim(t, "<>")
+				}
+			}
+		})
+	}
+
+	if all, _ := x.locks.List(); len(all) != 0 {
+		t.Errorf("locks left = %+v", all)
+	}
+}
+
+// TestAbortedPut клиент обрывает соединение посреди PUT: прежнее
+// содержимое файла остается, временный файл удаляется
+func TestAbortedPut(t *testing.T) {
+	x := newTestServer(t)
+	file := filepath.Join(x.storage, "files", "a.txt")
+	if err := os.WriteFile(file, []byte("original"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		x.srv.Serve(w, r, "api-key", func(rec domain.AuditRecord) {
+			t.Errorf("aborted put audited: %+v", rec)
+		})
+	}))
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(conn, "PUT /dav/a.txt HTTP/1.1\r\nHost: example.com\r\nContent-Length: 100\r\n\r\npartial")
+	conn.Close()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("put was not finished")
+	}
+	if data, _ := os.ReadFile(file); string(data) != "original" {
+		t.Errorf("content = %q", data)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(file))
+	if len(entries) != 1 {
+		t.Errorf("spool left: %v", entries)
+	}
+}
@@ -0,0 +1,232 @@
+// Package client клиент REST API файлового сервера. Загрузка и чтение идут
+// через REST, запись потоком, удаление и перенос — через WebDAV, загрузка
+// по частям и подписанные ссылки — через S3 API того же сервера.
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// errNoRewind тело запроса нельзя отправить повторно
+var errNoRewind = errors.New("request body can't be rewound for retry")
+
+// Config адреса и ключи сервера. Пустые WebDAVPrefix и S3URL отключают методы,
+// которым они нужны: они возвращают ErrNotConfigured.
+type Config struct {
+	// URL адрес REST API, например http://files:8080
+	URL string
+	// APIKey ключ API_KEY сервера, нужен для записи
+	APIKey string
+	// StoragePrefix STORAGE_PATH_URL сервера, по умолчанию "/"
+	StoragePrefix string
+	// WebDAVPrefix WEBDAV_PREFIX сервера: Put, Delete и Move
+	WebDAVPrefix string
+	// S3URL адрес S3 API (S3_PORT): загрузка по частям и подписанные ссылки
+	S3URL string
+	// S3AccessKey S3_ACCESS_KEY сервера, по умолчанию fileserver
+	S3AccessKey string
+	// Retries сколько раз повторить запрос после 429, а идемпотентный (GET, PUT,
+	// DELETE) и после сетевой ошибки или 5xx
+	Retries int
+	// Backoff пауза перед первым повтором, дальше удваивается. По умолчанию 500 мс.
+	Backoff time.Duration
+	// PartSize размер части загрузки по частям, по умолчанию 8 МиБ
+	PartSize int64
+	// HTTPClient по умолчанию http.DefaultClient
+	HTTPClient *http.Client
+}
+
+type Client struct {
+	cfg  Config
+	base *url.URL
+	s3   *url.URL
+	http *http.Client
+}
+
+func New(cfg Config) (*Client, error) {
+	base, err := url.Parse(strings.TrimSuffix(cfg.URL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, errors.New("client: URL must be http or https")
+	}
+
+	x := &Client{cfg: cfg, base: base, http: cfg.HTTPClient}
+	if cfg.S3URL != "" {
+		if x.s3, err = url.Parse(strings.TrimSuffix(cfg.S3URL, "/")); err != nil {
+			return nil, err
+		}
+	}
+	if x.http == nil {
+		x.http = http.DefaultClient
+	}
+	if x.cfg.StoragePrefix == "" {
+		x.cfg.StoragePrefix = "/"
+	}
+	if x.cfg.S3AccessKey == "" {
+		x.cfg.S3AccessKey = "fileserver"
+	}
+	if x.cfg.Backoff <= 0 {
+		x.cfg.Backoff = 500 * time.Millisecond
+	}
+	if x.cfg.PartSize <= 0 {
+		x.cfg.PartSize = 8 << 20
+	}
+	return x, nil
+}
+
+// request запрос к серверу. body вызывается перед каждой попыткой.
+type request struct {
+	method string
+	url    string
+	header http.Header
+	body   func() (io.Reader, error)
+	length int64
+	// s3 подписать запрос SigV4
+	s3 bool
+}
+
+// do выполняет запрос с повторами и возвращает ответ со статусом из ok,
+// остальные статусы превращаются в *StatusError
+func (x *Client) do(ctx context.Context, req *request, ok ...int) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := x.send(ctx, req, ok)
+		if err == nil || !retryable(req.method, err) || attempt >= x.cfg.Retries {
+			return resp, err
+		}
+		if err := x.pause(ctx, attempt, err); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// pause ждет перед повтором attempt: Backoff, удвоенный за каждый прошлый
+// повтор, или Retry-After сервера, если он дольше
+func (x *Client) pause(ctx context.Context, attempt int, err error) error {
+	wait := x.cfg.Backoff << attempt
+	var se *StatusError
+	if errors.As(err, &se) && se.RetryAfter > wait {
+		wait = se.RetryAfter
+	}
+
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+func (x *Client) send(ctx context.Context, req *request, ok []int) (*http.Response, error) {
+	var body io.Reader
+	if req.body != nil {
+		var err error
+		if body, err = req.body(); err != nil {
+			return nil, err
+		}
+	}
+
+	r, err := http.NewRequestWithContext(ctx, req.method, req.url, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		r.ContentLength = req.length
+		if req.length == 0 {
+			r.Body = http.NoBody
+		}
+	}
+	if form, ok := body.(*formBody); ok {
+		r.Header.Set("Content-Type", form.contentType)
+	}
+	for k, v := range req.header {
+		r.Header[k] = v
+	}
+	if req.s3 {
+		x.sign(r, time.Now())
+	} else if x.cfg.APIKey != "" {
+		r.Header.Set("X-API-Key", x.cfg.APIKey)
+	}
+
+	resp, err := x.http.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range ok {
+		if resp.StatusCode == code {
+			return resp, nil
+		}
+	}
+	defer resp.Body.Close()
+	return nil, statusError(resp)
+}
+
+// rewind тело из r для каждой попытки. io.ReadSeeker перематывается
+// на исходную позицию, другое тело отправляется только один раз.
+func rewind(r io.Reader) func() (io.Reader, error) {
+	s, seekable := r.(io.Seeker)
+	var start int64
+	if seekable {
+		var err error
+		if start, err = s.Seek(0, io.SeekCurrent); err != nil {
+			seekable = false
+		}
+	}
+
+	used := false
+	return func() (io.Reader, error) {
+		if seekable {
+			_, err := s.Seek(start, io.SeekStart)
+			return r, err
+		}
+		if used {
+			return nil, errNoRewind
+		}
+		used = true
+		return r, nil
+	}
+}
+
+// cleanPath путь хранилища с ведущим "/"
+func cleanPath(p string) string {
+	return path.Clean("/" + p)
+}
+
+// dirPath путь каталога с "/" на конце: так его ждет маршрут хранилища
+func dirPath(p string) string {
+	return strings.TrimSuffix(cleanPath(p), "/") + "/"
+}
+
+// storageURL адрес пути хранилища в REST API
+func (x *Client) storageURL(p string, query url.Values) string {
+	u := *x.base
+	u.Path = x.base.Path + p
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// davURL адрес пути хранилища в WebDAV. Корень WebDAV — StoragePrefix.
+func (x *Client) davURL(p string) (string, error) {
+	if x.cfg.WebDAVPrefix == "" {
+		return "", ErrNotConfigured
+	}
+	p = cleanPath(p)
+	prefix := strings.TrimSuffix(x.cfg.StoragePrefix, "/")
+	if prefix != "" && p != prefix && !strings.HasPrefix(p, prefix+"/") {
+		return "", ErrInvalid
+	}
+
+	u := *x.base
+	u.Path = x.base.Path + path.Join(x.cfg.WebDAVPrefix, strings.TrimPrefix(p, prefix))
+	return u.String(), nil
+}
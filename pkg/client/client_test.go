@@ -0,0 +1,573 @@
+package client
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AleksandrMac/fileserver/internal/testenv"
+)
+
+// testEnv сервер с REST под /files/, WebDAV под /dav и S3 API на отдельном
+// адресе. fault, если задан, может ответить вместо сервера.
+type testEnv struct {
+	storage string
+	client  *Client
+
+	mu       sync.Mutex
+	fault    func(w http.ResponseWriter, r *http.Request) bool
+	requests []*http.Request
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	base := testenv.New(t)
+	env := &testEnv{storage: base.Storage}
+	url, s3URL := base.Serve(t, env.middleware)
+
+	var err error
+	env.client, err = New(Config{
+		URL:           url,
+		APIKey:        testenv.APIKey,
+		StoragePrefix: "/files/",
+		WebDAVPrefix:  "/dav",
+		S3URL:         s3URL,
+		Retries:       2,
+		Backoff:       time.Millisecond,
+		PartSize:      4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return env
+}
+
+func (x *testEnv) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		x.mu.Lock()
+		x.requests = append(x.requests, r.Clone(context.Background()))
+		fault := x.fault
+		x.mu.Unlock()
+
+		if fault != nil && fault(w, r) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (x *testEnv) setFault(f func(w http.ResponseWriter, r *http.Request) bool) {
+	x.mu.Lock()
+	x.fault = f
+	x.requests = nil
+	x.mu.Unlock()
+}
+
+func (x *testEnv) write(t *testing.T, name, content string) {
+	t.Helper()
+	full := filepath.Join(x.storage, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (x *testEnv) read(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(x.storage, filepath.FromSlash(name)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// failing отвечает status первые n запросов
+func failing(n int, status int) func(w http.ResponseWriter, r *http.Request) bool {
+	var mu sync.Mutex
+	return func(w http.ResponseWriter, r *http.Request) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if n == 0 {
+			return false
+		}
+		n--
+		http.Error(w, "try later", status)
+		return true
+	}
+}
+
+// cutWriter обрывает ответ после limit байт тела
+type cutWriter struct {
+	http.ResponseWriter
+	limit int
+}
+
+func (x *cutWriter) Write(p []byte) (int, error) {
+	if len(p) > x.limit {
+		x.ResponseWriter.Write(p[:x.limit])
+		x.ResponseWriter.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	x.limit -= len(p)
+	return x.ResponseWriter.Write(p)
+}
+
+func TestUploadAndList(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	if err := env.client.Upload(ctx, "/files/docs", "a.txt", strings.NewReader("hello")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("upload to missing dir: %v", err)
+	}
+	env.write(t, "files/docs/.keep", "")
+
+	if err := env.client.Upload(ctx, "/files/docs", "a.txt", strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.client.Put(ctx, "/files/docs/sub/b.txt", strings.NewReader("streamed"), -1); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("put to missing dir: %v", err)
+	}
+	env.write(t, "files/docs/sub/.keep", "")
+	if err := env.client.Put(ctx, "/files/docs/sub/b.txt", strings.NewReader("streamed"), -1); err != nil {
+		t.Fatal(err)
+	}
+	if got := env.read(t, "files/docs/a.txt"); got != "hello" {
+		t.Errorf("uploaded %q", got)
+	}
+	if got := env.read(t, "files/docs/sub/b.txt"); got != "streamed" {
+		t.Errorf("put %q", got)
+	}
+
+	list, err := env.client.List(ctx, "/files/docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range list {
+		names = append(names, f.Path)
+	}
+	if strings.Join(names, ",") != "/files/docs/.keep,/files/docs/a.txt,/files/docs/sub" {
+		t.Errorf("list %v", names)
+	}
+	if _, err := env.client.List(ctx, "/files/docs/a.txt"); !errors.Is(err, ErrInvalid) {
+		t.Errorf("list of a file: %v", err)
+	}
+
+	meta, err := env.client.Stat(ctx, "/files/docs/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Size != 5 || meta.SHA256 != "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" {
+		t.Errorf("stat %+v", meta)
+	}
+	if _, err := env.client.Stat(ctx, "/files/missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("stat missing: %v", err)
+	}
+
+	info, err := env.client.Info(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Storage == nil || info.Storage.TotalFiles != 4 {
+		t.Errorf("info %+v", info.Storage)
+	}
+}
+
+func TestWriteNeedsKey(t *testing.T) {
+	env := newTestEnv(t)
+	env.write(t, "files/a.txt", "a")
+	env.client.cfg.APIKey = "wrong"
+	ctx := context.Background()
+
+	var se *StatusError
+	err := env.client.Upload(ctx, "/files", "b.txt", strings.NewReader("b"))
+	if !errors.Is(err, ErrForbidden) || !errors.As(err, &se) || se.StatusCode != http.StatusForbidden {
+		t.Errorf("upload: %v", err)
+	}
+	if err := env.client.Delete(ctx, "/files/a.txt"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("delete: %v", err)
+	}
+	if _, err := env.client.List(ctx, "/files"); err != nil {
+		t.Errorf("list is public: %v", err)
+	}
+}
+
+func TestDeleteMove(t *testing.T) {
+	env := newTestEnv(t)
+	env.write(t, "files/a.txt", "a")
+	env.write(t, "files/b.txt", "b")
+	env.write(t, "files/dir/c.txt", "c")
+	ctx := context.Background()
+
+	if err := env.client.Move(ctx, "/files/a.txt", "/files/b.txt", false); !errors.Is(err, ErrExists) {
+		t.Errorf("move onto existing: %v", err)
+	}
+	if err := env.client.Move(ctx, "/files/a.txt", "/files/b.txt", true); err != nil {
+		t.Fatal(err)
+	}
+	if got := env.read(t, "files/b.txt"); got != "a" {
+		t.Errorf("moved %q", got)
+	}
+	if err := env.client.Copy(ctx, "/files/dir", "/files/copy", false); err != nil {
+		t.Fatal(err)
+	}
+	if got := env.read(t, "files/copy/c.txt"); got != "c" {
+		t.Errorf("copied %q", got)
+	}
+	if err := env.client.Mkdir(ctx, "/files/new"); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.client.Mkdir(ctx, "/files/new"); !errors.Is(err, ErrExists) {
+		t.Errorf("mkdir existing: %v", err)
+	}
+	if err := env.client.Mkdir(ctx, "/files/no/parent"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("mkdir without parent: %v", err)
+	}
+	if err := env.client.Delete(ctx, "/files/dir"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(env.storage, "files", "dir")); !os.IsNotExist(err) {
+		t.Errorf("dir not deleted: %v", err)
+	}
+	if err := env.client.Delete(ctx, "/files/dir"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("delete missing: %v", err)
+	}
+	if err := env.client.Delete(ctx, "/other/a.txt"); !errors.Is(err, ErrInvalid) {
+		t.Errorf("delete outside storage prefix: %v", err)
+	}
+
+	env.client.cfg.WebDAVPrefix = ""
+	if err := env.client.Delete(ctx, "/files/b.txt"); !errors.Is(err, ErrNotConfigured) {
+		t.Errorf("delete without webdav: %v", err)
+	}
+}
+
+func TestZipContents(t *testing.T) {
+	env := newTestEnv(t)
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range []string{"one.txt", "dir/two.txt"} {
+		w, _ := zw.Create(name)
+		w.Write([]byte(name))
+	}
+	zw.Close()
+	env.write(t, "files/a.zip", buf.String())
+	env.write(t, "files/a.txt", "not a zip")
+
+	files, err := env.client.ZipContents(context.Background(), "/files/a.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0].Name != "one.txt" || files[1].Name != "dir/two.txt" {
+		t.Errorf("zip %+v", files)
+	}
+	if _, err := env.client.ZipContents(context.Background(), "/files/a.txt"); !errors.Is(err, ErrInvalid) {
+		t.Errorf("not a zip: %v", err)
+	}
+}
+
+func TestDownload(t *testing.T) {
+	env := newTestEnv(t)
+	env.write(t, "files/a.txt", "0123456789")
+	ctx := context.Background()
+
+	tests := []struct {
+		name           string
+		offset, length int64
+		want           string
+	}{
+		{"whole", 0, -1, "0123456789"},
+		{"tail", 6, -1, "6789"},
+		{"range", 2, 3, "234"},
+		{"head", 0, 4, "0123"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			n, err := env.client.Download(ctx, "/files/a.txt", &buf, tt.offset, tt.length)
+			if err != nil || buf.String() != tt.want || n != int64(len(tt.want)) {
+				t.Errorf("got %q, %d, %v", buf.String(), n, err)
+			}
+		})
+	}
+
+	if _, err := env.client.Download(ctx, "/files/missing", io.Discard, 0, -1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing: %v", err)
+	}
+}
+
+func TestDownloadResume(t *testing.T) {
+	env := newTestEnv(t)
+	content := strings.Repeat("0123456789", 1000)
+	env.write(t, "files/a.txt", content)
+	ctx := context.Background()
+
+	full := filepath.Join(env.storage, "files", "a.txt")
+	info, err := os.Stat(full)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cut := true
+	env.setFault(func(w http.ResponseWriter, r *http.Request) bool {
+		if !cut {
+			return false
+		}
+		cut = false
+		http.ServeContent(&cutWriter{ResponseWriter: w, limit: 3000}, r, "a.txt", info.ModTime(), strings.NewReader(content))
+		return true
+	})
+
+	var buf bytes.Buffer
+	n, err := env.client.Download(ctx, "/files/a.txt", &buf, 0, -1)
+	if err != nil || n != int64(len(content)) || buf.String() != content {
+		t.Fatalf("got %d bytes, %v", n, err)
+	}
+	if got := env.requests[1].Header.Get("Range"); got != "bytes=3000-" {
+		t.Errorf("resume range %q", got)
+	}
+	if env.requests[1].Header.Get("If-Range") == "" {
+		t.Error("resume without If-Range")
+	}
+
+	// файл изменился между попытками: докачка невозможна
+	env.setFault(func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Header.Get("If-Range") != "" {
+			return false
+		}
+		os.Chtimes(full, time.Now(), info.ModTime().Add(time.Hour))
+		http.ServeContent(&cutWriter{ResponseWriter: w, limit: 3000}, r, "a.txt", info.ModTime(), strings.NewReader(content))
+		return true
+	})
+	if _, err := env.client.Download(ctx, "/files/a.txt", io.Discard, 0, -1); !errors.Is(err, ErrChanged) {
+		t.Errorf("changed file: %v", err)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	env := newTestEnv(t)
+	env.write(t, "files/a.txt", "a")
+	ctx := context.Background()
+
+	env.setFault(failing(2, http.StatusServiceUnavailable))
+	if _, err := env.client.Stat(ctx, "/files/a.txt"); err != nil {
+		t.Errorf("stat after two failures: %v", err)
+	}
+	if len(env.requests) != 3 {
+		t.Errorf("%d requests", len(env.requests))
+	}
+
+	env.setFault(failing(3, http.StatusServiceUnavailable))
+	if _, err := env.client.Stat(ctx, "/files/a.txt"); !errors.Is(err, ErrServer) {
+		t.Errorf("stat after retries: %v", err)
+	}
+
+	// POST после 5xx не повторяется: сервер мог успеть его выполнить
+	env.setFault(failing(1, http.StatusServiceUnavailable))
+	if err := env.client.Upload(ctx, "/files", "b.txt", strings.NewReader("b")); !errors.Is(err, ErrServer) || len(env.requests) != 1 {
+		t.Errorf("upload after 503: %v after %d requests", err, len(env.requests))
+	}
+
+	// тело из обычного io.Reader не повторяется
+	env.setFault(failing(1, http.StatusTooManyRequests))
+	err := env.client.Upload(ctx, "/files", "b.txt", io.MultiReader(strings.NewReader("b")))
+	if !errors.Is(err, errNoRewind) {
+		t.Errorf("upload of a stream: %v", err)
+	}
+	env.setFault(failing(1, http.StatusTooManyRequests))
+	if err := env.client.Upload(ctx, "/files", "b.txt", strings.NewReader("b")); err != nil {
+		t.Errorf("upload of a seeker: %v", err)
+	}
+	if got := env.read(t, "files/b.txt"); got != "b" {
+		t.Errorf("uploaded %q", got)
+	}
+
+	// 4xx не повторяется
+	env.setFault(nil)
+	if _, err := env.client.Stat(ctx, "/files/missing"); !errors.Is(err, ErrNotFound) || len(env.requests) != 1 {
+		t.Errorf("not found: %v after %d requests", err, len(env.requests))
+	}
+}
+
+func TestLocked(t *testing.T) {
+	env := newTestEnv(t)
+	env.setFault(func(w http.ResponseWriter, r *http.Request) bool {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusLocked)
+		w.Write([]byte(`{"path":"/files/a.txt","kind":"session","owner":"user:ivanov","created":"2024-12-01T10:00:00Z"}`))
+		return true
+	})
+
+	var se *StatusError
+	err := env.client.Upload(context.Background(), "/files", "a.txt", strings.NewReader("a"))
+	if !errors.Is(err, ErrLocked) || !errors.As(err, &se) {
+		t.Fatalf("upload: %v", err)
+	}
+	if se.Lock == nil || se.Lock.Path != "/files/a.txt" || se.Lock.Owner != "user:ivanov" || se.Lock.Kind != "session" {
+		t.Errorf("lock %+v", se.Lock)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	env := newTestEnv(t)
+	env.write(t, "files/a.txt", "a")
+	env.client.cfg.Backoff = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	env.setFault(func(w http.ResponseWriter, r *http.Request) bool {
+		cancel()
+		http.Error(w, "try later", http.StatusServiceUnavailable)
+		return true
+	})
+	done := make(chan error)
+	go func() {
+		_, err := env.client.Stat(ctx, "/files/a.txt")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("canceled: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("backoff ignores context")
+	}
+}
+
+func TestMultipartUpload(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	content := "0123456789"
+
+	up, err := env.client.CreateUpload(ctx, "/files/big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// обрыв после второй части
+	src := &failingReader{r: strings.NewReader(content), limit: 9}
+	if err := up.Send(ctx, src); err == nil {
+		t.Fatal("send of a broken source succeeded")
+	}
+
+	up, err = env.client.ResumeUpload(ctx, "/files/big.bin", up.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if up.Offset() != 8 {
+		t.Fatalf("offset %d", up.Offset())
+	}
+	r := strings.NewReader(content)
+	r.Seek(up.Offset(), io.SeekStart)
+	if err := up.Send(ctx, r); err != nil {
+		t.Fatal(err)
+	}
+	if err := up.Complete(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := env.read(t, "files/big.bin"); got != content {
+		t.Errorf("assembled %q", got)
+	}
+
+	up, err = env.client.CreateUpload(ctx, "/files/empty.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := up.Send(ctx, strings.NewReader("")); err != nil {
+		t.Fatal(err)
+	}
+	if err := up.Complete(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := env.read(t, "files/empty.bin"); got != "" {
+		t.Errorf("empty %q", got)
+	}
+
+	up, err = env.client.CreateUpload(ctx, "/files/aborted.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := up.Abort(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.client.ResumeUpload(ctx, "/files/aborted.bin", up.ID()); !errors.Is(err, ErrNotFound) {
+		t.Errorf("resume aborted: %v", err)
+	}
+	if _, err := env.client.CreateUpload(ctx, "/top.bin"); !errors.Is(err, ErrInvalid) {
+		t.Errorf("upload outside a bucket: %v", err)
+	}
+}
+
+// failingReader отдает limit байт и затем ошибку
+type failingReader struct {
+	r     io.Reader
+	limit int
+}
+
+func (x *failingReader) Read(p []byte) (int, error) {
+	if x.limit == 0 {
+		return 0, errors.New("source broken")
+	}
+	if len(p) > x.limit {
+		p = p[:x.limit]
+	}
+	n, err := x.r.Read(p)
+	x.limit -= n
+	return n, err
+}
+
+func TestPresign(t *testing.T) {
+	env := newTestEnv(t)
+	env.write(t, "files/a b.txt", "signed")
+
+	get, err := env.client.PresignGet("/files/a b.txt", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Get(get)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "signed" {
+		t.Errorf("presigned get: %d %q", resp.StatusCode, body)
+	}
+
+	put, err := env.client.PresignPut("/files/up.txt", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest(http.MethodPut, put, strings.NewReader("uploaded"))
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || env.read(t, "files/up.txt") != "uploaded" {
+		t.Errorf("presigned put: %d", resp.StatusCode)
+	}
+
+	// подпись не подходит к другому пути
+	resp, err = http.Get(strings.Replace(get, "a%20b.txt", "up.txt", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("tampered url: %d", resp.StatusCode)
+	}
+
+	if _, err := env.client.PresignGet("/files/a.txt", 8*24*time.Hour); !errors.Is(err, ErrInvalid) {
+		t.Errorf("too long expiry: %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Download пишет в w файл p с позиции offset, length байт или до конца при
+// length -1. После обрыва докачивает с места остановки, если файл не
+// изменился (If-Range по Last-Modified), иначе возвращает ErrChanged.
+// Возвращает число записанных в w байт.
+func (x *Client) Download(ctx context.Context, p string, w io.Writer, offset, length int64) (int64, error) {
+	if offset < 0 || length < -1 {
+		return 0, fmt.Errorf("%w: negative offset or length", ErrInvalid)
+	}
+	if length == 0 {
+		return 0, nil
+	}
+
+	var written int64
+	var validator string
+	for attempt := 0; ; attempt++ {
+		rest := int64(-1)
+		if length > 0 {
+			rest = length - written
+		}
+		n, lastModified, err := x.download(ctx, p, w, offset+written, rest, validator)
+		written += n
+		if validator == "" {
+			validator = lastModified
+		}
+		if err == nil || !retryable(http.MethodGet, err) || attempt >= x.cfg.Retries {
+			return written, err
+		}
+		if err := x.pause(ctx, attempt, err); err != nil {
+			return written, err
+		}
+	}
+}
+
+// download одна попытка скачать length байт (-1 — до конца) с позиции start.
+// ifRange Last-Modified первой попытки, пустой в первой попытке.
+func (x *Client) download(ctx context.Context, p string, w io.Writer, start, length int64, ifRange string) (int64, string, error) {
+	header := http.Header{}
+	switch {
+	case length > 0:
+		header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, start+length-1))
+	case start > 0:
+		header.Set("Range", fmt.Sprintf("bytes=%d-", start))
+	}
+	if ifRange != "" {
+		header.Set("If-Range", ifRange)
+	}
+
+	resp, err := x.send(ctx, &request{method: http.MethodGet, url: x.storageURL(cleanPath(p), nil), header: header},
+		[]int{http.StatusOK, http.StatusPartialContent})
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	lastModified := resp.Header.Get("Last-Modified")
+
+	var body io.Reader = resp.Body
+	if resp.StatusCode == http.StatusOK && start > 0 {
+		// файл изменился с прошлой попытки: склеивать части нельзя
+		if ifRange != "" {
+			return 0, lastModified, ErrChanged
+		}
+		// сервер не поддержал Range: пропускаем начало
+		if _, err := io.CopyN(io.Discard, body, start); err != nil {
+			return 0, lastModified, err
+		}
+	}
+	if length > 0 {
+		body = io.LimitReader(body, length)
+	}
+
+	n, err := io.Copy(w, body)
+	if err == nil && length > 0 && n < length {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, lastModified, err
+}
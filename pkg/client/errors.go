@@ -0,0 +1,135 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound        = errors.New("not found")
+	ErrForbidden       = errors.New("forbidden")
+	ErrInvalid         = errors.New("invalid request")
+	ErrExists          = errors.New("already exists")
+	ErrLocked          = errors.New("locked")
+	ErrTooLarge        = errors.New("too large")
+	ErrTooManyRequests = errors.New("too many requests")
+	ErrServer          = errors.New("server error")
+	// ErrChanged файл изменился между частями докачки
+	ErrChanged = errors.New("file changed during download")
+	// ErrNotConfigured для метода нужен адрес WebDAV или S3, которого нет в Config
+	ErrNotConfigured = errors.New("endpoint is not configured")
+)
+
+// StatusError ответ сервера с ошибкой. errors.Is сопоставляет его с Err* по статусу.
+type StatusError struct {
+	StatusCode int
+	// Message текст ошибки сервера
+	Message string
+	// Lock блокировка, из-за которой отказано в записи (423)
+	Lock *Lock
+	// RetryAfter пауза из заголовка Retry-After
+	RetryAfter time.Duration
+}
+
+// Lock блокировка файла или каталога на сервере
+type Lock struct {
+	Path string `json:"path"`
+	// Kind session — файл открыт в редакторе, explicit — через API или WebDAV LOCK
+	Kind    string    `json:"kind"`
+	Owner   string    `json:"owner"`
+	Created time.Time `json:"created"`
+	// Expires нулевое у блокировок сессий редактора
+	Expires time.Time `json:"expires,omitempty"`
+	// Deep блокировка каталога распространяется на все вложенное
+	Deep bool `json:"deep,omitempty"`
+}
+
+func (x *StatusError) Error() string {
+	if x.Message == "" {
+		return fmt.Sprintf("fileserver: %d %s", x.StatusCode, http.StatusText(x.StatusCode))
+	}
+	return fmt.Sprintf("fileserver: %d %s: %s", x.StatusCode, http.StatusText(x.StatusCode), x.Message)
+}
+
+func (x *StatusError) Unwrap() error {
+	switch {
+	case x.StatusCode == http.StatusUnauthorized, x.StatusCode == http.StatusForbidden:
+		return ErrForbidden
+	// WebDAV отвечает 409, если нет родительского каталога
+	case x.StatusCode == http.StatusNotFound, x.StatusCode == http.StatusConflict:
+		return ErrNotFound
+	case x.StatusCode == http.StatusPreconditionFailed:
+		return ErrExists
+	case x.StatusCode == http.StatusLocked:
+		return ErrLocked
+	case x.StatusCode == http.StatusRequestEntityTooLarge:
+		return ErrTooLarge
+	case x.StatusCode == http.StatusTooManyRequests:
+		return ErrTooManyRequests
+	case x.StatusCode >= 500:
+		return ErrServer
+	case x.StatusCode >= 400:
+		return ErrInvalid
+	}
+	return nil
+}
+
+// maxErrorBody сколько байт тела ошибки читать
+const maxErrorBody = 4 << 10
+
+// statusError ошибка из ответа: текст, JSON блокировки или XML ошибки S3
+func statusError(resp *http.Response) *StatusError {
+	e := &StatusError{StatusCode: resp.StatusCode}
+	if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		e.RetryAfter = time.Duration(s) * time.Second
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	switch {
+	case resp.StatusCode == http.StatusLocked && strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json"):
+		var lock Lock
+		if json.Unmarshal(body, &lock) == nil && lock.Path != "" {
+			e.Lock = &lock
+		}
+	case strings.Contains(resp.Header.Get("Content-Type"), "xml"):
+		var s3err struct {
+			Code    string `xml:"Code"`
+			Message string `xml:"Message"`
+		}
+		if xml.Unmarshal(body, &s3err) == nil && s3err.Code != "" {
+			e.Message = s3err.Code + ": " + s3err.Message
+		}
+	default:
+		e.Message = strings.TrimSpace(string(body))
+	}
+	return e
+}
+
+// retryable повторяется 429: сервер отказал, не выполнив запрос. Сетевые
+// ошибки и 5xx повторяются только у идемпотентных методов — POST мог успеть
+// выполниться.
+func retryable(method string, err error) bool {
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.StatusCode == http.StatusTooManyRequests || se.StatusCode >= 500 && idempotent(method)
+	}
+	return idempotent(method) && !errors.Is(err, errNoRewind) && !errors.Is(err, ErrChanged) &&
+		!errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+// idempotent повтор запроса с методом method не меняет результат
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+)
+
+// List содержимое каталога p
+func (x *Client) List(ctx context.Context, p string) ([]domain.FileInfo, error) {
+	var files []domain.FileInfo
+	if err := x.getJSON(ctx, x.storageURL(dirPath(p), nil), &files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// Stat метаданные файла или каталога: размер, MIME, SHA-256, блокировка
+func (x *Client) Stat(ctx context.Context, p string) (*domain.FileMeta, error) {
+	u := *x.base
+	u.Path = x.base.Path + "/info"
+	u.RawQuery = url.Values{"path": {cleanPath(p)}}.Encode()
+
+	var meta domain.FileMeta
+	if err := x.getJSON(ctx, u.String(), &meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// ZipContents список файлов zip-архива p без его скачивания
+func (x *Client) ZipContents(ctx context.Context, p string) ([]domain.FileInfo, error) {
+	var files []domain.FileInfo
+	if err := x.getJSON(ctx, x.storageURL(cleanPath(p), url.Values{"meta": {"true"}}), &files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// Delete удаляет файл или каталог со всем содержимым
+func (x *Client) Delete(ctx context.Context, p string) error {
+	u, err := x.davURL(p)
+	if err != nil {
+		return err
+	}
+	resp, err := x.do(ctx, &request{method: http.MethodDelete, url: u}, http.StatusNoContent, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Move переносит файл или каталог. Без overwrite занятый путь назначения
+// дает ErrExists.
+func (x *Client) Move(ctx context.Context, from, to string, overwrite bool) error {
+	return x.transfer(ctx, "MOVE", from, to, overwrite)
+}
+
+// Copy копирует файл или каталог со всем содержимым
+func (x *Client) Copy(ctx context.Context, from, to string, overwrite bool) error {
+	return x.transfer(ctx, "COPY", from, to, overwrite)
+}
+
+// Mkdir создает каталог p. Родительский каталог должен существовать,
+// занятый путь дает ErrExists.
+func (x *Client) Mkdir(ctx context.Context, p string) error {
+	u, err := x.davURL(p)
+	if err != nil {
+		return err
+	}
+	resp, err := x.do(ctx, &request{method: "MKCOL", url: u}, http.StatusCreated)
+	var se *StatusError
+	if errors.As(err, &se) && se.StatusCode == http.StatusMethodNotAllowed {
+		return fmt.Errorf("%w: %s", ErrExists, cleanPath(p))
+	}
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Info версия сервера и размер хранилища
+func (x *Client) Info(ctx context.Context) (*domain.ServiceInfo, error) {
+	u := *x.base
+	u.Path = x.base.Path + "/info"
+
+	var info domain.ServiceInfo
+	if err := x.getJSON(ctx, u.String(), &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// transfer MOVE или COPY через WebDAV
+func (x *Client) transfer(ctx context.Context, method, from, to string, overwrite bool) error {
+	src, err := x.davURL(from)
+	if err != nil {
+		return err
+	}
+	dst, err := x.davURL(to)
+	if err != nil {
+		return err
+	}
+
+	header := http.Header{"Destination": {dst}, "Overwrite": {"F"}}
+	if overwrite {
+		header.Set("Overwrite", "T")
+	}
+	resp, err := x.do(ctx, &request{method: method, url: src, header: header}, http.StatusCreated, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (x *Client) getJSON(ctx context.Context, u string, v any) error {
+	resp, err := x.do(ctx, &request{
+		method: http.MethodGet,
+		url:    u,
+		header: http.Header{"Accept": {"application/json"}},
+	}, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		// вместо списка каталога сервер отдал описание файла
+		if _, ok := err.(*json.UnmarshalTypeError); ok {
+			return fmt.Errorf("%w: not a directory", ErrInvalid)
+		}
+		return err
+	}
+	return nil
+}
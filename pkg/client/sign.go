@@ -0,0 +1,174 @@
+package client
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	algorithm       = "AWS4-HMAC-SHA256"
+	amzDateFormat   = "20060102T150405Z"
+	region          = "us-east-1"
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+	emptySHA256     = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+	// maxPresignedTTL предел срока подписанной ссылки на сервере
+	maxPresignedTTL = 7 * 24 * time.Hour
+)
+
+// PresignGet ссылка на скачивание p без ключа, действует expires.
+// Ссылка ведет на S3 API, первый каталог пути — бакет.
+func (x *Client) PresignGet(p string, expires time.Duration) (string, error) {
+	return x.presign(http.MethodGet, p, expires, time.Now())
+}
+
+// PresignPut ссылка на загрузку p запросом PUT без ключа, действует expires
+func (x *Client) PresignPut(p string, expires time.Duration) (string, error) {
+	return x.presign(http.MethodPut, p, expires, time.Now())
+}
+
+func (x *Client) presign(method, p string, expires time.Duration, now time.Time) (string, error) {
+	if expires <= 0 || expires > maxPresignedTTL {
+		return "", fmt.Errorf("%w: expires must be between 1s and %s", ErrInvalid, maxPresignedTTL)
+	}
+	u, err := x.objectURL(p, nil)
+	if err != nil {
+		return "", err
+	}
+
+	date := now.UTC().Format(amzDateFormat)
+	scope := date[:8] + "/" + region + "/s3/aws4_request"
+	q := url.Values{
+		"X-Amz-Algorithm":     {algorithm},
+		"X-Amz-Credential":    {x.cfg.S3AccessKey + "/" + scope},
+		"X-Amz-Date":          {date},
+		"X-Amz-Expires":       {strconv.Itoa(int(expires / time.Second))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	u.RawQuery = q.Encode()
+
+	r := &http.Request{Method: method, URL: u, Host: u.Host, Header: http.Header{}}
+	sig := x.signature(r, []string{"host"}, unsignedPayload, date, scope)
+	q.Set("X-Amz-Signature", sig)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// sign подписывает запрос к S3 API в заголовке Authorization. Сумма тела
+// берется из X-Amz-Content-Sha256, без него тело считается пустым.
+func (x *Client) sign(r *http.Request, now time.Time) {
+	payload := r.Header.Get("X-Amz-Content-Sha256")
+	if payload == "" {
+		payload = emptySHA256
+		r.Header.Set("X-Amz-Content-Sha256", payload)
+	}
+	date := now.UTC().Format(amzDateFormat)
+	r.Header.Set("X-Amz-Date", date)
+
+	signed := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	scope := date[:8] + "/" + region + "/s3/aws4_request"
+	sig := x.signature(r, signed, payload, date, scope)
+	r.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		algorithm, x.cfg.S3AccessKey, scope, strings.Join(signed, ";"), sig))
+}
+
+func (x *Client) signature(r *http.Request, signed []string, payload, date, scope string) string {
+	stringToSign := strings.Join([]string{
+		algorithm,
+		date,
+		scope,
+		hexSHA256([]byte(canonicalRequest(r, signed, payload))),
+	}, "\n")
+	return hex.EncodeToString(hmacSHA256(signingKey(x.cfg.APIKey, date[:8]), stringToSign))
+}
+
+func canonicalRequest(r *http.Request, signed []string, payload string) string {
+	var b strings.Builder
+	b.WriteString(r.Method + "\n")
+	b.WriteString(uriEncode(r.URL.Path, false) + "\n")
+
+	q := r.URL.Query()
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, k := range keys {
+		values := append([]string(nil), q[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			pairs = append(pairs, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	b.WriteString(strings.Join(pairs, "&") + "\n")
+
+	for _, h := range signed {
+		v := r.Header.Get(h)
+		if h == "host" {
+			v = r.URL.Host
+		}
+		b.WriteString(h + ":" + strings.TrimSpace(v) + "\n")
+	}
+	b.WriteString("\n")
+	b.WriteString(strings.Join(signed, ";") + "\n")
+	b.WriteString(payload)
+	return b.String()
+}
+
+// uriEncode кодирует все, кроме незарезервированных символов (и '/' в пути)
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && !encodeSlash {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func signingKey(secret, date string) []byte {
+	k := hmacSHA256([]byte("AWS4"+secret), date)
+	k = hmacSHA256(k, region)
+	k = hmacSHA256(k, "s3")
+	return hmacSHA256(k, "aws4_request")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// objectURL адрес объекта p в S3 API: первый каталог пути — бакет
+func (x *Client) objectURL(p string, query url.Values) (*url.URL, error) {
+	if x.s3 == nil {
+		return nil, ErrNotConfigured
+	}
+	p = cleanPath(p)
+	if bucket, key, _ := strings.Cut(strings.TrimPrefix(p, "/"), "/"); bucket == "" || key == "" {
+		return nil, fmt.Errorf("%w: path must be inside a top-level directory", ErrInvalid)
+	}
+
+	u := *x.s3
+	u.Path = x.s3.Path + p
+	u.RawQuery = query.Encode()
+	return &u, nil
+}
@@ -0,0 +1,591 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/AleksandrMac/fileserver/internal/domain"
+)
+
+// SyncDirection куда переносятся изменения
+type SyncDirection int
+
+const (
+	// Push локальный каталог на сервер
+	Push SyncDirection = iota
+	// Pull каталог сервера в локальный
+	Pull
+)
+
+// partPrefix префикс недокачанных файлов при Pull, такие файлы не синхронизируются
+const partPrefix = ".fsync-"
+
+type SyncOptions struct {
+	Direction SyncDirection
+	// Delete удалить на приемнике то, чего нет в источнике
+	Delete bool
+	// DryRun только составить план
+	DryRun bool
+	// Exclude шаблоны path.Match для относительного пути или имени. Шаблон
+	// с "/" на конце относится только к каталогам. Исключенное не
+	// переносится и не удаляется.
+	Exclude []string
+	// StateFile журнал для продолжения прерванной синхронизации и кеш
+	// хешей локальных файлов. Пустой — без журнала.
+	StateFile string
+	// OnAction вызывается перед каждым действием
+	OnAction func(SyncAction)
+}
+
+// SyncAction действие синхронизации
+type SyncAction struct {
+	// Op upload, download, mkdir или delete
+	Op string `json:"op"`
+	// Path путь относительно синхронизируемых каталогов
+	Path string `json:"path"`
+	Size int64  `json:"size,omitempty"`
+	// Reason new, changed или extraneous
+	Reason string `json:"reason"`
+}
+
+type SyncReport struct {
+	Actions []SyncAction `json:"actions"`
+	// Unchanged сколько файлов совпало
+	Unchanged int `json:"unchanged"`
+	// Bytes сколько байт передано
+	Bytes  int64 `json:"bytes"`
+	DryRun bool  `json:"dry_run,omitempty"`
+}
+
+// Manifest все файлы и каталоги под p с размерами, временем изменения и SHA-256
+func (x *Client) Manifest(ctx context.Context, p string) ([]domain.ManifestEntry, error) {
+	u := *x.base
+	u.Path = x.base.Path + "/manifest"
+	u.RawQuery = url.Values{"path": {cleanPath(p)}}.Encode()
+
+	var entries []domain.ManifestEntry
+	if err := x.getJSON(ctx, u.String(), &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Sync приводит приемник к источнику: копирует новые и измененные файлы,
+// с Delete удаляет лишние. Файлы совпадают, если равны размер и время
+// изменения или, при разном времени, SHA-256. Push требует WebDAV; файлы
+// больше PartSize при настроенном S3 загружаются по частям и после обрыва
+// продолжаются по журналу. При Pull файл докачивается во временный рядом
+// и заменяет старый после проверки SHA-256.
+func (x *Client) Sync(ctx context.Context, local, remote string, opts SyncOptions) (*SyncReport, error) {
+	if opts.Direction == Push && x.cfg.WebDAVPrefix == "" {
+		return nil, fmt.Errorf("%w: push needs WebDAVPrefix", ErrNotConfigured)
+	}
+	for _, pattern := range opts.Exclude {
+		if _, err := path.Match(strings.TrimSuffix(pattern, "/"), ""); err != nil {
+			return nil, fmt.Errorf("%w: exclude %q: %v", ErrInvalid, pattern, err)
+		}
+	}
+
+	s := &syncer{
+		x:      x,
+		opts:   opts,
+		local:  local,
+		remote: cleanPath(remote),
+		state:  &syncState{Hashes: map[string]stateHash{}, Uploads: map[string]stateUpload{}},
+		report: &SyncReport{DryRun: opts.DryRun},
+	}
+	if opts.StateFile != "" {
+		if err := s.state.load(opts.StateFile); err != nil {
+			return nil, err
+		}
+		if abs, err := filepath.Abs(opts.StateFile); err == nil {
+			s.stateFile = abs
+		}
+	}
+
+	localTree, err := s.scanLocal()
+	if err != nil {
+		return nil, err
+	}
+	remoteTree, err := s.scanRemote(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	src, dst := localTree, remoteTree
+	if opts.Direction == Pull {
+		src, dst = remoteTree, localTree
+	}
+	if err := s.run(ctx, src, dst); err != nil {
+		return s.report, err
+	}
+	return s.report, s.saveState()
+}
+
+type syncer struct {
+	x         *Client
+	opts      SyncOptions
+	local     string
+	remote    string
+	state     *syncState
+	stateFile string
+	report    *SyncReport
+	// rootMissing корня приемника нет, его нужно создать
+	rootMissing bool
+}
+
+// entry файл или каталог дерева, у локального SHA256 пустой, пока не посчитан
+type entry struct {
+	domain.ManifestEntry
+	local bool
+}
+
+func (x *syncer) excluded(rel string, dir bool) bool {
+	for _, pattern := range x.opts.Exclude {
+		if strings.HasSuffix(pattern, "/") {
+			if !dir {
+				continue
+			}
+			pattern = strings.TrimSuffix(pattern, "/")
+		}
+		if ok, _ := path.Match(pattern, rel); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, path.Base(rel)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (x *syncer) scanLocal() (map[string]*entry, error) {
+	tree := map[string]*entry{}
+	root, err := filepath.Abs(x.local)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(root)
+	if errors.Is(err, os.ErrNotExist) && x.opts.Direction == Pull {
+		x.rootMissing = true
+		return tree, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%w: %s is not a directory", ErrInvalid, x.local)
+	}
+
+	err = filepath.WalkDir(root, func(full string, d fs.DirEntry, err error) error {
+		if err != nil || full == root {
+			return err
+		}
+		rel, err := filepath.Rel(root, full)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if x.excluded(rel, d.IsDir()) || strings.HasPrefix(d.Name(), partPrefix) || full == x.stateFile {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() && !d.Type().IsRegular() {
+			return nil
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		e := &entry{local: true, ManifestEntry: domain.ManifestEntry{Path: rel, ModTime: fi.ModTime(), IsDir: d.IsDir()}}
+		if !d.IsDir() {
+			e.Size = fi.Size()
+		}
+		tree[rel] = e
+		return nil
+	})
+	return tree, err
+}
+
+func (x *syncer) scanRemote(ctx context.Context) (map[string]*entry, error) {
+	tree := map[string]*entry{}
+	entries, err := x.x.Manifest(ctx, x.remote)
+	if errors.Is(err, ErrNotFound) && x.opts.Direction == Push {
+		x.rootMissing = true
+		return tree, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	skipped := map[string]bool{}
+	for _, e := range entries {
+		if dir := path.Dir(e.Path); dir != "." && skipped[dir] {
+			if e.IsDir {
+				skipped[e.Path] = true
+			}
+			continue
+		}
+		if x.excluded(e.Path, e.IsDir) || strings.HasPrefix(path.Base(e.Path), partPrefix) {
+			if e.IsDir {
+				skipped[e.Path] = true
+			}
+			continue
+		}
+		tree[e.Path] = &entry{ManifestEntry: e}
+	}
+	return tree, nil
+}
+
+func (x *syncer) run(ctx context.Context, src, dst map[string]*entry) error {
+	if x.rootMissing {
+		if err := x.apply(ctx, SyncAction{Op: "mkdir", Reason: "new"}, nil); err != nil {
+			return err
+		}
+	}
+
+	paths := make([]string, 0, len(src))
+	for p := range src {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	copyOp := "upload"
+	if x.opts.Direction == Pull {
+		copyOp = "download"
+	}
+	for _, p := range paths {
+		s, d := src[p], dst[p]
+		switch {
+		case d != nil && s.IsDir != d.IsDir:
+			// файл стал каталогом или наоборот: старый удаляется всегда
+			if err := x.apply(ctx, SyncAction{Op: "delete", Path: p, Reason: "changed"}, d); err != nil {
+				return err
+			}
+			x.dropUnder(dst, p)
+			d = nil
+		case d != nil && s.IsDir:
+			continue
+		case d != nil:
+			same, err := x.same(s, d)
+			if err != nil {
+				return err
+			}
+			if same {
+				x.report.Unchanged++
+				continue
+			}
+			if err := x.apply(ctx, SyncAction{Op: copyOp, Path: p, Size: s.Size, Reason: "changed"}, s); err != nil {
+				return err
+			}
+			continue
+		}
+
+		op := copyOp
+		if s.IsDir {
+			op = "mkdir"
+		}
+		if err := x.apply(ctx, SyncAction{Op: op, Path: p, Size: s.Size, Reason: "new"}, s); err != nil {
+			return err
+		}
+	}
+
+	if !x.opts.Delete {
+		return nil
+	}
+	extra := make([]string, 0)
+	for p := range dst {
+		if src[p] == nil {
+			extra = append(extra, p)
+		}
+	}
+	sort.Strings(extra)
+	deleted := map[string]bool{}
+	for _, p := range extra {
+		if deletedParent(deleted, p) {
+			continue
+		}
+		if err := x.apply(ctx, SyncAction{Op: "delete", Path: p, Reason: "extraneous"}, dst[p]); err != nil {
+			return err
+		}
+		deleted[p] = true
+	}
+	return nil
+}
+
+// dropUnder убирает из дерева содержимое удаленного каталога p
+func (x *syncer) dropUnder(tree map[string]*entry, p string) {
+	for k := range tree {
+		if strings.HasPrefix(k, p+"/") {
+			delete(tree, k)
+		}
+	}
+}
+
+func deletedParent(deleted map[string]bool, p string) bool {
+	for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+		if deleted[dir] {
+			return true
+		}
+	}
+	return false
+}
+
+// same файлы совпадают по размеру и времени изменения или по SHA-256
+func (x *syncer) same(a, b *entry) (bool, error) {
+	if a.Size != b.Size {
+		return false, nil
+	}
+	if a.ModTime.Equal(b.ModTime) {
+		return true, nil
+	}
+	sumA, err := x.sum(a)
+	if err != nil {
+		return false, err
+	}
+	sumB, err := x.sum(b)
+	if err != nil {
+		return false, err
+	}
+	return sumA == sumB, nil
+}
+
+// sum SHA-256 файла: серверный из манифеста, локальный из журнала или по содержимому
+func (x *syncer) sum(e *entry) (string, error) {
+	if !e.local || e.SHA256 != "" {
+		return e.SHA256, nil
+	}
+	if h, ok := x.state.Hashes[e.Path]; ok && h.Size == e.Size && h.ModTime.Equal(e.ModTime) {
+		e.SHA256 = h.SHA256
+		return e.SHA256, nil
+	}
+
+	f, err := os.Open(x.localPath(e.Path))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	e.SHA256 = hex.EncodeToString(h.Sum(nil))
+	x.state.Hashes[e.Path] = stateHash{Size: e.Size, ModTime: e.ModTime, SHA256: e.SHA256}
+	return e.SHA256, nil
+}
+
+func (x *syncer) localPath(rel string) string {
+	return filepath.Join(x.local, filepath.FromSlash(rel))
+}
+
+func (x *syncer) remotePath(rel string) string {
+	return path.Join(x.remote, rel)
+}
+
+// apply сообщает о действии и выполняет его, если это не пробный прогон
+func (x *syncer) apply(ctx context.Context, a SyncAction, e *entry) error {
+	x.report.Actions = append(x.report.Actions, a)
+	if x.opts.OnAction != nil {
+		x.opts.OnAction(a)
+	}
+	if x.opts.DryRun {
+		return nil
+	}
+
+	var err error
+	switch {
+	case a.Op == "mkdir" && x.opts.Direction == Push:
+		err = x.x.Mkdir(ctx, x.remotePath(a.Path))
+		if errors.Is(err, ErrExists) {
+			err = nil
+		}
+	case a.Op == "mkdir":
+		err = os.MkdirAll(x.localPath(a.Path), 0755)
+	case a.Op == "delete" && x.opts.Direction == Push:
+		err = x.x.Delete(ctx, x.remotePath(a.Path))
+	case a.Op == "delete":
+		err = os.RemoveAll(x.localPath(a.Path))
+	case a.Op == "upload":
+		err = x.upload(ctx, e)
+	case a.Op == "download":
+		err = x.download(ctx, e)
+	}
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", a.Op, a.Path, err)
+	}
+	if a.Op == "upload" || a.Op == "download" {
+		x.report.Bytes += a.Size
+		// журнал после каждого файла, чтобы прерванный прогон не считал хеши заново
+		return x.saveState()
+	}
+	return nil
+}
+
+func (x *syncer) upload(ctx context.Context, e *entry) error {
+	f, err := os.Open(x.localPath(e.Path))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	target := x.remotePath(e.Path)
+	if _, err := x.x.objectURL(target, nil); err != nil || e.Size <= x.x.cfg.PartSize {
+		return x.x.Put(ctx, target, f, e.Size)
+	}
+
+	// по частям: ID загрузки в журнале, чтобы продолжить после обрыва
+	var up *MultipartUpload
+	if u, ok := x.state.Uploads[e.Path]; ok && u.Size == e.Size && u.ModTime.Equal(e.ModTime) {
+		up, err = x.x.ResumeUpload(ctx, target, u.ID)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	if up == nil {
+		if up, err = x.x.CreateUpload(ctx, target); err != nil {
+			return err
+		}
+		x.state.Uploads[e.Path] = stateUpload{ID: up.ID(), Size: e.Size, ModTime: e.ModTime}
+		if err := x.saveState(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := f.Seek(up.Offset(), io.SeekStart); err != nil {
+		return err
+	}
+	if err := up.Send(ctx, f); err != nil {
+		return err
+	}
+	if err := up.Complete(ctx); err != nil {
+		return err
+	}
+	delete(x.state.Uploads, e.Path)
+	return nil
+}
+
+// download качает во временный файл рядом с целевым. Имя временного файла
+// содержит SHA-256, поэтому начатый файл дописывается, только если на
+// сервере та же версия.
+func (x *syncer) download(ctx context.Context, e *entry) error {
+	target := x.localPath(e.Path)
+	part := filepath.Join(filepath.Dir(target), partPrefix+e.SHA256[:min(16, len(e.SHA256))]+"-"+path.Base(e.Path))
+
+	f, err := os.OpenFile(part, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	offset, err := io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+	if offset > e.Size {
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		h.Reset()
+		offset = 0
+	}
+	if offset < e.Size {
+		if _, err := x.x.Download(ctx, x.remotePath(e.Path), io.MultiWriter(f, h), offset, -1); err != nil {
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if sum := hex.EncodeToString(h.Sum(nil)); e.SHA256 != "" && sum != e.SHA256 {
+		os.Remove(part)
+		return ErrChanged
+	}
+	if err := os.Chtimes(part, e.ModTime, e.ModTime); err != nil {
+		return err
+	}
+	// на месте файла мог быть каталог, он уже удален
+	return os.Rename(part, target)
+}
+
+func (x *syncer) saveState() error {
+	if x.opts.StateFile == "" || x.opts.DryRun {
+		return nil
+	}
+	return x.state.save(x.opts.StateFile)
+}
+
+// syncState журнал синхронизации
+type syncState struct {
+	// Hashes SHA-256 локальных файлов по пути, пока не изменились размер и время
+	Hashes map[string]stateHash `json:"hashes"`
+	// Uploads начатые загрузки по частям
+	Uploads map[string]stateUpload `json:"uploads"`
+}
+
+type stateHash struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	SHA256  string    `json:"sha256"`
+}
+
+type stateUpload struct {
+	ID      string    `json:"id"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+func (x *syncState) load(file string) error {
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, x); err != nil {
+		return fmt.Errorf("sync state %s: %w", file, err)
+	}
+	if x.Hashes == nil {
+		x.Hashes = map[string]stateHash{}
+	}
+	if x.Uploads == nil {
+		x.Uploads = map[string]stateUpload{}
+	}
+	return nil
+}
+
+// save пишет журнал через временный файл, чтобы обрыв не оставил его пустым
+func (x *syncState) save(file string) error {
+	data, err := json.Marshal(x)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
@@ -0,0 +1,199 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeLocal(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		full := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// actions действия отчета в виде "op path"
+func actions(report *SyncReport) string {
+	var list []string
+	for _, a := range report.Actions {
+		list = append(list, a.Op+" "+a.Path)
+	}
+	return strings.Join(list, ",")
+}
+
+func TestSyncPush(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	local := t.TempDir()
+	writeLocal(t, local, map[string]string{
+		"a.txt":     "alpha",
+		"sub/b.txt": "bravo",
+		"skip.log":  "log",
+		"tmp/c.txt": "tmp",
+	})
+	opts := SyncOptions{Exclude: []string{"*.log", "tmp/"}, StateFile: filepath.Join(t.TempDir(), "state.json")}
+
+	report, err := env.client.Sync(ctx, local, "/files/mirror", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := actions(report); got != "mkdir ,upload a.txt,mkdir sub,upload sub/b.txt" {
+		t.Errorf("first push %s", got)
+	}
+	if got := env.read(t, "files/mirror/sub/b.txt"); got != "bravo" {
+		t.Errorf("uploaded %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(env.storage, "files", "mirror", "tmp")); !os.IsNotExist(err) {
+		t.Errorf("excluded dir pushed: %v", err)
+	}
+
+	// время на сервере другое, совпадение по SHA-256
+	report, err = env.client.Sync(ctx, local, "/files/mirror", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Actions) != 0 || report.Unchanged != 2 {
+		t.Errorf("second push %s, unchanged %d", actions(report), report.Unchanged)
+	}
+
+	writeLocal(t, local, map[string]string{"sub/b.txt": "BRAVO"})
+	env.write(t, "files/mirror/old.txt", "old")
+	env.write(t, "files/mirror/server.log", "log")
+
+	opts.DryRun = true
+	opts.Delete = true
+	report, err = env.client.Sync(ctx, local, "/files/mirror", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := actions(report); got != "upload sub/b.txt,delete old.txt" {
+		t.Errorf("dry run %s", got)
+	}
+	if got := env.read(t, "files/mirror/sub/b.txt"); got != "bravo" {
+		t.Errorf("dry run changed %q", got)
+	}
+
+	opts.DryRun = false
+	if _, err := env.client.Sync(ctx, local, "/files/mirror", opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := env.read(t, "files/mirror/sub/b.txt"); got != "BRAVO" {
+		t.Errorf("changed %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(env.storage, "files", "mirror", "old.txt")); !os.IsNotExist(err) {
+		t.Errorf("extraneous file kept: %v", err)
+	}
+	if got := env.read(t, "files/mirror/server.log"); got != "log" {
+		t.Errorf("excluded file deleted")
+	}
+}
+
+func TestSyncPushResume(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	local := t.TempDir()
+	content := "0123456789"
+	writeLocal(t, local, map[string]string{"big.bin": content})
+	opts := SyncOptions{StateFile: filepath.Join(t.TempDir(), "state.json")}
+
+	// вторая часть отклоняется, первая уже на сервере
+	env.setFault(func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Query().Get("partNumber") == "2" {
+			http.Error(w, "bad part", http.StatusBadRequest)
+			return true
+		}
+		return false
+	})
+	if _, err := env.client.Sync(ctx, local, "/files/mirror", opts); err == nil {
+		t.Fatal("push with a failing part succeeded")
+	}
+
+	env.setFault(nil)
+	if _, err := env.client.Sync(ctx, local, "/files/mirror", opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := env.read(t, "files/mirror/big.bin"); got != content {
+		t.Errorf("assembled %q", got)
+	}
+	for _, r := range env.requests {
+		if r.URL.Query().Get("partNumber") == "1" {
+			t.Errorf("first part sent again")
+		}
+	}
+}
+
+func TestSyncPull(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	env.write(t, "files/src/a.txt", "alpha")
+	env.write(t, "files/src/sub/b.txt", "bravo")
+	env.write(t, "files/src/c.txt", "charlie")
+	local := filepath.Join(t.TempDir(), "dst")
+	opts := SyncOptions{Direction: Pull, Delete: true}
+
+	report, err := env.client.Sync(ctx, local, "/files/src", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := actions(report); got != "mkdir ,download a.txt,download c.txt,mkdir sub,download sub/b.txt" {
+		t.Errorf("first pull %s", got)
+	}
+	if report.Bytes != 17 {
+		t.Errorf("bytes %d", report.Bytes)
+	}
+
+	report, err = env.client.Sync(ctx, local, "/files/src", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Actions) != 0 || report.Unchanged != 3 {
+		t.Errorf("second pull %s, unchanged %d", actions(report), report.Unchanged)
+	}
+
+	// начатая загрузка новой версии дописывается, каталог заменяет файл
+	env.write(t, "files/src/a.txt", "ALPHA!")
+	sum := sha256.Sum256([]byte("ALPHA!"))
+	writeLocal(t, local, map[string]string{
+		partPrefix + hex.EncodeToString(sum[:])[:16] + "-a.txt": "AL",
+		"extra.txt": "extra",
+	})
+	if err := os.RemoveAll(filepath.Join(local, "sub")); err != nil {
+		t.Fatal(err)
+	}
+	writeLocal(t, local, map[string]string{"sub": "file"})
+
+	env.setFault(nil)
+	report, err = env.client.Sync(ctx, local, "/files/src", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := actions(report); got != "download a.txt,delete sub,mkdir sub,download sub/b.txt,delete extra.txt" {
+		t.Errorf("third pull %s", got)
+	}
+	for name, want := range map[string]string{"a.txt": "ALPHA!", "sub/b.txt": "bravo"} {
+		data, err := os.ReadFile(filepath.Join(local, filepath.FromSlash(name)))
+		if err != nil || string(data) != want {
+			t.Errorf("%s: %q %v", name, data, err)
+		}
+	}
+	for _, r := range env.requests {
+		if strings.HasSuffix(r.URL.Path, "/a.txt") && r.Header.Get("Range") != "bytes=2-" {
+			t.Errorf("a.txt not resumed: range %q", r.Header.Get("Range"))
+		}
+	}
+	if entries, _ := filepath.Glob(filepath.Join(local, partPrefix+"*")); len(entries) != 0 {
+		t.Errorf("part files left: %v", entries)
+	}
+}
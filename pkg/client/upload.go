@@ -0,0 +1,263 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Upload загружает r в каталог dir под именем name формой multipart, как
+// браузер. Существующий файл перезаписывается. Повтор после сбоя возможен,
+// только если r — io.ReadSeeker.
+func (x *Client) Upload(ctx context.Context, dir, name string, r io.Reader) error {
+	src := rewind(r)
+	resp, err := x.do(ctx, &request{
+		method: http.MethodPost,
+		url:    x.storageURL(dirPath(dir), url.Values{"filename": {name}}),
+		length: -1,
+		body: func() (io.Reader, error) {
+			body, err := src()
+			if err != nil {
+				return nil, err
+			}
+			pr, pw := io.Pipe()
+			mw := multipart.NewWriter(pw)
+			go func() {
+				part, err := mw.CreateFormFile("file", path.Base("/"+name))
+				if err == nil {
+					_, err = io.Copy(part, body)
+				}
+				if err == nil {
+					err = mw.Close()
+				}
+				pw.CloseWithError(err)
+			}()
+			return &formBody{Reader: pr, contentType: mw.FormDataContentType()}, nil
+		},
+	}, http.StatusCreated)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// formBody тело формы с ее Content-Type, он свой у каждой попытки
+type formBody struct {
+	io.Reader
+	contentType string
+}
+
+// Put записывает r в файл p потоком через WebDAV, не собирая форму.
+// size -1, если размер неизвестен. Каталог файла должен существовать,
+// иначе ErrNotFound.
+func (x *Client) Put(ctx context.Context, p string, r io.Reader, size int64) error {
+	u, err := x.davURL(p)
+	if err != nil {
+		return err
+	}
+	resp, err := x.do(ctx, &request{
+		method: http.MethodPut,
+		url:    u,
+		body:   rewind(r),
+		length: size,
+	}, http.StatusCreated, http.StatusNoContent, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// MultipartUpload загрузка по частям через S3 API. Загруженные части
+// хранятся на сервере, поэтому после обрыва загрузку можно продолжить
+// в другом процессе по пути и ID.
+type MultipartUpload struct {
+	x      *Client
+	path   string
+	id     string
+	parts  []uploadedPart
+	offset int64
+}
+
+type uploadedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+	Size       int64  `xml:"Size"`
+}
+
+// CreateUpload начинает загрузку по частям в файл p
+func (x *Client) CreateUpload(ctx context.Context, p string) (*MultipartUpload, error) {
+	u, err := x.objectURL(p, url.Values{"uploads": {""}})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := x.do(ctx, &request{method: http.MethodPost, url: u.String(), s3: true}, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var res struct {
+		UploadId string `xml:"UploadId"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, err
+	}
+	return &MultipartUpload{x: x, path: cleanPath(p), id: res.UploadId}, nil
+}
+
+// ResumeUpload продолжает загрузку id в файл p. Источник нужно перемотать
+// на Offset перед Send.
+func (x *Client) ResumeUpload(ctx context.Context, p, id string) (*MultipartUpload, error) {
+	u, err := x.objectURL(p, url.Values{"uploadId": {id}})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := x.do(ctx, &request{method: http.MethodGet, url: u.String(), s3: true}, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var res struct {
+		Parts []uploadedPart `xml:"Part"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, err
+	}
+	sort.Slice(res.Parts, func(i, j int) bool { return res.Parts[i].PartNumber < res.Parts[j].PartNumber })
+
+	// продолжаем после последней части без пропусков, остальные перезапишутся
+	up := &MultipartUpload{x: x, path: cleanPath(p), id: id}
+	for i, part := range res.Parts {
+		if part.PartNumber != i+1 {
+			break
+		}
+		up.parts = append(up.parts, part)
+		up.offset += part.Size
+	}
+	return up, nil
+}
+
+func (x *MultipartUpload) ID() string {
+	return x.id
+}
+
+// Offset сколько байт источника уже на сервере
+func (x *MultipartUpload) Offset() int64 {
+	return x.offset
+}
+
+// Send отправляет r до конца частями по Config.PartSize. Каждая часть
+// повторяется отдельно, после ошибки Send можно вызвать снова с позиции Offset.
+func (x *MultipartUpload) Send(ctx context.Context, r io.Reader) error {
+	buf := make([]byte, x.x.cfg.PartSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		last := errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+		if err != nil && !last {
+			return err
+		}
+		// пустой файл — одна пустая часть
+		if n > 0 || len(x.parts) == 0 {
+			if err := x.sendPart(ctx, buf[:n]); err != nil {
+				return err
+			}
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+func (x *MultipartUpload) sendPart(ctx context.Context, data []byte) error {
+	number := len(x.parts) + 1
+	u, err := x.x.objectURL(x.path, url.Values{
+		"partNumber": {strconv.Itoa(number)},
+		"uploadId":   {x.id},
+	})
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(data)
+	resp, err := x.x.do(ctx, &request{
+		method: http.MethodPut,
+		url:    u.String(),
+		header: http.Header{"X-Amz-Content-Sha256": {hex.EncodeToString(sum[:])}},
+		body:   rewind(bytes.NewReader(data)),
+		length: int64(len(data)),
+		s3:     true,
+	}, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	x.parts = append(x.parts, uploadedPart{
+		PartNumber: number,
+		ETag:       strings.Trim(resp.Header.Get("ETag"), `"`),
+		Size:       int64(len(data)),
+	})
+	x.offset += int64(len(data))
+	return nil
+}
+
+// Complete собирает файл из отправленных частей
+func (x *MultipartUpload) Complete(ctx context.Context) error {
+	type completePart struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	}
+	body := struct {
+		XMLName xml.Name       `xml:"CompleteMultipartUpload"`
+		Parts   []completePart `xml:"Part"`
+	}{}
+	for _, p := range x.parts {
+		body.Parts = append(body.Parts, completePart{PartNumber: p.PartNumber, ETag: `"` + p.ETag + `"`})
+	}
+	data, err := xml.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	u, err := x.x.objectURL(x.path, url.Values{"uploadId": {x.id}})
+	if err != nil {
+		return err
+	}
+	resp, err := x.x.do(ctx, &request{
+		method: http.MethodPost,
+		url:    u.String(),
+		header: http.Header{"X-Amz-Content-Sha256": {hexSHA256(data)}},
+		body:   rewind(bytes.NewReader(data)),
+		length: int64(len(data)),
+		s3:     true,
+	}, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Abort отменяет загрузку и удаляет части на сервере
+func (x *MultipartUpload) Abort(ctx context.Context) error {
+	u, err := x.x.objectURL(x.path, url.Values{"uploadId": {x.id}})
+	if err != nil {
+		return err
+	}
+	resp, err := x.x.do(ctx, &request{method: http.MethodDelete, url: u.String(), s3: true}, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
@@ -0,0 +1,36 @@
+package hashreader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+)
+
+// Reader считает размер и SHA256 прочитанных через него данных
+type Reader struct {
+	r    io.Reader
+	h    hash.Hash
+	size int64
+}
+
+func New(r io.Reader) *Reader {
+	return &Reader{r: r, h: sha256.New()}
+}
+
+func (x *Reader) Read(p []byte) (int, error) {
+	n, err := x.r.Read(p)
+	x.h.Write(p[:n])
+	x.size += int64(n)
+	return n, err
+}
+
+// Size количество прочитанных байт
+func (x *Reader) Size() int64 {
+	return x.size
+}
+
+// Sum hex-представление SHA256 прочитанных данных
+func (x *Reader) Sum() string {
+	return hex.EncodeToString(x.h.Sum(nil))
+}